@@ -0,0 +1,38 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/awslabs/ssosync/internal"
+	"github.com/spf13/cobra"
+)
+
+var diffPlansCmd = &cobra.Command{
+	Use:   "diff-plans OLD_PLAN NEW_PLAN",
+	Short: "Print the operations that differ between two saved plans",
+	Long: `Compares two plans written with --output-plan by earlier runs and prints
+the operations only the new plan has, prefixed with +, and the ones only the
+old plan has, prefixed with -. It helps to understand why a run deleted
+users or groups that an earlier run kept. Only the files are read, no api
+is called.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return internal.DiffPlans(args[0], args[1], cmd.OutOrStdout())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffPlansCmd)
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffPlansCmd(t *testing.T) {
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "old.json")
+	newPath := filepath.Join(dir, "new.json")
+	assert.NoError(t, ioutil.WriteFile(oldPath, []byte(`{"deleteGroups": []}`), 0644))
+	assert.NoError(t, ioutil.WriteFile(newPath, []byte(`{"deleteGroups": ["group-1"]}`), 0644))
+
+	var buf bytes.Buffer
+	rootCmd.SetOut(&buf)
+	defer rootCmd.SetOut(nil)
+
+	rootCmd.SetArgs([]string{"diff-plans", oldPath, newPath})
+	assert.NoError(t, rootCmd.Execute())
+	assert.Equal(t, "+ delete group group-1\n", buf.String())
+
+	// both plans are needed
+	rootCmd.SetArgs([]string{"diff-plans", oldPath})
+	assert.Error(t, rootCmd.Execute())
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+
+	"github.com/awslabs/ssosync/internal"
+	"github.com/spf13/cobra"
+)
+
+// reconcileExternalIDs repairs the external ids, it's swapped in tests
+var reconcileExternalIDs = internal.ReconcileExternalIDs
+
+var reconcileExternalIDsCmd = &cobra.Command{
+	Use:   "reconcile-external-ids",
+	Short: "Set the external id of the AWS users to the id of their Google user",
+	Long: `Matches every AWS user with the Google user of the same email and
+patches its SCIM externalId to the id of that Google user when it's missing
+or another one. No other attribute is changed, which makes it safe to run
+after migrating from a version of ssosync that didn't set external ids.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		return reconcileExternalIDs(ctx, cfg)
+	},
+}
+
+func init() {
+	reconcileExternalIDsCmd.Flags().BoolVar(&cfg.ReconcileDryRun, "dry-run", false, "log the external ids that would be repaired without changing them")
+	rootCmd.AddCommand(reconcileExternalIDsCmd)
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/awslabs/ssosync/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconcileExternalIDsCmd(t *testing.T) {
+	defer func(r func(context.Context, *config.Config) error) { reconcileExternalIDs = r }(reconcileExternalIDs)
+
+	var reconciled *config.Config
+	reconcileExternalIDs = func(ctx context.Context, c *config.Config) error {
+		reconciled = c
+		return nil
+	}
+
+	rootCmd.SetArgs([]string{"reconcile-external-ids", "--identity-store-id", "d-1234567890", "--dry-run"})
+	assert.NoError(t, rootCmd.Execute())
+	if assert.NotNil(t, reconciled) {
+		assert.Equal(t, "d-1234567890", reconciled.IdentityStoreID)
+		assert.True(t, reconciled.ReconcileDryRun)
+	}
+
+	// a failed repair fails the command
+	reconcileExternalIDs = func(ctx context.Context, c *config.Config) error {
+		return errors.New("access denied")
+	}
+
+	rootCmd.SetArgs([]string{"reconcile-external-ids"})
+	assert.EqualError(t, rootCmd.Execute(), "access denied")
+}
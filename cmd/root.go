@@ -18,8 +18,11 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -42,7 +45,15 @@ var (
 	builtBy = "unknown"
 )
 
-var cfg *config.Config
+// cfg is created before the inits run, so the subcommands can bind their
+// own flags to it
+var cfg = config.New()
+
+// stats holds the changes made by the last run
+var stats internal.SyncStats
+
+// metricsOut receives the metrics of the run, the Lambda logs turn them into CloudWatch metrics
+var metricsOut io.Writer = os.Stdout
 
 var rootCmd = &cobra.Command{
 	Version: "dev",
@@ -55,7 +66,13 @@ Complete documentation is available at https://github.com/awslabs/ssosync`,
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 
-		err := internal.DoSync(ctx, cfg)
+		var err error
+		start := time.Now()
+		stats, err = internal.DoSync(ctx, cfg)
+		log.WithFields(stats.Fields()).Info("sync summary")
+		if cfg.EmitMetrics {
+			emitMetrics(time.Since(start))
+		}
 		if err != nil {
 			return err
 		}
@@ -63,6 +80,17 @@ Complete documentation is available at https://github.com/awslabs/ssosync`,
 	},
 }
 
+// emitMetrics writes the stats of the run as a line of its own, outside of
+// the log formatting, as CloudWatch only reads metrics from a bare json line
+func emitMetrics(duration time.Duration) {
+	b, err := stats.EMF(cfg.SyncMethod, duration, time.Now())
+	if err != nil {
+		log.WithField("error", err).Warn("formatting metrics")
+		return
+	}
+	fmt.Fprintln(metricsOut, string(b))
+}
+
 // Execute is the entry point of the command. If we are
 // running inside of AWS Lambda, we use the Lambda
 // execution path.
@@ -119,6 +147,9 @@ func Handler(ctx context.Context, event events.CodePipelineEvent) (string, error
         // mark the job as Success.
         cplSuccess := &codepipeline.PutJobSuccessResultInput{
     	    JobId: aws.String(jobID),
+    	    ExecutionDetails: &codepipeline.ExecutionDetails{
+    		Summary: aws.String(stats.String()),
+    	    },
         }
         _, cplErr := cpl.PutJobSuccessResult(cplSuccess)
         if cplErr != nil {
@@ -137,12 +168,12 @@ func Handler(ctx context.Context, event events.CodePipelineEvent) (string, error
 
 func init() {
 	// init config
-	cfg = config.New()
 	cfg.IsLambda = len(os.Getenv("AWS_LAMBDA_FUNCTION_NAME")) > 0
 
 	// initialize cobra
 	cobra.OnInitialize(initConfig)
 	addFlags(rootCmd, cfg)
+	addSyncFlags(validateCmd, reconcileExternalIDsCmd)
 
 	rootCmd.SetVersionTemplate(fmt.Sprintf("%s, commit %s, built at %s by %s\n", version, commit, date, builtBy))
 
@@ -167,11 +198,102 @@ func initConfig() {
 		"ignore_users",
 		"ignore_groups",
 		"include_groups",
+		"include_users",
 		"user_match",
 		"group_match",
 		"sync_method",
 		"region",
 		"identity_store_id",
+		"scim_verify_tls_min_version",
+		"empty_group_action",
+		"google_retry_on_specific_codes",
+		"scim_disable_create_fallback_find",
+		"sync_attributes",
+		"google_group_query_expansion",
+		"migrate_group_names",
+		"max_users",
+		"reconcile_chunk_size",
+		"report_unresolved_members",
+		"scim_connection_pool_size",
+		"scim_client_cert",
+		"scim_client_key",
+		"scim_ca_cert",
+		"http_proxy",
+		"https_proxy",
+		"no_proxy",
+		"scim_timeout",
+		"sync_timeout",
+		"unmanaged_user_action",
+		"sync_group_aliases",
+		"invalid_user_action",
+		"google_delegation_subject_per_operation",
+		"continue_on_member_error",
+		"membership_fetch_concurrency",
+		"scim_unmarshal_retries",
+		"google_member_fetch_concurrency",
+		"plan_s3_uri",
+		"report_s3_uri",
+		"correlation_cache_s3_uri",
+		"correlation_cache_max_age",
+		"checkpoint_table",
+		"checkpoint_bucket",
+		"verify_equal_members",
+		"verify_equal_members_percent",
+		"max_deletions",
+		"max_deletions_percent",
+		"allow_empty_source",
+		"max_errors",
+		"sync_manager",
+		"manager_key",
+		"backfill_external_ids",
+		"identity_store_max_retries",
+		"google_credentials_secret",
+		"output_plan",
+		"export_mappings",
+		"user_backend",
+		"report_permission_set_impact",
+		"report_drift",
+		"sso_instance_arn",
+		"group_name_prefix",
+		"group_name_suffix",
+		"group_display_name_source",
+		"google_list_projection",
+		"google_custom_field_mask",
+		"google_page_size",
+		"audit_log_path",
+		"emit_metrics",
+		"sync_group_metadata_only",
+		"purge_orphaned_memberships",
+		"user_update_strategy",
+		"user_delete_strategy",
+		"fail_on_plan_conflicts",
+		"user_delete_concurrency",
+		"continue_on_user_delete_error",
+		"incremental_since",
+		"transitional_group_action",
+		"verbose_plan",
+		"plan_summary",
+		"membership_backends",
+		"only_phases",
+		"throttle_cooldown_threshold",
+		"throttle_cooldown_window",
+		"throttle_cooldown",
+		"delete_interval",
+		"sync_all_emails",
+		"sync_all_phones",
+		"sync_title",
+		"user_type_source",
+		"sync_locale",
+		"sync_preferred_language",
+		"timezone_field",
+		"min_group_members",
+		"trace_queries",
+		"google_members_include_suspended_separately",
+		"source_provider",
+		"okta_org_url",
+		"okta_api_token",
+		"source_file",
+		"protected_groups",
 	}
 
 	for _, e := range appEnvVars {
@@ -204,11 +326,15 @@ func configLambda() {
 	}
 	cfg.GoogleAdmin = unwrap
 
-	unwrap, err = secrets.GoogleCredentials(os.Getenv("GOOGLE_CREDENTIALS"))
-	if err != nil {
-		log.Fatalf(errors.Wrap(err, "cannot read config: GOOGLE_CREDENTIALS").Error())
+	// the credentials are read during the sync when they come from their own secret
+	cfg.GoogleCredentialsSecret = os.Getenv("GOOGLE_CREDENTIALS_SECRET")
+	if len([]rune(cfg.GoogleCredentialsSecret)) == 0 {
+		unwrap, err = secrets.GoogleCredentials(os.Getenv("GOOGLE_CREDENTIALS"))
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: GOOGLE_CREDENTIALS").Error())
+		}
+		cfg.GoogleCredentials = unwrap
 	}
-	cfg.GoogleCredentials = unwrap
 
 	unwrap, err = secrets.SCIMAccessToken(os.Getenv("SCIM_ACCESS_TOKEN"))
 	if err != nil {
@@ -234,6 +360,15 @@ func configLambda() {
 	}
 	cfg.IdentityStoreID = unwrap
 
+	// as the google credentials, the okta token is a secret, only read when synced from okta
+	if cfg.SourceProvider == config.SourceProviderOkta {
+		unwrap, err = secrets.OktaAPIToken(os.Getenv("OKTA_API_TOKEN"))
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: OKTA_API_TOKEN").Error())
+		}
+		cfg.OktaAPIToken = unwrap
+	}
+
         unwrap = os.Getenv("LOG_LEVEL")
         if len([]rune(unwrap)) != 0 {
            cfg.LogLevel = unwrap
@@ -282,6 +417,750 @@ func configLambda() {
 	   log.WithField("IncludeGroups", unwrap).Debug("from EnvVar")
         }
 
+	unwrap = os.Getenv("INCLUDE_USERS")
+	if len([]rune(unwrap)) != 0 {
+		cfg.IncludeUsers = strings.Split(unwrap, ",")
+		log.WithField("IncludeUsers", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("SCIM_VERIFY_TLS_MIN_VERSION")
+	if len([]rune(unwrap)) != 0 {
+		cfg.SCIMVerifyTLSMinVersion = unwrap
+		log.WithField("SCIMVerifyTLSMinVersion", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("EMPTY_GROUP_ACTION")
+	if len([]rune(unwrap)) != 0 {
+		cfg.EmptyGroupAction = unwrap
+		log.WithField("EmptyGroupAction", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("GOOGLE_RETRY_ON_SPECIFIC_CODES")
+	if len([]rune(unwrap)) != 0 {
+		codes := make([]int, 0)
+		for _, code := range strings.Split(unwrap, ",") {
+			c, err := strconv.Atoi(strings.TrimSpace(code))
+			if err != nil {
+				log.Fatalf(errors.Wrap(err, "cannot read config: GOOGLE_RETRY_ON_SPECIFIC_CODES").Error())
+			}
+			codes = append(codes, c)
+		}
+		cfg.GoogleRetryCodes = codes
+		log.WithField("GoogleRetryCodes", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("SCIM_DISABLE_CREATE_FALLBACK_FIND")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: SCIM_DISABLE_CREATE_FALLBACK_FIND").Error())
+		}
+		cfg.SCIMDisableCreateFallbackFind = b
+		log.WithField("SCIMDisableCreateFallbackFind", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("SYNC_ATTRIBUTES")
+	if len([]rune(unwrap)) != 0 {
+		attributes := make([]string, 0)
+		for _, a := range strings.Split(unwrap, ",") {
+			attributes = append(attributes, strings.TrimSpace(a))
+		}
+		cfg.SyncAttributes = attributes
+		log.WithField("SyncAttributes", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("GOOGLE_GROUP_QUERY_EXPANSION")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: GOOGLE_GROUP_QUERY_EXPANSION").Error())
+		}
+		cfg.GoogleGroupQueryExpansion = b
+		log.WithField("GoogleGroupQueryExpansion", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("MIGRATE_GROUP_NAMES")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: MIGRATE_GROUP_NAMES").Error())
+		}
+		cfg.MigrateGroupNames = b
+		log.WithField("MigrateGroupNames", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("MAX_USERS")
+	if len([]rune(unwrap)) != 0 {
+		n, err := strconv.Atoi(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: MAX_USERS").Error())
+		}
+		cfg.MaxUsers = n
+		log.WithField("MaxUsers", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("MAX_DELETIONS")
+	if len([]rune(unwrap)) != 0 {
+		n, err := strconv.Atoi(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: MAX_DELETIONS").Error())
+		}
+		cfg.MaxDeletions = n
+		log.WithField("MaxDeletions", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("MAX_DELETIONS_PERCENT")
+	if len([]rune(unwrap)) != 0 {
+		n, err := strconv.Atoi(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: MAX_DELETIONS_PERCENT").Error())
+		}
+		cfg.MaxDeletionsPercent = n
+		log.WithField("MaxDeletionsPercent", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("ALLOW_EMPTY_SOURCE")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: ALLOW_EMPTY_SOURCE").Error())
+		}
+		cfg.AllowEmptySource = b
+		log.WithField("AllowEmptySource", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("RECONCILE_CHUNK_SIZE")
+	if len([]rune(unwrap)) != 0 {
+		n, err := strconv.Atoi(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: RECONCILE_CHUNK_SIZE").Error())
+		}
+		cfg.ReconcileChunkSize = n
+		log.WithField("ReconcileChunkSize", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("REPORT_UNRESOLVED_MEMBERS")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: REPORT_UNRESOLVED_MEMBERS").Error())
+		}
+		cfg.ReportUnresolvedMembers = b
+		log.WithField("ReportUnresolvedMembers", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("SCIM_TIMEOUT")
+	if len([]rune(unwrap)) != 0 {
+		d, err := time.ParseDuration(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: SCIM_TIMEOUT").Error())
+		}
+		cfg.SCIMTimeout = d
+		log.WithField("SCIMTimeout", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("SYNC_TIMEOUT")
+	if len([]rune(unwrap)) != 0 {
+		d, err := time.ParseDuration(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: SYNC_TIMEOUT").Error())
+		}
+		cfg.SyncTimeout = d
+		log.WithField("SyncTimeout", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("SCIM_CONNECTION_POOL_SIZE")
+	if len([]rune(unwrap)) != 0 {
+		n, err := strconv.Atoi(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: SCIM_CONNECTION_POOL_SIZE").Error())
+		}
+		cfg.SCIMConnectionPoolSize = n
+		log.WithField("SCIMConnectionPoolSize", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("SCIM_CLIENT_CERT")
+	if len([]rune(unwrap)) != 0 {
+		cfg.SCIMClientCert = unwrap
+		log.WithField("SCIMClientCert", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("SCIM_CLIENT_KEY")
+	if len([]rune(unwrap)) != 0 {
+		cfg.SCIMClientKey = unwrap
+		log.WithField("SCIMClientKey", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("SCIM_CA_CERT")
+	if len([]rune(unwrap)) != 0 {
+		cfg.SCIMCACert = unwrap
+		log.WithField("SCIMCACert", unwrap).Debug("from EnvVar")
+	}
+
+	// the proxies are read from prefixed variables, the lambda runtime and
+	// the go http client already use HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	unwrap = os.Getenv("SSOSYNC_HTTP_PROXY")
+	if len([]rune(unwrap)) != 0 {
+		cfg.HTTPProxy = unwrap
+		log.WithField("HTTPProxy", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("SSOSYNC_HTTPS_PROXY")
+	if len([]rune(unwrap)) != 0 {
+		cfg.HTTPSProxy = unwrap
+		log.WithField("HTTPSProxy", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("SSOSYNC_NO_PROXY")
+	if len([]rune(unwrap)) != 0 {
+		cfg.NoProxy = unwrap
+		log.WithField("NoProxy", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("UNMANAGED_USER_ACTION")
+	if len([]rune(unwrap)) != 0 {
+		cfg.UnmanagedUserAction = unwrap
+		log.WithField("UnmanagedUserAction", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("SYNC_MANAGER")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: SYNC_MANAGER").Error())
+		}
+		cfg.SyncManager = b
+		log.WithField("SyncManager", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("MANAGER_KEY")
+	if len([]rune(unwrap)) != 0 {
+		cfg.ManagerKey = unwrap
+		log.WithField("ManagerKey", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("BACKFILL_EXTERNAL_IDS")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: BACKFILL_EXTERNAL_IDS").Error())
+		}
+		cfg.BackfillExternalIDs = b
+		log.WithField("BackfillExternalIDs", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("SYNC_GROUP_ALIASES")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: SYNC_GROUP_ALIASES").Error())
+		}
+		cfg.SyncGroupAliases = b
+		log.WithField("SyncGroupAliases", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("USER_BACKEND")
+	if len([]rune(unwrap)) != 0 {
+		cfg.UserBackend = unwrap
+		log.WithField("UserBackend", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("SOURCE_PROVIDER")
+	if len([]rune(unwrap)) != 0 {
+		cfg.SourceProvider = unwrap
+		log.WithField("SourceProvider", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("PROTECTED_GROUPS")
+	if len([]rune(unwrap)) != 0 {
+		cfg.ProtectedGroups = strings.Split(unwrap, ",")
+		log.WithField("ProtectedGroups", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("SOURCE_FILE")
+	if len([]rune(unwrap)) != 0 {
+		cfg.SourceFile = unwrap
+		log.WithField("SourceFile", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("OKTA_ORG_URL")
+	if len([]rune(unwrap)) != 0 {
+		cfg.OktaOrgURL = unwrap
+		log.WithField("OktaOrgURL", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("REPORT_PERMISSION_SET_IMPACT")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: REPORT_PERMISSION_SET_IMPACT").Error())
+		}
+		cfg.ReportPermissionSetImpact = b
+		log.WithField("ReportPermissionSetImpact", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("REPORT_DRIFT")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: REPORT_DRIFT").Error())
+		}
+		cfg.ReportDrift = b
+		log.WithField("ReportDrift", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("SSO_INSTANCE_ARN")
+	if len([]rune(unwrap)) != 0 {
+		cfg.SSOInstanceArn = unwrap
+		log.WithField("SSOInstanceArn", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("GROUP_NAME_PREFIX")
+	if len([]rune(unwrap)) != 0 {
+		cfg.GroupNamePrefix = unwrap
+		log.WithField("GroupNamePrefix", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("GROUP_NAME_SUFFIX")
+	if len([]rune(unwrap)) != 0 {
+		cfg.GroupNameSuffix = unwrap
+		log.WithField("GroupNameSuffix", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("GROUP_DISPLAY_NAME_SOURCE")
+	if len([]rune(unwrap)) != 0 {
+		cfg.GroupDisplayNameSource = unwrap
+		log.WithField("GroupDisplayNameSource", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("USER_DELETE_CONCURRENCY")
+	if len([]rune(unwrap)) != 0 {
+		n, err := strconv.Atoi(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: USER_DELETE_CONCURRENCY").Error())
+		}
+		cfg.UserDeleteConcurrency = n
+		log.WithField("UserDeleteConcurrency", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("INCREMENTAL_SINCE")
+	if len([]rune(unwrap)) != 0 {
+		d, err := time.ParseDuration(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: INCREMENTAL_SINCE").Error())
+		}
+		cfg.IncrementalSince = d
+		log.WithField("IncrementalSince", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("TRANSITIONAL_GROUP_ACTION")
+	if len([]rune(unwrap)) != 0 {
+		cfg.TransitionalGroupAction = unwrap
+		log.WithField("TransitionalGroupAction", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("CONTINUE_ON_USER_DELETE_ERROR")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: CONTINUE_ON_USER_DELETE_ERROR").Error())
+		}
+		cfg.ContinueOnUserDeleteError = b
+		log.WithField("ContinueOnUserDeleteError", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("FAIL_ON_PLAN_CONFLICTS")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: FAIL_ON_PLAN_CONFLICTS").Error())
+		}
+		cfg.FailOnPlanConflicts = b
+		log.WithField("FailOnPlanConflicts", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("USER_UPDATE_STRATEGY")
+	if len([]rune(unwrap)) != 0 {
+		cfg.UserUpdateStrategy = unwrap
+		log.WithField("UserUpdateStrategy", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("USER_DELETE_STRATEGY")
+	if len([]rune(unwrap)) != 0 {
+		cfg.UserDeleteStrategy = unwrap
+		log.WithField("UserDeleteStrategy", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("GOOGLE_LIST_PROJECTION")
+	if len([]rune(unwrap)) != 0 {
+		cfg.GoogleListProjection = unwrap
+		log.WithField("GoogleListProjection", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("GOOGLE_PAGE_SIZE")
+	if len([]rune(unwrap)) != 0 {
+		n, err := strconv.Atoi(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: GOOGLE_PAGE_SIZE").Error())
+		}
+		cfg.GooglePageSize = n
+		log.WithField("GooglePageSize", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("GOOGLE_CUSTOM_FIELD_MASK")
+	if len([]rune(unwrap)) != 0 {
+		cfg.GoogleCustomFieldMask = unwrap
+		log.WithField("GoogleCustomFieldMask", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("AUDIT_LOG_PATH")
+	if len([]rune(unwrap)) != 0 {
+		cfg.AuditLogPath = unwrap
+		log.WithField("AuditLogPath", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("EMIT_METRICS")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: EMIT_METRICS").Error())
+		}
+		cfg.EmitMetrics = b
+		log.WithField("EmitMetrics", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("VERBOSE_PLAN")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: VERBOSE_PLAN").Error())
+		}
+		cfg.VerbosePlan = b
+		log.WithField("VerbosePlan", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("PLAN_SUMMARY")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: PLAN_SUMMARY").Error())
+		}
+		cfg.PlanSummary = b
+		log.WithField("PlanSummary", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("TRACE_QUERIES")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: TRACE_QUERIES").Error())
+		}
+		cfg.TraceQueries = b
+		log.WithField("TraceQueries", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("GOOGLE_MEMBERS_INCLUDE_SUSPENDED_SEPARATELY")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: GOOGLE_MEMBERS_INCLUDE_SUSPENDED_SEPARATELY").Error())
+		}
+		cfg.GoogleMembersIncludeSuspendedSeparately = b
+		log.WithField("GoogleMembersIncludeSuspendedSeparately", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("SYNC_ALL_EMAILS")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: SYNC_ALL_EMAILS").Error())
+		}
+		cfg.SyncAllEmails = b
+		log.WithField("SyncAllEmails", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("SYNC_ALL_PHONES")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: SYNC_ALL_PHONES").Error())
+		}
+		cfg.SyncAllPhones = b
+		log.WithField("SyncAllPhones", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("SYNC_TITLE")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: SYNC_TITLE").Error())
+		}
+		cfg.SyncTitle = b
+		log.WithField("SyncTitle", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("USER_TYPE_SOURCE")
+	if len([]rune(unwrap)) != 0 {
+		cfg.UserTypeSource = unwrap
+		log.WithField("UserTypeSource", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("SYNC_LOCALE")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: SYNC_LOCALE").Error())
+		}
+		cfg.SyncLocale = b
+		log.WithField("SyncLocale", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("SYNC_PREFERRED_LANGUAGE")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: SYNC_PREFERRED_LANGUAGE").Error())
+		}
+		cfg.SyncPreferredLanguage = b
+		log.WithField("SyncPreferredLanguage", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("TIMEZONE_FIELD")
+	if len([]rune(unwrap)) != 0 {
+		cfg.TimezoneField = unwrap
+		log.WithField("TimezoneField", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("SYNC_GROUP_METADATA_ONLY")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: SYNC_GROUP_METADATA_ONLY").Error())
+		}
+		cfg.SyncGroupMetadataOnly = b
+		log.WithField("SyncGroupMetadataOnly", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("PURGE_ORPHANED_MEMBERSHIPS")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: PURGE_ORPHANED_MEMBERSHIPS").Error())
+		}
+		cfg.PurgeOrphanedMemberships = b
+		log.WithField("PurgeOrphanedMemberships", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("INVALID_USER_ACTION")
+	if len([]rune(unwrap)) != 0 {
+		cfg.InvalidUserAction = unwrap
+		log.WithField("InvalidUserAction", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("GOOGLE_DELEGATION_SUBJECT_PER_OPERATION")
+	if len([]rune(unwrap)) != 0 {
+		cfg.GoogleDelegationSubjects = strings.Split(unwrap, ",")
+		log.WithField("GoogleDelegationSubjects", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("MEMBERSHIP_BACKENDS")
+	if len([]rune(unwrap)) != 0 {
+		cfg.MembershipBackends = strings.Split(unwrap, ",")
+		log.WithField("MembershipBackends", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("ONLY_PHASES")
+	if len([]rune(unwrap)) != 0 {
+		cfg.OnlyPhases = strings.Split(unwrap, ",")
+		log.WithField("OnlyPhases", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("CONTINUE_ON_MEMBER_ERROR")
+	if len([]rune(unwrap)) != 0 {
+		b, err := strconv.ParseBool(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: CONTINUE_ON_MEMBER_ERROR").Error())
+		}
+		cfg.ContinueOnMemberError = b
+		log.WithField("ContinueOnMemberError", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("MAX_ERRORS")
+	if len([]rune(unwrap)) != 0 {
+		n, err := strconv.Atoi(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: MAX_ERRORS").Error())
+		}
+		cfg.MaxErrors = n
+		log.WithField("MaxErrors", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("IDENTITY_STORE_MAX_RETRIES")
+	if len([]rune(unwrap)) != 0 {
+		n, err := strconv.Atoi(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: IDENTITY_STORE_MAX_RETRIES").Error())
+		}
+		cfg.IdentityStoreMaxRetries = n
+		log.WithField("IdentityStoreMaxRetries", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("MIN_GROUP_MEMBERS")
+	if len([]rune(unwrap)) != 0 {
+		n, err := strconv.Atoi(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: MIN_GROUP_MEMBERS").Error())
+		}
+		cfg.MinGroupMembers = n
+		log.WithField("MinGroupMembers", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("THROTTLE_COOLDOWN_THRESHOLD")
+	if len([]rune(unwrap)) != 0 {
+		n, err := strconv.Atoi(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: THROTTLE_COOLDOWN_THRESHOLD").Error())
+		}
+		cfg.ThrottleCooldownThreshold = n
+		log.WithField("ThrottleCooldownThreshold", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("THROTTLE_COOLDOWN_WINDOW")
+	if len([]rune(unwrap)) != 0 {
+		d, err := time.ParseDuration(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: THROTTLE_COOLDOWN_WINDOW").Error())
+		}
+		cfg.ThrottleCooldownWindow = d
+		log.WithField("ThrottleCooldownWindow", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("THROTTLE_COOLDOWN")
+	if len([]rune(unwrap)) != 0 {
+		d, err := time.ParseDuration(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: THROTTLE_COOLDOWN").Error())
+		}
+		cfg.ThrottleCooldown = d
+		log.WithField("ThrottleCooldown", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("DELETE_INTERVAL")
+	if len([]rune(unwrap)) != 0 {
+		d, err := time.ParseDuration(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: DELETE_INTERVAL").Error())
+		}
+		cfg.DeleteInterval = d
+		log.WithField("DeleteInterval", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("MEMBERSHIP_FETCH_CONCURRENCY")
+	if len([]rune(unwrap)) != 0 {
+		n, err := strconv.Atoi(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: MEMBERSHIP_FETCH_CONCURRENCY").Error())
+		}
+		cfg.MembershipFetchConcurrency = n
+		log.WithField("MembershipFetchConcurrency", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("SCIM_UNMARSHAL_RETRIES")
+	if len([]rune(unwrap)) != 0 {
+		n, err := strconv.Atoi(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: SCIM_UNMARSHAL_RETRIES").Error())
+		}
+		cfg.SCIMUnmarshalRetries = n
+		log.WithField("SCIMUnmarshalRetries", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("GOOGLE_MEMBER_FETCH_CONCURRENCY")
+	if len([]rune(unwrap)) != 0 {
+		n, err := strconv.Atoi(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: GOOGLE_MEMBER_FETCH_CONCURRENCY").Error())
+		}
+		cfg.GoogleMemberFetchConcurrency = n
+		log.WithField("GoogleMemberFetchConcurrency", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("PLAN_S3_URI")
+	if len([]rune(unwrap)) != 0 {
+		cfg.PlanS3URI = unwrap
+		log.WithField("PlanS3URI", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("CORRELATION_CACHE_S3_URI")
+	if len([]rune(unwrap)) != 0 {
+		cfg.CorrelationCacheS3URI = unwrap
+		log.WithField("CorrelationCacheS3URI", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("CORRELATION_CACHE_MAX_AGE")
+	if len([]rune(unwrap)) != 0 {
+		n, err := strconv.Atoi(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: CORRELATION_CACHE_MAX_AGE").Error())
+		}
+		cfg.CorrelationCacheMaxAge = n
+		log.WithField("CorrelationCacheMaxAge", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("CHECKPOINT_TABLE")
+	if len([]rune(unwrap)) != 0 {
+		cfg.CheckpointTable = unwrap
+		log.WithField("CheckpointTable", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("CHECKPOINT_BUCKET")
+	if len([]rune(unwrap)) != 0 {
+		cfg.CheckpointBucket = unwrap
+		log.WithField("CheckpointBucket", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("VERIFY_EQUAL_MEMBERS")
+	if len([]rune(unwrap)) != 0 {
+		cfg.VerifyEqualMembers = unwrap
+		log.WithField("VerifyEqualMembers", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("VERIFY_EQUAL_MEMBERS_PERCENT")
+	if len([]rune(unwrap)) != 0 {
+		n, err := strconv.Atoi(unwrap)
+		if err != nil {
+			log.Fatalf(errors.Wrap(err, "cannot read config: VERIFY_EQUAL_MEMBERS_PERCENT").Error())
+		}
+		cfg.VerifyEqualMembersPercent = n
+		log.WithField("VerifyEqualMembersPercent", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("EXPORT_MAPPINGS")
+	if len([]rune(unwrap)) != 0 {
+		cfg.ExportMappings = unwrap
+		log.WithField("ExportMappings", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("OUTPUT_PLAN")
+	if len([]rune(unwrap)) != 0 {
+		cfg.OutputPlan = unwrap
+		log.WithField("OutputPlan", unwrap).Debug("from EnvVar")
+	}
+
+	unwrap = os.Getenv("REPORT_S3_URI")
+	if len([]rune(unwrap)) != 0 {
+		cfg.ReportS3URI = unwrap
+		log.WithField("ReportS3URI", unwrap).Debug("from EnvVar")
+	}
+
+}
+
+// addSyncFlags gives the subcommands the same flags as a sync, once they
+// were added to the root command
+func addSyncFlags(cmds ...*cobra.Command) {
+	for _, cmd := range cmds {
+		cmd.Flags().AddFlagSet(rootCmd.Flags())
+	}
 }
 
 func addFlags(cmd *cobra.Command, cfg *config.Config) {
@@ -291,16 +1170,107 @@ func addFlags(cmd *cobra.Command, cfg *config.Config) {
 	rootCmd.PersistentFlags().StringVarP(&cfg.LogLevel, "log-level", "", config.DefaultLogLevel, "log level")
 	rootCmd.Flags().StringVarP(&cfg.SCIMAccessToken, "access-token", "t", "", "AWS SSO SCIM API Access Token")
 	rootCmd.Flags().StringVarP(&cfg.SCIMEndpoint, "endpoint", "e", "", "AWS SSO SCIM API Endpoint")
-	rootCmd.Flags().StringVarP(&cfg.GoogleCredentials, "google-credentials", "c", config.DefaultGoogleCredentials, "path to Google Workspace credentials file")
+	rootCmd.Flags().StringVarP(&cfg.GoogleCredentials, "google-credentials", "c", config.DefaultGoogleCredentials, "path to Google Workspace credentials file, or the AWS Secrets Manager secret holding them as secretsmanager://name or a secret ARN")
+	rootCmd.Flags().StringVar(&cfg.GoogleCredentialsSecret, "google-credentials-secret", "", "name or ARN of an AWS Secrets Manager secret holding the Google Workspace credentials JSON, used instead of --google-credentials")
 	rootCmd.Flags().StringVarP(&cfg.GoogleAdmin, "google-admin", "u", "", "Google Workspace admin user email")
 	rootCmd.Flags().StringSliceVar(&cfg.IgnoreUsers, "ignore-users", []string{}, "ignores these Google Workspace users")
+	rootCmd.Flags().StringSliceVar(&cfg.ProtectedGroups, "protected-groups", config.DefaultProtectedGroups, "AWS groups, by display name or as a /regular expression/ of display names, that are never deleted, renamed or have their members changed, unlike --ignore-groups they are AWS groups, by default the groups created by AWS Control Tower, an empty value protects none")
 	rootCmd.Flags().StringSliceVar(&cfg.IgnoreGroups, "ignore-groups", []string{}, "ignores these Google Workspace groups")
 	rootCmd.Flags().StringSliceVar(&cfg.IncludeGroups, "include-groups", []string{}, "include only these Google Workspace groups, NOTE: only works when --sync-method 'users_groups'")
+	rootCmd.Flags().StringSliceVar(&cfg.IncludeUsers, "include-users", []string{}, "include only these Google Workspace users, on top of the --user-match and --group-match queries, by default all are included, NOTE: only works when --sync-method 'groups'")
 	rootCmd.Flags().StringVarP(&cfg.UserMatch, "user-match", "m", "", "Google Workspace Users filter query parameter, example: 'name:John*' 'name=John Doe,email:admin*', to sync all users in the directory specify '*'. For query syntax and more examples see: https://developers.google.com/admin-sdk/directory/v1/guides/search-users")
 	rootCmd.Flags().StringVarP(&cfg.GroupMatch, "group-match", "g", "*", "Google Workspace Groups filter query parameter, example: 'name:Admin*' 'name=Admins,email:aws-*', to sync all groups (and their member users) specify '*'. For query syntax and more examples see: https://developers.google.com/admin-sdk/directory/v1/guides/search-groups")
+	rootCmd.Flags().BoolVar(&cfg.GoogleMembersIncludeSuspendedSeparately, "google-members-include-suspended-separately", false, "keep suspended Google group members in the AWS groups they already belong to instead of removing them, without adding them to any group, their users are still synced as inactive, only the groups sync method uses it")
+	rootCmd.Flags().BoolVar(&cfg.TraceQueries, "trace-queries", false, "log the filter, as sent, and the number of results of each SCIM user and group lookup at info level, to find why an existing user or group isn't matched and gets created again")
+	rootCmd.Flags().BoolVar(&cfg.SyncAllEmails, "sync-all-emails", false, "send all the emails of the Google Workspace users, the primary email stays the only primary one, by default only the primary email is sent")
+	rootCmd.Flags().BoolVar(&cfg.SyncAllPhones, "sync-all-phones", false, "send all the phone numbers of the Google Workspace users, the one flagged primary or else the first stays the only primary one, by default only that one is sent")
+	rootCmd.Flags().BoolVar(&cfg.SyncTitle, "sync-title", false, "set the SCIM title of the AWS users to the title of their primary Google Workspace organization, the users are updated when it changes, --sync-method 'users_groups' only sends it when the status of a user changes")
+	rootCmd.Flags().StringVar(&cfg.UserTypeSource, "user-type-source", config.DefaultUserTypeSource, "set the SCIM userType of the AWS users from this attribute of their primary Google Workspace organization (none|employee-type|department|cost-center), the users are updated when it changes, --sync-method 'users_groups' only sends it when the status of a user changes")
+	rootCmd.Flags().BoolVar(&cfg.SyncLocale, "sync-locale", false, "set the SCIM locale of the AWS users to the code of their first Google Workspace language, such as en-GB, the users are updated when it changes, --sync-method 'users_groups' only sends it when the status of a user changes")
+	rootCmd.Flags().BoolVar(&cfg.SyncPreferredLanguage, "sync-preferred-language", false, "set the SCIM preferredLanguage of the AWS users to the code of their first Google Workspace language, the users are updated when it changes, --sync-method 'users_groups' only sends it when the status of a user changes")
+	rootCmd.Flags().StringVar(&cfg.TimezoneField, "timezone-field", "", "set the SCIM timezone of the AWS users from this schema.field of their Google Workspace custom schemas, such as Profile.timezone, requires --google-list-projection full or custom, --sync-method 'users_groups' only sends it when the status of a user changes")
+	rootCmd.Flags().StringSliceVar(&cfg.SyncAttributes, "sync-attributes", []string{}, "only send and compare these SCIM user attributes (name|displayName|active|emails|addresses|phoneNumbers|title|userType|locale|timezone|preferredLanguage), phoneNumbers is the primary phone number, userName is always sent, updated users are then always patched, by default all are managed")
 	rootCmd.Flags().StringVarP(&cfg.SyncMethod, "sync-method", "s", config.DefaultSyncMethod, "Sync method to use (users_groups|groups)")
 	rootCmd.Flags().StringVarP(&cfg.Region, "region", "r", "", "AWS Region where AWS SSO is enabled")
 	rootCmd.Flags().StringVarP(&cfg.IdentityStoreID, "identity-store-id", "i", "", "Identifier of Identity Store in AWS SSO")
+	rootCmd.Flags().StringVar(&cfg.EmptyGroupAction, "empty-group-action", config.DefaultEmptyGroupAction, "what to do with the AWS members of a Google group that is confirmed to have no members (remove|keep), groups whose members could not be fetched are never emptied")
+	rootCmd.Flags().IntVar(&cfg.GoogleMemberFetchConcurrency, "google-member-fetch-concurrency", config.DefaultGoogleMemberFetchConcurrency, "number of Google Workspace groups whose members are fetched in parallel, each fetch keeps its own retries")
+	rootCmd.Flags().BoolVar(&cfg.GoogleGroupQueryExpansion, "google-group-query-expansion", false, "combine the comma separated --group-match queries into as few Google Workspace API calls as possible, dropping duplicates and queries covered by a broader prefix query")
+	rootCmd.Flags().IntVar(&cfg.UserDeleteConcurrency, "user-delete-concurrency", config.DefaultUserDeleteConcurrency, "number of AWS users of deleted Google Workspace users deleted in parallel, identity store calls keep their retries, NOTE: only works when --sync-method 'users_groups'")
+	rootCmd.Flags().DurationVar(&cfg.IncrementalSince, "incremental-since", 0, "only create and update the Google Workspace users created or logged in within this duration, such as 24h, deleted users are still all deleted, changes made without a login are missed until a full sync so run one regularly, NOTE: only works when --sync-method 'users_groups'")
+	rootCmd.Flags().BoolVar(&cfg.ContinueOnUserDeleteError, "continue-on-user-delete-error", false, "log failed deletions of AWS users of deleted Google Workspace users and keep deleting the others, the run still fails at the end with all the errors, NOTE: only works when --sync-method 'users_groups'")
+	rootCmd.Flags().BoolVar(&cfg.FailOnPlanConflicts, "fail-on-plan-conflicts", false, "abort the sync before any change when its operations contradict each other, such as a member added to a deleted group, by default they are only logged, only the groups sync method plans its changes")
+	rootCmd.Flags().StringVar(&cfg.UserUpdateStrategy, "user-update-strategy", config.DefaultUserUpdateStrategy, "how updated users are sent to the SCIM endpoint (replace|patch), patch only sends the attributes that changed")
+	rootCmd.Flags().StringVar(&cfg.UserDeleteStrategy, "user-delete-strategy", config.DefaultUserDeleteStrategy, "what to do with the AWS users of deleted Google Workspace users and, with --unmanaged-user-action delete, of the users missing from Google Workspace (delete|deactivate), deactivate sets them inactive and keeps them with their permission set assignments")
+	rootCmd.Flags().IntVar(&cfg.GooglePageSize, "google-page-size", 0, "number of results per page of the Google Workspace list calls (1-500), groups and members are listed by at most 200, fewer pages mean fewer calls, by default the API page size is used")
+	rootCmd.Flags().StringVar(&cfg.GoogleListProjection, "google-list-projection", config.DefaultGoogleListProjection, "subset of fields fetched for Google Workspace users (basic|full|custom), full and custom also fetch their custom schemas")
+	rootCmd.Flags().StringVar(&cfg.GoogleCustomFieldMask, "google-custom-field-mask", "", "comma separated custom schemas fetched with --google-list-projection custom")
+	rootCmd.Flags().IntSliceVar(&cfg.GoogleRetryCodes, "google-retry-on-specific-codes", config.DefaultGoogleRetryCodes, "HTTP status codes from the Google Workspace API that are retried, any other error fails immediately, a 403 is only retried for quota and rate limit errors, not for missing permissions")
+	rootCmd.Flags().BoolVar(&cfg.SCIMDisableCreateFallbackFind, "scim-disable-create-fallback-find", false, "treat a SCIM create user response without an id as an error, instead of looking the user up by email")
+	rootCmd.Flags().IntVar(&cfg.MinGroupMembers, "min-group-members", 0, "skip the Google groups with fewer members than this once ignored and not included users are left out, their AWS groups are neither created, changed nor deleted, 0 syncs all groups, only the groups sync method uses it")
+	rootCmd.Flags().IntVar(&cfg.MaxUsers, "max-users", 0, "abort the sync when Google Workspace returns more users than this, 0 means no limit")
+	rootCmd.Flags().BoolVar(&cfg.AllowEmptySource, "allow-empty-source", false, "continue when Google Workspace returns no users or no groups while AWS has some, deleting them all, by default this is treated as an upstream failure")
+	rootCmd.Flags().IntVar(&cfg.MaxDeletions, "max-deletions", 0, "abort the sync before deleting anything when it would delete more AWS users and groups than this, 0 means no limit")
+	rootCmd.Flags().IntVar(&cfg.MaxDeletionsPercent, "max-deletions-percent", 0, "abort the sync before deleting anything when it would delete more than this percentage of the AWS users and groups, 0 means no limit")
+	rootCmd.Flags().IntVar(&cfg.ReconcileChunkSize, "reconcile-chunk-size", 0, "compare users in alphabetical batches of this many Google users, all the users are still read first so memory is not bounded, 0 compares all at once")
+	rootCmd.Flags().BoolVar(&cfg.EmitMetrics, "emit-metrics", false, "print the counts of the changes and the duration of the run as a CloudWatch Embedded Metric Format line, in the SSOSync namespace, for Lambda deployments to get them as metrics")
+	rootCmd.Flags().StringVar(&cfg.AuditLogPath, "audit-log-path", "", "append a JSON line to this file for each user, group and membership created, updated or deleted in AWS, with the AWS ids and a timestamp")
+	rootCmd.Flags().BoolVar(&cfg.VerbosePlan, "verbose-plan", false, "log each user, group and membership change of the plan at info level before any change is made, only the groups sync method plans its changes")
+	rootCmd.Flags().BoolVar(&cfg.PlanSummary, "plan-summary", false, "print a table of the planned changes to stdout before any change is made, with the count and the first names of the created, updated and deleted users and groups and the added and removed members, only the groups sync method plans its changes")
+	rootCmd.Flags().StringVar(&cfg.ExportMappings, "export-mappings", "", "write the Google id and email of each synced user and group with the id of its AWS user or group as JSON to this file after the sync, only the groups sync method exports them")
+	rootCmd.Flags().StringVar(&cfg.OutputPlan, "output-plan", "", "write the planned user, group and membership changes as JSON to this file before applying them, only the groups sync method plans its changes")
+	rootCmd.Flags().StringVar(&cfg.CorrelationCacheS3URI, "correlation-cache-s3-uri", "", "cache the AWS users and groups at this s3://bucket/key so the next runs don't list the whole Identity Store, the users and groups changed by the sync are fetched again, the cache is removed after a failed run, only the groups sync method uses it")
+	rootCmd.Flags().StringVar(&cfg.CheckpointTable, "checkpoint-table", "", "DynamoDB table, with the string partition key identityStoreId, the hashes of the users and groups applied by the last successful run are kept in, users and groups unchanged in Google since are not looked up through SCIM so changes made to them outside of ssosync are not seen, needs dynamodb:GetItem and dynamodb:PutItem, only the groups sync method uses it")
+	rootCmd.Flags().StringVar(&cfg.CheckpointBucket, "checkpoint-bucket", "", "s3://bucket/key the hashes of the users and groups applied by the last successful run are kept at, in place of --checkpoint-table")
+	rootCmd.Flags().StringVar(&cfg.VerifyEqualMembers, "verify-equal-members", config.DefaultVerifyEqualMembers, "how many members of the groups unchanged in Google since the --checkpoint-table or --checkpoint-bucket checkpoint are confirmed with the Identity Store (none|sample|all), members lost in AWS outside of ssosync are added again, other groups always have all their members confirmed")
+	rootCmd.Flags().IntVar(&cfg.VerifyEqualMembersPercent, "verify-equal-members-percent", config.DefaultVerifyEqualMembersPercent, "percentage of the members of unchanged groups confirmed on each run with --verify-equal-members sample")
+	rootCmd.Flags().IntVar(&cfg.CorrelationCacheMaxAge, "correlation-cache-max-age", config.DefaultCorrelationCacheMaxAge, "minutes after which the Identity Store is listed again instead of using the correlation cache, changes made outside of ssosync are only seen then, 0 means no limit")
+	rootCmd.Flags().StringVar(&cfg.PlanS3URI, "plan-s3-uri", "", "write the planned user, group and membership changes as JSON to this s3://bucket/key before applying them, only the groups sync method plans its changes")
+	rootCmd.Flags().BoolVar(&cfg.PurgeOrphanedMemberships, "purge-orphaned-memberships", false, "remove the remaining members of an AWS group deleted in Google before deleting the group, a group whose members can't all be removed is kept, only the groups sync method deletes groups")
+	rootCmd.Flags().StringVar(&cfg.ReportS3URI, "report-s3-uri", "", "write the report of the run, such as the unresolved group members, as JSON to this s3://bucket/key")
+	rootCmd.Flags().BoolVar(&cfg.ReportPermissionSetImpact, "report-permission-set-impact", false, "before deleting, list the permission set assignments lost by each deleted user and group and removed member through SSO Admin, reported in the plan, summary and report, needs sso:ListInstances, sso:ListPermissionSets, sso:ListAccountsForProvisionedPermissionSet and sso:ListAccountAssignments, only the groups sync method reports it")
+	rootCmd.Flags().BoolVar(&cfg.ReportDrift, "report-drift", false, "list the AWS users and groups with no counterpart in Google, such as ones created by hand, and what the sync does with each, including the ones it leaves alone, reported in the logs, summary and report, only the groups sync method reports it")
+	rootCmd.Flags().StringVar(&cfg.SSOInstanceArn, "sso-instance-arn", "", "ARN of the IAM Identity Center instance the permission set assignments are listed from, by default the instance of the account")
+	rootCmd.Flags().BoolVar(&cfg.ReportUnresolvedMembers, "report-unresolved-members", false, "log, per group, the Google group members that could not be resolved to a user (external, ignored, missing or failed to fetch)")
+	rootCmd.Flags().StringVar(&cfg.GroupNamePrefix, "group-name-prefix", "", "prepend this to the Google group name to name its AWS group, AWS groups without the prefix are left alone")
+	rootCmd.Flags().StringVar(&cfg.GroupNameSuffix, "group-name-suffix", "", "append this to the Google group name to name its AWS group, AWS groups without the suffix are left alone")
+	rootCmd.Flags().StringVar(&cfg.GroupDisplayNameSource, "group-display-name-source", "", "Google group attribute AWS groups are named by, for both sync methods (email|name), by default users_groups names groups by email and groups by name")
+	rootCmd.Flags().BoolVar(&cfg.MigrateGroupNames, "migrate-group-names", false, "rename AWS groups created by the other --sync-method (named by group email for users_groups, by group name for groups) to the current naming, instead of creating duplicates")
+	rootCmd.Flags().IntVar(&cfg.ThrottleCooldownThreshold, "throttle-cooldown-threshold", 0, "pause every call to the SCIM endpoint and the Identity Store once this many of them were throttled within --throttle-cooldown-window, for --throttle-cooldown, instead of retrying straight away, 0 disables")
+	rootCmd.Flags().DurationVar(&cfg.ThrottleCooldownWindow, "throttle-cooldown-window", config.DefaultThrottleCooldownWindow, "time the throttled SCIM and Identity Store calls are counted over for --throttle-cooldown-threshold")
+	rootCmd.Flags().DurationVar(&cfg.ThrottleCooldown, "throttle-cooldown", config.DefaultThrottleCooldown, "how long the SCIM and Identity Store calls are paused for once --throttle-cooldown-threshold is reached")
+	rootCmd.Flags().DurationVar(&cfg.DeleteInterval, "delete-interval", 0, "minimum time between the user and group deletions and the member removals, also across --user-delete-concurrency workers, to smooth out bursts of them before they are throttled, 0 doesn't space them out")
+	rootCmd.Flags().IntVar(&cfg.IdentityStoreMaxRetries, "identity-store-max-retries", config.DefaultIdentityStoreMaxRetries, "number of times a throttled or failed Identity Store call is retried with exponential backoff, on top of the AWS SDK retries, creates are only retried when throttled, 0 disables")
+	rootCmd.Flags().IntVar(&cfg.MembershipFetchConcurrency, "membership-fetch-concurrency", config.DefaultMembershipFetchConcurrency, "number of AWS groups whose members are fetched from the Identity Store in parallel")
+	rootCmd.Flags().IntVar(&cfg.MaxErrors, "max-errors", 0, "with --continue-on-member-error, abort the run once more than this many group membership changes failed, 0 means no limit")
+	rootCmd.Flags().BoolVar(&cfg.ContinueOnMemberError, "continue-on-member-error", false, "log failed group membership changes and keep syncing the remaining groups, the run still fails at the end with all the errors")
+	rootCmd.Flags().StringSliceVar(&cfg.GoogleDelegationSubjects, "google-delegation-subject-per-operation", []string{}, "override the Google Workspace admin impersonated for an operation, as operation=subject (users|groups), operations without an override use --google-admin")
+	rootCmd.Flags().StringSliceVar(&cfg.MembershipBackends, "membership-backend", []string{}, "route the group member changes of the AWS groups whose name matches a pattern to an API, as pattern=backend (scim|identitystore), the pattern is a glob such as 'eng-*', the first match wins, other groups use the Identity Store, members are always listed through the Identity Store")
+	rootCmd.Flags().StringSliceVar(&cfg.OnlyPhases, "only", []string{}, "only apply the changes of these phases (users|groups|members), such as members to only add and remove group members, the other changes are left out of the plan and of the deletion limits, only the groups sync method supports phases")
+	rootCmd.Flags().StringVar(&cfg.UserBackend, "user-backend", config.DefaultUserBackend, "API used to create users (scim|identitystore), suspended users created through the Identity Store are then disabled through SCIM, users are always deleted through the Identity Store")
+	rootCmd.Flags().StringVar(&cfg.SourceProvider, "source-provider", config.DefaultSourceProvider, "directory users and groups are synced from (google|okta|file), okta needs --okta-org-url and --okta-api-token and its queries are Okta search expressions, file reads --source-file")
+	rootCmd.Flags().StringVar(&cfg.SourceFile, "source-file", "", "JSON or CSV export of the directory synced from with --source-provider file, its queries are comma separated email or name clauses such as 'email:aws-*', see the README for the file formats")
+	rootCmd.Flags().StringVar(&cfg.OktaOrgURL, "okta-org-url", "", "URL of the Okta org synced from with --source-provider okta, such as https://example.okta.com")
+	rootCmd.Flags().StringVar(&cfg.OktaAPIToken, "okta-api-token", "", "API token of the Okta org synced from with --source-provider okta, it needs read access to users and groups, in Lambda OKTA_API_TOKEN is the ARN of the AWS Secrets Manager secret holding it (default secret SSOSyncOktaAPIToken)")
+	rootCmd.Flags().StringVar(&cfg.TransitionalGroupAction, "transitional-group-action", config.DefaultTransitionalGroupAction, "what to do with the AWS group of a Google group that is listed but whose members can't be found as it's being deleted (skip|deactivate|delete), deactivate removes its AWS members and keeps the group, only the groups sync method handles it")
+	rootCmd.Flags().StringVar(&cfg.InvalidUserAction, "invalid-user-action", config.DefaultInvalidUserAction, "what to do with users missing a field required by SCIM, such as an empty given or family name (skip|fail)")
+	rootCmd.Flags().BoolVar(&cfg.SyncManager, "sync-manager", false, "set the SCIM enterprise manager of each AWS user to the AWS user of their Google Workspace manager relation, only the groups sync method syncs managers")
+	rootCmd.Flags().StringVar(&cfg.ManagerKey, "manager-key", config.DefaultManagerKey, "the attribute of the Google Workspace users their reports' manager relation holds (email|employeeNumber), employeeNumber is the employee id of the user and only resolves managers that are synced, used by --sync-manager")
+	rootCmd.Flags().BoolVar(&cfg.BackfillExternalIDs, "backfill-external-ids", false, "set the SCIM externalId of each managed AWS user lacking one to the id of their Google user before the sync, and of the created users, only the groups sync method backfills external ids")
+	rootCmd.Flags().BoolVar(&cfg.SyncGroupMetadataOnly, "sync-group-metadata-only", false, "only create, rename (with --migrate-group-names) and delete AWS groups to match the Google groups, named as --sync-method names them, users and group members are left untouched, users_groups never deletes groups")
+	rootCmd.Flags().BoolVar(&cfg.SyncGroupAliases, "sync-group-aliases", false, "also sync each alias of a Google group as its own AWS group, named by the alias, with the same members")
+	rootCmd.Flags().StringVar(&cfg.UnmanagedUserAction, "unmanaged-user-action", config.DefaultUnmanagedUserAction, "what to do with AWS users that have no matching Google user, such as users created directly in IAM Identity Center (delete|disable|ignore)")
+	rootCmd.Flags().IntVar(&cfg.SCIMUnmarshalRetries, "scim-unmarshal-retries", config.DefaultSCIMUnmarshalRetries, "number of times a SCIM read is retried when its successful response can't be decoded, usually a truncated body, 0 disables")
+	rootCmd.Flags().DurationVar(&cfg.SCIMTimeout, "scim-timeout", 0, "time limit of each attempt of a call to the SCIM endpoint, such as 30s, a timed out attempt is retried, 0 means no limit")
+	rootCmd.Flags().DurationVar(&cfg.SyncTimeout, "sync-timeout", 0, "abort the sync with a timeout error when it runs longer than this, such as 10m, set it below the Lambda timeout to fail cleanly rather than be killed, 0 means no limit")
+	rootCmd.Flags().IntVar(&cfg.SCIMConnectionPoolSize, "scim-connection-pool-size", 0, "number of idle connections kept open to the SCIM endpoint, 0 keeps the Go defaults")
+	rootCmd.Flags().StringVar(&cfg.SCIMClientCert, "scim-client-cert", "", "PEM file of the client certificate presented to the SCIM endpoint, for gateways requiring mutual TLS, requires --scim-client-key")
+	rootCmd.Flags().StringVar(&cfg.SCIMClientKey, "scim-client-key", "", "PEM file of the private key of the --scim-client-cert client certificate")
+	rootCmd.Flags().StringVar(&cfg.SCIMCACert, "scim-ca-cert", "", "PEM file of the CA certificates the SCIM endpoint certificate is verified with instead of the system ones")
+	rootCmd.Flags().StringVar(&cfg.HTTPProxy, "http-proxy", "", "proxy of the http calls to the SCIM endpoint, such as http://proxy.example.com:3128, the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used when no proxy is set")
+	rootCmd.Flags().StringVar(&cfg.HTTPSProxy, "https-proxy", "", "proxy of the https calls to the SCIM endpoint, such as http://proxy.example.com:3128")
+	rootCmd.Flags().StringVar(&cfg.NoProxy, "no-proxy", "", "comma separated hosts, domains and CIDRs called without the proxy")
+	rootCmd.Flags().StringVar(&cfg.SCIMVerifyTLSMinVersion, "scim-verify-tls-min-version", config.DefaultSCIMVerifyTLSMinVersion, "minimum TLS version accepted from the AWS SSO SCIM API Endpoint (1.0|1.1|1.2|1.3)")
 }
 
 func logConfig(cfg *config.Config) {
@@ -0,0 +1,43 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+
+	"github.com/awslabs/ssosync/internal"
+	"github.com/spf13/cobra"
+)
+
+// validate checks the config and connections, it's swapped in tests
+var validate = internal.Validate
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the configuration and the connections without syncing",
+	Long: `Checks the configuration, then makes a test call to the Identity Store
+and the SCIM endpoint to confirm the credentials work. Nothing is synced,
+the exit code tells whether the configuration is usable.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		return validate(ctx, cfg)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Operations recorded in the audit log
+const (
+	auditCreateUser   = "create_user"
+	auditUpdateUser   = "update_user"
+	auditDeleteUser   = "delete_user"
+	auditCreateGroup  = "create_group"
+	auditUpdateGroup  = "update_group"
+	auditDeleteGroup  = "delete_group"
+	auditAddMember    = "add_member"
+	auditRemoveMember = "remove_member"
+)
+
+// auditRecord is a change made in AWS, users are identified by their user
+// name, the google primary email, and groups by their display name. The
+// user operations carry the external id of the user when it has one, and
+// the updates of users the reasons they were made.
+type auditRecord struct {
+	Time       time.Time      `json:"time"`
+	Operation  string         `json:"operation"`
+	User       string         `json:"user,omitempty"`
+	UserID     string         `json:"userId,omitempty"`
+	ExternalID string         `json:"externalId,omitempty"`
+	Group      string         `json:"group,omitempty"`
+	GroupID    string         `json:"groupId,omitempty"`
+	Reasons    []updateReason `json:"reasons,omitempty"`
+}
+
+// auditLog writes a json line for each change made in AWS, a nil log
+// records nothing
+type auditLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	now func() time.Time
+}
+
+// newAuditLog returns an audit log writing to w
+func newAuditLog(w io.Writer) *auditLog {
+	return &auditLog{enc: json.NewEncoder(w), now: time.Now}
+}
+
+// record writes the change, a failed write is logged rather than failing a
+// change that was already made
+func (a *auditLog) record(r auditRecord) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	r.Time = a.now().UTC()
+	if err := a.enc.Encode(r); err != nil {
+		log.WithFields(log.Fields{"error": err, "operation": r.Operation}).Warn("writing audit record")
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_auditLog(t *testing.T) {
+	var buf bytes.Buffer
+	a := newAuditLog(&buf)
+	a.now = func() time.Time { return time.Date(2022, 9, 1, 10, 0, 0, 0, time.UTC) }
+
+	a.record(auditRecord{Operation: auditAddMember, User: "user-1@email.com", UserID: "id-user-1", Group: "group-1", GroupID: "group-1"})
+	assert.JSONEq(t, `{"time": "2022-09-01T10:00:00Z", "operation": "add_member", "user": "user-1@email.com", "userId": "id-user-1", "group": "group-1", "groupId": "group-1"}`, buf.String())
+
+	// the user operations record the external id, and the updates their reasons
+	buf.Reset()
+	a.record(auditRecord{Operation: auditUpdateUser, User: "user-1@email.com", UserID: "id-user-1", ExternalID: "g-user-1", Reasons: []updateReason{updateReasonExternalID}})
+	assert.JSONEq(t, `{"time": "2022-09-01T10:00:00Z", "operation": "update_user", "user": "user-1@email.com", "userId": "id-user-1", "externalId": "g-user-1", "reasons": ["external id change"]}`, buf.String())
+
+	// a nil log records nothing
+	var none *auditLog
+	none.record(auditRecord{Operation: auditDeleteUser})
+}
@@ -16,13 +16,19 @@ package aws
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -34,6 +40,10 @@ var (
 	ErrGroupNotFound     = errors.New("group not found")
 	// ErrUserNotSpecified
 	ErrUserNotSpecified  = errors.New("user not specified")
+	// ErrUserIDMissing
+	ErrUserIDMissing     = errors.New("create user response did not include an id")
+	// ErrUserFieldEmpty
+	ErrUserFieldEmpty    = errors.New("required user field is empty")
 )
 
 // ErrHTTPNotOK
@@ -45,6 +55,55 @@ func (e *ErrHTTPNotOK) Error() string {
 	return fmt.Sprintf("status of http response was %d", e.StatusCode)
 }
 
+// ErrRateLimited is returned when the SCIM endpoint throttled the request,
+// RetryAfter is how long it asked to wait, 0 when it didn't say
+type ErrRateLimited struct {
+	RetryAfter time.Duration
+}
+
+func (e *ErrRateLimited) Error() string {
+	return fmt.Sprintf("status of http response was %d, retry after %s", http.StatusTooManyRequests, e.RetryAfter)
+}
+
+// Unwrap returns the ErrHTTPNotOK of the throttled response
+func (e *ErrRateLimited) Unwrap() error {
+	return &ErrHTTPNotOK{StatusCode: http.StatusTooManyRequests}
+}
+
+// statusError returns the error of a non-2xx response, nil otherwise
+func statusError(resp *http.Response) error {
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode <= http.StatusNoContent {
+		return nil
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &ErrRateLimited{RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	return &ErrHTTPNotOK{resp.StatusCode}
+}
+
+// parseRetryAfter returns the wait of a Retry-After header, given either
+// in seconds or as a date, 0 when it's missing or can't be read
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
 // OperationType handle patch operations for add/remove
 type OperationType string
 
@@ -54,21 +113,49 @@ const (
 
 	// OperationRemove is the remove operation for a patch
 	OperationRemove = "remove"
+
+	// OperationReplace is the replace operation for a patch
+	OperationReplace = "replace"
 )
 
+// ManagerPath is the patch path of the manager of a user
+const ManagerPath = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:manager"
+
+// ExternalIDPath is the patch path of the external id of a user
+const ExternalIDPath = "externalId"
+
 // Client represents an interface of methods used
-// to communicate with AWS SSO
+// to communicate with AWS SSO, the requests stop with their context
 type Client interface {
-	CreateUser(*User) (*User, error)
-	FindGroupByDisplayName(string) (*Group, error)
-	FindUserByEmail(string) (*User, error)
-	UpdateUser(*User) (*User, error)
+	AddUserToGroup(context.Context, *User, *Group) error
+	CheckConnection(context.Context) error
+	CreateUser(context.Context, *User) (*User, error)
+	FindGroupByDisplayName(context.Context, string) (*Group, error)
+	FindUserByEmail(context.Context, string) (*User, error)
+	FindUsersByEmails(context.Context, []string) (map[string]*User, error)
+	RemoveUserFromGroup(context.Context, *User, *Group) error
+	UpdateGroupDisplayName(context.Context, *Group, string) error
+	UpdateUser(context.Context, *User) (*User, error)
+	UpdateUserExternalID(context.Context, *User, string) error
+	UpdateUserManager(context.Context, *User, string) error
 }
 
 type client struct {
 	httpClient  HTTPClient
 	endpointURL *url.URL
 	bearerToken string
+
+	disableCreateFallbackFind bool
+	attributes                []string
+	unmarshalRetries          int
+	patchUpdates              bool
+	traceQueries              bool
+	syncedAttributes          []string
+
+	// groups caches the groups found by display name for the run, the
+	// client is created per run
+	groupsMu sync.Mutex
+	groups   map[string]Group
 }
 
 // NewClient creates a new client to talk with AWS SSO's SCIM endpoint. It
@@ -83,12 +170,40 @@ func NewClient(c HTTPClient, config *Config) (Client, error) {
 		httpClient:  c,
 		endpointURL: u,
 		bearerToken: config.Token,
+
+		disableCreateFallbackFind: config.DisableCreateFallbackFind,
+		attributes:                config.Attributes,
+		unmarshalRetries:          config.UnmarshalRetries,
+		patchUpdates:              config.PatchUpdates || len(config.Attributes) > 0,
+		traceQueries:              config.TraceQueries,
+		syncedAttributes:          config.SyncedAttributes,
+		groups:                    make(map[string]Group),
 	}, nil
 }
 
+// traceQuery logs the filter of a lookup as it was sent and the number of
+// results, when queries are traced
+func (c *client) traceQuery(resource string, filter string, query string, results int) {
+	if !c.traceQueries {
+		return
+	}
+
+	log.WithFields(log.Fields{"resource": resource, "filter": filter, "query": query, "totalResults": results}).Info("scim query")
+}
+
+// userBody returns the request body for the user, limited to the
+// configured attributes
+func (c *client) userBody(u *User) (interface{}, error) {
+	if len(c.attributes) == 0 {
+		return *u, nil
+	}
+
+	return FilterUserAttributes(u, c.attributes)
+}
+
 // sendRequestWithBody will send the body given to the url/method combination
 // with the right Bearer token as well as the correct content type for SCIM.
-func (c *client) sendRequestWithBody(method string, url string, body interface{}) (response []byte, err error) {
+func (c *client) sendRequestWithBody(ctx context.Context, method string, url string, body interface{}) (response []byte, err error) {
 	// Convert the body to JSON
 	d, err := json.Marshal(body)
 	if err != nil {
@@ -96,7 +211,7 @@ func (c *client) sendRequestWithBody(method string, url string, body interface{}
 	}
 
 	// Create a request with our body of JSON
-	r, err := http.NewRequest(method, url, bytes.NewBuffer(d))
+	r, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(d))
 	if err != nil {
 		return
 	}
@@ -121,15 +236,13 @@ func (c *client) sendRequestWithBody(method string, url string, body interface{}
 	}
 
 	// If we get a non-2xx status code, raise that via an error
-	if resp.StatusCode < http.StatusOK || resp.StatusCode > http.StatusNoContent {
-		err = &ErrHTTPNotOK{resp.StatusCode}
-	}
+	err = statusError(resp)
 
 	return
 }
 
-func (c *client) sendRequest(method string, url string) (response []byte, err error) {
-	r, err := http.NewRequest(method, url, nil)
+func (c *client) sendRequest(ctx context.Context, method string, url string) (response []byte, err error) {
+	r, err := http.NewRequestWithContext(ctx, method, url, nil)
 	if err != nil {
 		return
 	}
@@ -149,38 +262,74 @@ func (c *client) sendRequest(method string, url string) (response []byte, err er
 		return
 	}
 
-	if resp.StatusCode < http.StatusOK || resp.StatusCode > http.StatusNoContent {
-		err = fmt.Errorf("status of http response was %d", resp.StatusCode)
-	}
+	err = statusError(resp)
 
 	return
 }
 
-// FindUserByEmail will find the user by the email address specified
-func (c *client) FindUserByEmail(email string) (*User, error) {
+// getJSON sends a GET to the url and decodes the response into v. A 2xx
+// response that can't be decoded was most likely truncated, so the GET is
+// re-issued up to the configured number of retries.
+func (c *client) getJSON(ctx context.Context, url string, v interface{}) error {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.sendRequest(ctx, http.MethodGet, url)
+		if err != nil {
+			return err
+		}
+
+		err = json.Unmarshal(resp, v)
+		if err == nil || attempt >= c.unmarshalRetries || !isTruncated(err) {
+			return err
+		}
+
+		log.WithFields(log.Fields{"url": url, "error": err, "attempt": attempt + 1}).Warn("retrying truncated scim response")
+	}
+}
+
+// isTruncated reports whether the json error is caused by an incomplete body
+func isTruncated(err error) bool {
+	var syntaxErr *json.SyntaxError
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &syntaxErr)
+}
+
+// CheckConnection lists a single user to confirm the endpoint and token work
+func (c *client) CheckConnection(ctx context.Context) error {
 	startURL, err := url.Parse(c.endpointURL.String())
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	filter := fmt.Sprintf("userName eq \"%s\"", email)
-
 	startURL.Path = path.Join(startURL.Path, "/Users")
 	q := startURL.Query()
-	q.Add("filter", filter)
+	q.Add("count", "1")
 
 	startURL.RawQuery = q.Encode()
 
-	resp, err := c.sendRequest(http.MethodGet, startURL.String())
+	var r UserFilterResults
+	return c.getJSON(ctx, startURL.String(), &r)
+}
+
+// FindUserByEmail will find the user by the email address specified
+func (c *client) FindUserByEmail(ctx context.Context, email string) (*User, error) {
+	startURL, err := url.Parse(c.endpointURL.String())
 	if err != nil {
 		return nil, err
 	}
 
+	filter := eqFilter("userName", email)
+
+	startURL.Path = path.Join(startURL.Path, "/Users")
+	q := startURL.Query()
+	q.Add("filter", filter)
+
+	startURL.RawQuery = q.Encode()
+
 	var r UserFilterResults
-	err = json.Unmarshal(resp, &r)
+	err = c.getJSON(ctx, startURL.String(), &r)
 	if err != nil {
 		return nil, err
 	}
+	c.traceQuery("Users", filter, startURL.RawQuery, r.TotalResults)
 
 	if r.TotalResults != 1 {
 		return nil, ErrUserNotFound
@@ -189,41 +338,175 @@ func (c *client) FindUserByEmail(email string) (*User, error) {
 	return &r.Resources[0], nil
 }
 
-// FindGroupByDisplayName will find the group by its displayname.
-func (c *client) FindGroupByDisplayName(name string) (*Group, error) {
+// maxEmailsPerFilter and maxFilterLength bound the or filters of
+// FindUsersByEmails, so the results fit a page and the url stays short
+const (
+	maxEmailsPerFilter = 10
+	maxFilterLength    = 1500
+)
+
+// FindUsersByEmails will find the users by the email addresses specified,
+// with or filters of several emails each, the map is keyed by the emails
+// given and those without a user are left out. Endpoints that reject or
+// filters are asked for the users one by one.
+func (c *client) FindUsersByEmails(ctx context.Context, emails []string) (map[string]*User, error) {
+	users := make(map[string]*User)
+	for _, chunk := range chunkEmails(emails, maxEmailsPerFilter, maxFilterLength) {
+		found, err := c.findUsersByFilter(ctx, chunk)
+		var errHTTP *ErrHTTPNotOK
+		if errors.As(err, &errHTTP) && errHTTP.StatusCode == http.StatusBadRequest && len(chunk) > 1 {
+			log.WithField("error", err).Debug("or filter rejected, finding users one by one")
+			found = make(map[string]*User)
+			for _, email := range chunk {
+				u, err := c.FindUserByEmail(ctx, email)
+				if errors.Is(err, ErrUserNotFound) {
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				found[email] = u
+			}
+		} else if err != nil {
+			return nil, err
+		}
+
+		for email, u := range found {
+			users[email] = u
+		}
+	}
+
+	return users, nil
+}
+
+// findUsersByFilter finds the users of the emails with a single or filter
+func (c *client) findUsersByFilter(ctx context.Context, emails []string) (map[string]*User, error) {
 	startURL, err := url.Parse(c.endpointURL.String())
 	if err != nil {
 		return nil, err
 	}
 
-	filter := fmt.Sprintf("displayName eq \"%s\"", name)
+	filter := emailsFilter(emails)
 
-	startURL.Path = path.Join(startURL.Path, "/Groups")
+	startURL.Path = path.Join(startURL.Path, "/Users")
 	q := startURL.Query()
 	q.Add("filter", filter)
 
 	startURL.RawQuery = q.Encode()
 
-	resp, err := c.sendRequest(http.MethodGet, startURL.String())
+	var r UserFilterResults
+	err = c.getJSON(ctx, startURL.String(), &r)
 	if err != nil {
 		return nil, err
 	}
+	c.traceQuery("Users", filter, startURL.RawQuery, r.TotalResults)
+
+	// userName is matched regardless of case
+	byName := make(map[string]*User, len(r.Resources))
+	for i := range r.Resources {
+		byName[strings.ToLower(r.Resources[i].Username)] = &r.Resources[i]
+	}
+
+	users := make(map[string]*User)
+	for _, email := range emails {
+		if u, ok := byName[strings.ToLower(email)]; ok {
+			users[email] = u
+		}
+	}
+
+	return users, nil
+}
+
+// filterEscaper escapes the characters that end or escape a string of a SCIM
+// filter, see https://datatracker.ietf.org/doc/html/rfc7644#section-3.4.2.2
+var filterEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// eqFilter returns the filter matching the resources whose attribute is value
+func eqFilter(attribute string, value string) string {
+	return fmt.Sprintf("%s eq \"%s\"", attribute, filterEscaper.Replace(value))
+}
+
+// emailsFilter returns the filter matching the users of any of the emails
+func emailsFilter(emails []string) string {
+	clauses := make([]string, 0, len(emails))
+	for _, email := range emails {
+		clauses = append(clauses, eqFilter("userName", email))
+	}
+	return strings.Join(clauses, " or ")
+}
+
+// chunkEmails splits the emails into chunks of at most size emails whose
+// filter is at most length characters, an email too long for it is alone
+func chunkEmails(emails []string, size int, length int) [][]string {
+	var chunks [][]string
+	var chunk []string
+	for _, email := range emails {
+		if len(chunk) > 0 && (len(chunk) >= size || len(emailsFilter(append(chunk, email))) > length) {
+			chunks = append(chunks, chunk)
+			chunk = nil
+		}
+		chunk = append(chunk, email)
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks
+}
+
+// FindGroupByDisplayName will find the group by its displayname, groups
+// found before are returned without querying again
+func (c *client) FindGroupByDisplayName(ctx context.Context, name string) (*Group, error) {
+	c.groupsMu.Lock()
+	cached, found := c.groups[name]
+	c.groupsMu.Unlock()
+	if found {
+		return &cached, nil
+	}
+
+	startURL, err := url.Parse(c.endpointURL.String())
+	if err != nil {
+		return nil, err
+	}
+
+	filter := eqFilter("displayName", name)
+
+	startURL.Path = path.Join(startURL.Path, "/Groups")
+	q := startURL.Query()
+	q.Add("filter", filter)
+
+	startURL.RawQuery = q.Encode()
 
 	var r GroupFilterResults
-	err = json.Unmarshal(resp, &r)
+	err = c.getJSON(ctx, startURL.String(), &r)
 	if err != nil {
 		return nil, err
 	}
+	c.traceQuery("Groups", filter, startURL.RawQuery, r.TotalResults)
 
 	if r.TotalResults != 1 {
 		return nil, ErrGroupNotFound
 	}
 
+	// a copy is cached as the callers may change the group returned
+	c.groupsMu.Lock()
+	c.groups[name] = r.Resources[0]
+	c.groupsMu.Unlock()
+
 	return &r.Resources[0], nil
 }
 
+// forgetGroup drops the cached groups of the display names
+func (c *client) forgetGroup(names ...string) {
+	c.groupsMu.Lock()
+	defer c.groupsMu.Unlock()
+	for _, name := range names {
+		delete(c.groups, name)
+	}
+}
+
 // CreateUser will create the user specified
-func (c *client) CreateUser(u *User) (*User, error) {
+func (c *client) CreateUser(ctx context.Context, u *User) (*User, error) {
 	startURL, err := url.Parse(c.endpointURL.String())
 	if err != nil {
 		return nil, err
@@ -234,8 +517,13 @@ func (c *client) CreateUser(u *User) (*User, error) {
 		return nil, err
 	}
 
+	body, err := c.userBody(u)
+	if err != nil {
+		return nil, err
+	}
+
 	startURL.Path = path.Join(startURL.Path, "/Users")
-	resp, err := c.sendRequestWithBody(http.MethodPost, startURL.String(), *u)
+	resp, err := c.sendRequestWithBody(ctx, http.MethodPost, startURL.String(), body)
 	if err != nil {
 		return nil, err
 	}
@@ -243,17 +531,26 @@ func (c *client) CreateUser(u *User) (*User, error) {
 	var newUser User
 	err = json.Unmarshal(resp, &newUser)
 	if err != nil {
+		// the user was created, a truncated response can be recovered by
+		// looking it up rather than posting it again
+		if c.unmarshalRetries > 0 && isTruncated(err) {
+			log.WithFields(log.Fields{"user": u.Username, "error": err}).Warn("truncated scim response, finding user")
+			return c.FindUserByEmail(ctx, u.Username)
+		}
 		return nil, err
 	}
 	if newUser.ID == "" {
-		return c.FindUserByEmail(u.Username)
+		if c.disableCreateFallbackFind {
+			return nil, ErrUserIDMissing
+		}
+		return c.FindUserByEmail(ctx, u.Username)
 	}
 
 	return &newUser, nil
 }
 
 // UpdateUser will update/replace the user specified
-func (c *client) UpdateUser(u *User) (*User, error) {
+func (c *client) UpdateUser(ctx context.Context, u *User) (*User, error) {
 	startURL, err := url.Parse(c.endpointURL.String())
 	if err != nil {
 		return nil, err
@@ -264,8 +561,17 @@ func (c *client) UpdateUser(u *User) (*User, error) {
 		return nil, err
 	}
 
+	if c.patchUpdates {
+		return c.patchUser(ctx, u)
+	}
+
+	body, err := c.userBody(u)
+	if err != nil {
+		return nil, err
+	}
+
 	startURL.Path = path.Join(startURL.Path, fmt.Sprintf("/Users/%s", u.ID))
-	resp, err := c.sendRequestWithBody(http.MethodPut, startURL.String(), *u)
+	resp, err := c.sendRequestWithBody(ctx, http.MethodPut, startURL.String(), body)
 	if err != nil {
 		return nil, err
 	}
@@ -273,11 +579,191 @@ func (c *client) UpdateUser(u *User) (*User, error) {
 	var newUser User
 	err = json.Unmarshal(resp, &newUser)
 	if err != nil {
+		if c.unmarshalRetries > 0 && isTruncated(err) {
+			log.WithFields(log.Fields{"user": u.Username, "error": err}).Warn("truncated scim response, finding user")
+			return c.FindUserByEmail(ctx, u.Username)
+		}
 		return nil, err
 	}
 	if newUser.ID == "" {
-		return c.FindUserByEmail(u.Username)
+		return c.FindUserByEmail(ctx, u.Username)
 	}
 
 	return &newUser, nil
 }
+
+// patchUser will update the attributes of the user that differ from the
+// existing user, the user is left untouched when nothing changed
+func (c *client) patchUser(ctx context.Context, u *User) (*User, error) {
+	startURL, err := url.Parse(c.endpointURL.String())
+	if err != nil {
+		return nil, err
+	}
+
+	startURL.Path = path.Join(startURL.Path, fmt.Sprintf("/Users/%s", u.ID))
+
+	var existing User
+	err = c.getJSON(ctx, startURL.String(), &existing)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := UserPatchOperations(&existing, u, c.attributes, c.syncedAttributes)
+	if len(ops) == 0 {
+		return &existing, nil
+	}
+
+	uc := &UserAttributeChange{
+		Schemas:    []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		Operations: ops,
+	}
+
+	resp, err := c.sendRequestWithBody(ctx, http.MethodPatch, startURL.String(), *uc)
+	if err != nil {
+		return nil, err
+	}
+
+	// the endpoint may answer with no content
+	var newUser User
+	if len(resp) > 0 {
+		if err := json.Unmarshal(resp, &newUser); err != nil && !isTruncated(err) {
+			return nil, err
+		}
+	}
+	if newUser.ID == "" {
+		return c.FindUserByEmail(ctx, u.Username)
+	}
+
+	return &newUser, nil
+}
+
+// UpdateUserExternalID will set the external id of the user, the id of the
+// user in the source directory
+func (c *client) UpdateUserExternalID(ctx context.Context, u *User, externalID string) error {
+	startURL, err := url.Parse(c.endpointURL.String())
+	if err != nil {
+		return err
+	}
+
+	if u == nil {
+		return ErrUserNotSpecified
+	}
+
+	uc := &UserAttributeChange{
+		Schemas: []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		Operations: []UserAttributeChangeOperation{{
+			Operation: OperationReplace,
+			Path:      ExternalIDPath,
+			Value:     externalID,
+		}},
+	}
+
+	startURL.Path = path.Join(startURL.Path, fmt.Sprintf("/Users/%s", u.ID))
+	_, err = c.sendRequestWithBody(ctx, http.MethodPatch, startURL.String(), *uc)
+
+	return err
+}
+
+// UpdateUserManager will set the manager of the user to the user with the
+// given id, an empty id removes the manager
+func (c *client) UpdateUserManager(ctx context.Context, u *User, managerID string) error {
+	startURL, err := url.Parse(c.endpointURL.String())
+	if err != nil {
+		return err
+	}
+
+	if u == nil {
+		return ErrUserNotSpecified
+	}
+
+	op := UserAttributeChangeOperation{
+		Operation: OperationRemove,
+		Path:      ManagerPath,
+	}
+	if managerID != "" {
+		op.Operation = OperationReplace
+		op.Value = ManagerRef{Value: managerID}
+	}
+
+	uc := &UserAttributeChange{
+		Schemas:    []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		Operations: []UserAttributeChangeOperation{op},
+	}
+
+	startURL.Path = path.Join(startURL.Path, fmt.Sprintf("/Users/%s", u.ID))
+	_, err = c.sendRequestWithBody(ctx, http.MethodPatch, startURL.String(), *uc)
+
+	return err
+}
+
+// UpdateGroupDisplayName will rename the group specified
+func (c *client) UpdateGroupDisplayName(ctx context.Context, g *Group, name string) error {
+	startURL, err := url.Parse(c.endpointURL.String())
+	if err != nil {
+		return err
+	}
+
+	if g == nil {
+		return ErrGroupNotFound
+	}
+
+	gc := &GroupAttributeChange{
+		Schemas: []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		Operations: []GroupAttributeChangeOperation{
+			{
+				Operation: OperationReplace,
+				Value:     map[string]string{"displayName": name},
+			},
+		},
+	}
+
+	startURL.Path = path.Join(startURL.Path, fmt.Sprintf("/Groups/%s", g.ID))
+	_, err = c.sendRequestWithBody(ctx, http.MethodPatch, startURL.String(), *gc)
+	if err == nil {
+		c.forgetGroup(g.DisplayName, name)
+	}
+
+	return err
+}
+
+// groupChangeOperation patches the members of the group with the user
+func (c *client) groupChangeOperation(ctx context.Context, op OperationType, u *User, g *Group) error {
+	startURL, err := url.Parse(c.endpointURL.String())
+	if err != nil {
+		return err
+	}
+
+	if u == nil {
+		return ErrUserNotSpecified
+	}
+
+	if g == nil {
+		return ErrGroupNotFound
+	}
+
+	gc := &GroupMemberChange{
+		Schemas: []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		Operations: []GroupMemberChangeOperation{
+			{
+				Operation: string(op),
+				Path:      "members",
+				Members:   []GroupMemberChangeMember{{Value: u.ID}},
+			},
+		},
+	}
+
+	startURL.Path = path.Join(startURL.Path, fmt.Sprintf("/Groups/%s", g.ID))
+	_, err = c.sendRequestWithBody(ctx, http.MethodPatch, startURL.String(), *gc)
+
+	return err
+}
+
+// AddUserToGroup will add the user specified to the group specified
+func (c *client) AddUserToGroup(ctx context.Context, u *User, g *Group) error {
+	return c.groupChangeOperation(ctx, OperationAdd, u, g)
+}
+
+// RemoveUserFromGroup will remove the user specified from the group specified
+func (c *client) RemoveUserFromGroup(ctx context.Context, u *User, g *Group) error {
+	return c.groupChangeOperation(ctx, OperationRemove, u, g)
+}
@@ -16,15 +16,21 @@ package aws
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/awslabs/ssosync/internal/aws/mock"
@@ -95,7 +101,7 @@ func TestSendRequestBadUrl(t *testing.T) {
 	assert.NoError(t, err)
 	cc := c.(*client)
 
-	r, err := cc.sendRequest(http.MethodGet, ":foo")
+	r, err := cc.sendRequest(context.Background(), http.MethodGet, ":foo")
 	assert.Error(t, err)
 	assert.Nil(t, r)
 }
@@ -126,7 +132,7 @@ func TestSendRequestBadStatusCode(t *testing.T) {
 		Body:       nopCloser{bytes.NewBufferString("")},
 	}, nil)
 
-	_, err = cc.sendRequest(http.MethodGet, "https://scim.example.com/")
+	_, err = cc.sendRequest(context.Background(), http.MethodGet, "https://scim.example.com/")
 	assert.Error(t, err)
 }
 
@@ -161,7 +167,7 @@ func TestSendRequestCheckAuthHeader(t *testing.T) {
 		Body:       nopCloser{bytes.NewBufferString("")},
 	}, nil)
 
-	_, err = cc.sendRequest(http.MethodGet, "https://scim.example.com/")
+	_, err = cc.sendRequest(context.Background(), http.MethodGet, "https://scim.example.com/")
 	assert.NoError(t, err)
 }
 
@@ -198,10 +204,131 @@ func TestSendRequestWithBodyCheckHeaders(t *testing.T) {
 		Body:       nopCloser{bytes.NewBufferString("")},
 	}, nil)
 
-	_, err = cc.sendRequestWithBody(http.MethodPost, "https://scim.example.com/", &User{})
+	_, err = cc.sendRequestWithBody(context.Background(), http.MethodPost, "https://scim.example.com/", &User{})
 	assert.NoError(t, err)
 }
 
+func TestSendRequestRateLimited(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "5")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.Client(), &Config{
+		Endpoint: srv.URL,
+		Token:    "bearerToken",
+	})
+	assert.NoError(t, err)
+	cc := c.(*client)
+
+	for _, send := range []func() error{
+		func() error {
+			_, err := cc.sendRequest(context.Background(), http.MethodGet, srv.URL)
+			return err
+		},
+		func() error {
+			_, err := cc.sendRequestWithBody(context.Background(), http.MethodPost, srv.URL, struct{}{})
+			return err
+		},
+	} {
+		err := send()
+
+		var rateLimited *ErrRateLimited
+		if assert.True(t, errors.As(err, &rateLimited), err) {
+			assert.Equal(t, 5*time.Second, rateLimited.RetryAfter)
+		}
+
+		// it's still a non-2xx response for the callers checking the status
+		var notOK *ErrHTTPNotOK
+		if assert.True(t, errors.As(err, &notOK), err) {
+			assert.Equal(t, http.StatusTooManyRequests, notOK.StatusCode)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "", want: 0},
+		{value: "5", want: 5 * time.Second},
+		{value: "-1", want: 0},
+		{value: "soon", want: 0},
+		{value: "Wed, 21 Oct 2015 07:28:00 GMT", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseRetryAfter(tt.value))
+		})
+	}
+
+	// a date in the future is the time left until then
+	wait := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.True(t, wait > 50*time.Second && wait <= time.Minute, wait)
+}
+
+func TestSendRequestCanceledContext(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.Client(), &Config{
+		Endpoint: srv.URL,
+		Token:    "bearerToken",
+	})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = c.FindUserByEmail(ctx, "test@example.com")
+	assert.True(t, errors.Is(err, context.Canceled), err)
+
+	_, err = c.CreateUser(ctx, NewUser("Lee", "Packham", "test@example.com", true))
+	assert.True(t, errors.Is(err, context.Canceled), err)
+	assert.Equal(t, 0, calls)
+}
+
+func TestClient_CheckConnection(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	x := mock.NewIHTTPClient(ctrl)
+
+	c, err := NewClient(x, &Config{
+		Endpoint: "https://scim.example.com/",
+		Token:    "bearerToken",
+	})
+	assert.NoError(t, err)
+
+	calledURL, _ := url.Parse("https://scim.example.com/Users?count=1")
+
+	req := httpReqMatcher{httpReq: &http.Request{
+		URL:    calledURL,
+		Method: http.MethodGet,
+	}}
+
+	gomock.InOrder(
+		x.EXPECT().Do(&req).Times(1).Return(&http.Response{
+			Status:     "OK",
+			StatusCode: 200,
+			Body:       nopCloser{bytes.NewBufferString(`{"totalResults": 1, "Resources": [{"id": "userId"}]}`)},
+		}, nil),
+		x.EXPECT().Do(&req).Times(1).Return(&http.Response{
+			Status:     "Unauthorized",
+			StatusCode: 401,
+			Body:       nopCloser{bytes.NewBufferString("")},
+		}, nil),
+	)
+
+	assert.NoError(t, c.CheckConnection(context.Background()))
+	assert.Error(t, c.CheckConnection(context.Background()))
+}
+
 func TestClient_FindUserByEmail(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -237,7 +364,7 @@ func TestClient_FindUserByEmail(t *testing.T) {
 		Body:       nopCloser{bytes.NewBufferString("")},
 	}, nil)
 
-	u, err := c.FindUserByEmail("test@example.com")
+	u, err := c.FindUserByEmail(context.Background(), "test@example.com")
 	assert.Nil(t, u)
 	assert.Error(t, err)
 
@@ -253,7 +380,7 @@ func TestClient_FindUserByEmail(t *testing.T) {
 		Body:       nopCloser{bytes.NewBuffer(falseResult)},
 	}, nil)
 
-	u, err = c.FindUserByEmail("test@example.com")
+	u, err = c.FindUserByEmail(context.Background(), "test@example.com")
 	assert.Nil(t, u)
 	assert.Error(t, err)
 
@@ -273,7 +400,7 @@ func TestClient_FindUserByEmail(t *testing.T) {
 		Body:       nopCloser{bytes.NewBuffer(trueResult)},
 	}, nil)
 
-	u, err = c.FindUserByEmail("test@example.com")
+	u, err = c.FindUserByEmail(context.Background(), "test@example.com")
 	assert.NotNil(t, u)
 	assert.NoError(t, err)
 }
@@ -313,7 +440,7 @@ func TestClient_FindGroupByDisplayName(t *testing.T) {
 		Body:       nopCloser{bytes.NewBufferString("")},
 	}, nil)
 
-	u, err := c.FindGroupByDisplayName("testGroup")
+	u, err := c.FindGroupByDisplayName(context.Background(), "testGroup")
 	assert.Nil(t, u)
 	assert.Error(t, err)
 
@@ -329,7 +456,7 @@ func TestClient_FindGroupByDisplayName(t *testing.T) {
 		Body:       nopCloser{bytes.NewBuffer(falseResult)},
 	}, nil)
 
-	u, err = c.FindGroupByDisplayName("testGroup")
+	u, err = c.FindGroupByDisplayName(context.Background(), "testGroup")
 	assert.Nil(t, u)
 	assert.Error(t, err)
 
@@ -349,7 +476,7 @@ func TestClient_FindGroupByDisplayName(t *testing.T) {
 		Body:       nopCloser{bytes.NewBuffer(trueResult)},
 	}, nil)
 
-	u, err = c.FindGroupByDisplayName("testGroup")
+	u, err = c.FindGroupByDisplayName(context.Background(), "testGroup")
 	assert.NotNil(t, u)
 	assert.NoError(t, err)
 }
@@ -390,7 +517,7 @@ func TestClient_CreateUser(t *testing.T) {
 		Body:       nopCloser{bytes.NewBuffer(response)},
 	}, nil)
 
-	r, err := c.CreateUser(nu)
+	r, err := c.CreateUser(context.Background(), nu)
 	assert.NotNil(t, r)
 	assert.NoError(t, err)
 
@@ -435,7 +562,7 @@ func TestClient_UpdateUser(t *testing.T) {
 		Body:       nopCloser{bytes.NewBuffer(response)},
 	}, nil)
 
-	r, err := c.UpdateUser(nu)
+	r, err := c.UpdateUser(context.Background(), nu)
 	assert.NotNil(t, r)
 	assert.NoError(t, err)
 
@@ -443,3 +570,871 @@ func TestClient_UpdateUser(t *testing.T) {
 		assert.Equal(t, *r, nuResult)
 	}
 }
+
+func TestClient_CreateUserFallbackFind(t *testing.T) {
+	nu := NewUser("Lee", "Packham", "test@example.com", true)
+	nuResult := *nu
+	nuResult.ID = "userId"
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	x := mock.NewIHTTPClient(ctrl)
+
+	c, err := NewClient(x, &Config{
+		Endpoint: "https://scim.example.com/",
+		Token:    "bearerToken",
+	})
+	assert.NoError(t, err)
+
+	createURL, _ := url.Parse("https://scim.example.com/Users")
+	requestJSON, _ := json.Marshal(nu)
+	createReq := httpReqMatcher{
+		httpReq: &http.Request{
+			URL:    createURL,
+			Method: http.MethodPost,
+		},
+		body: string(requestJSON),
+	}
+
+	// the create response is missing the id
+	createResponse, _ := json.Marshal(nu)
+	x.EXPECT().Do(&createReq).Times(1).Return(&http.Response{
+		Status:     "OK",
+		StatusCode: 200,
+		Body:       nopCloser{bytes.NewBuffer(createResponse)},
+	}, nil)
+
+	findURL, _ := url.Parse("https://scim.example.com/Users?filter=userName+eq+%22test%40example.com%22")
+	findReq := httpReqMatcher{httpReq: &http.Request{
+		URL:    findURL,
+		Method: http.MethodGet,
+	}}
+	findResponse, _ := json.Marshal(UserFilterResults{TotalResults: 1, Resources: []User{nuResult}})
+	x.EXPECT().Do(&findReq).Times(1).Return(&http.Response{
+		Status:     "OK",
+		StatusCode: 200,
+		Body:       nopCloser{bytes.NewBuffer(findResponse)},
+	}, nil)
+
+	r, err := c.CreateUser(context.Background(), nu)
+	assert.NoError(t, err)
+	assert.Equal(t, "userId", r.ID)
+}
+
+func TestClient_CreateUserDisableFallbackFind(t *testing.T) {
+	nu := NewUser("Lee", "Packham", "test@example.com", true)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	x := mock.NewIHTTPClient(ctrl)
+
+	c, err := NewClient(x, &Config{
+		Endpoint: "https://scim.example.com/",
+		Token:    "bearerToken",
+
+		DisableCreateFallbackFind: true,
+	})
+	assert.NoError(t, err)
+
+	createURL, _ := url.Parse("https://scim.example.com/Users")
+	requestJSON, _ := json.Marshal(nu)
+	createReq := httpReqMatcher{
+		httpReq: &http.Request{
+			URL:    createURL,
+			Method: http.MethodPost,
+		},
+		body: string(requestJSON),
+	}
+
+	// the create response is missing the id, no lookup should follow
+	createResponse, _ := json.Marshal(nu)
+	x.EXPECT().Do(&createReq).Times(1).Return(&http.Response{
+		Status:     "OK",
+		StatusCode: 200,
+		Body:       nopCloser{bytes.NewBuffer(createResponse)},
+	}, nil)
+
+	r, err := c.CreateUser(context.Background(), nu)
+	assert.Nil(t, r)
+	assert.Equal(t, ErrUserIDMissing, err)
+}
+
+func TestClient_CreateUserAttributeAllowlist(t *testing.T) {
+	nu := NewUser("Lee", "Packham", "test@example.com", true)
+	nuResult := *nu
+	nuResult.ID = "userId"
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	x := mock.NewIHTTPClient(ctrl)
+
+	c, err := NewClient(x, &Config{
+		Endpoint: "https://scim.example.com/",
+		Token:    "bearerToken",
+
+		Attributes: []string{AttributeName, AttributeActive},
+	})
+	assert.NoError(t, err)
+
+	calledURL, _ := url.Parse("https://scim.example.com/Users")
+
+	// only the required and allowlisted attributes are sent
+	requestJSON, _ := json.Marshal(map[string]interface{}{
+		"schemas":  nu.Schemas,
+		"userName": nu.Username,
+		"name":     nu.Name,
+		"active":   nu.Active,
+	})
+
+	req := httpReqMatcher{
+		httpReq: &http.Request{
+			URL:    calledURL,
+			Method: http.MethodPost,
+		},
+		body: string(requestJSON),
+	}
+
+	response, _ := json.Marshal(nuResult)
+
+	x.EXPECT().Do(&req).Times(1).Return(&http.Response{
+		Status:     "OK",
+		StatusCode: 200,
+		Body:       nopCloser{bytes.NewBuffer(response)},
+	}, nil)
+
+	r, err := c.CreateUser(context.Background(), nu)
+	assert.NoError(t, err)
+	assert.Equal(t, "userId", r.ID)
+}
+
+func TestClient_UpdateGroupDisplayName(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	x := mock.NewIHTTPClient(ctrl)
+
+	c, err := NewClient(x, &Config{
+		Endpoint: "https://scim.example.com/",
+		Token:    "bearerToken",
+	})
+	assert.NoError(t, err)
+
+	calledURL, _ := url.Parse("https://scim.example.com/Groups/groupId")
+
+	requestJSON, _ := json.Marshal(GroupAttributeChange{
+		Schemas: []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		Operations: []GroupAttributeChangeOperation{
+			{
+				Operation: OperationReplace,
+				Value:     map[string]string{"displayName": "Admins"},
+			},
+		},
+	})
+
+	req := httpReqMatcher{
+		httpReq: &http.Request{
+			URL:    calledURL,
+			Method: http.MethodPatch,
+		},
+		body: string(requestJSON),
+	}
+
+	x.EXPECT().Do(&req).Times(1).Return(&http.Response{
+		Status:     "No Content",
+		StatusCode: http.StatusNoContent,
+		Body:       nopCloser{bytes.NewBufferString("")},
+	}, nil)
+
+	err = c.UpdateGroupDisplayName(context.Background(), &Group{ID: "groupId", DisplayName: "admins@example.com"}, "Admins")
+	assert.NoError(t, err)
+}
+
+func TestClient_FindUserByEmailTruncatedResponse(t *testing.T) {
+	nu := NewUser("Lee", "Packham", "test@example.com", true)
+	nu.ID = "userId"
+
+	complete, _ := json.Marshal(UserFilterResults{
+		Schemas:      []string{"urn:ietf:params:scim:api:messages:2.0:ListResponse"},
+		TotalResults: 1,
+		ItemsPerPage: 1,
+		StartIndex:   1,
+		Resources:    []User{*nu},
+	})
+	truncated := complete[:len(complete)/2]
+
+	tests := []struct {
+		name      string
+		retries   int
+		responses [][]byte
+		wantErr   bool
+	}{
+		{name: "truncated then complete", retries: 2, responses: [][]byte{truncated, complete}},
+		{name: "retries exhausted", retries: 1, responses: [][]byte{truncated, truncated}, wantErr: true},
+		{name: "retries disabled", retries: 0, responses: [][]byte{truncated}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			x := mock.NewIHTTPClient(ctrl)
+
+			c, err := NewClient(x, &Config{
+				Endpoint: "https://scim.example.com/",
+				Token:    "bearerToken",
+
+				UnmarshalRetries: tt.retries,
+			})
+			assert.NoError(t, err)
+
+			calls := 0
+			x.EXPECT().Do(gomock.Any()).Times(len(tt.responses)).DoAndReturn(func(r *http.Request) (*http.Response, error) {
+				body := tt.responses[calls]
+				calls++
+				return &http.Response{
+					Status:     "OK",
+					StatusCode: 200,
+					Body:       nopCloser{bytes.NewBuffer(body)},
+				}, nil
+			})
+
+			u, err := c.FindUserByEmail(context.Background(), "test@example.com")
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, "userId", u.ID)
+		})
+	}
+}
+
+func TestClient_CreateUserTruncatedResponse(t *testing.T) {
+	nu := NewUser("Lee", "Packham", "test@example.com", true)
+	found := *nu
+	found.ID = "userId"
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	x := mock.NewIHTTPClient(ctrl)
+
+	c, err := NewClient(x, &Config{
+		Endpoint: "https://scim.example.com/",
+		Token:    "bearerToken",
+
+		UnmarshalRetries: 1,
+	})
+	assert.NoError(t, err)
+
+	created, _ := json.Marshal(found)
+	search, _ := json.Marshal(UserFilterResults{TotalResults: 1, Resources: []User{found}})
+
+	// the create is not posted again, the user is looked up instead
+	gomock.InOrder(
+		x.EXPECT().Do(gomock.Any()).Times(1).DoAndReturn(func(r *http.Request) (*http.Response, error) {
+			assert.Equal(t, http.MethodPost, r.Method)
+			return &http.Response{StatusCode: 201, Body: nopCloser{bytes.NewBuffer(created[:10])}}, nil
+		}),
+		x.EXPECT().Do(gomock.Any()).Times(1).DoAndReturn(func(r *http.Request) (*http.Response, error) {
+			assert.Equal(t, http.MethodGet, r.Method)
+			return &http.Response{StatusCode: 200, Body: nopCloser{bytes.NewBuffer(search)}}, nil
+		}),
+	)
+
+	u, err := c.CreateUser(context.Background(), nu)
+	assert.NoError(t, err)
+	assert.Equal(t, "userId", u.ID)
+}
+
+func TestClient_UpdateUserPatch(t *testing.T) {
+	existing := UpdateUser("userId", "Lee", "Packham", "test@example.com", true)
+	nu := UpdateUser("userId", "Lee", "Smith", "test@example.com", true)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	x := mock.NewIHTTPClient(ctrl)
+
+	c, err := NewClient(x, &Config{
+		Endpoint:     "https://scim.example.com/",
+		Token:        "bearerToken",
+		PatchUpdates: true,
+	})
+	assert.NoError(t, err)
+
+	calledURL, _ := url.Parse("https://scim.example.com/Users/userId")
+
+	existingJSON, _ := json.Marshal(existing)
+
+	// only the changed attributes are in the patch
+	requestJSON, _ := json.Marshal(UserAttributeChange{
+		Schemas: []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		Operations: []UserAttributeChangeOperation{
+			{Operation: OperationReplace, Path: "name.familyName", Value: "Smith"},
+			{Operation: OperationReplace, Path: "displayName", Value: "Lee Smith"},
+		},
+	})
+
+	response, _ := json.Marshal(nu)
+
+	gomock.InOrder(
+		x.EXPECT().Do(&httpReqMatcher{httpReq: &http.Request{URL: calledURL, Method: http.MethodGet}}).Times(1).Return(&http.Response{
+			Status:     "OK",
+			StatusCode: 200,
+			Body:       nopCloser{bytes.NewBuffer(existingJSON)},
+		}, nil),
+		x.EXPECT().Do(&httpReqMatcher{httpReq: &http.Request{URL: calledURL, Method: http.MethodPatch}, body: string(requestJSON)}).Times(1).Return(&http.Response{
+			Status:     "OK",
+			StatusCode: 200,
+			Body:       nopCloser{bytes.NewBuffer(response)},
+		}, nil),
+	)
+
+	r, err := c.UpdateUser(context.Background(), nu)
+	assert.NoError(t, err)
+	assert.Equal(t, nu, r)
+}
+
+func TestClient_UpdateUserPatchUnchanged(t *testing.T) {
+	existing := UpdateUser("userId", "Lee", "Packham", "test@example.com", true)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	x := mock.NewIHTTPClient(ctrl)
+
+	c, err := NewClient(x, &Config{
+		Endpoint:     "https://scim.example.com/",
+		Token:        "bearerToken",
+		PatchUpdates: true,
+	})
+	assert.NoError(t, err)
+
+	calledURL, _ := url.Parse("https://scim.example.com/Users/userId")
+
+	existingJSON, _ := json.Marshal(existing)
+
+	// no patch is sent
+	x.EXPECT().Do(&httpReqMatcher{httpReq: &http.Request{URL: calledURL, Method: http.MethodGet}}).Times(1).Return(&http.Response{
+		Status:     "OK",
+		StatusCode: 200,
+		Body:       nopCloser{bytes.NewBuffer(existingJSON)},
+	}, nil)
+
+	r, err := c.UpdateUser(context.Background(), UpdateUser("userId", "Lee", "Packham", "test@example.com", true))
+	assert.NoError(t, err)
+	assert.Equal(t, existing, r)
+}
+
+func TestClient_UpdateUserClearedAttributes(t *testing.T) {
+	existing := UpdateUser("userId", "Lee", "Packham", "test@example.com", true)
+	existing.Title = "Engineer"
+	existing.UserType = "Contractor"
+	existing.Locale = "fr-CA"
+	existing.Timezone = "America/Toronto"
+	existing.PreferredLanguage = "fr-CA"
+	nu := UpdateUser("userId", "Lee", "Packham", "test@example.com", true)
+
+	calledURL, _ := url.Parse("https://scim.example.com/Users/userId")
+	existingJSON, _ := json.Marshal(existing)
+	response, _ := json.Marshal(nu)
+
+	// the replaced user has none of the cleared attributes, the patch removes them
+	putJSON, _ := json.Marshal(nu)
+	patchJSON, _ := json.Marshal(UserAttributeChange{
+		Schemas: []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		Operations: []UserAttributeChangeOperation{
+			{Operation: OperationRemove, Path: "title"},
+			{Operation: OperationRemove, Path: "userType"},
+			{Operation: OperationRemove, Path: "locale"},
+			{Operation: OperationRemove, Path: "timezone"},
+			{Operation: OperationRemove, Path: "preferredLanguage"},
+		},
+	})
+	for _, a := range []string{"title", "userType", "locale", "timezone", "preferredLanguage"} {
+		assert.NotContains(t, string(putJSON), a)
+	}
+
+	tests := []struct {
+		name         string
+		patchUpdates bool
+	}{
+		{name: "put"},
+		{name: "patch", patchUpdates: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			x := mock.NewIHTTPClient(ctrl)
+
+			c, err := NewClient(x, &Config{
+				Endpoint:         "https://scim.example.com/",
+				Token:            "bearerToken",
+				PatchUpdates:     tt.patchUpdates,
+				SyncedAttributes: []string{AttributeTitle, AttributeUserType, AttributeLocale, AttributeTimezone, AttributePreferredLanguage},
+			})
+			assert.NoError(t, err)
+
+			if tt.patchUpdates {
+				gomock.InOrder(
+					x.EXPECT().Do(&httpReqMatcher{httpReq: &http.Request{URL: calledURL, Method: http.MethodGet}}).Times(1).Return(&http.Response{
+						StatusCode: 200,
+						Body:       nopCloser{bytes.NewBuffer(existingJSON)},
+					}, nil),
+					x.EXPECT().Do(&httpReqMatcher{httpReq: &http.Request{URL: calledURL, Method: http.MethodPatch}, body: string(patchJSON)}).Times(1).Return(&http.Response{
+						StatusCode: 200,
+						Body:       nopCloser{bytes.NewBuffer(response)},
+					}, nil),
+				)
+			} else {
+				x.EXPECT().Do(&httpReqMatcher{httpReq: &http.Request{URL: calledURL, Method: http.MethodPut}, body: string(putJSON)}).Times(1).Return(&http.Response{
+					StatusCode: 200,
+					Body:       nopCloser{bytes.NewBuffer(response)},
+				}, nil)
+			}
+
+			r, err := c.UpdateUser(context.Background(), nu)
+			assert.NoError(t, err)
+			assert.Equal(t, nu, r)
+		})
+	}
+}
+
+func TestClient_UpdateUserAttributeAllowlist(t *testing.T) {
+	existing := UpdateUser("userId", "Lee", "Packham", "test@example.com", true)
+	nu := UpdateUser("userId", "Lee", "Smith", "test@example.com", true)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	x := mock.NewIHTTPClient(ctrl)
+
+	// a replace would clear the attributes left out, so the user is patched
+	c, err := NewClient(x, &Config{
+		Endpoint: "https://scim.example.com/",
+		Token:    "bearerToken",
+
+		Attributes: []string{AttributeName},
+	})
+	assert.NoError(t, err)
+
+	calledURL, _ := url.Parse("https://scim.example.com/Users/userId")
+
+	existingJSON, _ := json.Marshal(existing)
+
+	// the display name changed as well but isn't allowlisted
+	requestJSON, _ := json.Marshal(UserAttributeChange{
+		Schemas: []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		Operations: []UserAttributeChangeOperation{
+			{Operation: OperationReplace, Path: "name.familyName", Value: "Smith"},
+		},
+	})
+
+	response, _ := json.Marshal(nu)
+
+	gomock.InOrder(
+		x.EXPECT().Do(&httpReqMatcher{httpReq: &http.Request{URL: calledURL, Method: http.MethodGet}}).Times(1).Return(&http.Response{
+			Status:     "OK",
+			StatusCode: 200,
+			Body:       nopCloser{bytes.NewBuffer(existingJSON)},
+		}, nil),
+		x.EXPECT().Do(&httpReqMatcher{httpReq: &http.Request{URL: calledURL, Method: http.MethodPatch}, body: string(requestJSON)}).Times(1).Return(&http.Response{
+			Status:     "OK",
+			StatusCode: 200,
+			Body:       nopCloser{bytes.NewBuffer(response)},
+		}, nil),
+	)
+
+	r, err := c.UpdateUser(context.Background(), nu)
+	assert.NoError(t, err)
+	assert.Equal(t, nu, r)
+}
+
+func TestClient_UpdateUserManager(t *testing.T) {
+	tests := []struct {
+		name      string
+		managerID string
+		operation UserAttributeChangeOperation
+	}{
+		{
+			name:      "replace",
+			managerID: "managerId",
+			operation: UserAttributeChangeOperation{Operation: OperationReplace, Path: ManagerPath, Value: ManagerRef{Value: "managerId"}},
+		},
+		{
+			name:      "remove",
+			operation: UserAttributeChangeOperation{Operation: OperationRemove, Path: ManagerPath},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			x := mock.NewIHTTPClient(ctrl)
+
+			c, err := NewClient(x, &Config{
+				Endpoint: "https://scim.example.com/",
+				Token:    "bearerToken",
+			})
+			assert.NoError(t, err)
+
+			calledURL, _ := url.Parse("https://scim.example.com/Users/userId")
+
+			requestJSON, _ := json.Marshal(UserAttributeChange{
+				Schemas:    []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+				Operations: []UserAttributeChangeOperation{tt.operation},
+			})
+
+			req := httpReqMatcher{
+				httpReq: &http.Request{
+					URL:    calledURL,
+					Method: http.MethodPatch,
+				},
+				body: string(requestJSON),
+			}
+
+			x.EXPECT().Do(&req).Times(1).Return(&http.Response{
+				Status:     "No Content",
+				StatusCode: http.StatusNoContent,
+				Body:       nopCloser{bytes.NewBufferString("")},
+			}, nil)
+
+			err = c.UpdateUserManager(context.Background(), &User{ID: "userId"}, tt.managerID)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestClient_UpdateUserExternalID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	x := mock.NewIHTTPClient(ctrl)
+
+	c, err := NewClient(x, &Config{
+		Endpoint: "https://scim.example.com/",
+		Token:    "bearerToken",
+	})
+	assert.NoError(t, err)
+
+	calledURL, _ := url.Parse("https://scim.example.com/Users/userId")
+
+	requestJSON, _ := json.Marshal(UserAttributeChange{
+		Schemas:    []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		Operations: []UserAttributeChangeOperation{{Operation: OperationReplace, Path: ExternalIDPath, Value: "googleId"}},
+	})
+
+	req := httpReqMatcher{
+		httpReq: &http.Request{
+			URL:    calledURL,
+			Method: http.MethodPatch,
+		},
+		body: string(requestJSON),
+	}
+
+	x.EXPECT().Do(&req).Times(1).Return(&http.Response{
+		Status:     "No Content",
+		StatusCode: http.StatusNoContent,
+		Body:       nopCloser{bytes.NewBufferString("")},
+	}, nil)
+
+	err = c.UpdateUserExternalID(context.Background(), &User{ID: "userId"}, "googleId")
+	assert.NoError(t, err)
+
+	assert.Equal(t, ErrUserNotSpecified, c.UpdateUserExternalID(context.Background(), nil, "googleId"))
+}
+
+func TestClient_GroupMembers(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation OperationType
+		change    func(Client, *User, *Group) error
+	}{
+		{
+			name:      "add",
+			operation: OperationAdd,
+			change: func(c Client, u *User, g *Group) error {
+				return c.AddUserToGroup(context.Background(), u, g)
+			},
+		},
+		{
+			name:      "remove",
+			operation: OperationRemove,
+			change: func(c Client, u *User, g *Group) error {
+				return c.RemoveUserFromGroup(context.Background(), u, g)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			x := mock.NewIHTTPClient(ctrl)
+
+			c, err := NewClient(x, &Config{
+				Endpoint: "https://scim.example.com/",
+				Token:    "bearerToken",
+			})
+			assert.NoError(t, err)
+
+			calledURL, _ := url.Parse("https://scim.example.com/Groups/groupId")
+
+			requestJSON, _ := json.Marshal(GroupMemberChange{
+				Schemas: []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+				Operations: []GroupMemberChangeOperation{
+					{
+						Operation: string(tt.operation),
+						Path:      "members",
+						Members:   []GroupMemberChangeMember{{Value: "userId"}},
+					},
+				},
+			})
+
+			req := httpReqMatcher{
+				httpReq: &http.Request{
+					URL:    calledURL,
+					Method: http.MethodPatch,
+				},
+				body: string(requestJSON),
+			}
+
+			x.EXPECT().Do(&req).Times(1).Return(&http.Response{
+				Status:     "No Content",
+				StatusCode: http.StatusNoContent,
+				Body:       nopCloser{bytes.NewBufferString("")},
+			}, nil)
+
+			err = tt.change(c, &User{ID: "userId"}, &Group{ID: "groupId"})
+			assert.NoError(t, err)
+
+			assert.Equal(t, ErrUserNotSpecified, tt.change(c, nil, &Group{ID: "groupId"}))
+		})
+	}
+}
+
+func TestClient_TraceQueries(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/Groups" {
+			w.Write([]byte(`{"totalResults": 2, "Resources": [{"id": "1"}, {"id": "2"}]}`))
+			return
+		}
+		w.Write([]byte(`{"totalResults": 0}`))
+	}))
+	defer srv.Close()
+
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	// nothing is logged by default
+	c, err := NewClient(srv.Client(), &Config{Endpoint: srv.URL, Token: "bearerToken"})
+	assert.NoError(t, err)
+	_, err = c.FindUserByEmail(context.Background(), "test@example.com")
+	assert.Equal(t, ErrUserNotFound, err)
+	assert.Empty(t, hook.AllEntries())
+
+	c, err = NewClient(srv.Client(), &Config{Endpoint: srv.URL, Token: "bearerToken", TraceQueries: true})
+	assert.NoError(t, err)
+
+	_, err = c.FindUserByEmail(context.Background(), "test@example.com")
+	assert.Equal(t, ErrUserNotFound, err)
+	entry := hook.LastEntry()
+	if assert.NotNil(t, entry) {
+		assert.Equal(t, "scim query", entry.Message)
+		assert.Equal(t, `userName eq "test@example.com"`, entry.Data["filter"])
+		assert.Equal(t, "filter=userName+eq+%22test%40example.com%22", entry.Data["query"])
+		assert.Equal(t, 0, entry.Data["totalResults"])
+	}
+
+	_, err = c.FindGroupByDisplayName(context.Background(), "admins")
+	assert.Equal(t, ErrGroupNotFound, err)
+	entry = hook.LastEntry()
+	if assert.NotNil(t, entry) {
+		assert.Equal(t, "Groups", entry.Data["resource"])
+		assert.Equal(t, `displayName eq "admins"`, entry.Data["filter"])
+		assert.Equal(t, 2, entry.Data["totalResults"])
+	}
+}
+
+func TestEqFilter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "test@example.com", want: `userName eq "test@example.com"`},
+		{value: `Team "Alpha"`, want: `userName eq "Team \"Alpha\""`},
+		{value: `back\slash`, want: `userName eq "back\\slash"`},
+		{value: `\"`, want: `userName eq "\\\""`},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, eqFilter("userName", tt.value))
+	}
+}
+
+func TestClient_FindGroupByDisplayNameCached(t *testing.T) {
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPatch {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		filter := r.URL.Query().Get("filter")
+		queries = append(queries, filter)
+		if filter == `displayName eq "missing"` {
+			w.Write([]byte(`{"totalResults": 0}`))
+			return
+		}
+		w.Write([]byte(`{"totalResults": 1, "Resources": [{"id": "1", "displayName": "admins"}]}`))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.Client(), &Config{Endpoint: srv.URL, Token: "bearerToken"})
+	assert.NoError(t, err)
+
+	// the second lookup of the name doesn't query again, and changes to
+	// the group returned don't change the cached one
+	g, err := c.FindGroupByDisplayName(context.Background(), "admins")
+	assert.NoError(t, err)
+	g.DisplayName = "changed"
+	g, err = c.FindGroupByDisplayName(context.Background(), "admins")
+	assert.NoError(t, err)
+	assert.Equal(t, &Group{ID: "1", DisplayName: "admins"}, g)
+	assert.Equal(t, []string{`displayName eq "admins"`}, queries)
+
+	// groups not found are queried again, they may be created meanwhile
+	for i := 0; i < 2; i++ {
+		_, err = c.FindGroupByDisplayName(context.Background(), "missing")
+		assert.Equal(t, ErrGroupNotFound, err)
+	}
+	assert.Len(t, queries, 3)
+
+	// a renamed group is queried again
+	assert.NoError(t, c.UpdateGroupDisplayName(context.Background(), g, "owners"))
+	_, err = c.FindGroupByDisplayName(context.Background(), "admins")
+	assert.NoError(t, err)
+	assert.Len(t, queries, 4)
+}
+
+func TestClient_FindGroupByDisplayNameEscaped(t *testing.T) {
+	var filters []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filters = append(filters, r.URL.Query().Get("filter"))
+		w.Write([]byte(`{"totalResults": 1, "Resources": [{"id": "1", "displayName": "Team \"Alpha\""}]}`))
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(srv.Client(), &Config{Endpoint: srv.URL, Token: "bearerToken"})
+	assert.NoError(t, err)
+
+	g, err := c.FindGroupByDisplayName(context.Background(), `Team "Alpha"`)
+	assert.NoError(t, err)
+	assert.Equal(t, `Team "Alpha"`, g.DisplayName)
+
+	_, err = c.FindUserByEmail(context.Background(), `"quoted"@example.com`)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		`displayName eq "Team \"Alpha\""`,
+		`userName eq "\"quoted\"@example.com"`,
+	}, filters)
+}
+
+func TestChunkEmails(t *testing.T) {
+	emails := []string{"a@example.com", "b@example.com", "c@example.com", "d@example.com", "e@example.com"}
+
+	assert.Equal(t, [][]string{
+		{"a@example.com", "b@example.com"},
+		{"c@example.com", "d@example.com"},
+		{"e@example.com"},
+	}, chunkEmails(emails, 2, maxFilterLength))
+
+	// the filter length bounds the chunks too, a single clause is 31 characters
+	assert.Equal(t, [][]string{
+		{"a@example.com", "b@example.com", "c@example.com"},
+		{"d@example.com", "e@example.com"},
+	}, chunkEmails(emails, 10, 3*31+2*4))
+
+	assert.Equal(t, [][]string{{"a@example.com"}}, chunkEmails(emails[:1], 10, 5))
+	assert.Empty(t, chunkEmails(nil, 10, maxFilterLength))
+}
+
+func TestClient_FindUsersByEmails(t *testing.T) {
+	existing := map[string]bool{"a@example.com": true, "c@example.com": true, "e@example.com": true}
+
+	tests := []struct {
+		name       string
+		rejectOr   bool
+		wantCalls  int
+		wantFilter string
+	}{
+		{
+			name:       "or filters",
+			wantCalls:  2,
+			wantFilter: `userName eq "k@example.com" or userName eq "l@example.com"`,
+		},
+		{
+			name:      "or filters rejected",
+			rejectOr:  true,
+			wantCalls: 2 + 12,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var filters []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				filter := r.URL.Query().Get("filter")
+				filters = append(filters, filter)
+
+				clauses := strings.Split(filter, " or ")
+				if tt.rejectOr && len(clauses) > 1 {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+
+				var res UserFilterResults
+				for _, clause := range clauses {
+					email := strings.TrimSuffix(strings.TrimPrefix(clause, `userName eq "`), `"`)
+					if existing[strings.ToLower(email)] {
+						res.Resources = append(res.Resources, User{ID: "id-" + strings.ToLower(email), Username: strings.ToLower(email)})
+					}
+				}
+				res.TotalResults = len(res.Resources)
+				json.NewEncoder(w).Encode(res)
+			}))
+			defer srv.Close()
+
+			c, err := NewClient(srv.Client(), &Config{Endpoint: srv.URL, Token: "bearerToken"})
+			assert.NoError(t, err)
+
+			// more emails than fit a filter, with a mixed case one
+			emails := []string{"A@example.com"}
+			for _, name := range "bcdefghijkl" {
+				emails = append(emails, string(name)+"@example.com")
+			}
+
+			users, err := c.FindUsersByEmails(context.Background(), emails)
+			assert.NoError(t, err)
+
+			// only the partial matches are returned, keyed by the emails asked for
+			assert.Len(t, users, 3)
+			assert.Equal(t, "id-a@example.com", users["A@example.com"].ID)
+			assert.Equal(t, "id-c@example.com", users["c@example.com"].ID)
+			assert.Equal(t, "id-e@example.com", users["e@example.com"].ID)
+			assert.NotContains(t, users, "b@example.com")
+
+			assert.Len(t, filters, tt.wantCalls)
+			if tt.wantFilter != "" {
+				assert.Len(t, strings.Split(filters[0], " or "), maxEmailsPerFilter)
+				assert.Equal(t, tt.wantFilter, filters[1])
+			}
+		})
+	}
+}
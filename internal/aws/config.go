@@ -20,6 +20,31 @@ import "github.com/BurntSushi/toml"
 type Config struct {
 	Endpoint string
 	Token    string
+
+	// DisableCreateFallbackFind treats a create response without an id as
+	// an error instead of looking the user up afterwards
+	DisableCreateFallbackFind bool
+
+	// Attributes limits the user attributes sent on create and update,
+	// when empty all attributes are sent. Updated users are then always
+	// patched, as a replace would clear the attributes left out.
+	Attributes []string
+
+	// UnmarshalRetries is the number of times a GET is re-issued when its
+	// 2xx response can't be decoded, as it was likely truncated
+	UnmarshalRetries int
+
+	// PatchUpdates sends only the attributes of an updated user that differ
+	// from the existing user, as a PatchOp, instead of replacing the user
+	PatchUpdates bool
+
+	// TraceQueries logs the encoded filter and the number of results of
+	// the user and group lookups at info level
+	TraceQueries bool
+
+	// SyncedAttributes are the optional user attributes, such as title, that
+	// are synced. A patched user has them removed when they are empty.
+	SyncedAttributes []string
 }
 
 // ReadConfigFromFile will read a TOML file into the Config Struct
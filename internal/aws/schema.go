@@ -52,6 +52,20 @@ type GroupMemberChange struct {
 	Operations []GroupMemberChangeOperation `json:"Operations"`
 }
 
+// GroupAttributeChangeOperation details a replace operation on the
+// attributes of a group
+type GroupAttributeChangeOperation struct {
+	Operation string            `json:"op"`
+	Value     map[string]string `json:"value"`
+}
+
+// GroupAttributeChange represents a change operation on the attributes
+// of a group
+type GroupAttributeChange struct {
+	Schemas    []string                        `json:"schemas"`
+	Operations []GroupAttributeChangeOperation `json:"Operations"`
+}
+
 // UserEmail represents a user email address
 type UserEmail struct {
 	Value   string `json:"value"`
@@ -59,6 +73,13 @@ type UserEmail struct {
 	Primary bool   `json:"primary"`
 }
 
+// UserPhoneNumber represents a user phone number
+type UserPhoneNumber struct {
+	Value   string `json:"value"`
+	Type    string `json:"type"`
+	Primary bool   `json:"primary"`
+}
+
 // UserAddress represents address values of users
 type UserAddress struct {
 	Type string `json:"type"`
@@ -66,17 +87,62 @@ type UserAddress struct {
 
 // User represents a User in AWS SSO
 type User struct {
-	ID       string   `json:"id,omitempty"`
-	Schemas  []string `json:"schemas"`
-	Username string   `json:"userName"`
-	Name     struct {
+	ID         string   `json:"id,omitempty"`
+	ExternalID string   `json:"externalId,omitempty"`
+	Schemas    []string `json:"schemas"`
+	Username   string   `json:"userName"`
+	Name       struct {
 		FamilyName string `json:"familyName"`
 		GivenName  string `json:"givenName"`
 	} `json:"name"`
 	DisplayName string        `json:"displayName"`
+	Title       string        `json:"title,omitempty"`
+	UserType    string        `json:"userType,omitempty"`
 	Active      bool          `json:"active"`
 	Emails      []UserEmail   `json:"emails"`
 	Addresses   []UserAddress `json:"addresses"`
+
+	PhoneNumbers []UserPhoneNumber `json:"phoneNumbers,omitempty"`
+
+	Locale            string `json:"locale,omitempty"`
+	Timezone          string `json:"timezone,omitempty"`
+	PreferredLanguage string `json:"preferredLanguage,omitempty"`
+
+	Enterprise *EnterpriseUser `json:"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User,omitempty"`
+}
+
+// ManagerRef references the manager of a user by their id
+type ManagerRef struct {
+	Value string `json:"value"`
+}
+
+// EnterpriseUser represents the enterprise extension attributes of a user
+type EnterpriseUser struct {
+	Manager *ManagerRef `json:"manager,omitempty"`
+}
+
+// ManagerID returns the id of the manager of the user, or an empty
+// string when the user has none
+func (u *User) ManagerID() string {
+	if u.Enterprise == nil || u.Enterprise.Manager == nil {
+		return ""
+	}
+	return u.Enterprise.Manager.Value
+}
+
+// UserAttributeChangeOperation details an operation on an attribute
+// of a user
+type UserAttributeChangeOperation struct {
+	Operation string      `json:"op"`
+	Path      string      `json:"path"`
+	Value     interface{} `json:"value,omitempty"`
+}
+
+// UserAttributeChange represents a change operation on the attributes
+// of a user
+type UserAttributeChange struct {
+	Schemas    []string                       `json:"schemas"`
+	Operations []UserAttributeChangeOperation `json:"Operations"`
 }
 
 // UserFilterResults represents filtered results when we search for
@@ -15,9 +15,205 @@
 package aws
 
 import (
+	"encoding/json"
+	"fmt"
+	"reflect"
 	"strings"
 )
 
+// SCIM user attributes that can be restricted with an allowlist, userName,
+// id and schemas are required and are always sent, as is externalId when set
+const (
+	AttributeUserName          = "userName"
+	AttributeName              = "name"
+	AttributeDisplayName       = "displayName"
+	AttributeActive            = "active"
+	AttributeEmails            = "emails"
+	AttributeAddresses         = "addresses"
+	AttributePhoneNumbers      = "phoneNumbers"
+	AttributeTitle             = "title"
+	AttributeUserType          = "userType"
+	AttributeLocale            = "locale"
+	AttributeTimezone          = "timezone"
+	AttributePreferredLanguage = "preferredLanguage"
+)
+
+// ManagedUserAttributes are the SCIM user attributes managed by ssosync
+var ManagedUserAttributes = []string{
+	AttributeName,
+	AttributeDisplayName,
+	AttributeActive,
+	AttributeEmails,
+	AttributeAddresses,
+	AttributePhoneNumbers,
+	AttributeTitle,
+	AttributeUserType,
+	AttributeLocale,
+	AttributeTimezone,
+	AttributePreferredLanguage,
+}
+
+// optionalUserAttributes are only set when they are synced, they are left
+// alone when a user is patched without them
+var optionalUserAttributes = map[string]struct{}{
+	AttributeTitle:             {},
+	AttributeUserType:          {},
+	AttributeLocale:            {},
+	AttributeTimezone:          {},
+	AttributePreferredLanguage: {},
+}
+
+// requiredUserAttributes are always sent regardless of the allowlist
+var requiredUserAttributes = map[string]struct{}{
+	"id":         {},
+	"externalId": {},
+	"schemas":    {},
+	"userName":   {},
+}
+
+// IsManagedUserAttribute reports whether name can be used in an allowlist,
+// userName is accepted though it's always sent
+func IsManagedUserAttribute(name string) bool {
+	if name == AttributeUserName {
+		return true
+	}
+
+	for _, a := range ManagedUserAttributes {
+		if a == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FilterUserAttributes returns the SCIM representation of the user limited
+// to the required attributes and those in the allowlist
+func FilterUserAttributes(u *User, allowlist []string) (map[string]interface{}, error) {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+
+	allowed := make(map[string]struct{})
+	for _, a := range allowlist {
+		allowed[a] = struct{}{}
+	}
+
+	for k := range m {
+		if _, ok := requiredUserAttributes[k]; ok {
+			continue
+		}
+		if _, ok := allowed[k]; !ok {
+			delete(m, k)
+		}
+	}
+
+	return m, nil
+}
+
+// UserPatchOperations returns a replace operation for each attribute of the
+// updated user that differs from the existing user, limited to the
+// attributes in the allowlist when it's not empty. The optional attributes
+// are left alone when empty, unless synced, then they are removed. An
+// emptied multi-valued attribute is removed, and the addresses are only
+// patched when allow-listed as the users are built with a placeholder one.
+func UserPatchOperations(existing *User, updated *User, allowlist []string, synced []string) []UserAttributeChangeOperation {
+	contains := func(attributes []string, attribute string) bool {
+		for _, a := range attributes {
+			if a == attribute {
+				return true
+			}
+		}
+		return false
+	}
+	allowed := func(attribute string) bool {
+		return len(allowlist) == 0 || contains(allowlist, attribute)
+	}
+
+	attributes := []struct {
+		attribute string
+		path      string
+		existing  interface{}
+		updated   interface{}
+	}{
+		{"userName", "userName", existing.Username, updated.Username},
+		{AttributeName, "name.givenName", existing.Name.GivenName, updated.Name.GivenName},
+		{AttributeName, "name.familyName", existing.Name.FamilyName, updated.Name.FamilyName},
+		{AttributeDisplayName, "displayName", existing.DisplayName, updated.DisplayName},
+		{AttributeActive, "active", existing.Active, updated.Active},
+		{AttributeEmails, "emails", existing.Emails, updated.Emails},
+		{AttributeAddresses, "addresses", existing.Addresses, updated.Addresses},
+		{AttributePhoneNumbers, "phoneNumbers", existing.PhoneNumbers, updated.PhoneNumbers},
+		{AttributeTitle, "title", existing.Title, updated.Title},
+		{AttributeUserType, "userType", existing.UserType, updated.UserType},
+		{AttributeLocale, "locale", existing.Locale, updated.Locale},
+		{AttributeTimezone, "timezone", existing.Timezone, updated.Timezone},
+		{AttributePreferredLanguage, "preferredLanguage", existing.PreferredLanguage, updated.PreferredLanguage},
+	}
+
+	var ops []UserAttributeChangeOperation
+	for _, a := range attributes {
+		if _, ok := requiredUserAttributes[a.attribute]; !ok && !allowed(a.attribute) {
+			continue
+		}
+		if a.attribute == AttributeAddresses && !contains(allowlist, a.attribute) {
+			continue
+		}
+		if _, optional := optionalUserAttributes[a.attribute]; optional && a.updated == "" {
+			// the value cleared in the source would be flagged as changed
+			// on every run if it was kept
+			if contains(synced, a.attribute) && a.existing != "" {
+				ops = append(ops, UserAttributeChangeOperation{Operation: OperationRemove, Path: a.path})
+			}
+			continue
+		}
+		if v := reflect.ValueOf(a.updated); v.Kind() == reflect.Slice && v.Len() == 0 {
+			// an empty list would be sent as null
+			if reflect.ValueOf(a.existing).Len() > 0 {
+				ops = append(ops, UserAttributeChangeOperation{Operation: OperationRemove, Path: a.path})
+			}
+			continue
+		}
+		if reflect.DeepEqual(a.existing, a.updated) {
+			continue
+		}
+		ops = append(ops, UserAttributeChangeOperation{
+			Operation: OperationReplace,
+			Path:      a.path,
+			Value:     a.updated,
+		})
+	}
+
+	return ops
+}
+
+// ValidateUser checks the fields required by the SCIM endpoint are set,
+// the endpoint rejects the request otherwise
+func ValidateUser(u *User) error {
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"userName", u.Username},
+		{"name.givenName", u.Name.GivenName},
+		{"name.familyName", u.Name.FamilyName},
+	}
+
+	for _, f := range fields {
+		if strings.TrimSpace(f.value) == "" {
+			return fmt.Errorf("%w: %s", ErrUserFieldEmpty, f.name)
+		}
+	}
+
+	return nil
+}
+
 // NewUser creates a user object representing a user with the given
 // details.
 func NewUser(firstName string, lastName string, email string, active bool) *User {
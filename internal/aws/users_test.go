@@ -15,6 +15,7 @@
 package aws
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -51,3 +52,130 @@ func TestUpdateUser(t *testing.T) {
 	assert.Len(t, u.Schemas, 1)
 	assert.Equal(t, u.Schemas[0], "urn:ietf:params:scim:schemas:core:2.0:User")
 }
+
+func TestFilterUserAttributes(t *testing.T) {
+	u := NewUser("Lee", "Packham", "test@email.com", true)
+	u.PhoneNumbers = []UserPhoneNumber{{Value: "+1 555 0100", Type: "work", Primary: true}}
+
+	m, err := FilterUserAttributes(u, []string{AttributeName, AttributeActive})
+	assert.NoError(t, err)
+
+	assert.Contains(t, m, "userName")
+	assert.Contains(t, m, "schemas")
+	assert.Contains(t, m, "name")
+	assert.Contains(t, m, "active")
+	assert.NotContains(t, m, "displayName")
+	assert.NotContains(t, m, "emails")
+	assert.NotContains(t, m, "addresses")
+	assert.NotContains(t, m, "phoneNumbers")
+
+	// the external id is sent whenever it's set
+	u.ExternalID = "externalId"
+	m, err = FilterUserAttributes(u, []string{AttributeName})
+	assert.NoError(t, err)
+	assert.Equal(t, "externalId", m["externalId"])
+}
+
+func TestIsManagedUserAttribute(t *testing.T) {
+	assert.True(t, IsManagedUserAttribute(AttributeEmails))
+	assert.True(t, IsManagedUserAttribute(AttributeUserName))
+	assert.False(t, IsManagedUserAttribute("nickName"))
+}
+
+func TestValidateUser(t *testing.T) {
+	assert.NoError(t, ValidateUser(NewUser("Lee", "Packham", "test@example.com", true)))
+
+	err := ValidateUser(NewUser(" ", "Packham", "test@example.com", true))
+	assert.True(t, errors.Is(err, ErrUserFieldEmpty))
+	assert.Contains(t, err.Error(), "name.givenName")
+
+	err = ValidateUser(NewUser("Lee", "", "test@example.com", true))
+	assert.Contains(t, err.Error(), "name.familyName")
+
+	err = ValidateUser(NewUser("Lee", "Packham", "", true))
+	assert.Contains(t, err.Error(), "userName")
+}
+
+func TestUserPatchOperations(t *testing.T) {
+	existing := UpdateUser("111", "Lee", "Packham", "test@email.com", true)
+
+	// nothing changed
+	assert.Empty(t, UserPatchOperations(existing, UpdateUser("111", "Lee", "Packham", "test@email.com", true), nil, nil))
+
+	updated := UpdateUser("111", "Lee", "Smith", "test@email.com", false)
+	assert.Equal(t, []UserAttributeChangeOperation{
+		{Operation: OperationReplace, Path: "name.familyName", Value: "Smith"},
+		{Operation: OperationReplace, Path: "displayName", Value: "Lee Smith"},
+		{Operation: OperationReplace, Path: "active", Value: false},
+	}, UserPatchOperations(existing, updated, nil, nil))
+
+	// attributes outside of the allowlist are left out
+	assert.Equal(t, []UserAttributeChangeOperation{
+		{Operation: OperationReplace, Path: "active", Value: false},
+	}, UserPatchOperations(existing, updated, []string{AttributeActive}, nil))
+
+	// a phone number is only diffed when it's managed
+	phone := UpdateUser("111", "Lee", "Packham", "test@email.com", true)
+	phone.PhoneNumbers = []UserPhoneNumber{{Value: "+1 555 0100", Type: "work", Primary: true}}
+	assert.Equal(t, []UserAttributeChangeOperation{
+		{Operation: OperationReplace, Path: "phoneNumbers", Value: phone.PhoneNumbers},
+	}, UserPatchOperations(existing, phone, nil, nil))
+	assert.Empty(t, UserPatchOperations(existing, phone, []string{AttributeName, AttributeEmails}, nil))
+
+	// and removed once the user has none
+	assert.Equal(t, []UserAttributeChangeOperation{
+		{Operation: OperationRemove, Path: "phoneNumbers"},
+	}, UserPatchOperations(phone, existing, nil, nil))
+
+	// the placeholder address is only patched when allow-listed
+	unaddressed := UpdateUser("111", "Lee", "Packham", "test@email.com", true)
+	unaddressed.Addresses = nil
+	assert.Empty(t, UserPatchOperations(unaddressed, existing, nil, nil))
+	assert.Equal(t, []UserAttributeChangeOperation{
+		{Operation: OperationReplace, Path: "addresses", Value: existing.Addresses},
+	}, UserPatchOperations(unaddressed, existing, []string{AttributeAddresses}, nil))
+
+	// the title and user type are only patched when they are synced
+	titled := UpdateUser("111", "Lee", "Packham", "test@email.com", true)
+	titled.Title = "Engineer"
+	titled.UserType = "Contractor"
+	assert.Equal(t, []UserAttributeChangeOperation{
+		{Operation: OperationReplace, Path: "title", Value: "Engineer"},
+		{Operation: OperationReplace, Path: "userType", Value: "Contractor"},
+	}, UserPatchOperations(existing, titled, nil, nil))
+	assert.Empty(t, UserPatchOperations(titled, existing, nil, nil))
+
+	// a cleared title or user type is removed when it's synced
+	assert.Equal(t, []UserAttributeChangeOperation{
+		{Operation: OperationRemove, Path: "title"},
+		{Operation: OperationRemove, Path: "userType"},
+	}, UserPatchOperations(titled, existing, nil, []string{AttributeTitle, AttributeUserType}))
+	assert.Equal(t, []UserAttributeChangeOperation{
+		{Operation: OperationRemove, Path: "title"},
+	}, UserPatchOperations(titled, existing, nil, []string{AttributeTitle}))
+	assert.Empty(t, UserPatchOperations(existing, existing, nil, []string{AttributeTitle, AttributeUserType}))
+	assert.Empty(t, UserPatchOperations(titled, existing, []string{AttributeName}, []string{AttributeTitle}))
+
+	// as are the locale, timezone and preferred language
+	localized := UpdateUser("111", "Lee", "Packham", "test@email.com", true)
+	localized.Locale = "fr-CA"
+	localized.Timezone = "America/Toronto"
+	localized.PreferredLanguage = "fr-CA"
+	assert.Equal(t, []UserAttributeChangeOperation{
+		{Operation: OperationReplace, Path: "locale", Value: "fr-CA"},
+		{Operation: OperationReplace, Path: "timezone", Value: "America/Toronto"},
+		{Operation: OperationReplace, Path: "preferredLanguage", Value: "fr-CA"},
+	}, UserPatchOperations(existing, localized, nil, nil))
+	assert.Empty(t, UserPatchOperations(localized, existing, nil, nil))
+	assert.Empty(t, UserPatchOperations(existing, localized, []string{AttributeTitle}, nil))
+
+	// and removed once cleared when they are synced
+	assert.Equal(t, []UserAttributeChangeOperation{
+		{Operation: OperationRemove, Path: "locale"},
+		{Operation: OperationRemove, Path: "timezone"},
+		{Operation: OperationRemove, Path: "preferredLanguage"},
+	}, UserPatchOperations(localized, existing, nil, []string{AttributeLocale, AttributeTimezone, AttributePreferredLanguage}))
+	assert.Equal(t, []UserAttributeChangeOperation{
+		{Operation: OperationRemove, Path: "timezone"},
+	}, UserPatchOperations(localized, existing, nil, []string{AttributeTimezone}))
+}
@@ -0,0 +1,321 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/awslabs/ssosync/internal/aws"
+
+	aws_sdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/identitystore"
+	"github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+)
+
+// objectStore is the part of the S3 API used to keep the correlation cache
+type objectStore interface {
+	objectPutter
+	GetObject(*s3.GetObjectInput) (*s3.GetObjectOutput, error)
+	DeleteObject(*s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error)
+}
+
+// correlationCache is the aws users and groups of a previous run, kept so a
+// run doesn't have to list the whole identity store. The users and groups
+// changed by the sync since the listing are fetched again when it's loaded.
+type correlationCache struct {
+	mu sync.Mutex
+
+	IdentityStoreID string `json:"identityStoreId"`
+	// ListedAt is the time of the full listing, the changes made since
+	// don't extend the life of the cache
+	ListedAt time.Time    `json:"listedAt"`
+	Users    []*aws.User  `json:"users"`
+	Groups   []*aws.Group `json:"groups"`
+
+	ChangedUsers  []string `json:"changedUsers,omitempty"`
+	ChangedGroups []string `json:"changedGroups,omitempty"`
+}
+
+// apply keeps the cache up to date with a change made in AWS, the users and
+// groups are never changed in place as the sync iterates over them
+func (c *correlationCache) apply(r auditRecord) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch r.Operation {
+	case auditCreateUser, auditUpdateUser:
+		c.ChangedUsers = appendID(c.ChangedUsers, r.UserID)
+	case auditDeleteUser:
+		users := make([]*aws.User, 0, len(c.Users))
+		for _, u := range c.Users {
+			if u.ID != r.UserID {
+				users = append(users, u)
+			}
+		}
+		c.Users = users
+		c.ChangedUsers = removeID(c.ChangedUsers, r.UserID)
+	case auditCreateGroup, auditUpdateGroup:
+		c.ChangedGroups = appendID(c.ChangedGroups, r.GroupID)
+	case auditDeleteGroup:
+		groups := make([]*aws.Group, 0, len(c.Groups))
+		for _, g := range c.Groups {
+			if g.ID != r.GroupID {
+				groups = append(groups, g)
+			}
+		}
+		c.Groups = groups
+		c.ChangedGroups = removeID(c.ChangedGroups, r.GroupID)
+	}
+}
+
+// appendID adds the id to the list once
+func appendID(ids []string, id string) []string {
+	for _, i := range ids {
+		if i == id {
+			return ids
+		}
+	}
+	return append(ids, id)
+}
+
+// removeID returns the list without the id
+func removeID(ids []string, id string) []string {
+	kept := make([]string, 0, len(ids))
+	for _, i := range ids {
+		if i != id {
+			kept = append(kept, i)
+		}
+	}
+	return kept
+}
+
+// isNotFound reports whether the identity store has no such user or group
+func isNotFound(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == identitystore.ErrCodeResourceNotFoundException
+}
+
+// describedUser returns the described user as listed, so that it's converted
+// with the same attributes as the listed users
+func describedUser(out *identitystore.DescribeUserOutput) *identitystore.User {
+	return &identitystore.User{
+		Addresses:         out.Addresses,
+		DisplayName:       out.DisplayName,
+		Emails:            out.Emails,
+		ExternalIds:       out.ExternalIds,
+		IdentityStoreId:   out.IdentityStoreId,
+		Locale:            out.Locale,
+		Name:              out.Name,
+		NickName:          out.NickName,
+		PhoneNumbers:      out.PhoneNumbers,
+		PreferredLanguage: out.PreferredLanguage,
+		ProfileUrl:        out.ProfileUrl,
+		Timezone:          out.Timezone,
+		Title:             out.Title,
+		UserId:            out.UserId,
+		UserName:          out.UserName,
+		UserType:          out.UserType,
+	}
+}
+
+// refreshCache fetches the changed users and groups again, the ones that no
+// longer exist are dropped
+func (s *syncGSuite) refreshCache(c *correlationCache) error {
+	for _, id := range c.ChangedUsers {
+		c.apply(auditRecord{Operation: auditDeleteUser, UserID: id})
+
+		out, err := s.identityStoreClient.DescribeUser(&identitystore.DescribeUserInput{
+			IdentityStoreId: &s.cfg.IdentityStoreID,
+			UserId:          aws_sdk.String(id),
+		})
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		c.Users = append(c.Users, ConvertSdkUserObjToNative(describedUser(out)))
+	}
+
+	for _, id := range c.ChangedGroups {
+		c.apply(auditRecord{Operation: auditDeleteGroup, GroupID: id})
+
+		out, err := s.identityStoreClient.DescribeGroup(&identitystore.DescribeGroupInput{
+			IdentityStoreId: &s.cfg.IdentityStoreID,
+			GroupId:         aws_sdk.String(id),
+		})
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		c.Groups = append(c.Groups, &aws.Group{
+			ID:          aws_sdk.StringValue(out.GroupId),
+			Schemas:     []string{"urn:ietf:params:scim:schemas:core:2.0:Group"},
+			DisplayName: aws_sdk.StringValue(out.DisplayName),
+			Members:     []string{},
+		})
+	}
+
+	c.ChangedUsers, c.ChangedGroups = nil, nil
+	return nil
+}
+
+// loadCache reads the correlation cache, falling back to listing the
+// identity store when there is none or it's of another identity store, too
+// old or can't be refreshed
+func (s *syncGSuite) loadCache(now time.Time) {
+	if s.cacheStore == nil || s.cfg.CorrelationCacheS3URI == "" {
+		return
+	}
+
+	s.cache = &correlationCache{IdentityStoreID: s.cfg.IdentityStoreID, ListedAt: now}
+	s.cacheHit = false
+
+	logger := log.WithField("uri", s.cfg.CorrelationCacheS3URI)
+
+	var c correlationCache
+	if err := getS3Object(s.cacheStore, s.cfg.CorrelationCacheS3URI, &c); err != nil {
+		logger.WithField("error", err).Info("no usable correlation cache, listing the identity store")
+		return
+	}
+
+	maxAge := time.Duration(s.cfg.CorrelationCacheMaxAge) * time.Minute
+	switch {
+	case c.IdentityStoreID != s.cfg.IdentityStoreID:
+		logger.WithField("identity_store_id", c.IdentityStoreID).Warn("correlation cache is of another identity store, listing the identity store")
+		return
+	case maxAge > 0 && now.Sub(c.ListedAt) > maxAge:
+		logger.WithField("listed_at", c.ListedAt).Info("correlation cache is too old, listing the identity store")
+		return
+	}
+
+	if err := s.refreshCache(&c); err != nil {
+		logger.WithField("error", err).Warn("refreshing the correlation cache, listing the identity store")
+		return
+	}
+
+	logger.WithFields(log.Fields{"listed_at": c.ListedAt, "users": len(c.Users), "groups": len(c.Groups)}).Info("using the correlation cache")
+	s.cache = &c
+	s.cacheHit = true
+}
+
+// saveCache writes the correlation cache of a successful run. After a failed
+// run it's removed, as the failure may come from the cache being stale. The
+// cache only saves calls, so its failures are logged rather than failing
+// the run.
+func (s *syncGSuite) saveCache(syncErr error) {
+	if s.cache == nil {
+		return
+	}
+
+	logger := log.WithField("uri", s.cfg.CorrelationCacheS3URI)
+
+	if syncErr != nil {
+		logger.Warn("sync failed, removing the correlation cache")
+		if err := deleteS3Object(s.cacheStore, s.cfg.CorrelationCacheS3URI); err != nil {
+			logger.WithField("error", err).Warn("removing the correlation cache")
+		}
+		return
+	}
+
+	s.cache.mu.Lock()
+	defer s.cache.mu.Unlock()
+
+	logger.Info("writing the correlation cache")
+	if err := putS3Object(s.cacheStore, s.cfg.CorrelationCacheS3URI, s.cache); err != nil {
+		logger.WithField("error", err).Warn("writing the correlation cache")
+	}
+}
+
+// listGroups returns the aws groups of the correlation cache when it's used,
+// they are listed from the identity store otherwise
+func (s *syncGSuite) listGroups() ([]*aws.Group, error) {
+	if s.cacheHit {
+		return s.cache.Groups, nil
+	}
+
+	groups, err := s.GetGroups()
+	if err == nil && s.cache != nil {
+		s.cache.Groups = groups
+	}
+	return groups, err
+}
+
+// listUsers returns the aws users of the correlation cache when it's used,
+// they are listed from the identity store otherwise
+func (s *syncGSuite) listUsers() ([]*aws.User, error) {
+	if s.cacheHit {
+		return s.cache.Users, nil
+	}
+
+	users, err := s.GetUsers()
+	if err == nil && s.cache != nil {
+		s.cache.Users = users
+	}
+	return users, err
+}
+
+// record writes the change to the audit log and keeps the correlation cache
+// up to date with it
+func (s *syncGSuite) record(r auditRecord) {
+	s.audit.record(r)
+	s.cache.apply(r)
+}
+
+// getS3Object reads the json object at the s3://bucket/key uri into v
+func getS3Object(store objectStore, uri string, v interface{}) error {
+	bucket, key, err := parseS3URI(uri)
+	if err != nil {
+		return err
+	}
+
+	out, err := store.GetObject(&s3.GetObjectInput{Bucket: aws_sdk.String(bucket), Key: aws_sdk.String(key)})
+	if err != nil {
+		return err
+	}
+	defer out.Body.Close()
+
+	b, err := ioutil.ReadAll(out.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+// deleteS3Object removes the object at the s3://bucket/key uri
+func deleteS3Object(store objectStore, uri string) error {
+	bucket, key, err := parseS3URI(uri)
+	if err != nil {
+		return err
+	}
+
+	_, err = store.DeleteObject(&s3.DeleteObjectInput{Bucket: aws_sdk.String(bucket), Key: aws_sdk.String(key)})
+	return err
+}
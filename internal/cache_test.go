@@ -0,0 +1,300 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/awslabs/ssosync/internal/aws"
+	"github.com/awslabs/ssosync/internal/config"
+	"github.com/awslabs/ssosync/internal/mocks"
+
+	aws_sdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/identitystore"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/googleapi"
+)
+
+const testCacheURI = "s3://bucket/cache.json"
+
+// fakeObjectStore keeps the objects written to it in memory
+type fakeObjectStore struct {
+	fakePutter
+	deleted []string
+}
+
+func (f *fakeObjectStore) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	b, ok := f.objects[*input.Bucket+"/"+*input.Key]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "no such key", nil)
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(b))}, nil
+}
+
+func (f *fakeObjectStore) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	delete(f.objects, *input.Bucket+"/"+*input.Key)
+	f.deleted = append(f.deleted, *input.Bucket+"/"+*input.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+// storeCache writes the cache to the fake store as a previous run would
+func storeCache(t *testing.T, store *fakeObjectStore, c *correlationCache) {
+	b, err := json.Marshal(c)
+	assert.NoError(t, err)
+	store.objects = map[string][]byte{"bucket/cache.json": b}
+}
+
+func newTestCacheSync(ctrl *gomock.Controller) (*syncGSuite, *mocks.MockIdentityStoreAPI, *fakeObjectStore) {
+	cfg := config.New()
+	cfg.IdentityStoreID = "test-identity-store-id"
+	cfg.CorrelationCacheS3URI = testCacheURI
+
+	mockIdentityStoreClient := mocks.NewMockIdentityStoreAPI(ctrl)
+	store := &fakeObjectStore{}
+	s := &syncGSuite{cfg: cfg, identityStoreClient: mockIdentityStoreClient, cacheStore: store}
+	return s, mockIdentityStoreClient, store
+}
+
+func Test_correlationCacheApply(t *testing.T) {
+	c := &correlationCache{
+		Users:  []*aws.User{{ID: "id-user-1"}, {ID: "id-user-2"}},
+		Groups: []*aws.Group{{ID: "group-1"}},
+	}
+	users := c.Users
+
+	c.apply(auditRecord{Operation: auditCreateUser, UserID: "id-user-3"})
+	c.apply(auditRecord{Operation: auditUpdateUser, UserID: "id-user-3"})
+	c.apply(auditRecord{Operation: auditUpdateUser, UserID: "id-user-1"})
+	c.apply(auditRecord{Operation: auditDeleteUser, UserID: "id-user-1"})
+	c.apply(auditRecord{Operation: auditCreateGroup, GroupID: "group-2"})
+	c.apply(auditRecord{Operation: auditDeleteGroup, GroupID: "group-1"})
+	c.apply(auditRecord{Operation: auditAddMember, UserID: "id-user-2", GroupID: "group-2"})
+
+	assert.Equal(t, []*aws.User{{ID: "id-user-2"}}, c.Users)
+	assert.Equal(t, []*aws.Group{}, c.Groups)
+	assert.Equal(t, []string{"id-user-3"}, c.ChangedUsers)
+	assert.Equal(t, []string{"group-2"}, c.ChangedGroups)
+
+	// the listed users aren't changed in place
+	assert.Len(t, users, 2)
+
+	// a nil cache keeps nothing
+	var none *correlationCache
+	none.apply(auditRecord{Operation: auditDeleteUser})
+}
+
+func Test_loadCache(t *testing.T) {
+	now := time.Date(2022, 9, 1, 10, 0, 0, 0, time.UTC)
+	cached := &correlationCache{
+		IdentityStoreID: "test-identity-store-id",
+		ListedAt:        now.Add(-30 * time.Minute),
+		Users:           []*aws.User{{ID: "id-user-1", Username: "user-1@email.com"}},
+		Groups:          []*aws.Group{{ID: "group-1", DisplayName: "group-1"}},
+	}
+
+	tests := []struct {
+		name   string
+		cache  func() *correlationCache
+		maxAge int
+		hit    bool
+	}{
+		{name: "no cache", cache: func() *correlationCache { return nil }},
+		{name: "fresh", cache: func() *correlationCache { return cached }, hit: true},
+		{
+			name: "other identity store",
+			cache: func() *correlationCache {
+				return &correlationCache{IdentityStoreID: "other-identity-store-id", ListedAt: cached.ListedAt, Users: cached.Users}
+			},
+		},
+		{name: "stale", cache: func() *correlationCache { return cached }, maxAge: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			s, _, store := newTestCacheSync(ctrl)
+			s.cfg.CorrelationCacheMaxAge = tt.maxAge
+			if c := tt.cache(); c != nil {
+				storeCache(t, store, c)
+			}
+
+			s.loadCache(now)
+			assert.Equal(t, tt.hit, s.cacheHit)
+			if tt.hit {
+				assert.Equal(t, cached.ListedAt, s.cache.ListedAt)
+				assert.Equal(t, cached.Users, s.cache.Users)
+				assert.Equal(t, cached.Groups, s.cache.Groups)
+			} else {
+				// a new listing is kept from now on
+				assert.Equal(t, now, s.cache.ListedAt)
+				assert.Empty(t, s.cache.Users)
+			}
+		})
+	}
+}
+
+func Test_loadCacheRefresh(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	now := time.Date(2022, 9, 1, 10, 0, 0, 0, time.UTC)
+	s, mockIdentityStoreClient, store := newTestCacheSync(ctrl)
+	storeCache(t, store, &correlationCache{
+		IdentityStoreID: "test-identity-store-id",
+		ListedAt:        now.Add(-30 * time.Minute),
+		Users:           []*aws.User{{ID: "id-user-1", Username: "user-1@email.com"}, {ID: "id-user-2", Username: "user-2@email.com"}},
+		Groups:          []*aws.Group{{ID: "group-1", DisplayName: "group-1"}},
+		ChangedUsers:    []string{"id-user-2", "id-user-3"},
+		ChangedGroups:   []string{"group-2"},
+	})
+
+	mockIdentityStoreClient.EXPECT().DescribeUser(&identitystore.DescribeUserInput{
+		IdentityStoreId: aws_sdk.String("test-identity-store-id"),
+		UserId:          aws_sdk.String("id-user-2"),
+	}).Return(&identitystore.DescribeUserOutput{
+		UserId:      aws_sdk.String("id-user-2"),
+		UserName:    aws_sdk.String("renamed-2@email.com"),
+		DisplayName: aws_sdk.String("name-2 renamed-2"),
+		Name:        &identitystore.Name{GivenName: aws_sdk.String("name-2"), FamilyName: aws_sdk.String("renamed-2")},
+	}, nil)
+	mockIdentityStoreClient.EXPECT().DescribeUser(&identitystore.DescribeUserInput{
+		IdentityStoreId: aws_sdk.String("test-identity-store-id"),
+		UserId:          aws_sdk.String("id-user-3"),
+	}).Return(nil, awserr.New(identitystore.ErrCodeResourceNotFoundException, "user not found", nil))
+	mockIdentityStoreClient.EXPECT().DescribeGroup(gomock.Any()).Return(&identitystore.DescribeGroupOutput{
+		GroupId:     aws_sdk.String("group-2"),
+		DisplayName: aws_sdk.String("group-2"),
+	}, nil)
+
+	s.loadCache(now)
+	assert.True(t, s.cacheHit)
+
+	users, err := s.listUsers()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user-1@email.com", "renamed-2@email.com"}, []string{users[0].Username, users[1].Username})
+	assert.Len(t, users, 2)
+
+	groups, err := s.listGroups()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"group-1", "group-2"}, []string{groups[0].DisplayName, groups[1].DisplayName})
+	assert.Empty(t, s.cache.ChangedUsers)
+	assert.Empty(t, s.cache.ChangedGroups)
+}
+
+func Test_loadCacheRefreshUserAttributes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	now := time.Date(2022, 9, 1, 10, 0, 0, 0, time.UTC)
+	s, mockIdentityStoreClient, store := newTestCacheSync(ctrl)
+	storeCache(t, store, &correlationCache{
+		IdentityStoreID: "test-identity-store-id",
+		ListedAt:        now.Add(-30 * time.Minute),
+		ChangedUsers:    []string{"id-user-1"},
+	})
+
+	mockIdentityStoreClient.EXPECT().DescribeUser(gomock.Any()).Return(&identitystore.DescribeUserOutput{
+		UserId:      aws_sdk.String("id-user-1"),
+		UserName:    aws_sdk.String("user-1@email.com"),
+		DisplayName: aws_sdk.String("name-1 lastname-1"),
+		Name:        &identitystore.Name{GivenName: aws_sdk.String("name-1"), FamilyName: aws_sdk.String("lastname-1")},
+		Emails: []*identitystore.Email{
+			{Value: aws_sdk.String("user-1@email.com"), Type: aws_sdk.String("work"), Primary: aws_sdk.Bool(true)},
+		},
+		PhoneNumbers: []*identitystore.PhoneNumber{
+			{Value: aws_sdk.String("+1 555 0100"), Type: aws_sdk.String("work"), Primary: aws_sdk.Bool(true)},
+		},
+		Title:             aws_sdk.String("Engineer"),
+		UserType:          aws_sdk.String("Contractor"),
+		Locale:            aws_sdk.String("fr-CA"),
+		Timezone:          aws_sdk.String("America/Toronto"),
+		PreferredLanguage: aws_sdk.String("fr-CA"),
+	}, nil)
+
+	s.loadCache(now)
+	assert.True(t, s.cacheHit)
+
+	// the refreshed user has every synced attribute, it's not updated again
+	gUser := &admin.User{
+		Name:          &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1"},
+		PrimaryEmail:  "user-1@email.com",
+		Phones:        []interface{}{map[string]interface{}{"value": "+1 555 0100", "type": "work", "primary": true}},
+		Organizations: []interface{}{map[string]interface{}{"title": "Engineer", "description": "Contractor", "primary": true}},
+		Languages:     []interface{}{map[string]interface{}{"languageCode": "fr-CA"}},
+		CustomSchemas: map[string]googleapi.RawMessage{"Location": googleapi.RawMessage(`{"Timezone": "America/Toronto"}`)},
+	}
+	mapping := userMapping{
+		title:             true,
+		userTypeSource:    config.UserTypeSourceEmployeeType,
+		locale:            true,
+		timezoneField:     "Location.Timezone",
+		preferredLanguage: true,
+	}
+
+	// the identity store has no status, the sync reads it from scim
+	assert.Len(t, s.cache.Users, 1)
+	s.cache.Users[0].Active = true
+	assert.Empty(t, getUserUpdateReasons(s.cache.Users[0], gUser, mapping))
+}
+
+func Test_loadCacheRefreshError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	now := time.Date(2022, 9, 1, 10, 0, 0, 0, time.UTC)
+	s, mockIdentityStoreClient, store := newTestCacheSync(ctrl)
+	storeCache(t, store, &correlationCache{
+		IdentityStoreID: "test-identity-store-id",
+		ListedAt:        now.Add(-30 * time.Minute),
+		ChangedUsers:    []string{"id-user-1"},
+	})
+
+	mockIdentityStoreClient.EXPECT().DescribeUser(gomock.Any()).Return(nil, errors.New("throttled"))
+
+	s.loadCache(now)
+	assert.False(t, s.cacheHit)
+}
+
+func Test_saveCache(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	now := time.Date(2022, 9, 1, 10, 0, 0, 0, time.UTC)
+	s, _, store := newTestCacheSync(ctrl)
+	s.cache = &correlationCache{IdentityStoreID: "test-identity-store-id", ListedAt: now, Users: []*aws.User{{ID: "id-user-1"}}}
+	s.record(auditRecord{Operation: auditCreateUser, UserID: "id-user-2"})
+
+	s.saveCache(nil)
+
+	var saved correlationCache
+	assert.NoError(t, json.Unmarshal(store.objects["bucket/cache.json"], &saved))
+	assert.Equal(t, now, saved.ListedAt)
+	assert.Equal(t, []string{"id-user-2"}, saved.ChangedUsers)
+
+	// a failed run invalidates the cache
+	s.saveCache(errors.New("sync failed"))
+	assert.Equal(t, []string{"bucket/cache.json"}, store.deleted)
+	assert.Empty(t, store.objects)
+}
@@ -0,0 +1,298 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/awslabs/ssosync/internal/aws"
+	"github.com/awslabs/ssosync/internal/config"
+
+	aws_sdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+const (
+	// checkpointTableKey is the partition key of the checkpoint table, the
+	// checkpoint of each identity store is kept under its id
+	checkpointTableKey = "identityStoreId"
+	// checkpointTableAttribute holds the checkpoint json in the table
+	checkpointTableAttribute = "checkpoint"
+)
+
+// Checkpoint is the state applied by the last successful sync. The hashes of
+// the google users and groups let the next run skip the ones unchanged since.
+type Checkpoint struct {
+	IdentityStoreID string    `json:"identityStoreId"`
+	SyncedAt        time.Time `json:"syncedAt"`
+	// Users are the hashes of the applied google users by email
+	Users map[string]string `json:"users"`
+	// Groups are the hashes of the applied group members by aws group name
+	Groups map[string]string `json:"groups"`
+}
+
+// Checkpointer keeps the checkpoint between runs
+type Checkpointer interface {
+	// Load returns the checkpoint of the last successful run, nil when there is none
+	Load() (*Checkpoint, error)
+	// Save replaces the checkpoint
+	Save(*Checkpoint) error
+}
+
+// newCheckpointer returns the checkpointer of the configured table or
+// bucket, nil when incremental sync isn't requested
+func newCheckpointer(sess *session.Session, cfg *config.Config) Checkpointer {
+	switch {
+	case cfg.CheckpointTable != "":
+		return &dynamoCheckpointer{api: dynamodb.New(sess), table: cfg.CheckpointTable, identityStoreID: cfg.IdentityStoreID}
+	case cfg.CheckpointBucket != "":
+		return &s3Checkpointer{store: s3.New(sess), uri: cfg.CheckpointBucket}
+	}
+	return nil
+}
+
+// s3Checkpointer keeps the checkpoint as a json object at a s3://bucket/key
+type s3Checkpointer struct {
+	store objectStore
+	uri   string
+}
+
+// Load reads the checkpoint object, a missing object is no checkpoint
+func (c *s3Checkpointer) Load() (*Checkpoint, error) {
+	var checkpoint Checkpoint
+	err := getS3Object(c.store, c.uri, &checkpoint)
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+// Save writes the checkpoint object
+func (c *s3Checkpointer) Save(checkpoint *Checkpoint) error {
+	return putS3Object(c.store, c.uri, checkpoint)
+}
+
+// dynamoCheckpointer keeps the checkpoint of the identity store as an item
+// of a DynamoDB table, keyed by identityStoreId
+type dynamoCheckpointer struct {
+	api             dynamodbiface.DynamoDBAPI
+	table           string
+	identityStoreID string
+}
+
+// Load reads the checkpoint item, a missing item is no checkpoint
+func (c *dynamoCheckpointer) Load() (*Checkpoint, error) {
+	out, err := c.api.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws_sdk.String(c.table),
+		Key:            map[string]*dynamodb.AttributeValue{checkpointTableKey: {S: aws_sdk.String(c.identityStoreID)}},
+		ConsistentRead: aws_sdk.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	attr, found := out.Item[checkpointTableAttribute]
+	if !found || attr.S == nil {
+		return nil, nil
+	}
+
+	var checkpoint Checkpoint
+	if err := json.Unmarshal([]byte(*attr.S), &checkpoint); err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+// Save writes the checkpoint item
+func (c *dynamoCheckpointer) Save(checkpoint *Checkpoint) error {
+	b, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.api.PutItem(&dynamodb.PutItemInput{
+		TableName: aws_sdk.String(c.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			checkpointTableKey:       {S: aws_sdk.String(c.identityStoreID)},
+			checkpointTableAttribute: {S: aws_sdk.String(string(b))},
+		},
+	})
+	return err
+}
+
+// stateHash returns the sha256 of the json of v
+func stateHash(v interface{}) string {
+	// the hashed states are plain structs and strings, they always marshal
+	b, _ := json.Marshal(v)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// userHashes returns the hashes of the google users, by email, of the
+// attributes and manager the sync applies to their aws user
+func userHashes(googleUsers []*admin.User, mapping userMapping) map[string]string {
+	hashes := make(map[string]string, len(googleUsers))
+	for _, u := range googleUsers {
+		hashes[u.PrimaryEmail] = stateHash(struct {
+			User    *aws.User `json:"user"`
+			Manager string    `json:"manager"`
+		}{awsUserFromGoogle(u, mapping), googleManager(u)})
+	}
+	return hashes
+}
+
+// groupHashes returns the hashes of the members of the groups, by aws group name
+func groupHashes(googleGroupsUsers map[string][]*admin.User) map[string]string {
+	hashes := make(map[string]string, len(googleGroupsUsers))
+	for name, users := range googleGroupsUsers {
+		emails := make([]string, 0, len(users))
+		for _, u := range users {
+			emails = append(emails, u.PrimaryEmail)
+		}
+		sort.Strings(emails)
+		hashes[name] = stateHash(emails)
+	}
+	return hashes
+}
+
+// unchanged returns the keys whose hash is the one of the checkpoint
+func unchanged(hashes map[string]string, checkpointed map[string]string) map[string]struct{} {
+	same := make(map[string]struct{})
+	for key, hash := range hashes {
+		if checkpointed[key] == hash {
+			same[key] = struct{}{}
+		}
+	}
+	return same
+}
+
+// appliedCheckpoint returns the checkpoint of the hashes of a successful run,
+// without the users it skipped and the groups they are members of
+func appliedCheckpoint(users map[string]string, groups map[string]string, googleGroupsUsers map[string][]*admin.User, skipped ...map[string]struct{}) *Checkpoint {
+	isSkipped := func(email string) bool {
+		for _, s := range skipped {
+			if _, found := s[email]; found {
+				return true
+			}
+		}
+		return false
+	}
+
+	checkpoint := &Checkpoint{Users: make(map[string]string), Groups: make(map[string]string)}
+	for email, hash := range users {
+		if !isSkipped(email) {
+			checkpoint.Users[email] = hash
+		}
+	}
+
+groups:
+	for name, hash := range groups {
+		for _, u := range googleGroupsUsers[name] {
+			if isSkipped(u.PrimaryEmail) {
+				continue groups
+			}
+		}
+		checkpoint.Groups[name] = hash
+	}
+
+	return checkpoint
+}
+
+// loadCheckpoint reads the checkpoint of the last successful run, the run
+// compares everything when there is none or it's of another identity store
+func (s *syncGSuite) loadCheckpoint() {
+	s.checkpoint = nil
+	s.applied = nil
+	if s.checkpointer == nil {
+		return
+	}
+
+	checkpoint, err := s.checkpointer.Load()
+	switch {
+	case err != nil:
+		log.WithField("error", err).Warn("reading the checkpoint, comparing all users and groups")
+		return
+	case checkpoint == nil:
+		log.Info("no checkpoint, comparing all users and groups")
+		return
+	case checkpoint.IdentityStoreID != s.cfg.IdentityStoreID:
+		log.WithField("identity_store_id", checkpoint.IdentityStoreID).Warn("checkpoint is of another identity store, comparing all users and groups")
+		return
+	}
+
+	log.WithFields(log.Fields{"synced_at": checkpoint.SyncedAt, "users": len(checkpoint.Users), "groups": len(checkpoint.Groups)}).Info("using the checkpoint")
+	s.checkpoint = checkpoint
+}
+
+// unchangedSinceCheckpoint returns the users and groups whose hash is the
+// one applied by the last successful run, none without a checkpoint
+func (s *syncGSuite) unchangedSinceCheckpoint(users map[string]string, groups map[string]string) (map[string]struct{}, map[string]struct{}) {
+	if s.checkpoint == nil {
+		return map[string]struct{}{}, map[string]struct{}{}
+	}
+	return unchanged(users, s.checkpoint.Users), unchanged(groups, s.checkpoint.Groups)
+}
+
+// equalMembersToVerify returns the members of a group unchanged since the
+// checkpoint that are confirmed in aws, the others are assumed to still be
+// members as the last run left them
+func (s *syncGSuite) equalMembersToVerify(members []*admin.User) []*admin.User {
+	switch s.cfg.VerifyEqualMembers {
+	case config.VerifyEqualMembersAll:
+		return members
+	case config.VerifyEqualMembersSample:
+		sample := make([]*admin.User, 0)
+		for _, u := range members {
+			if rand.Intn(100) < s.cfg.VerifyEqualMembersPercent {
+				sample = append(sample, u)
+			}
+		}
+		return sample
+	}
+	return nil
+}
+
+// saveCheckpoint writes the hashes applied by a successful run. A failed run
+// keeps the previous checkpoint, and as the checkpoint only saves calls its
+// failures are logged rather than failing the run.
+func (s *syncGSuite) saveCheckpoint(syncErr error, now time.Time) {
+	if s.checkpointer == nil || syncErr != nil || s.applied == nil {
+		return
+	}
+
+	s.applied.IdentityStoreID = s.cfg.IdentityStoreID
+	s.applied.SyncedAt = now
+
+	log.Info("writing the checkpoint")
+	if err := s.checkpointer.Save(s.applied); err != nil {
+		log.WithField("error", err).Warn("writing the checkpoint")
+	}
+}
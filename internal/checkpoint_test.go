@@ -0,0 +1,263 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/awslabs/ssosync/internal/config"
+
+	aws_sdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/identitystore"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// memoryCheckpointer keeps the checkpoint in memory
+type memoryCheckpointer struct {
+	checkpoint *Checkpoint
+	loadErr    error
+	saves      int
+}
+
+func (m *memoryCheckpointer) Load() (*Checkpoint, error) {
+	return m.checkpoint, m.loadErr
+}
+
+func (m *memoryCheckpointer) Save(c *Checkpoint) error {
+	m.checkpoint = c
+	m.saves++
+	return nil
+}
+
+// fakeDynamo keeps the items of the checkpoint table in memory
+type fakeDynamo struct {
+	dynamodbiface.DynamoDBAPI
+	items map[string]map[string]*dynamodb.AttributeValue
+}
+
+func (f *fakeDynamo) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: f.items[*input.TableName+"/"+*input.Key[checkpointTableKey].S]}, nil
+}
+
+func (f *fakeDynamo) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	if f.items == nil {
+		f.items = make(map[string]map[string]*dynamodb.AttributeValue)
+	}
+	f.items[*input.TableName+"/"+*input.Item[checkpointTableKey].S] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func Test_Checkpointers(t *testing.T) {
+	checkpoint := &Checkpoint{
+		IdentityStoreID: "test-identity-store-id",
+		SyncedAt:        time.Date(2022, 9, 1, 10, 0, 0, 0, time.UTC),
+		Users:           map[string]string{"user-1@email.com": "hash-1"},
+		Groups:          map[string]string{"group-1": "hash-2"},
+	}
+
+	checkpointers := map[string]Checkpointer{
+		"memory": &memoryCheckpointer{},
+		"s3":     &s3Checkpointer{store: &fakeObjectStore{}, uri: "s3://bucket/checkpoint.json"},
+		"dynamo": &dynamoCheckpointer{api: &fakeDynamo{}, table: "checkpoints", identityStoreID: "test-identity-store-id"},
+	}
+	for name, c := range checkpointers {
+		t.Run(name, func(t *testing.T) {
+			// there is no checkpoint before the first save
+			loaded, err := c.Load()
+			assert.NoError(t, err)
+			assert.Nil(t, loaded)
+
+			assert.NoError(t, c.Save(checkpoint))
+			loaded, err = c.Load()
+			assert.NoError(t, err)
+			assert.Equal(t, checkpoint, loaded)
+		})
+	}
+}
+
+func Test_appliedCheckpoint(t *testing.T) {
+	users := map[string]string{"user-1@email.com": "hash-1", "user-2@email.com": "hash-2", "user-3@email.com": "hash-3"}
+	groups := map[string]string{"group-1": "hash-4", "group-2": "hash-5"}
+	googleGroupsUsers := map[string][]*admin.User{
+		"group-1": {{PrimaryEmail: "user-1@email.com"}},
+		"group-2": {{PrimaryEmail: "user-1@email.com"}, {PrimaryEmail: "user-3@email.com"}},
+	}
+
+	// the skipped users and their groups are compared again on the next run
+	got := appliedCheckpoint(users, groups, googleGroupsUsers,
+		map[string]struct{}{"user-2@email.com": {}}, map[string]struct{}{"user-3@email.com": {}})
+	assert.Equal(t, &Checkpoint{
+		Users:  map[string]string{"user-1@email.com": "hash-1"},
+		Groups: map[string]string{"group-1": "hash-4"},
+	}, got)
+}
+
+func Test_stateHashes(t *testing.T) {
+	mapping := newUserMapping(config.New())
+	user := &admin.User{Name: &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1"}, PrimaryEmail: "user-1@email.com"}
+	renamed := &admin.User{Name: &admin.UserName{GivenName: "name-1", FamilyName: "renamed-1"}, PrimaryEmail: "user-1@email.com"}
+
+	assert.Equal(t, userHashes([]*admin.User{user}, mapping), userHashes([]*admin.User{user}, mapping))
+	assert.NotEqual(t, userHashes([]*admin.User{user}, mapping), userHashes([]*admin.User{renamed}, mapping))
+
+	// the members are hashed in any order
+	user2 := &admin.User{PrimaryEmail: "user-2@email.com"}
+	assert.Equal(t,
+		groupHashes(map[string][]*admin.User{"group-1": {user, user2}}),
+		groupHashes(map[string][]*admin.User{"group-1": {user2, user}}))
+	assert.NotEqual(t,
+		groupHashes(map[string][]*admin.User{"group-1": {user, user2}}),
+		groupHashes(map[string][]*admin.User{"group-1": {user}}))
+}
+
+func Test_SyncGroupsUsersCheckpoint(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := config.New()
+	cfg.IdentityStoreID = "test-identity-store-id"
+	checkpointer := &memoryCheckpointer{}
+
+	// the first run compares everything and saves what it applied
+	s, mockIdentityStoreClient, f := newTestSyncGroupsUsers(ctrl, cfg)
+	s.(*syncGSuite).checkpointer = checkpointer
+	expectSyncGroupsUsersChanges(mockIdentityStoreClient)
+
+	assert.NoError(t, s.SyncGroupsUsers("*", ""))
+	assert.Equal(t, 1, checkpointer.saves)
+	assert.Equal(t, "test-identity-store-id", checkpointer.checkpoint.IdentityStoreID)
+	assert.Len(t, checkpointer.checkpoint.Users, 2)
+	assert.Len(t, checkpointer.checkpoint.Groups, 2)
+	assert.Equal(t, 4, f.aws.lookups["user-2@email.com"])
+
+	// the next run skips the status lookup of the unchanged users and the
+	// member checks of the unchanged groups, group-2 keeps the aws member
+	// user-2 was not added to but still loses user-3
+	s, mockIdentityStoreClient, f = newTestSyncGroupsUsers(ctrl, cfg)
+	s.(*syncGSuite).checkpointer = checkpointer
+	mockIdentityStoreClient.EXPECT().DeleteUserWithContext(gomock.Any(), gomock.Any()).Return(&identitystore.DeleteUserOutput{}, nil)
+	mockIdentityStoreClient.EXPECT().CreateGroupWithContext(gomock.Any(), gomock.Any()).Return(&identitystore.CreateGroupOutput{GroupId: aws_sdk.String("group-1")}, nil)
+	mockIdentityStoreClient.EXPECT().CreateGroupMembership(gomock.Any()).Times(2).Return(&identitystore.CreateGroupMembershipOutput{}, nil)
+	mockIdentityStoreClient.EXPECT().GetGroupMembershipId(gomock.Any()).Return(&identitystore.GetGroupMembershipIdOutput{MembershipId: aws_sdk.String("membership-1")}, nil)
+	mockIdentityStoreClient.EXPECT().DeleteGroupMembership(gomock.Any()).Return(&identitystore.DeleteGroupMembershipOutput{}, nil)
+	mockIdentityStoreClient.EXPECT().DeleteGroupWithContext(gomock.Any(), gomock.Any()).Return(&identitystore.DeleteGroupOutput{}, nil)
+
+	assert.NoError(t, s.SyncGroupsUsers("*", ""))
+	assert.Equal(t, 2, checkpointer.saves)
+	assert.Equal(t, 2, f.aws.lookups["user-2@email.com"])
+	assert.Equal(t, 2, s.Stats().MembershipsAdded)
+	assert.Equal(t, 1, s.Stats().MembershipsRemoved)
+}
+
+func Test_SyncGroupsUsersVerifyEqualMembers(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		percent int
+		readded bool
+	}{
+		{name: "none", mode: config.VerifyEqualMembersNone},
+		{name: "sample none", mode: config.VerifyEqualMembersSample, percent: 0},
+		{name: "sample all", mode: config.VerifyEqualMembersSample, percent: 100, readded: true},
+		{name: "all", mode: config.VerifyEqualMembersAll, readded: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			cfg := config.New()
+			cfg.IdentityStoreID = "test-identity-store-id"
+			cfg.VerifyEqualMembers = tt.mode
+			cfg.VerifyEqualMembersPercent = tt.percent
+			assert.NoError(t, validateConfig(cfg))
+
+			// user-2 was applied to group-2 by the last run, and its
+			// membership has since been removed in aws
+			s, mockIdentityStoreClient, f := newTestSyncGroupsUsers(ctrl, cfg)
+			s.(*syncGSuite).checkpointer = &memoryCheckpointer{checkpoint: &Checkpoint{
+				IdentityStoreID: "test-identity-store-id",
+				Users:           userHashes(f.googleUsers, newUserMapping(cfg)),
+				Groups:          groupHashes(map[string][]*admin.User{"group-2": {f.googleUsers[1]}}),
+			}}
+
+			if tt.readded {
+				expectSyncGroupsUsersChanges(mockIdentityStoreClient)
+			} else {
+				mockIdentityStoreClient.EXPECT().DeleteUserWithContext(gomock.Any(), gomock.Any()).Return(&identitystore.DeleteUserOutput{}, nil)
+				mockIdentityStoreClient.EXPECT().CreateGroupWithContext(gomock.Any(), gomock.Any()).Return(&identitystore.CreateGroupOutput{GroupId: aws_sdk.String("group-1")}, nil)
+				mockIdentityStoreClient.EXPECT().CreateGroupMembership(gomock.Any()).Times(2).Return(&identitystore.CreateGroupMembershipOutput{}, nil)
+				mockIdentityStoreClient.EXPECT().GetGroupMembershipId(gomock.Any()).Return(&identitystore.GetGroupMembershipIdOutput{MembershipId: aws_sdk.String("membership-1")}, nil)
+				mockIdentityStoreClient.EXPECT().DeleteGroupMembership(gomock.Any()).Return(&identitystore.DeleteGroupMembershipOutput{}, nil)
+				mockIdentityStoreClient.EXPECT().DeleteGroupWithContext(gomock.Any(), gomock.Any()).Return(&identitystore.DeleteGroupOutput{}, nil)
+			}
+
+			assert.NoError(t, s.SyncGroupsUsers("*", ""))
+			if tt.readded {
+				assert.Equal(t, 3, s.Stats().MembershipsAdded)
+			} else {
+				assert.Equal(t, 2, s.Stats().MembershipsAdded)
+			}
+		})
+	}
+
+	cfg := config.New()
+	cfg.VerifyEqualMembers = "some"
+	assert.Error(t, validateConfig(cfg))
+
+	cfg = config.New()
+	cfg.VerifyEqualMembers = config.VerifyEqualMembersSample
+	cfg.VerifyEqualMembersPercent = 150
+	assert.Error(t, validateConfig(cfg))
+}
+
+func Test_loadCheckpoint(t *testing.T) {
+	cfg := config.New()
+	cfg.IdentityStoreID = "test-identity-store-id"
+
+	tests := []struct {
+		name         string
+		checkpointer *memoryCheckpointer
+		used         bool
+	}{
+		{name: "none", checkpointer: &memoryCheckpointer{}},
+		{name: "error", checkpointer: &memoryCheckpointer{loadErr: errors.New("access denied")}},
+		{name: "other identity store", checkpointer: &memoryCheckpointer{checkpoint: &Checkpoint{IdentityStoreID: "other"}}},
+		{name: "used", checkpointer: &memoryCheckpointer{checkpoint: &Checkpoint{IdentityStoreID: "test-identity-store-id"}}, used: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &syncGSuite{cfg: cfg, checkpointer: tt.checkpointer}
+			s.loadCheckpoint()
+			assert.Equal(t, tt.used, s.checkpoint != nil)
+
+			// a failed run keeps the previous checkpoint
+			s.applied = &Checkpoint{}
+			s.saveCheckpoint(errors.New("sync failed"), time.Now())
+			assert.Equal(t, 0, tt.checkpointer.saves)
+		})
+	}
+
+	// both a table and a bucket can't be set
+	cfg.CheckpointTable = "checkpoints"
+	cfg.CheckpointBucket = "s3://bucket/checkpoint.json"
+	assert.Error(t, validateConfig(cfg))
+}
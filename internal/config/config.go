@@ -1,6 +1,8 @@
 // Package config ...
 package config
 
+import "time"
+
 // Config ...
 type Config struct {
 	// Verbose toggles the verbosity
@@ -31,12 +33,196 @@ type Config struct {
 	IgnoreGroups []string `mapstructure:"ignore_groups"`
 	// Include groups ...
 	IncludeGroups []string `mapstructure:"include_groups"`
+	// IncludeUsers restricts the synced google users to these, empty includes all
+	IncludeUsers []string `mapstructure:"include_users"`
 	// SyncMethod allow to defined the sync method used to get the user and groups from Google Workspace
 	SyncMethod string `mapstructure:"sync_method"`
 	// Region is the region that the identity store exists on
 	Region string `mapstructure:"region"`
 	// IdentityStoreID is the ID of the identity store
 	IdentityStoreID string `mapstructure:"identity_store_id"`
+	// SCIMVerifyTLSMinVersion is the minimum TLS version accepted from the SCIM endpoint
+	SCIMVerifyTLSMinVersion string `mapstructure:"scim_verify_tls_min_version"`
+	// EmptyGroupAction controls what happens to aws group members when the google group is confirmed empty
+	EmptyGroupAction string `mapstructure:"empty_group_action"`
+	// GoogleRetryCodes are the HTTP status codes from the Google API that will be retried
+	GoogleRetryCodes []int `mapstructure:"google_retry_on_specific_codes"`
+	// SCIMDisableCreateFallbackFind treats a SCIM create without an id in the response as a failure
+	SCIMDisableCreateFallbackFind bool `mapstructure:"scim_disable_create_fallback_find"`
+	// SyncAttributes limits the SCIM user attributes sent and compared, empty means all
+	SyncAttributes []string `mapstructure:"sync_attributes"`
+	// GoogleGroupQueryExpansion merges the comma separated group queries into as few calls as possible
+	GoogleGroupQueryExpansion bool `mapstructure:"google_group_query_expansion"`
+	// MigrateGroupNames renames aws groups created by the other sync method instead of creating duplicates
+	MigrateGroupNames bool `mapstructure:"migrate_group_names"`
+	// MaxUsers aborts the sync when google returns more users than this, 0 means no limit
+	MaxUsers int `mapstructure:"max_users"`
+	// ReconcileChunkSize is the number of google users compared per batch, 0 compares all at once
+	ReconcileChunkSize int `mapstructure:"reconcile_chunk_size"`
+	// ReportUnresolvedMembers logs the google group members that could not be resolved to a user
+	ReportUnresolvedMembers bool `mapstructure:"report_unresolved_members"`
+	// SCIMConnectionPoolSize is the number of idle connections kept to the SCIM endpoint, 0 keeps the defaults
+	SCIMConnectionPoolSize int `mapstructure:"scim_connection_pool_size"`
+	// SCIMClientCert is the PEM certificate file presented to the SCIM endpoint, with SCIMClientKey
+	SCIMClientCert string `mapstructure:"scim_client_cert"`
+	// SCIMClientKey is the PEM private key file of SCIMClientCert
+	SCIMClientKey string `mapstructure:"scim_client_key"`
+	// SCIMCACert is the PEM file of the CAs the SCIM endpoint certificate is verified with, empty uses the system CAs
+	SCIMCACert string `mapstructure:"scim_ca_cert"`
+	// HTTPProxy is the proxy of the http calls to the SCIM endpoint, the proxy environment variables are used when no proxy is configured
+	HTTPProxy string `mapstructure:"http_proxy"`
+	// HTTPSProxy is the proxy of the https calls to the SCIM endpoint
+	HTTPSProxy string `mapstructure:"https_proxy"`
+	// NoProxy lists the hosts, domains and cidrs of the SCIM endpoint that are called without the proxy
+	NoProxy string `mapstructure:"no_proxy"`
+	// SCIMTimeout limits each attempt of a call to the SCIM endpoint, 0 means no limit
+	SCIMTimeout time.Duration `mapstructure:"scim_timeout"`
+	// SyncTimeout aborts the whole sync when it runs longer, 0 means no limit
+	SyncTimeout time.Duration `mapstructure:"sync_timeout"`
+	// UnmanagedUserAction controls what happens to aws users that are not in google
+	UnmanagedUserAction string `mapstructure:"unmanaged_user_action"`
+	// SyncGroupAliases also syncs every alias of a google group as an aws group with the same members
+	SyncGroupAliases bool `mapstructure:"sync_group_aliases"`
+	// InvalidUserAction controls what happens to users missing fields required by SCIM
+	InvalidUserAction string `mapstructure:"invalid_user_action"`
+	// GoogleDelegationSubjects overrides the delegated subject per operation, as operation=subject
+	GoogleDelegationSubjects []string `mapstructure:"google_delegation_subject_per_operation"`
+	// ContinueOnMemberError keeps syncing the remaining groups after a group membership change fails
+	ContinueOnMemberError bool `mapstructure:"continue_on_member_error"`
+	// MembershipFetchConcurrency is the number of aws groups whose members are fetched in parallel
+	MembershipFetchConcurrency int `mapstructure:"membership_fetch_concurrency"`
+	// SCIMUnmarshalRetries is the number of retries of a SCIM read whose response was truncated
+	SCIMUnmarshalRetries int `mapstructure:"scim_unmarshal_retries"`
+	// GoogleMemberFetchConcurrency is the number of google groups whose members are fetched in parallel
+	GoogleMemberFetchConcurrency int `mapstructure:"google_member_fetch_concurrency"`
+	// PlanS3URI is the s3://bucket/key the plan of the changes is written to
+	PlanS3URI string `mapstructure:"plan_s3_uri"`
+	// ReportS3URI is the s3://bucket/key the report of the run is written to
+	ReportS3URI string `mapstructure:"report_s3_uri"`
+	// CorrelationCacheS3URI is the s3://bucket/key the aws users and groups are cached at between runs
+	CorrelationCacheS3URI string `mapstructure:"correlation_cache_s3_uri"`
+	// CorrelationCacheMaxAge is the number of minutes after which the aws users and groups are listed again, 0 means no limit
+	CorrelationCacheMaxAge int `mapstructure:"correlation_cache_max_age"`
+	// CheckpointTable is the DynamoDB table the hashes of the applied users and groups are kept in between runs
+	CheckpointTable string `mapstructure:"checkpoint_table"`
+	// CheckpointBucket is the s3://bucket/key the hashes of the applied users and groups are kept at between runs
+	CheckpointBucket string `mapstructure:"checkpoint_bucket"`
+	// VerifyEqualMembers is how many members of the groups unchanged since the checkpoint are confirmed in aws
+	VerifyEqualMembers string `mapstructure:"verify_equal_members"`
+	// VerifyEqualMembersPercent is the share of those members confirmed when sampling
+	VerifyEqualMembersPercent int `mapstructure:"verify_equal_members_percent"`
+	// MaxDeletions aborts the sync when it would delete more users and groups than this, 0 means no limit
+	MaxDeletions int `mapstructure:"max_deletions"`
+	// MaxDeletionsPercent aborts the sync when it would delete more than this percentage of the aws users and groups, 0 means no limit
+	MaxDeletionsPercent int `mapstructure:"max_deletions_percent"`
+	// AllowEmptySource lets google return no users or groups while aws has some, deleting them all
+	AllowEmptySource bool `mapstructure:"allow_empty_source"`
+	// MaxErrors aborts a run continuing on member errors once more than this many failed, 0 means no limit
+	MaxErrors int `mapstructure:"max_errors"`
+	// SyncManager sets the SCIM manager of each user from their google manager relation
+	SyncManager bool `mapstructure:"sync_manager"`
+	// ManagerKey is the attribute of the google users their manager relations reference them by
+	ManagerKey string `mapstructure:"manager_key"`
+	// BackfillExternalIDs sets the google user id as the SCIM externalId of the managed users lacking one, and of the created users
+	BackfillExternalIDs bool `mapstructure:"backfill_external_ids"`
+	// IdentityStoreMaxRetries is the number of retries of throttled or failed identity store calls
+	IdentityStoreMaxRetries int `mapstructure:"identity_store_max_retries"`
+	// GoogleCredentialsSecret is the name or ARN of the secret holding the google credentials, used instead of GoogleCredentials
+	GoogleCredentialsSecret string `mapstructure:"google_credentials_secret"`
+	// ReconcileDryRun logs the external ids reconcile-external-ids would repair without changing them
+	ReconcileDryRun bool `mapstructure:"reconcile_dry_run"`
+	// OutputPlan is the path of the file the plan of the changes is written to
+	OutputPlan string `mapstructure:"output_plan"`
+	// ExportMappings is the path of the file the google to aws id mappings of the synced users and groups are written to
+	ExportMappings string `mapstructure:"export_mappings"`
+	// UserBackend is the api users are created with
+	UserBackend string `mapstructure:"user_backend"`
+	// ReportPermissionSetImpact reports the permission set assignments lost through the deletions
+	ReportPermissionSetImpact bool `mapstructure:"report_permission_set_impact"`
+	// ReportDrift reports the aws users and groups that have no counterpart in google
+	ReportDrift bool `mapstructure:"report_drift"`
+	// SSOInstanceArn is the instance the permission set assignments are listed from
+	SSOInstanceArn string `mapstructure:"sso_instance_arn"`
+	// GroupNamePrefix is prepended to the google group name to name the aws group
+	GroupNamePrefix string `mapstructure:"group_name_prefix"`
+	// GroupNameSuffix is appended to the google group name to name the aws group
+	GroupNameSuffix string `mapstructure:"group_name_suffix"`
+	// GroupDisplayNameSource is the google group attribute aws groups are named by, empty keeps the sync method's own
+	GroupDisplayNameSource string `mapstructure:"group_display_name_source"`
+	// GoogleListProjection is the subset of user fields fetched from google
+	GoogleListProjection string `mapstructure:"google_list_projection"`
+	// GoogleCustomFieldMask lists the custom schemas fetched with the custom projection
+	GoogleCustomFieldMask string `mapstructure:"google_custom_field_mask"`
+	// GooglePageSize is the number of results per page of the google list calls, 0 keeps the api default
+	GooglePageSize int `mapstructure:"google_page_size"`
+	// AuditLogPath is the file a json line is appended to for each change made in aws
+	AuditLogPath string `mapstructure:"audit_log_path"`
+	// EmitMetrics writes the stats and duration of the run as a CloudWatch Embedded Metric Format line
+	EmitMetrics bool `mapstructure:"emit_metrics"`
+	// SyncGroupMetadataOnly reconciles the groups only, leaving users and members untouched
+	SyncGroupMetadataOnly bool `mapstructure:"sync_group_metadata_only"`
+	// PurgeOrphanedMemberships removes the members of an aws group before deleting it
+	PurgeOrphanedMemberships bool `mapstructure:"purge_orphaned_memberships"`
+	// UserUpdateStrategy is how updated users are sent to the SCIM endpoint
+	UserUpdateStrategy string `mapstructure:"user_update_strategy"`
+	// UserDeleteStrategy is whether the aws users of deleted or unmanaged google users are deleted or deactivated
+	UserDeleteStrategy string `mapstructure:"user_delete_strategy"`
+	// FailOnPlanConflicts aborts the sync when operations of the plan contradict each other
+	FailOnPlanConflicts bool `mapstructure:"fail_on_plan_conflicts"`
+	// UserDeleteConcurrency is the number of aws users of deleted google users deleted in parallel
+	UserDeleteConcurrency int `mapstructure:"user_delete_concurrency"`
+	// ContinueOnUserDeleteError keeps deleting the remaining users after a deletion fails
+	ContinueOnUserDeleteError bool `mapstructure:"continue_on_user_delete_error"`
+	// IncrementalSince only creates and updates the google users created or logged in within this duration, 0 syncs all of them
+	IncrementalSince time.Duration `mapstructure:"incremental_since"`
+	// TransitionalGroupAction controls what happens to the aws group of a google group that is being deleted
+	TransitionalGroupAction string `mapstructure:"transitional_group_action"`
+	// VerbosePlan logs each operation of the plan before the changes are made
+	VerbosePlan bool `mapstructure:"verbose_plan"`
+	// PlanSummary prints a table of the counts and a few names of each category of the plan before the changes are made
+	PlanSummary bool `mapstructure:"plan_summary"`
+	// MembershipBackends routes the membership changes of the aws groups matching a pattern to an api, as pattern=backend
+	MembershipBackends []string `mapstructure:"membership_backends"`
+	// OnlyPhases restricts the changes of the groups sync method to these phases, empty applies all of them
+	OnlyPhases []string `mapstructure:"only_phases"`
+	// ThrottleCooldownThreshold is the number of throttled aws calls within the window that pauses all calls, 0 disables
+	ThrottleCooldownThreshold int `mapstructure:"throttle_cooldown_threshold"`
+	// ThrottleCooldownWindow is the time the throttled aws calls are counted over
+	ThrottleCooldownWindow time.Duration `mapstructure:"throttle_cooldown_window"`
+	// ThrottleCooldown is how long all aws calls are paused for once throttled too often
+	ThrottleCooldown time.Duration `mapstructure:"throttle_cooldown"`
+	// DeleteInterval is the minimum time between the user and group deletions and member removals, 0 doesn't space them out
+	DeleteInterval time.Duration `mapstructure:"delete_interval"`
+	// SyncAllEmails sends all the emails of the google users instead of the primary one
+	SyncAllEmails bool `mapstructure:"sync_all_emails"`
+	// SyncAllPhones sends all the phone numbers of the google users instead of the primary one
+	SyncAllPhones bool `mapstructure:"sync_all_phones"`
+	// SyncTitle sets the SCIM title of the users from the title of their google organization
+	SyncTitle bool `mapstructure:"sync_title"`
+	// UserTypeSource is the attribute of the google organization of the users their SCIM userType is set from
+	UserTypeSource string `mapstructure:"user_type_source"`
+	// SyncLocale sets the SCIM locale of the users from their first google language
+	SyncLocale bool `mapstructure:"sync_locale"`
+	// SyncPreferredLanguage sets the SCIM preferredLanguage of the users from their first google language
+	SyncPreferredLanguage bool `mapstructure:"sync_preferred_language"`
+	// TimezoneField is the schema.field of the google custom schemas the SCIM timezone of the users is set from, empty doesn't sync it
+	TimezoneField string `mapstructure:"timezone_field"`
+	// MinGroupMembers is the number of members below which google groups are not synced, 0 syncs all of them
+	MinGroupMembers int `mapstructure:"min_group_members"`
+	// TraceQueries logs the filter and result count of each SCIM user and group lookup
+	TraceQueries bool `mapstructure:"trace_queries"`
+	// GoogleMembersIncludeSuspendedSeparately keeps the suspended google group members in their aws groups without adding them to any
+	GoogleMembersIncludeSuspendedSeparately bool `mapstructure:"google_members_include_suspended_separately"`
+	// SourceProvider is the directory users and groups are synced from
+	SourceProvider string `mapstructure:"source_provider"`
+	// OktaOrgURL is the url of the Okta org synced from with the okta source provider
+	OktaOrgURL string `mapstructure:"okta_org_url"`
+	// OktaAPIToken is the API token of the Okta org
+	OktaAPIToken string `mapstructure:"okta_api_token"`
+	// SourceFile is the JSON or CSV export synced from with the file source provider
+	SourceFile string `mapstructure:"source_file"`
+	// ProtectedGroups are the aws groups never deleted nor changed, such as the ones created by Control Tower, by display name or /regular expression/
+	ProtectedGroups []string `mapstructure:"protected_groups"`
 }
 
 const (
@@ -50,15 +236,224 @@ const (
 	DefaultGoogleCredentials = "credentials.json"
 	// DefaultSyncMethod is the default sync method to use.
 	DefaultSyncMethod = "groups"
+	// DefaultSCIMVerifyTLSMinVersion is the default minimum TLS version for the SCIM endpoint
+	DefaultSCIMVerifyTLSMinVersion = "1.2"
+	// DefaultEmptyGroupAction is the default handling of confirmed empty google groups
+	DefaultEmptyGroupAction = EmptyGroupActionRemove
+	// DefaultUnmanagedUserAction is the default handling of aws users that are not in google
+	DefaultUnmanagedUserAction = UnmanagedUserActionDelete
+	// DefaultUserTypeSource is the default source of the user type, it's not synced
+	DefaultUserTypeSource = UserTypeSourceNone
+	// DefaultManagerKey is the default key of the managers, their primary email
+	DefaultManagerKey = ManagerKeyEmail
+	// DefaultInvalidUserAction is the default handling of users missing required fields
+	DefaultInvalidUserAction = InvalidUserActionSkip
+	// DefaultMembershipFetchConcurrency is the default number of parallel group membership fetches
+	DefaultMembershipFetchConcurrency = 5
+	// DefaultSCIMUnmarshalRetries is the default number of retries of truncated SCIM responses
+	DefaultSCIMUnmarshalRetries = 2
+	// DefaultGoogleMemberFetchConcurrency is the default number of parallel google group member fetches
+	DefaultGoogleMemberFetchConcurrency = 5
+	// DefaultIdentityStoreMaxRetries is the default number of retries of failed identity store calls
+	DefaultIdentityStoreMaxRetries = 3
+	// DefaultUserBackend is the default api users are created with
+	DefaultUserBackend = UserBackendSCIM
+	// DefaultGoogleListProjection is the default subset of user fields fetched from google
+	DefaultGoogleListProjection = GoogleListProjectionBasic
+	// DefaultUserUpdateStrategy is the default way updated users are sent
+	DefaultUserUpdateStrategy = UserUpdateStrategyReplace
+	// DefaultUserDeleteStrategy is the default handling of the aws users to delete
+	DefaultUserDeleteStrategy = UserDeleteStrategyDelete
+	// DefaultUserDeleteConcurrency is the default number of parallel user deletions
+	DefaultUserDeleteConcurrency = 1
+	// DefaultCorrelationCacheMaxAge is the default number of minutes the correlation cache is used for
+	DefaultCorrelationCacheMaxAge = 60
+	// DefaultTransitionalGroupAction is the default handling of google groups that are being deleted
+	DefaultTransitionalGroupAction = TransitionalGroupActionSkip
+	// DefaultThrottleCooldownWindow is the default time throttled aws calls are counted over
+	DefaultThrottleCooldownWindow = time.Minute
+	// DefaultThrottleCooldown is the default pause of the aws calls once throttled too often
+	DefaultThrottleCooldown = 30 * time.Second
+	// DefaultSourceProvider is the default directory users and groups are synced from
+	DefaultSourceProvider = SourceProviderGoogle
+	// DefaultVerifyEqualMembers is the default confirmation of the members assumed equal
+	DefaultVerifyEqualMembers = VerifyEqualMembersNone
+	// DefaultVerifyEqualMembersPercent is the default share of the members confirmed when sampling
+	DefaultVerifyEqualMembersPercent = 10
+)
+
+// DefaultGoogleRetryCodes are the default HTTP status codes retried on the Google API
+var DefaultGoogleRetryCodes = []int{403, 429, 500, 502, 503, 504}
+
+// DefaultProtectedGroups are the aws groups created by AWS Control Tower
+var DefaultProtectedGroups = []string{
+	"AWSAccountFactory",
+	"AWSAuditAccountAdmins",
+	"AWSControlTowerAdmins",
+	"AWSLogArchiveAdmins",
+	"AWSLogArchiveViewers",
+	"AWSSecurityAuditPowerUsers",
+	"AWSSecurityAuditors",
+	"AWSServiceCatalogAdmins",
+}
+
+const (
+	// EmptyGroupActionRemove removes the aws members of a confirmed empty google group
+	EmptyGroupActionRemove = "remove"
+	// EmptyGroupActionKeep keeps the aws members of a confirmed empty google group
+	EmptyGroupActionKeep = "keep"
+)
+
+const (
+	// UnmanagedUserActionDelete deletes aws users that are not in google
+	UnmanagedUserActionDelete = "delete"
+	// UnmanagedUserActionDisable disables aws users that are not in google
+	UnmanagedUserActionDisable = "disable"
+	// UnmanagedUserActionIgnore leaves aws users that are not in google untouched
+	UnmanagedUserActionIgnore = "ignore"
+)
+
+const (
+	// InvalidUserActionSkip skips users missing required fields with a warning
+	InvalidUserActionSkip = "skip"
+	// InvalidUserActionFail fails the sync on users missing required fields
+	InvalidUserActionFail = "fail"
+)
+
+const (
+	// UserBackendSCIM creates users through the SCIM endpoint
+	UserBackendSCIM = "scim"
+	// UserBackendIdentityStore creates users through the Identity Store api
+	UserBackendIdentityStore = "identitystore"
+)
+
+const (
+	// MembershipBackendSCIM changes the group members through the SCIM endpoint
+	MembershipBackendSCIM = "scim"
+	// MembershipBackendIdentityStore changes the group members through the Identity Store api
+	MembershipBackendIdentityStore = "identitystore"
+)
+
+const (
+	// GroupDisplayNameSourceEmail names aws groups by the google group email
+	GroupDisplayNameSourceEmail = "email"
+	// GroupDisplayNameSourceName names aws groups by the google group name
+	GroupDisplayNameSourceName = "name"
+)
+
+const (
+	// GoogleListProjectionBasic fetches the users without their custom schemas
+	GoogleListProjectionBasic = "basic"
+	// GoogleListProjectionFull fetches the users with all their custom schemas
+	GoogleListProjectionFull = "full"
+	// GoogleListProjectionCustom fetches the users with the custom schemas of the field mask
+	GoogleListProjectionCustom = "custom"
+)
+
+const (
+	// UserUpdateStrategyReplace replaces the whole user with a PUT
+	UserUpdateStrategyReplace = "replace"
+	// UserUpdateStrategyPatch sends only the changed attributes with a PATCH
+	UserUpdateStrategyPatch = "patch"
+)
+
+const (
+	// UserDeleteStrategyDelete deletes the aws users
+	UserDeleteStrategyDelete = "delete"
+	// UserDeleteStrategyDeactivate sets the aws users inactive and keeps them,
+	// with their permission set assignments
+	UserDeleteStrategyDeactivate = "deactivate"
+)
+
+const (
+	// TransitionalGroupActionSkip leaves the aws group of a google group being deleted untouched
+	TransitionalGroupActionSkip = "skip"
+	// TransitionalGroupActionDeactivate removes the aws members of a google group being deleted
+	TransitionalGroupActionDeactivate = "deactivate"
+	// TransitionalGroupActionDelete deletes the aws group of a google group being deleted
+	TransitionalGroupActionDelete = "delete"
+)
+
+const (
+	// VerifyEqualMembersNone assumes the members of unchanged groups are still in aws
+	VerifyEqualMembersNone = "none"
+	// VerifyEqualMembersSample confirms a random share of the members of unchanged groups
+	VerifyEqualMembersSample = "sample"
+	// VerifyEqualMembersAll confirms every member of unchanged groups
+	VerifyEqualMembersAll = "all"
+)
+
+const (
+	// SourceProviderGoogle syncs from the Google Workspace directory
+	SourceProviderGoogle = "google"
+	// SourceProviderOkta syncs from an Okta org
+	SourceProviderOkta = "okta"
+	// SourceProviderFile syncs from a local JSON or CSV export of the directory
+	SourceProviderFile = "file"
+)
+
+const (
+	// UserTypeSourceNone leaves the user type of the users alone
+	UserTypeSourceNone = "none"
+	// UserTypeSourceEmployeeType sets the user type from the employee type of the google organization
+	UserTypeSourceEmployeeType = "employee-type"
+	// UserTypeSourceDepartment sets the user type from the department of the google organization
+	UserTypeSourceDepartment = "department"
+	// UserTypeSourceCostCenter sets the user type from the cost center of the google organization
+	UserTypeSourceCostCenter = "cost-center"
+)
+
+const (
+	// ManagerKeyEmail resolves the manager relations by the primary email of the google users
+	ManagerKeyEmail = "email"
+	// ManagerKeyEmployeeNumber resolves the manager relations by the employee id of the google users
+	ManagerKeyEmployeeNumber = "employeeNumber"
+)
+
+const (
+	// PhaseUsers creates, updates and deletes the aws users
+	PhaseUsers = "users"
+	// PhaseGroups creates and deletes the aws groups
+	PhaseGroups = "groups"
+	// PhaseMembers adds and removes the members of the aws groups
+	PhaseMembers = "members"
 )
 
 // New returns a new Config
 func New() *Config {
 	return &Config{
-		Debug:             DefaultDebug,
-		LogLevel:          DefaultLogLevel,
-		LogFormat:         DefaultLogFormat,
-		SyncMethod:        DefaultSyncMethod,
-		GoogleCredentials: DefaultGoogleCredentials,
+		Debug:                   DefaultDebug,
+		LogLevel:                DefaultLogLevel,
+		LogFormat:               DefaultLogFormat,
+		SyncMethod:              DefaultSyncMethod,
+		GoogleCredentials:       DefaultGoogleCredentials,
+		SCIMVerifyTLSMinVersion: DefaultSCIMVerifyTLSMinVersion,
+		EmptyGroupAction:        DefaultEmptyGroupAction,
+		GoogleRetryCodes:        append([]int{}, DefaultGoogleRetryCodes...),
+		ProtectedGroups:         append([]string{}, DefaultProtectedGroups...),
+		UnmanagedUserAction:     DefaultUnmanagedUserAction,
+		InvalidUserAction:       DefaultInvalidUserAction,
+		UserBackend:             DefaultUserBackend,
+		GoogleListProjection:    DefaultGoogleListProjection,
+		UserUpdateStrategy:      DefaultUserUpdateStrategy,
+		UserDeleteStrategy:      DefaultUserDeleteStrategy,
+		TransitionalGroupAction: DefaultTransitionalGroupAction,
+		SourceProvider:          DefaultSourceProvider,
+		VerifyEqualMembers:      DefaultVerifyEqualMembers,
+		UserTypeSource:          DefaultUserTypeSource,
+		ManagerKey:              DefaultManagerKey,
+
+		VerifyEqualMembersPercent: DefaultVerifyEqualMembersPercent,
+
+		MembershipFetchConcurrency: DefaultMembershipFetchConcurrency,
+		SCIMUnmarshalRetries:       DefaultSCIMUnmarshalRetries,
+
+		GoogleMemberFetchConcurrency: DefaultGoogleMemberFetchConcurrency,
+		IdentityStoreMaxRetries:      DefaultIdentityStoreMaxRetries,
+		UserDeleteConcurrency:        DefaultUserDeleteConcurrency,
+		CorrelationCacheMaxAge:       DefaultCorrelationCacheMaxAge,
+
+		ThrottleCooldownWindow: DefaultThrottleCooldownWindow,
+		ThrottleCooldown:       DefaultThrottleCooldown,
 	}
 }
@@ -4,15 +4,16 @@ import (
 	"encoding/base64"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
 )
 
 // Secrets ...
 type Secrets struct {
-	svc *secretsmanager.SecretsManager
+	svc secretsmanageriface.SecretsManagerAPI
 }
 
 // NewSecrets ...
-func NewSecrets(svc *secretsmanager.SecretsManager) *Secrets {
+func NewSecrets(svc secretsmanageriface.SecretsManagerAPI) *Secrets {
 	return &Secrets{
 		svc: svc,
 	}
@@ -50,6 +51,14 @@ func (s *Secrets) GoogleCredentials(secretArn string) (string, error) {
      return s.getSecret(secretArn)
 }
 
+// OktaAPIToken ...
+func (s *Secrets) OktaAPIToken(secretArn string) (string, error) {
+     if len([]rune(secretArn)) == 0 {
+        return s.getSecret("SSOSyncOktaAPIToken")
+     }
+     return s.getSecret(secretArn)
+}
+
 // Region ...
 func (s *Secrets) Region(secretArn string) (string, error) {
      if len([]rune(secretArn)) == 0 {
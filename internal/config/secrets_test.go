@@ -0,0 +1,73 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config_test
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/awslabs/ssosync/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSecretsManager returns the secret values stored by secret id
+type fakeSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+
+	values map[string]*secretsmanager.GetSecretValueOutput
+}
+
+func (f *fakeSecretsManager) GetSecretValue(input *secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error) {
+	return f.values[*input.SecretId], nil
+}
+
+func TestSecrets_GoogleCredentials(t *testing.T) {
+	creds := `{"type": "service_account"}`
+	binary := make([]byte, base64.StdEncoding.EncodedLen(len(creds)))
+	base64.StdEncoding.Encode(binary, []byte(creds))
+
+	secrets := config.NewSecrets(&fakeSecretsManager{values: map[string]*secretsmanager.GetSecretValueOutput{
+		"SSOSyncGoogleCredentials": {SecretString: aws.String(creds)},
+		"ssosync/google":           {SecretBinary: binary},
+	}})
+
+	// the default secret is used without a name
+	v, err := secrets.GoogleCredentials("")
+	assert.NoError(t, err)
+	assert.Equal(t, creds, v)
+
+	v, err = secrets.GoogleCredentials("ssosync/google")
+	assert.NoError(t, err)
+	assert.Equal(t, creds, v)
+}
+
+func TestSecrets_OktaAPIToken(t *testing.T) {
+	secrets := config.NewSecrets(&fakeSecretsManager{values: map[string]*secretsmanager.GetSecretValueOutput{
+		"SSOSyncOktaAPIToken": {SecretString: aws.String("default-token")},
+		"ssosync/okta":        {SecretString: aws.String("token")},
+	}})
+
+	// the default secret is used without a name
+	v, err := secrets.OktaAPIToken("")
+	assert.NoError(t, err)
+	assert.Equal(t, "default-token", v)
+
+	v, err = secrets.OktaAPIToken("ssosync/okta")
+	assert.NoError(t, err)
+	assert.Equal(t, "token", v)
+}
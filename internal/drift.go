@@ -0,0 +1,132 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"sort"
+
+	"github.com/awslabs/ssosync/internal/aws"
+	"github.com/awslabs/ssosync/internal/config"
+
+	log "github.com/sirupsen/logrus"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+const (
+	// driftUser is the kind of an aws only user
+	driftUser = "user"
+	// driftGroup is the kind of an aws only group
+	driftGroup = "group"
+)
+
+const (
+	// driftActionDelete is used for the resources the sync deletes
+	driftActionDelete = "delete"
+	// driftActionDisable is used for the users the sync disables
+	driftActionDisable = "disable"
+	// driftActionIgnore is used for the resources the sync leaves untouched
+	driftActionIgnore = "ignore"
+)
+
+// driftResource is an aws user or group with no counterpart in google,
+// created by hand or left over, and what the sync does with it
+type driftResource struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	ID     string `json:"id"`
+	Action string `json:"action"`
+}
+
+// getDrift returns the aws users and groups missing from google, sorted by
+// kind and name. Unlike the deletions of the plan it lists the users left
+// alone by the unmanaged user action and the groups outside the group name
+// prefix and suffix
+func getDrift(awsUsers []*aws.User, googleUsers []*admin.User, awsGroups []*aws.Group, googleGroups []*admin.Group, name func(*admin.Group) string, prefix string, suffix string, unmanagedAction string) []driftResource {
+	drift := make([]driftResource, 0)
+
+	googleUsersMap := make(map[string]struct{})
+	for _, u := range googleUsers {
+		googleUsersMap[u.PrimaryEmail] = struct{}{}
+	}
+
+	for _, u := range awsUsers {
+		if _, found := googleUsersMap[u.Username]; found {
+			continue
+		}
+
+		action := driftActionDelete
+		switch unmanagedAction {
+		case config.UnmanagedUserActionIgnore:
+			action = driftActionIgnore
+		case config.UnmanagedUserActionDisable:
+			action = driftActionDisable
+			if !u.Active {
+				action = driftActionIgnore
+			}
+		}
+		drift = append(drift, driftResource{Kind: driftUser, Name: u.Username, ID: u.ID, Action: action})
+	}
+
+	googleGroupsMap := make(map[string]struct{})
+	for _, g := range googleGroups {
+		googleGroupsMap[name(g)] = struct{}{}
+	}
+
+	for _, g := range awsGroups {
+		stripped, ok := stripGroupName(g.DisplayName, prefix, suffix)
+		if !ok {
+			drift = append(drift, driftResource{Kind: driftGroup, Name: g.DisplayName, ID: g.ID, Action: driftActionIgnore})
+			continue
+		}
+		if _, found := googleGroupsMap[stripped]; !found {
+			drift = append(drift, driftResource{Kind: driftGroup, Name: g.DisplayName, ID: g.ID, Action: driftActionDelete})
+		}
+	}
+
+	sort.Slice(drift, func(i, j int) bool {
+		if drift[i].Kind != drift[j].Kind {
+			return drift[i].Kind > drift[j].Kind
+		}
+		return drift[i].Name < drift[j].Name
+	})
+
+	return drift
+}
+
+// reportDrift logs the aws users and groups missing from google when
+// enabled, and counts them in the stats and report. The ignored users are
+// missing from google on purpose, they're not drift.
+func (s *syncGSuite) reportDrift(awsUsers []*aws.User, googleUsers []*admin.User, awsGroups []*aws.Group, googleGroups []*admin.Group) {
+	if !s.cfg.ReportDrift {
+		return
+	}
+
+	managed := make([]*aws.User, 0, len(awsUsers))
+	for _, u := range awsUsers {
+		if !s.ignoreUser(u.Username) {
+			managed = append(managed, u)
+		}
+	}
+
+	s.drift = getDrift(managed, googleUsers, awsGroups, googleGroups, s.groupSource(googleGroupName), s.cfg.GroupNamePrefix, s.cfg.GroupNameSuffix, s.unmanagedUserAction())
+	for _, d := range s.drift {
+		log.WithFields(log.Fields{"kind": d.Kind, "name": d.Name, "id": d.ID, "action": d.Action}).Warn("aws only resource")
+		if d.Kind == driftUser {
+			s.stats.AWSOnlyUsers++
+		} else {
+			s.stats.AWSOnlyGroups++
+		}
+	}
+}
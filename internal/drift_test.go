@@ -0,0 +1,92 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"testing"
+
+	"github.com/awslabs/ssosync/internal/aws"
+	"github.com/awslabs/ssosync/internal/config"
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+func Test_getDrift(t *testing.T) {
+	awsUsers := []*aws.User{
+		{ID: "a-user-1", Username: "user-1@email.com", Active: true},
+		{ID: "a-user-3", Username: "user-3@email.com", Active: true},
+		{ID: "a-user-2", Username: "user-2@email.com", Active: false},
+	}
+	googleUsers := []*admin.User{{PrimaryEmail: "user-1@email.com"}}
+	awsGroups := []*aws.Group{
+		{ID: "a-group-1", DisplayName: "sso-group-1"},
+		{ID: "a-group-old", DisplayName: "sso-group-old"},
+		// outside the prefix, created by hand
+		{ID: "a-manual", DisplayName: "manual"},
+	}
+	googleGroups := []*admin.Group{{Name: "group-1", Email: "group-1@email.com"}}
+
+	tests := []struct {
+		name            string
+		unmanagedAction string
+		wantUsers       []driftResource
+	}{
+		{
+			name:            "delete",
+			unmanagedAction: config.UnmanagedUserActionDelete,
+			wantUsers: []driftResource{
+				{Kind: driftUser, Name: "user-2@email.com", ID: "a-user-2", Action: driftActionDelete},
+				{Kind: driftUser, Name: "user-3@email.com", ID: "a-user-3", Action: driftActionDelete},
+			},
+		},
+		{
+			name:            "disable",
+			unmanagedAction: config.UnmanagedUserActionDisable,
+			wantUsers: []driftResource{
+				// already disabled
+				{Kind: driftUser, Name: "user-2@email.com", ID: "a-user-2", Action: driftActionIgnore},
+				{Kind: driftUser, Name: "user-3@email.com", ID: "a-user-3", Action: driftActionDisable},
+			},
+		},
+		{
+			name:            "ignore",
+			unmanagedAction: config.UnmanagedUserActionIgnore,
+			wantUsers: []driftResource{
+				{Kind: driftUser, Name: "user-2@email.com", ID: "a-user-2", Action: driftActionIgnore},
+				{Kind: driftUser, Name: "user-3@email.com", ID: "a-user-3", Action: driftActionIgnore},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := append(tt.wantUsers,
+				driftResource{Kind: driftGroup, Name: "manual", ID: "a-manual", Action: driftActionIgnore},
+				driftResource{Kind: driftGroup, Name: "sso-group-old", ID: "a-group-old", Action: driftActionDelete},
+			)
+			got := getDrift(awsUsers, googleUsers, awsGroups, googleGroups, googleGroupName, "sso-", "", tt.unmanagedAction)
+			assert.Equal(t, want, got)
+		})
+	}
+
+	// nothing drifts when aws matches google
+	assert.Empty(t, getDrift(awsUsers[:1], googleUsers, awsGroups[:1], googleGroups, googleGroupName, "sso-", "", config.UnmanagedUserActionDelete))
+}
+
+func Test_reportDriftIgnoredUsers(t *testing.T) {
+	s := &syncGSuite{cfg: &config.Config{ReportDrift: true, IgnoreUsers: []string{"user-3@email.com"}}}
+	s.reportDrift([]*aws.User{{ID: "id-user-3", Username: "user-3@email.com", Active: true}}, nil, nil, nil)
+	assert.Empty(t, s.drift)
+	assert.Zero(t, s.Stats().AWSOnlyUsers)
+}
@@ -19,12 +19,32 @@ import (
 	"context"
 	"strings"
 	"errors"
+	"fmt"
+	"net/http"
+	"time"
 
+	log "github.com/sirupsen/logrus"
 	"golang.org/x/oauth2/google"
 	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
+const (
+	// maxRetries is the number of times a failed call is retried
+	maxRetries = 3
+	// retryWait is the initial wait before a retry, it doubles on each attempt
+	retryWait = time.Second
+)
+
+// Operations that can be delegated to a different subject
+const (
+	// OperationUsers covers the user reads
+	OperationUsers = "users"
+	// OperationGroups covers the group and group member reads
+	OperationGroups = "groups"
+)
+
 // Client is the Interface for the Client
 type Client interface {
 	GetUsers(string) ([]*admin.User, error)
@@ -33,13 +53,112 @@ type Client interface {
 	GetGroupMembers(*admin.Group) ([]*admin.Member, error)
 }
 
+// Config specifies the tuning options of the Google client
+type Config struct {
+	// RetryCodes are the HTTP status codes that will be retried, any other
+	// error fails immediately
+	RetryCodes []int
+	// CompactGroupQueries drops group queries that are duplicates or are
+	// covered by a broader prefix query, to reduce the number of calls
+	CompactGroupQueries bool
+	// Subjects overrides the delegated subject by operation, operations
+	// without an override use the admin email
+	Subjects map[string]string
+	// Projection is the subset of fields fetched for users (basic|full|custom),
+	// empty keeps the api default of basic
+	Projection string
+	// CustomFieldMask lists the custom schemas fetched with the custom projection
+	CustomFieldMask string
+	// PageSize is the number of results requested per page of the list calls,
+	// 0 keeps the api default. The groups and members lists are capped at
+	// their maximum of 200.
+	PageSize int
+}
+
+const (
+	// MaxUsersPageSize is the largest page of the users list
+	MaxUsersPageSize = 500
+	// maxGroupsPageSize is the largest page of the groups and members lists
+	maxGroupsPageSize = 200
+)
+
 type client struct {
 	ctx     context.Context
 	service *admin.Service
+	// groupService is used for the group reads, it's the same as service
+	// unless the groups are read as a different subject
+	groupService *admin.Service
+
+	retryCodes map[int]struct{}
+	maxRetries int
+	retryWait  time.Duration
+
+	compactGroupQueries bool
+
+	projection      string
+	customFieldMask string
+	pageSize        int
 }
 
 // NewClient creates a new client for Google's Admin API
-func NewClient(ctx context.Context, adminEmail string, serviceAccountKey []byte) (Client, error) {
+func NewClient(ctx context.Context, adminEmail string, serviceAccountKey []byte, cfg *Config) (Client, error) {
+	return newDelegatedClient(ctx, adminEmail, serviceAccountKey, cfg)
+}
+
+// ParseSubjects parses the operation=subject overrides of the delegated subject
+func ParseSubjects(overrides []string) (map[string]string, error) {
+	subjects := make(map[string]string)
+	for _, o := range overrides {
+		parts := strings.SplitN(o, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid subject override %q, expected operation=subject", o)
+		}
+
+		operation := strings.TrimSpace(parts[0])
+		if operation != OperationUsers && operation != OperationGroups {
+			return nil, fmt.Errorf("unsupported operation %q, expected one of %s, %s", operation, OperationUsers, OperationGroups)
+		}
+		subjects[operation] = strings.TrimSpace(parts[1])
+	}
+
+	return subjects, nil
+}
+
+// newDelegatedClient creates the admin services for each delegated subject,
+// a single service is shared when the subjects are the same
+func newDelegatedClient(ctx context.Context, adminEmail string, serviceAccountKey []byte, cfg *Config, opts ...option.ClientOption) (*client, error) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	subject := func(operation string) string {
+		if s, ok := cfg.Subjects[operation]; ok {
+			return s
+		}
+		return adminEmail
+	}
+
+	srv, err := newService(ctx, serviceAccountKey, subject(OperationUsers), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	groupSrv := srv
+	if subject(OperationGroups) != subject(OperationUsers) {
+		groupSrv, err = newService(ctx, serviceAccountKey, subject(OperationGroups), opts...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c := newClient(ctx, srv, cfg)
+	c.groupService = groupSrv
+
+	return c, nil
+}
+
+// newService creates an admin service authenticated as the delegated subject
+func newService(ctx context.Context, serviceAccountKey []byte, subject string, opts ...option.ClientOption) (*admin.Service, error) {
 	config, err := google.JWTConfigFromJSON(serviceAccountKey, admin.AdminDirectoryGroupReadonlyScope,
 		admin.AdminDirectoryGroupMemberReadonlyScope,
 		admin.AdminDirectoryUserReadonlyScope)
@@ -48,38 +167,196 @@ func NewClient(ctx context.Context, adminEmail string, serviceAccountKey []byte)
 		return nil, err
 	}
 
-	config.Subject = adminEmail
+	config.Subject = subject
 
 	ts := config.TokenSource(ctx)
 
-	srv, err := admin.NewService(ctx, option.WithTokenSource(ts))
-	if err != nil {
-		return nil, err
+	return admin.NewService(ctx, append([]option.ClientOption{option.WithTokenSource(ts)}, opts...)...)
+}
+
+// newClient wraps the admin service with the retry behaviour from the config
+func newClient(ctx context.Context, srv *admin.Service, cfg *Config) *client {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	retryCodes := make(map[int]struct{})
+	for _, code := range cfg.RetryCodes {
+		retryCodes[code] = struct{}{}
 	}
 
 	return &client{
-		ctx:     ctx,
-		service: srv,
-	}, nil
+		ctx:          ctx,
+		service:      srv,
+		groupService: srv,
+		retryCodes:   retryCodes,
+		maxRetries:   maxRetries,
+		retryWait:    retryWait,
+
+		compactGroupQueries: cfg.CompactGroupQueries,
+
+		projection:      cfg.Projection,
+		customFieldMask: cfg.CustomFieldMask,
+		pageSize:        cfg.PageSize,
+	}
 }
 
-// GetDeletedUsers will get the deleted users from the Google's Admin API.
-func (c *client) GetDeletedUsers() ([]*admin.User, error) {
-	u := make([]*admin.User, 0)
-	err := c.service.Users.List().Customer("my_customer").ShowDeleted("true").Pages(c.ctx, func(users *admin.Users) error {
-		u = append(u, users.Users...)
-		return nil
+// usersList returns a users list call of the customer with the configured projection
+func (c *client) usersList() *admin.UsersListCall {
+	call := c.service.Users.List().Customer("my_customer")
+	if c.projection != "" {
+		call = call.Projection(c.projection)
+	}
+	if c.customFieldMask != "" {
+		call = call.CustomFieldMask(c.customFieldMask)
+	}
+	if c.pageSize > 0 {
+		call = call.MaxResults(int64(c.pageSize))
+	}
+	return call
+}
+
+// groupsPageSize returns the page size of the groups and members lists, 0
+// keeps the api default
+func (c *client) groupsPageSize() int64 {
+	if c.pageSize > maxGroupsPageSize {
+		return maxGroupsPageSize
+	}
+	return int64(c.pageSize)
+}
+
+// groupsList returns a groups list call of the customer with the configured page size
+func (c *client) groupsList() *admin.GroupsListCall {
+	call := c.groupService.Groups.List().Customer("my_customer")
+	if size := c.groupsPageSize(); size > 0 {
+		call = call.MaxResults(size)
+	}
+	return call
+}
+
+// quotaReasons are the reasons of the 403 errors Google returns when a quota
+// or rate limit is hit, any other 403 is a missing permission
+var quotaReasons = map[string]struct{}{
+	"rateLimitExceeded":     {},
+	"userRateLimitExceeded": {},
+	"quotaExceeded":         {},
+}
+
+// isQuotaError reports whether the 403 error is caused by a quota or rate limit
+func isQuotaError(gErr *googleapi.Error) bool {
+	for _, e := range gErr.Errors {
+		if _, ok := quotaReasons[e.Reason]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isRetryable reports whether the error is a Google API error with one of
+// the configured retry codes, a 403 is only retried when it's a quota or
+// rate limit error, a missing permission fails immediately
+func (c *client) isRetryable(err error) bool {
+	var gErr *googleapi.Error
+	if !errors.As(err, &gErr) {
+		return false
+	}
+
+	if _, ok := c.retryCodes[gErr.Code]; !ok {
+		return false
+	}
+	return gErr.Code != http.StatusForbidden || isQuotaError(gErr)
+}
+
+// groupNotFoundMessage is the message of the 404 of a missing group key
+const groupNotFoundMessage = "Resource Not Found: groupKey"
+
+// IsGroupNotFound reports whether the error is the 404 of a missing group
+// key, a group being deleted is still listed for a while but its members are
+// gone. Other 404s, such as a wrong endpoint, are not.
+func IsGroupNotFound(err error) bool {
+	var gErr *googleapi.Error
+	if !errors.As(err, &gErr) || gErr.Code != http.StatusNotFound {
+		return false
+	}
+
+	if gErr.Message == groupNotFoundMessage {
+		return true
+	}
+	for _, e := range gErr.Errors {
+		if e.Reason == "notFound" && e.Message == groupNotFoundMessage {
+			return true
+		}
+	}
+	return false
+}
+
+// withRetry calls fn until it succeeds, returns an error that is not
+// retryable or the retries are exhausted, fn must be safe to call again.
+// The wait between the calls ends early when the context is done.
+func (c *client) withRetry(fn func() error) error {
+	wait := c.retryWait
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= c.maxRetries || !c.isRetryable(err) {
+			return err
+		}
+
+		log.WithFields(log.Fields{"error": err, "attempt": attempt + 1}).Warn("retrying google api call")
+		select {
+		case <-c.ctx.Done():
+			return c.ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// listUsers fetches all pages of the users list call
+func (c *client) listUsers(call *admin.UsersListCall) ([]*admin.User, error) {
+	var u []*admin.User
+	err := c.withRetry(func() error {
+		u = make([]*admin.User, 0)
+		return call.Pages(c.ctx, func(users *admin.Users) error {
+			u = append(u, users.Users...)
+			return nil
+		})
 	})
 
 	return u, err
 }
 
+// listGroups fetches all pages of the groups list call
+func (c *client) listGroups(call *admin.GroupsListCall) ([]*admin.Group, error) {
+	var g []*admin.Group
+	err := c.withRetry(func() error {
+		g = make([]*admin.Group, 0)
+		return call.Pages(c.ctx, func(groups *admin.Groups) error {
+			g = append(g, groups.Groups...)
+			return nil
+		})
+	})
+
+	return g, err
+}
+
+// GetDeletedUsers will get the deleted users from the Google's Admin API.
+func (c *client) GetDeletedUsers() ([]*admin.User, error) {
+	return c.listUsers(c.usersList().ShowDeleted("true"))
+}
+
 // GetGroupMembers will get the members of the group specified
 func (c *client) GetGroupMembers(g *admin.Group) ([]*admin.Member, error) {
-	m := make([]*admin.Member, 0)
-	err := c.service.Members.List(g.Id).Pages(context.TODO(), func(members *admin.Members) error {
-		m = append(m, members.Members...)
-		return nil
+	var m []*admin.Member
+	err := c.withRetry(func() error {
+		m = make([]*admin.Member, 0)
+		call := c.groupService.Members.List(g.Id)
+		if size := c.groupsPageSize(); size > 0 {
+			call = call.MaxResults(size)
+		}
+		return call.Pages(c.ctx, func(members *admin.Members) error {
+			m = append(m, members.Members...)
+			return nil
+		})
 	})
 
 	return m, err
@@ -109,10 +386,10 @@ func (c *client) GetUsers(query string) ([]*admin.User, error) {
 
 	// If we have wildcard then fetch all users
 	if query  == "*" {
-                err = c.service.Users.List().Customer("my_customer").Pages(c.ctx, func(users *admin.Users) error {
-                        u = append(u, users.Users...)
-                        return nil
-                })
+		u, err = c.listUsers(c.usersList())
+		if err != nil {
+			return nil, err
+		}
         } else {
 
 	        // The Google api doesn't support multi-part queries, but we do so we need to split into an array of query strings
@@ -120,10 +397,11 @@ func (c *client) GetUsers(query string) ([]*admin.User, error) {
 
 		// Then call the api one query at a time, appending to our list
 		for _, subQuery := range queries {
-			err = c.service.Users.List().Query(subQuery).Customer("my_customer").Pages(c.ctx, func(users *admin.Users) error {
-				u = append(u, users.Users...)
-				return nil
-			})
+			users, err := c.listUsers(c.usersList().Query(subQuery))
+			if err != nil {
+				return nil, err
+			}
+			u = append(u, users...)
 		}
 	}
 
@@ -170,22 +448,22 @@ func (c *client) GetGroups(query string) ([]*admin.Group, error) {
 
         // If we have wildcard then fetch all groups
         if query  == "*" {
-		err = c.service.Groups.List().Customer("my_customer").Pages(context.TODO(), func(groups *admin.Groups) error {
-                        g = append(g, groups.Groups...)
-                        return nil
-                })
-		return g, err
+		return c.listGroups(c.groupsList())
 	}
 
       	// The Google api doesn't support multi-part queries, but we do so we need to split into an array of query strings
        	queries := strings.Split(query, ",")
+	if c.compactGroupQueries {
+		queries = compactQueries(queries)
+	}
 
        	// Then call the api one query at a time, appending to our list
        	for _, subQuery := range queries {
-		err = c.service.Groups.List().Customer("my_customer").Query(subQuery).Pages(context.TODO(), func(groups *admin.Groups) error {
-			g = append(g, groups.Groups...)
-			return nil
-		})
+		groups, err := c.listGroups(c.groupsList().Query(subQuery))
+		if err != nil {
+			return nil, err
+		}
+		g = append(g, groups...)
 	}
 
 	// Check we've got some users otherwise something is wrong.
@@ -194,3 +472,82 @@ func (c *client) GetGroups(query string) ([]*admin.Group, error) {
 	}
 	return g, err
 }
+
+// queryClause is a single field clause of a directory search query,
+// either an exact match (email=x) or a prefix match (email:x*)
+type queryClause struct {
+	field  string
+	value  string
+	prefix bool
+}
+
+// parseQueryClause parses a query made of a single clause, it returns false
+// for anything more complex which must be kept as is
+func parseQueryClause(query string) (queryClause, bool) {
+	if strings.ContainsAny(query, " '\"") {
+		return queryClause{}, false
+	}
+
+	if i := strings.Index(query, "="); i > 0 {
+		return queryClause{field: strings.ToLower(query[:i]), value: strings.ToLower(query[i+1:])}, true
+	}
+
+	if i := strings.Index(query, ":"); i > 0 && strings.HasSuffix(query, "*") && strings.Count(query, "*") == 1 {
+		return queryClause{field: strings.ToLower(query[:i]), value: strings.ToLower(strings.TrimSuffix(query[i+1:], "*")), prefix: true}, true
+	}
+
+	return queryClause{}, false
+}
+
+// compactQueries removes empty and duplicate queries and any single clause
+// query already matched by a prefix query on the same field, the Google
+// search syntax has no OR so disjoint clauses still need their own call
+func compactQueries(queries []string) []string {
+	seen := make(map[string]struct{})
+	unique := make([]string, 0, len(queries))
+	for _, q := range queries {
+		q = strings.TrimSpace(q)
+		if q == "" {
+			continue
+		}
+		if _, ok := seen[strings.ToLower(q)]; ok {
+			continue
+		}
+		seen[strings.ToLower(q)] = struct{}{}
+		unique = append(unique, q)
+	}
+
+	prefixes := make([]queryClause, 0)
+	for _, q := range unique {
+		if clause, ok := parseQueryClause(q); ok && clause.prefix {
+			prefixes = append(prefixes, clause)
+		}
+	}
+
+	compacted := make([]string, 0, len(unique))
+	for _, q := range unique {
+		clause, ok := parseQueryClause(q)
+		if ok && coveredByPrefix(clause, prefixes) {
+			log.WithField("query", q).Debug("query covered by a broader query")
+			continue
+		}
+		compacted = append(compacted, q)
+	}
+
+	return compacted
+}
+
+// coveredByPrefix reports whether another prefix clause on the same field
+// matches everything the clause matches
+func coveredByPrefix(clause queryClause, prefixes []queryClause) bool {
+	for _, p := range prefixes {
+		if p == clause || p.field != clause.field {
+			continue
+		}
+		if strings.HasPrefix(clause.value, p.value) {
+			return true
+		}
+	}
+
+	return false
+}
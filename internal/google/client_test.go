@@ -0,0 +1,420 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package google
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/option"
+)
+
+// newTestClient returns a client talking to a test server that fails with
+// the given status codes, in order, before succeeding with body
+func newTestClient(t *testing.T, cfg *Config, failures []int, body string) (*client, *int) {
+	return newTestClientWithFailure(t, cfg, failures, `{"error": {"code": 0, "message": "failure"}}`, body)
+}
+
+// newTestClientWithFailure is newTestClient with the body of the failures
+func newTestClientWithFailure(t *testing.T, cfg *Config, failures []int, failure string, body string) (*client, *int) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		if calls <= len(failures) {
+			w.WriteHeader(failures[calls-1])
+			_, _ = w.Write([]byte(failure))
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	ctx := context.Background()
+	service, err := admin.NewService(ctx, option.WithHTTPClient(srv.Client()), option.WithEndpoint(srv.URL))
+	assert.NoError(t, err)
+
+	c := newClient(ctx, service, cfg)
+	c.retryWait = time.Millisecond
+
+	return c, &calls
+}
+
+func TestClient_RetryOnConfiguredCodes(t *testing.T) {
+	cfg := &Config{RetryCodes: []int{429, 503}}
+	c, calls := newTestClient(t, cfg, []int{503, 429}, `{"groups": [{"email": "group@example.com"}]}`)
+
+	groups, err := c.GetGroups("*")
+	assert.NoError(t, err)
+	assert.Len(t, groups, 1)
+	assert.Equal(t, 3, *calls)
+}
+
+func TestClient_RetryWaitStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		// the sync is stopped while the call fails
+		cancel()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"error": {"code": 503, "message": "failure"}}`))
+	}))
+	defer srv.Close()
+
+	service, err := admin.NewService(context.Background(), option.WithHTTPClient(srv.Client()), option.WithEndpoint(srv.URL))
+	assert.NoError(t, err)
+
+	c := newClient(ctx, service, &Config{RetryCodes: []int{503}})
+	c.retryWait = time.Hour
+
+	_, err = c.GetGroupMembers(&admin.Group{Id: "group"})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, calls)
+}
+
+func TestClient_NoRetryOnOtherCodes(t *testing.T) {
+	cfg := &Config{RetryCodes: []int{429, 503}}
+	c, calls := newTestClient(t, cfg, []int{500}, `{"groups": [{"email": "group@example.com"}]}`)
+
+	_, err := c.GetGroups("*")
+	assert.Error(t, err)
+	assert.Equal(t, 1, *calls)
+}
+
+func TestClient_RetriesExhausted(t *testing.T) {
+	cfg := &Config{RetryCodes: []int{503}}
+	c, calls := newTestClient(t, cfg, []int{503, 503, 503, 503, 503}, `{}`)
+
+	_, err := c.GetGroups("*")
+	assert.Error(t, err)
+	assert.Equal(t, maxRetries+1, *calls)
+}
+
+func TestClient_RetryDoesNotDuplicateResults(t *testing.T) {
+	cfg := &Config{RetryCodes: []int{502}}
+	c, _ := newTestClient(t, cfg, []int{502}, `{"members": [{"email": "user@example.com"}]}`)
+
+	members, err := c.GetGroupMembers(&admin.Group{Id: "group"})
+	assert.NoError(t, err)
+	assert.Len(t, members, 1)
+}
+
+func TestClient_GroupNotFound(t *testing.T) {
+	groupNotFound := `{"error": {"code": 404, "message": "Resource Not Found: groupKey", "errors": [{"message": "Resource Not Found: groupKey", "domain": "global", "reason": "notFound"}]}}`
+	c, _ := newTestClientWithFailure(t, &Config{RetryCodes: []int{503}}, []int{404}, groupNotFound, `{}`)
+
+	_, err := c.GetGroupMembers(&admin.Group{Id: "deleting"})
+	assert.True(t, IsGroupNotFound(err))
+
+	// another 404 is an error of its own
+	c, _ = newTestClient(t, &Config{RetryCodes: []int{503}}, []int{404}, `{}`)
+	_, err = c.GetGroupMembers(&admin.Group{Id: "group"})
+	assert.Error(t, err)
+	assert.False(t, IsGroupNotFound(err))
+
+	c, _ = newTestClient(t, &Config{RetryCodes: []int{503}}, []int{400}, `{}`)
+	_, err = c.GetGroupMembers(&admin.Group{Id: "group"})
+	assert.Error(t, err)
+	assert.False(t, IsGroupNotFound(err))
+}
+
+func TestClient_Forbidden(t *testing.T) {
+	tests := []struct {
+		name      string
+		reason    string
+		wantErr   bool
+		wantCalls int
+	}{
+		{name: "rate limit is retried", reason: "rateLimitExceeded", wantCalls: 2},
+		{name: "user rate limit is retried", reason: "userRateLimitExceeded", wantCalls: 2},
+		{name: "quota is retried", reason: "quotaExceeded", wantCalls: 2},
+		{name: "permission fails fast", reason: "forbidden", wantErr: true, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{RetryCodes: []int{403}}
+			failure := fmt.Sprintf(`{"error": {"code": 403, "message": "failure", "errors": [{"reason": %q}]}}`, tt.reason)
+			c, calls := newTestClientWithFailure(t, cfg, []int{403}, failure, `{"groups": [{"email": "group@example.com"}]}`)
+
+			_, err := c.GetGroups("*")
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.wantCalls, *calls)
+		})
+	}
+}
+
+func Test_compactQueries(t *testing.T) {
+	tests := []struct {
+		name    string
+		queries []string
+		want    []string
+	}{
+		{
+			name:    "duplicates and empty",
+			queries: []string{"email:aws-*", " email:aws-* ", "", "EMAIL:aws-*"},
+			want:    []string{"email:aws-*"},
+		},
+		{
+			name:    "covered by prefix",
+			queries: []string{"email=aws-admins@example.com", "email:aws-*", "email:aws-dev*", "name=Admins"},
+			want:    []string{"email:aws-*", "name=Admins"},
+		},
+		{
+			name:    "other fields are not covered",
+			queries: []string{"name:aws-*", "email=aws-admins@example.com"},
+			want:    []string{"name:aws-*", "email=aws-admins@example.com"},
+		},
+		{
+			name:    "multi clause queries are kept",
+			queries: []string{"email:aws-*", "name:Admin* email:aws-adm*"},
+			want:    []string{"email:aws-*", "name:Admin* email:aws-adm*"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, compactQueries(tt.queries))
+		})
+	}
+}
+
+func TestClient_GetGroupsQueryExpansion(t *testing.T) {
+	queries := []string{"email:team-*"}
+	for i := 0; i < 50; i++ {
+		queries = append(queries, fmt.Sprintf("email=team-%d@example.com", i))
+	}
+	query := strings.Join(queries, ",")
+	body := `{"groups": [{"email": "team-1@example.com"}]}`
+
+	c, calls := newTestClient(t, &Config{}, nil, body)
+	_, err := c.GetGroups(query)
+	assert.NoError(t, err)
+	assert.Equal(t, 51, *calls)
+
+	c, calls = newTestClient(t, &Config{CompactGroupQueries: true}, nil, body)
+	_, err = c.GetGroups(query)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, *calls)
+}
+
+// newTestServiceAccount returns a service account key whose token endpoint
+// issues "token-<subject>" for the delegated subject of the request
+func newTestServiceAccount(t *testing.T) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		parts := strings.Split(r.Form.Get("assertion"), ".")
+		assert.Len(t, parts, 3)
+
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		assert.NoError(t, err)
+
+		var claims struct {
+			Sub string `json:"sub"`
+		}
+		assert.NoError(t, json.Unmarshal(payload, &claims))
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token": "token-%s", "token_type": "Bearer", "expires_in": 3600}`, claims.Sub)
+	}))
+	t.Cleanup(srv.Close)
+
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	sa, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "sync@example.iam.gserviceaccount.com",
+		"private_key_id": "1",
+		"private_key":    string(pemKey),
+		"token_uri":      srv.URL,
+	})
+	assert.NoError(t, err)
+
+	return sa
+}
+
+func Test_ParseSubjects(t *testing.T) {
+	subjects, err := ParseSubjects([]string{"users=reader@example.com", " groups = groups@example.com "})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{OperationUsers: "reader@example.com", OperationGroups: "groups@example.com"}, subjects)
+
+	_, err = ParseSubjects([]string{"members=reader@example.com"})
+	assert.Error(t, err)
+
+	_, err = ParseSubjects([]string{"users"})
+	assert.Error(t, err)
+}
+
+func TestClient_SubjectPerOperation(t *testing.T) {
+	var mu sync.Mutex
+	tokens := make(map[string]string)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operation := OperationUsers
+		if strings.Contains(r.URL.Path, "/groups") {
+			operation = OperationGroups
+		}
+
+		mu.Lock()
+		tokens[operation] = r.Header.Get("Authorization")
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"users": [{"primaryEmail": "user@example.com", "name": {}}], "groups": [{"email": "group@example.com"}]}`))
+	}))
+	t.Cleanup(api.Close)
+
+	key := newTestServiceAccount(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		subjects  map[string]string
+		wantUsers string
+		wantGroup string
+	}{
+		{
+			name:      "default subject",
+			wantUsers: "Bearer token-admin@example.com",
+			wantGroup: "Bearer token-admin@example.com",
+		},
+		{
+			name:      "groups override",
+			subjects:  map[string]string{OperationGroups: "groups@example.com"},
+			wantUsers: "Bearer token-admin@example.com",
+			wantGroup: "Bearer token-groups@example.com",
+		},
+		{
+			name:      "both overridden",
+			subjects:  map[string]string{OperationUsers: "users@example.com", OperationGroups: "groups@example.com"},
+			wantUsers: "Bearer token-users@example.com",
+			wantGroup: "Bearer token-groups@example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := newDelegatedClient(ctx, "admin@example.com", key, &Config{Subjects: tt.subjects}, option.WithEndpoint(api.URL))
+			assert.NoError(t, err)
+
+			_, err = c.GetUsers("*")
+			assert.NoError(t, err)
+			_, err = c.GetGroups("*")
+			assert.NoError(t, err)
+
+			assert.Equal(t, tt.wantUsers, tokens[OperationUsers])
+			assert.Equal(t, tt.wantGroup, tokens[OperationGroups])
+		})
+	}
+}
+
+func TestClient_GetUsersProjection(t *testing.T) {
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"users": [{"primaryEmail": "user@example.com", "name": {}}]}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	ctx := context.Background()
+	service, err := admin.NewService(ctx, option.WithHTTPClient(srv.Client()), option.WithEndpoint(srv.URL))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		cfg        *Config
+		projection string
+		mask       string
+	}{
+		{name: "api default", cfg: &Config{}},
+		{name: "full", cfg: &Config{Projection: "full"}, projection: "full"},
+		{name: "custom", cfg: &Config{Projection: "custom", CustomFieldMask: "employment,aws"}, projection: "custom", mask: "employment,aws"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClient(ctx, service, tt.cfg)
+
+			_, err := c.GetUsers("name:Jane*")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.projection, query.Get("projection"))
+			assert.Equal(t, tt.mask, query.Get("customFieldMask"))
+			assert.Equal(t, "name:Jane*", query.Get("query"))
+
+			_, err = c.GetDeletedUsers()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.projection, query.Get("projection"))
+		})
+	}
+}
+
+func TestClient_PageSize(t *testing.T) {
+	pageSizes := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pageSizes[path.Base(r.URL.Path)] = r.URL.Query().Get("maxResults")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"users": [{"primaryEmail": "user@example.com", "name": {}}]}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	ctx := context.Background()
+	service, err := admin.NewService(ctx, option.WithHTTPClient(srv.Client()), option.WithEndpoint(srv.URL))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		pageSize int
+		users    string
+		groups   string
+	}{
+		{name: "api default", pageSize: 0},
+		{name: "within limits", pageSize: 100, users: "100", groups: "100"},
+		{name: "groups capped", pageSize: 500, users: "500", groups: "200"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClient(ctx, service, &Config{PageSize: tt.pageSize})
+
+			_, err := c.GetUsers("*")
+			assert.NoError(t, err)
+			_, err = c.GetGroups("*")
+			assert.NoError(t, err)
+			_, err = c.GetGroupMembers(&admin.Group{Id: "group-id"})
+			assert.NoError(t, err)
+
+			assert.Equal(t, tt.users, pageSizes["users"])
+			assert.Equal(t, tt.groups, pageSizes["groups"])
+			assert.Equal(t, tt.groups, pageSizes["members"])
+		})
+	}
+}
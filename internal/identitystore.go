@@ -0,0 +1,273 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	aws_sdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/identitystore"
+	"github.com/aws/aws-sdk-go/service/identitystore/identitystoreiface"
+	log "github.com/sirupsen/logrus"
+)
+
+// identityStoreRetryWait is the initial wait before a retry, it doubles on each attempt
+const identityStoreRetryWait = 500 * time.Millisecond
+
+// retryingIdentityStore retries the identity store calls of the sync that are
+// throttled or fail on the server, on top of the retries of the SDK. The
+// creates are only retried when throttled, a server error may come after
+// the resource was created. The pages of the paginated calls are passed on
+// once all of them were read, so their callbacks never see a page twice.
+type retryingIdentityStore struct {
+	identitystoreiface.IdentityStoreAPI
+
+	// ctx stops the waits between the retries of the calls without a context
+	ctx        context.Context
+	maxRetries int
+	retryWait  time.Duration
+}
+
+// newRetryingIdentityStore wraps api to retry failed calls up to maxRetries times
+func newRetryingIdentityStore(ctx context.Context, api identitystoreiface.IdentityStoreAPI, maxRetries int) *retryingIdentityStore {
+	return &retryingIdentityStore{
+		IdentityStoreAPI: api,
+		ctx:              ctx,
+		maxRetries:       maxRetries,
+		retryWait:        identityStoreRetryWait,
+	}
+}
+
+// isThrottledIdentityStoreError reports whether the call was throttled, and
+// so was not applied
+func isThrottledIdentityStoreError(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == identitystore.ErrCodeThrottlingException
+}
+
+// isRetryableIdentityStoreError reports whether the call may succeed when retried
+func isRetryableIdentityStoreError(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+
+	switch aerr.Code() {
+	case identitystore.ErrCodeThrottlingException, identitystore.ErrCodeInternalServerException:
+		return true
+	}
+	return false
+}
+
+// interpretIdentityStoreError adds what to check to the identity store errors
+// caused by the configuration, others are returned as they are
+func interpretIdentityStoreError(err error) error {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return err
+	}
+
+	switch aerr.Code() {
+	case identitystore.ErrCodeAccessDeniedException:
+		return fmt.Errorf("%w, check the role or credentials used are allowed the identitystore actions", err)
+	case identitystore.ErrCodeResourceNotFoundException, identitystore.ErrCodeValidationException:
+		return fmt.Errorf("%w, check the identity store id and that the region is the one of the identity store", err)
+	case "UnrecognizedClientException", "ExpiredTokenException", "NoCredentialProviders":
+		return fmt.Errorf("%w, check the AWS credentials are set and valid", err)
+	}
+	return err
+}
+
+// withRetry calls fn until it succeeds, returns an error that is not
+// retryable or the retries are exhausted, fn must be safe to call again
+func (r *retryingIdentityStore) withRetry(fn func() error) error {
+	return r.retry(r.ctx, isRetryableIdentityStoreError, fn)
+}
+
+// withThrottledRetry is withRetry for the calls that are only safe to call
+// again when they were throttled
+func (r *retryingIdentityStore) withThrottledRetry(fn func() error) error {
+	return r.retry(r.ctx, isThrottledIdentityStoreError, fn)
+}
+
+// retry calls fn until it succeeds, returns an error that is not retryable
+// or the retries are exhausted. The wait doubles on each attempt with up to
+// half of it randomised, so concurrent callers spread out, and ends early
+// when the context is done.
+func (r *retryingIdentityStore) retry(ctx context.Context, retryable func(error) bool, fn func() error) error {
+	wait := r.retryWait
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= r.maxRetries || !retryable(err) {
+			return err
+		}
+
+		log.WithFields(log.Fields{"error": err, "attempt": attempt + 1}).Warn("retrying identity store call")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))):
+		}
+		wait *= 2
+	}
+}
+
+// ListGroups retries identitystoreiface.IdentityStoreAPI.ListGroups
+func (r *retryingIdentityStore) ListGroups(input *identitystore.ListGroupsInput) (out *identitystore.ListGroupsOutput, err error) {
+	err = r.withRetry(func() error {
+		out, err = r.IdentityStoreAPI.ListGroups(input)
+		return err
+	})
+	return out, err
+}
+
+// ListGroupsPages retries identitystoreiface.IdentityStoreAPI.ListGroupsPages
+func (r *retryingIdentityStore) ListGroupsPages(input *identitystore.ListGroupsInput, fn func(*identitystore.ListGroupsOutput, bool) bool) error {
+	var pages []*identitystore.ListGroupsOutput
+	err := r.withRetry(func() error {
+		pages = nil
+		return r.IdentityStoreAPI.ListGroupsPages(input, func(page *identitystore.ListGroupsOutput, lastPage bool) bool {
+			pages = append(pages, page)
+			return true
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, page := range pages {
+		if !fn(page, i == len(pages)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+// ListUsersPages retries identitystoreiface.IdentityStoreAPI.ListUsersPages
+func (r *retryingIdentityStore) ListUsersPages(input *identitystore.ListUsersInput, fn func(*identitystore.ListUsersOutput, bool) bool) error {
+	var pages []*identitystore.ListUsersOutput
+	err := r.withRetry(func() error {
+		pages = nil
+		return r.IdentityStoreAPI.ListUsersPages(input, func(page *identitystore.ListUsersOutput, lastPage bool) bool {
+			pages = append(pages, page)
+			return true
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, page := range pages {
+		if !fn(page, i == len(pages)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+// ListGroupMembershipsPagesWithContext retries
+// identitystoreiface.IdentityStoreAPI.ListGroupMembershipsPagesWithContext
+func (r *retryingIdentityStore) ListGroupMembershipsPagesWithContext(ctx aws_sdk.Context, input *identitystore.ListGroupMembershipsInput, fn func(*identitystore.ListGroupMembershipsOutput, bool) bool, opts ...request.Option) error {
+	var pages []*identitystore.ListGroupMembershipsOutput
+	err := r.retry(ctx, isRetryableIdentityStoreError, func() error {
+		pages = nil
+		return r.IdentityStoreAPI.ListGroupMembershipsPagesWithContext(ctx, input, func(page *identitystore.ListGroupMembershipsOutput, lastPage bool) bool {
+			pages = append(pages, page)
+			return true
+		}, opts...)
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, page := range pages {
+		if !fn(page, i == len(pages)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+// CreateGroupWithContext retries
+// identitystoreiface.IdentityStoreAPI.CreateGroupWithContext
+func (r *retryingIdentityStore) CreateGroupWithContext(ctx aws_sdk.Context, input *identitystore.CreateGroupInput, opts ...request.Option) (out *identitystore.CreateGroupOutput, err error) {
+	err = r.retry(ctx, isThrottledIdentityStoreError, func() error {
+		out, err = r.IdentityStoreAPI.CreateGroupWithContext(ctx, input, opts...)
+		return err
+	})
+	return out, err
+}
+
+// DeleteGroupWithContext retries
+// identitystoreiface.IdentityStoreAPI.DeleteGroupWithContext
+func (r *retryingIdentityStore) DeleteGroupWithContext(ctx aws_sdk.Context, input *identitystore.DeleteGroupInput, opts ...request.Option) (out *identitystore.DeleteGroupOutput, err error) {
+	err = r.retry(ctx, isRetryableIdentityStoreError, func() error {
+		out, err = r.IdentityStoreAPI.DeleteGroupWithContext(ctx, input, opts...)
+		return err
+	})
+	return out, err
+}
+
+// DeleteUserWithContext retries
+// identitystoreiface.IdentityStoreAPI.DeleteUserWithContext
+func (r *retryingIdentityStore) DeleteUserWithContext(ctx aws_sdk.Context, input *identitystore.DeleteUserInput, opts ...request.Option) (out *identitystore.DeleteUserOutput, err error) {
+	err = r.retry(ctx, isRetryableIdentityStoreError, func() error {
+		out, err = r.IdentityStoreAPI.DeleteUserWithContext(ctx, input, opts...)
+		return err
+	})
+	return out, err
+}
+
+// CreateGroupMembership retries identitystoreiface.IdentityStoreAPI.CreateGroupMembership
+func (r *retryingIdentityStore) CreateGroupMembership(input *identitystore.CreateGroupMembershipInput) (out *identitystore.CreateGroupMembershipOutput, err error) {
+	err = r.withThrottledRetry(func() error {
+		out, err = r.IdentityStoreAPI.CreateGroupMembership(input)
+		return err
+	})
+	return out, err
+}
+
+// DeleteGroupMembership retries identitystoreiface.IdentityStoreAPI.DeleteGroupMembership
+func (r *retryingIdentityStore) DeleteGroupMembership(input *identitystore.DeleteGroupMembershipInput) (out *identitystore.DeleteGroupMembershipOutput, err error) {
+	err = r.withRetry(func() error {
+		out, err = r.IdentityStoreAPI.DeleteGroupMembership(input)
+		return err
+	})
+	return out, err
+}
+
+// GetGroupMembershipId retries identitystoreiface.IdentityStoreAPI.GetGroupMembershipId
+func (r *retryingIdentityStore) GetGroupMembershipId(input *identitystore.GetGroupMembershipIdInput) (out *identitystore.GetGroupMembershipIdOutput, err error) {
+	err = r.withRetry(func() error {
+		out, err = r.IdentityStoreAPI.GetGroupMembershipId(input)
+		return err
+	})
+	return out, err
+}
+
+// IsMemberInGroups retries identitystoreiface.IdentityStoreAPI.IsMemberInGroups
+func (r *retryingIdentityStore) IsMemberInGroups(input *identitystore.IsMemberInGroupsInput) (out *identitystore.IsMemberInGroupsOutput, err error) {
+	err = r.withRetry(func() error {
+		out, err = r.IdentityStoreAPI.IsMemberInGroups(input)
+		return err
+	})
+	return out, err
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	aws_sdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/identitystore"
+	"github.com/awslabs/ssosync/internal/mocks"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_retryingIdentityStore(t *testing.T) {
+	throttled := awserr.New(identitystore.ErrCodeThrottlingException, "rate exceeded", nil)
+	serverErr := awserr.New(identitystore.ErrCodeInternalServerException, "internal error", nil)
+	denied := awserr.New(identitystore.ErrCodeAccessDeniedException, "denied", nil)
+
+	tests := []struct {
+		name      string
+		create    bool
+		failures  []error
+		wantCalls int
+		wantErr   error
+	}{
+		{name: "throttled twice", failures: []error{throttled, throttled}, wantCalls: 3},
+		{name: "server error", failures: []error{serverErr}, wantCalls: 2},
+		{name: "not retryable", failures: []error{denied}, wantCalls: 1, wantErr: denied},
+		{name: "not an aws error", failures: []error{errors.New("failed")}, wantCalls: 1},
+		{name: "retries exhausted", failures: []error{throttled, throttled, throttled, throttled}, wantCalls: 4, wantErr: throttled},
+		{name: "create throttled", create: true, failures: []error{throttled}, wantCalls: 2},
+		{name: "create server error is not retried", create: true, failures: []error{serverErr}, wantCalls: 1, wantErr: serverErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockIdentityStoreClient := mocks.NewMockIdentityStoreAPI(ctrl)
+
+			calls := 0
+			respond := func() (string, error) {
+				calls++
+				if calls <= len(tt.failures) {
+					return "", tt.failures[calls-1]
+				}
+				return "membership-1", nil
+			}
+
+			r := newRetryingIdentityStore(context.Background(), mockIdentityStoreClient, 3)
+			r.retryWait = time.Millisecond
+
+			var id string
+			var err error
+			if tt.create {
+				mockIdentityStoreClient.EXPECT().CreateGroupMembership(gomock.Any()).Times(tt.wantCalls).DoAndReturn(
+					func(input *identitystore.CreateGroupMembershipInput) (*identitystore.CreateGroupMembershipOutput, error) {
+						id, err := respond()
+						return &identitystore.CreateGroupMembershipOutput{MembershipId: aws_sdk.String(id)}, err
+					})
+
+				var out *identitystore.CreateGroupMembershipOutput
+				out, err = r.CreateGroupMembership(&identitystore.CreateGroupMembershipInput{})
+				id = aws_sdk.StringValue(out.MembershipId)
+			} else {
+				mockIdentityStoreClient.EXPECT().GetGroupMembershipId(gomock.Any()).Times(tt.wantCalls).DoAndReturn(
+					func(input *identitystore.GetGroupMembershipIdInput) (*identitystore.GetGroupMembershipIdOutput, error) {
+						id, err := respond()
+						return &identitystore.GetGroupMembershipIdOutput{MembershipId: aws_sdk.String(id)}, err
+					})
+
+				var out *identitystore.GetGroupMembershipIdOutput
+				out, err = r.GetGroupMembershipId(&identitystore.GetGroupMembershipIdInput{})
+				id = aws_sdk.StringValue(out.MembershipId)
+			}
+
+			if tt.wantCalls > len(tt.failures) {
+				assert.NoError(t, err)
+				assert.Equal(t, "membership-1", id)
+				return
+			}
+
+			assert.Error(t, err)
+			if tt.wantErr != nil {
+				assert.Equal(t, tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_retryingIdentityStorePages(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockIdentityStoreClient := mocks.NewMockIdentityStoreAPI(ctrl)
+	throttled := awserr.New(identitystore.ErrCodeThrottlingException, "rate exceeded", nil)
+
+	page := func(id string) *identitystore.ListGroupMembershipsOutput {
+		return &identitystore.ListGroupMembershipsOutput{GroupMemberships: []*identitystore.GroupMembership{
+			{MembershipId: aws_sdk.String(id)},
+		}}
+	}
+
+	// the first attempt is throttled after its first page
+	gomock.InOrder(
+		mockIdentityStoreClient.EXPECT().ListGroupMembershipsPagesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx aws_sdk.Context, input *identitystore.ListGroupMembershipsInput, fn func(*identitystore.ListGroupMembershipsOutput, bool) bool, opts ...request.Option) error {
+				fn(page("1"), false)
+				return throttled
+			}),
+		mockIdentityStoreClient.EXPECT().ListGroupMembershipsPagesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx aws_sdk.Context, input *identitystore.ListGroupMembershipsInput, fn func(*identitystore.ListGroupMembershipsOutput, bool) bool, opts ...request.Option) error {
+				if fn(page("1"), false) {
+					fn(page("2"), true)
+				}
+				return nil
+			}),
+	)
+
+	r := newRetryingIdentityStore(context.Background(), mockIdentityStoreClient, 3)
+	r.retryWait = time.Millisecond
+
+	seen := make([]string, 0)
+	last := false
+	err := r.ListGroupMembershipsPagesWithContext(context.Background(), &identitystore.ListGroupMembershipsInput{},
+		func(out *identitystore.ListGroupMembershipsOutput, lastPage bool) bool {
+			seen = append(seen, *out.GroupMemberships[0].MembershipId)
+			last = lastPage
+			return true
+		})
+	assert.NoError(t, err)
+
+	// each page is seen once
+	assert.Equal(t, []string{"1", "2"}, seen)
+	assert.True(t, last)
+}
+
+func Test_retryingIdentityStoreContext(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockIdentityStoreClient := mocks.NewMockIdentityStoreAPI(ctrl)
+	throttled := awserr.New(identitystore.ErrCodeThrottlingException, "rate exceeded", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mockIdentityStoreClient.EXPECT().DeleteGroupMembership(gomock.Any()).Times(1).DoAndReturn(
+		func(input *identitystore.DeleteGroupMembershipInput) (*identitystore.DeleteGroupMembershipOutput, error) {
+			// the sync is stopped while the call is throttled
+			cancel()
+			return nil, throttled
+		})
+
+	r := newRetryingIdentityStore(ctx, mockIdentityStoreClient, 3)
+	r.retryWait = time.Hour
+
+	_, err := r.DeleteGroupMembership(&identitystore.DeleteGroupMembershipInput{})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// the calls with a context stop with theirs
+	ctx, cancel = context.WithCancel(context.Background())
+	mockIdentityStoreClient.EXPECT().DeleteGroupWithContext(ctx, gomock.Any()).Times(1).DoAndReturn(
+		func(ctx aws_sdk.Context, input *identitystore.DeleteGroupInput, opts ...request.Option) (*identitystore.DeleteGroupOutput, error) {
+			cancel()
+			return nil, throttled
+		})
+
+	r = newRetryingIdentityStore(context.Background(), mockIdentityStoreClient, 3)
+	r.retryWait = time.Hour
+
+	_, err = r.DeleteGroupWithContext(ctx, &identitystore.DeleteGroupInput{})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func Test_interpretIdentityStoreError(t *testing.T) {
+	denied := awserr.New(identitystore.ErrCodeAccessDeniedException, "denied", nil)
+	err := interpretIdentityStoreError(denied)
+	assert.True(t, errors.Is(err, denied))
+	assert.Contains(t, err.Error(), "identitystore actions")
+
+	err = interpretIdentityStoreError(awserr.New(identitystore.ErrCodeResourceNotFoundException, "not found", nil))
+	assert.Contains(t, err.Error(), "identity store id")
+
+	err = interpretIdentityStoreError(awserr.New("NoCredentialProviders", "no valid providers in chain", nil))
+	assert.Contains(t, err.Error(), "AWS credentials")
+
+	// other errors are left as they are
+	throttled := awserr.New(identitystore.ErrCodeThrottlingException, "rate exceeded", nil)
+	assert.Equal(t, throttled, interpretIdentityStoreError(throttled))
+	assert.Nil(t, interpretIdentityStoreError(nil))
+}
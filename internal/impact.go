@@ -0,0 +1,229 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/awslabs/ssosync/internal/aws"
+
+	aws_sdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssoadmin"
+	"github.com/aws/aws-sdk-go/service/ssoadmin/ssoadminiface"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// impactUserDeleted is used for the assignments of a deleted user
+	impactUserDeleted = "user deleted"
+	// impactGroupDeleted is used for the assignments of a deleted group
+	impactGroupDeleted = "group deleted"
+	// impactRemovedFromGroup is used for the group assignments a removed member loses
+	impactRemovedFromGroup = "removed from group"
+)
+
+// permissionSetAssignment is a permission set assigned to a principal in an account
+type permissionSetAssignment struct {
+	AccountID        string `json:"accountId"`
+	PermissionSetArn string `json:"permissionSetArn"`
+}
+
+// accessImpact lists the assignments a user or group loses through a change of the sync
+type accessImpact struct {
+	Principal   string                    `json:"principal"`
+	Change      string                    `json:"change"`
+	Group       string                    `json:"group,omitempty"`
+	Assignments []permissionSetAssignment `json:"assignments"`
+}
+
+// instanceArn returns the configured SSO instance, or the only instance of the account
+func instanceArn(api ssoadminiface.SSOAdminAPI, configured string) (string, error) {
+	if configured != "" {
+		return configured, nil
+	}
+
+	out, err := api.ListInstances(&ssoadmin.ListInstancesInput{})
+	if err != nil {
+		return "", err
+	}
+	if len(out.Instances) == 0 {
+		return "", errors.New("no sso instance found, set the sso instance arn")
+	}
+
+	return aws_sdk.StringValue(out.Instances[0].InstanceArn), nil
+}
+
+// listAssignments returns the permission set assignments of the instance by principal id
+func listAssignments(api ssoadminiface.SSOAdminAPI, instance string) (map[string][]permissionSetAssignment, error) {
+	var permissionSets []*string
+	err := api.ListPermissionSetsPages(&ssoadmin.ListPermissionSetsInput{InstanceArn: &instance},
+		func(page *ssoadmin.ListPermissionSetsOutput, lastPage bool) bool {
+			permissionSets = append(permissionSets, page.PermissionSets...)
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	assignments := make(map[string][]permissionSetAssignment)
+	for _, permissionSet := range permissionSets {
+		var accounts []*string
+		err := api.ListAccountsForProvisionedPermissionSetPages(&ssoadmin.ListAccountsForProvisionedPermissionSetInput{
+			InstanceArn:      &instance,
+			PermissionSetArn: permissionSet,
+		}, func(page *ssoadmin.ListAccountsForProvisionedPermissionSetOutput, lastPage bool) bool {
+			accounts = append(accounts, page.AccountIds...)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, account := range accounts {
+			err := api.ListAccountAssignmentsPages(&ssoadmin.ListAccountAssignmentsInput{
+				AccountId:        account,
+				InstanceArn:      &instance,
+				PermissionSetArn: permissionSet,
+			}, func(page *ssoadmin.ListAccountAssignmentsOutput, lastPage bool) bool {
+				for _, a := range page.AccountAssignments {
+					id := aws_sdk.StringValue(a.PrincipalId)
+					assignments[id] = append(assignments[id], permissionSetAssignment{
+						AccountID:        aws_sdk.StringValue(a.AccountId),
+						PermissionSetArn: aws_sdk.StringValue(a.PermissionSetArn),
+					})
+				}
+				return true
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return assignments, nil
+}
+
+// getAccessImpact returns the assignments lost by the deleted users and groups
+// and by the members removed from their groups, changes without assignments are left out.
+// The deletions don't carry their ids, they are looked up by name in the aws users and groups.
+// A deleted user also loses the assignments of its groups, they are listed once with its
+// deletion rather than again as removals from the groups.
+func getAccessImpact(assignments map[string][]permissionSetAssignment, delUsers []*aws.User, delGroups []*aws.Group, awsUsers []*aws.User, awsGroups []*aws.Group, removeMembers map[string][]*aws.User) []accessImpact {
+	var impact []accessImpact
+
+	userIDs := make(map[string]string)
+	for _, u := range awsUsers {
+		userIDs[u.Username] = u.ID
+	}
+
+	groupIDs := make(map[string]string)
+	for _, g := range awsGroups {
+		groupIDs[g.DisplayName] = g.ID
+	}
+
+	groups := make([]string, 0, len(removeMembers))
+	for group := range removeMembers {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	deleted := make(map[string]struct{}, len(delUsers))
+	for _, u := range delUsers {
+		deleted[u.Username] = struct{}{}
+
+		a := withAssignments(nil, assignments[userIDs[u.Username]])
+		for _, group := range groups {
+			for _, m := range removeMembers[group] {
+				if m.Username == u.Username {
+					a = withAssignments(a, assignments[groupIDs[group]])
+				}
+			}
+		}
+		if len(a) > 0 {
+			impact = append(impact, accessImpact{Principal: u.Username, Change: impactUserDeleted, Assignments: a})
+		}
+	}
+
+	for _, g := range delGroups {
+		if a := assignments[groupIDs[g.DisplayName]]; len(a) > 0 {
+			impact = append(impact, accessImpact{Principal: g.DisplayName, Change: impactGroupDeleted, Assignments: a})
+		}
+	}
+
+	for _, group := range groups {
+		a := assignments[groupIDs[group]]
+		if len(a) == 0 {
+			continue
+		}
+		for _, u := range removeMembers[group] {
+			if _, found := deleted[u.Username]; found {
+				continue
+			}
+			impact = append(impact, accessImpact{Principal: u.Username, Change: impactRemovedFromGroup, Group: group, Assignments: a})
+		}
+	}
+
+	return impact
+}
+
+// withAssignments appends the assignments that aren't in a yet
+func withAssignments(a []permissionSetAssignment, more []permissionSetAssignment) []permissionSetAssignment {
+	for _, m := range more {
+		found := false
+		for _, existing := range a {
+			if existing == m {
+				found = true
+				break
+			}
+		}
+		if !found {
+			a = append(a, m)
+		}
+	}
+	return a
+}
+
+// reportAccessImpact looks up the permission set assignments lost by the planned
+// deletions, it logs them and counts them in the stats and report
+func (s *syncGSuite) reportAccessImpact(delUsers []*aws.User, delGroups []*aws.Group, awsUsers []*aws.User, awsGroups []*aws.Group, removeMembers map[string][]*aws.User) error {
+	if s.ssoAdmin == nil {
+		return nil
+	}
+
+	log.Info("get permission set assignments")
+	instance, err := instanceArn(s.ssoAdmin, s.cfg.SSOInstanceArn)
+	if err != nil {
+		return err
+	}
+
+	assignments, err := listAssignments(s.ssoAdmin, instance)
+	if err != nil {
+		return err
+	}
+
+	s.impact = getAccessImpact(assignments, delUsers, delGroups, awsUsers, awsGroups, removeMembers)
+	for _, i := range s.impact {
+		log.WithFields(log.Fields{
+			"principal":   i.Principal,
+			"change":      i.Change,
+			"group":       i.Group,
+			"assignments": len(i.Assignments),
+		}).Warn("permission set assignments affected")
+		s.stats.AssignmentsAffected += len(i.Assignments)
+	}
+
+	return nil
+}
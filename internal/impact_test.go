@@ -0,0 +1,147 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/awslabs/ssosync/internal/aws"
+
+	aws_sdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssoadmin"
+	"github.com/aws/aws-sdk-go/service/ssoadmin/ssoadminiface"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSSOAdmin serves the assignments of each permission set by account,
+// one page per account
+type fakeSSOAdmin struct {
+	ssoadminiface.SSOAdminAPI
+
+	instances   []string
+	assignments map[string]map[string][]*ssoadmin.AccountAssignment
+	err         error
+}
+
+func (f *fakeSSOAdmin) ListInstances(input *ssoadmin.ListInstancesInput) (*ssoadmin.ListInstancesOutput, error) {
+	out := &ssoadmin.ListInstancesOutput{}
+	for _, arn := range f.instances {
+		out.Instances = append(out.Instances, &ssoadmin.InstanceMetadata{InstanceArn: aws_sdk.String(arn)})
+	}
+	return out, nil
+}
+
+func (f *fakeSSOAdmin) ListPermissionSetsPages(input *ssoadmin.ListPermissionSetsInput, fn func(*ssoadmin.ListPermissionSetsOutput, bool) bool) error {
+	if f.err != nil {
+		return f.err
+	}
+	out := &ssoadmin.ListPermissionSetsOutput{}
+	for permissionSet := range f.assignments {
+		out.PermissionSets = append(out.PermissionSets, aws_sdk.String(permissionSet))
+	}
+	fn(out, true)
+	return nil
+}
+
+func (f *fakeSSOAdmin) ListAccountsForProvisionedPermissionSetPages(input *ssoadmin.ListAccountsForProvisionedPermissionSetInput, fn func(*ssoadmin.ListAccountsForProvisionedPermissionSetOutput, bool) bool) error {
+	out := &ssoadmin.ListAccountsForProvisionedPermissionSetOutput{}
+	for account := range f.assignments[*input.PermissionSetArn] {
+		out.AccountIds = append(out.AccountIds, aws_sdk.String(account))
+	}
+	fn(out, true)
+	return nil
+}
+
+func (f *fakeSSOAdmin) ListAccountAssignmentsPages(input *ssoadmin.ListAccountAssignmentsInput, fn func(*ssoadmin.ListAccountAssignmentsOutput, bool) bool) error {
+	fn(&ssoadmin.ListAccountAssignmentsOutput{AccountAssignments: f.assignments[*input.PermissionSetArn][*input.AccountId]}, true)
+	return nil
+}
+
+func assignment(account string, permissionSet string, principalType string, principal string) *ssoadmin.AccountAssignment {
+	return &ssoadmin.AccountAssignment{
+		AccountId:        aws_sdk.String(account),
+		PermissionSetArn: aws_sdk.String(permissionSet),
+		PrincipalType:    aws_sdk.String(principalType),
+		PrincipalId:      aws_sdk.String(principal),
+	}
+}
+
+func Test_instanceArn(t *testing.T) {
+	api := &fakeSSOAdmin{instances: []string{"arn:instance-1"}}
+
+	arn, err := instanceArn(api, "arn:configured")
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:configured", arn)
+
+	arn, err = instanceArn(api, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:instance-1", arn)
+
+	_, err = instanceArn(&fakeSSOAdmin{}, "")
+	assert.Error(t, err)
+}
+
+func Test_listAssignments(t *testing.T) {
+	api := &fakeSSOAdmin{assignments: map[string]map[string][]*ssoadmin.AccountAssignment{
+		"arn:ps-admin": {
+			"111111111111": {assignment("111111111111", "arn:ps-admin", ssoadmin.PrincipalTypeGroup, "group-2")},
+			"222222222222": {
+				assignment("222222222222", "arn:ps-admin", ssoadmin.PrincipalTypeGroup, "group-2"),
+				assignment("222222222222", "arn:ps-admin", ssoadmin.PrincipalTypeUser, "id-user-3"),
+			},
+		},
+	}}
+
+	assignments, err := listAssignments(api, "arn:instance")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []permissionSetAssignment{
+		{AccountID: "111111111111", PermissionSetArn: "arn:ps-admin"},
+		{AccountID: "222222222222", PermissionSetArn: "arn:ps-admin"},
+	}, assignments["group-2"])
+	assert.Equal(t, []permissionSetAssignment{{AccountID: "222222222222", PermissionSetArn: "arn:ps-admin"}}, assignments["id-user-3"])
+
+	_, err = listAssignments(&fakeSSOAdmin{err: errors.New("access denied")}, "arn:instance")
+	assert.Error(t, err)
+}
+
+func Test_getAccessImpact(t *testing.T) {
+	admins := []permissionSetAssignment{{AccountID: "111111111111", PermissionSetArn: "arn:ps-admin"}}
+	readers := []permissionSetAssignment{{AccountID: "222222222222", PermissionSetArn: "arn:ps-read"}}
+	assignments := map[string][]permissionSetAssignment{
+		"id-user-1": admins,
+		"group-1":   readers,
+		"group-2":   admins,
+		"group-4":   readers,
+	}
+
+	impact := getAccessImpact(assignments,
+		[]*aws.User{{Username: "user-1@email.com"}, {Username: "user-2@email.com"}},
+		[]*aws.Group{{DisplayName: "group-1"}},
+		[]*aws.User{{ID: "id-user-1", Username: "user-1@email.com"}, {ID: "id-user-2", Username: "user-2@email.com"}},
+		[]*aws.Group{{ID: "group-1", DisplayName: "group-1"}, {ID: "group-2", DisplayName: "group-2"}, {ID: "group-3", DisplayName: "group-3"}, {ID: "group-4", DisplayName: "group-4"}},
+		map[string][]*aws.User{
+			"group-2": {{ID: "id-user-1", Username: "user-1@email.com"}, {ID: "id-user-4", Username: "user-4@email.com"}},
+			"group-3": {{ID: "id-user-3", Username: "user-3@email.com"}},
+			"group-4": {{ID: "id-user-1", Username: "user-1@email.com"}},
+		})
+
+	// user-1 is deleted, the assignments of its groups are listed once with its deletion
+	assert.Equal(t, []accessImpact{
+		{Principal: "user-1@email.com", Change: impactUserDeleted, Assignments: append(admins, readers...)},
+		{Principal: "group-1", Change: impactGroupDeleted, Assignments: readers},
+		{Principal: "user-4@email.com", Change: impactRemovedFromGroup, Group: "group-2", Assignments: admins},
+	}, impact)
+}
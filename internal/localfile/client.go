@@ -0,0 +1,291 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package localfile reads the users and groups of a directory export file
+package localfile
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/awslabs/ssosync/internal/google"
+
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// User is a user of the export
+type User struct {
+	ID         string `json:"id"`
+	Email      string `json:"email"`
+	GivenName  string `json:"givenName"`
+	FamilyName string `json:"familyName"`
+	Suspended  bool   `json:"suspended"`
+}
+
+// Group is a group of the export, its members are the emails of users or
+// of other groups
+type Group struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Email   string   `json:"email"`
+	Members []string `json:"members"`
+}
+
+// Directory is the content of a JSON export
+type Directory struct {
+	Users        []User  `json:"users"`
+	DeletedUsers []User  `json:"deletedUsers"`
+	Groups       []Group `json:"groups"`
+}
+
+// csvColumns are the columns of a CSV export, one user per row. The groups
+// column lists the names of the groups of the user, separated by ;
+var csvColumns = []string{"email", "given_name", "family_name", "suspended", "groups"}
+
+type client struct {
+	dir Directory
+	// groups and users are indexed by id and email
+	groups map[string]Group
+	users  map[string]User
+}
+
+// NewClient reads the export file, JSON or CSV by its extension, and
+// returns a client of it in the shape of the Google directory the sync
+// works on
+func NewClient(path string) (google.Client, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var dir *Directory
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dir, err = readJSON(f)
+	case ".csv":
+		dir, err = readCSV(f)
+	default:
+		return nil, fmt.Errorf("unsupported source file %s, expected a .json or .csv file", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("source file %s: %w", path, err)
+	}
+
+	return newClient(dir), nil
+}
+
+func newClient(dir *Directory) *client {
+	c := &client{
+		dir:    *dir,
+		groups: make(map[string]Group),
+		users:  make(map[string]User),
+	}
+	for i, g := range c.dir.Groups {
+		if g.ID == "" {
+			g.ID = g.Email
+		}
+		c.dir.Groups[i] = g
+		c.groups[g.ID] = g
+	}
+	for _, u := range c.dir.Users {
+		c.users[u.Email] = u
+	}
+
+	return c
+}
+
+// readJSON reads a JSON export
+func readJSON(r io.Reader) (*Directory, error) {
+	var dir Directory
+	if err := json.NewDecoder(r).Decode(&dir); err != nil {
+		return nil, err
+	}
+	return &dir, nil
+}
+
+// readCSV reads a CSV export, the groups have no email so their name is
+// used in its place
+func readCSV(r io.Reader) (*Directory, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 || strings.Join(rows[0], ",") != strings.Join(csvColumns, ",") {
+		return nil, fmt.Errorf("expected the header %s", strings.Join(csvColumns, ","))
+	}
+
+	dir := &Directory{}
+	groups := make(map[string]int)
+	for i, row := range rows[1:] {
+		suspended := false
+		if row[3] != "" {
+			suspended, err = strconv.ParseBool(row[3])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+2, err)
+			}
+		}
+		dir.Users = append(dir.Users, User{ID: row[0], Email: row[0], GivenName: row[1], FamilyName: row[2], Suspended: suspended})
+
+		for _, name := range strings.Split(row[4], ";") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if _, found := groups[name]; !found {
+				groups[name] = len(dir.Groups)
+				dir.Groups = append(dir.Groups, Group{ID: name, Name: name, Email: name})
+			}
+			g := &dir.Groups[groups[name]]
+			g.Members = append(g.Members, row[0])
+		}
+	}
+
+	return dir, nil
+}
+
+// GetUsers will get the users matching the query, see matches
+func (c *client) GetUsers(query string) ([]*admin.User, error) {
+	users := make([]*admin.User, 0)
+	for _, u := range c.dir.Users {
+		ok, err := matches(query, u.Email, u.GivenName+" "+u.FamilyName)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			users = append(users, toGoogleUser(u))
+		}
+	}
+
+	return users, nil
+}
+
+// GetDeletedUsers will get the deleted users of the export
+func (c *client) GetDeletedUsers() ([]*admin.User, error) {
+	users := make([]*admin.User, 0, len(c.dir.DeletedUsers))
+	for _, u := range c.dir.DeletedUsers {
+		users = append(users, toGoogleUser(u))
+	}
+
+	return users, nil
+}
+
+// GetGroups will get the groups matching the query, see matches
+func (c *client) GetGroups(query string) ([]*admin.Group, error) {
+	groups := make([]*admin.Group, 0)
+	for _, g := range c.dir.Groups {
+		ok, err := matches(query, g.Email, g.Name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			groups = append(groups, &admin.Group{Id: g.ID, Name: g.Name, Email: g.Email})
+		}
+	}
+
+	return groups, nil
+}
+
+// GetGroupMembers will get the members of the group, members that are
+// groups of the export are nested groups
+func (c *client) GetGroupMembers(g *admin.Group) ([]*admin.Member, error) {
+	group, found := c.groups[g.Id]
+	if !found {
+		return nil, fmt.Errorf("group %s is not in the source file", g.Email)
+	}
+
+	emails := make(map[string]struct{})
+	for _, other := range c.dir.Groups {
+		emails[other.Email] = struct{}{}
+	}
+
+	members := make([]*admin.Member, 0, len(group.Members))
+	for _, email := range group.Members {
+		if _, isGroup := emails[email]; isGroup {
+			members = append(members, &admin.Member{Email: email, Type: "GROUP"})
+			continue
+		}
+		status := "ACTIVE"
+		if c.users[email].Suspended {
+			status = "SUSPENDED"
+		}
+		members = append(members, &admin.Member{Email: email, Type: "USER", Status: status})
+	}
+
+	return members, nil
+}
+
+// toGoogleUser maps the user of the export to a google user
+func toGoogleUser(u User) *admin.User {
+	id := u.ID
+	if id == "" {
+		id = u.Email
+	}
+	return &admin.User{
+		Id:           id,
+		PrimaryEmail: u.Email,
+		Name:         &admin.UserName{GivenName: u.GivenName, FamilyName: u.FamilyName},
+		Suspended:    u.Suspended,
+	}
+}
+
+// matches reports whether a user or group of the email and name matches the
+// query. As with Google, an empty query matches nothing and * everything,
+// other queries are comma separated clauses of email or name, either exact
+// (email=admin@example.com) or prefixes (name:Admin*).
+func matches(query string, email string, name string) (bool, error) {
+	if query == "" {
+		return false, nil
+	}
+	if query == "*" {
+		return true, nil
+	}
+
+	for _, clause := range strings.Split(query, ",") {
+		clause = strings.TrimSpace(clause)
+		sep := strings.IndexAny(clause, "=:")
+		if sep < 0 {
+			return false, fmt.Errorf("unsupported source file query %q, expected email or name clauses", clause)
+		}
+
+		var value string
+		switch strings.ToLower(clause[:sep]) {
+		case "email":
+			value = email
+		case "name":
+			value = name
+		default:
+			return false, fmt.Errorf("unsupported source file query %q, expected email or name clauses", clause)
+		}
+
+		want := strings.Trim(clause[sep+1:], "'\"")
+		if clause[sep] == ':' && strings.HasSuffix(want, "*") {
+			if strings.HasPrefix(strings.ToLower(value), strings.ToLower(strings.TrimSuffix(want, "*"))) {
+				return true, nil
+			}
+			continue
+		}
+		if strings.EqualFold(value, want) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localfile
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+func writeFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestNewClient_JSON(t *testing.T) {
+	c, err := NewClient(writeFile(t, "directory.json", `{
+		"users": [
+			{"id": "u1", "email": "jane@example.com", "givenName": "Jane", "familyName": "Doe"},
+			{"email": "john@example.com", "givenName": "John", "familyName": "Roe", "suspended": true}
+		],
+		"deletedUsers": [{"id": "u3", "email": "jim@example.com"}],
+		"groups": [
+			{"id": "g1", "name": "admins", "email": "admins@example.com", "members": ["jane@example.com", "devs@example.com"]},
+			{"name": "devs", "email": "devs@example.com", "members": ["john@example.com"]}
+		]
+	}`))
+	assert.NoError(t, err)
+
+	users, err := c.GetUsers("*")
+	assert.NoError(t, err)
+	assert.Equal(t, []*admin.User{
+		{Id: "u1", PrimaryEmail: "jane@example.com", Name: &admin.UserName{GivenName: "Jane", FamilyName: "Doe"}},
+		{Id: "john@example.com", PrimaryEmail: "john@example.com", Name: &admin.UserName{GivenName: "John", FamilyName: "Roe"}, Suspended: true},
+	}, users)
+
+	deleted, err := c.GetDeletedUsers()
+	assert.NoError(t, err)
+	assert.Equal(t, []*admin.User{{Id: "u3", PrimaryEmail: "jim@example.com", Name: &admin.UserName{}}}, deleted)
+
+	groups, err := c.GetGroups("*")
+	assert.NoError(t, err)
+	assert.Equal(t, []*admin.Group{
+		{Id: "g1", Name: "admins", Email: "admins@example.com"},
+		{Id: "devs@example.com", Name: "devs", Email: "devs@example.com"},
+	}, groups)
+
+	// groups of the export are nested groups
+	members, err := c.GetGroupMembers(groups[0])
+	assert.NoError(t, err)
+	assert.Equal(t, []*admin.Member{
+		{Email: "jane@example.com", Type: "USER", Status: "ACTIVE"},
+		{Email: "devs@example.com", Type: "GROUP"},
+	}, members)
+
+	members, err = c.GetGroupMembers(groups[1])
+	assert.NoError(t, err)
+	assert.Equal(t, []*admin.Member{{Email: "john@example.com", Type: "USER", Status: "SUSPENDED"}}, members)
+
+	_, err = c.GetGroupMembers(&admin.Group{Id: "missing"})
+	assert.Error(t, err)
+}
+
+func TestNewClient_CSV(t *testing.T) {
+	c, err := NewClient(writeFile(t, "directory.csv", `email,given_name,family_name,suspended,groups
+jane@example.com,Jane,Doe,,admins;devs
+john@example.com,John,Roe,true,devs
+`))
+	assert.NoError(t, err)
+
+	users, err := c.GetUsers("*")
+	assert.NoError(t, err)
+	assert.Len(t, users, 2)
+	assert.True(t, users[1].Suspended)
+
+	groups, err := c.GetGroups("*")
+	assert.NoError(t, err)
+	assert.Equal(t, []*admin.Group{
+		{Id: "admins", Name: "admins", Email: "admins"},
+		{Id: "devs", Name: "devs", Email: "devs"},
+	}, groups)
+
+	members, err := c.GetGroupMembers(groups[1])
+	assert.NoError(t, err)
+	assert.Equal(t, []*admin.Member{
+		{Email: "jane@example.com", Type: "USER", Status: "ACTIVE"},
+		{Email: "john@example.com", Type: "USER", Status: "SUSPENDED"},
+	}, members)
+}
+
+func TestNewClient_Invalid(t *testing.T) {
+	_, err := NewClient(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+
+	_, err = NewClient(writeFile(t, "directory.yaml", "users: []"))
+	assert.Error(t, err)
+
+	_, err = NewClient(writeFile(t, "directory.json", "{"))
+	assert.Error(t, err)
+
+	_, err = NewClient(writeFile(t, "directory.csv", "email,name\njane@example.com,Jane\n"))
+	assert.Error(t, err)
+
+	_, err = NewClient(writeFile(t, "directory.csv", "email,given_name,family_name,suspended,groups\njane@example.com,Jane,Doe,maybe,\n"))
+	assert.Error(t, err)
+}
+
+func Test_matches(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    bool
+		wantErr bool
+	}{
+		{query: "", want: false},
+		{query: "*", want: true},
+		{query: "email=jane@example.com", want: true},
+		{query: "email=JANE@example.com", want: true},
+		{query: "email=john@example.com", want: false},
+		{query: "email:jane*", want: true},
+		{query: "name:'Jane D*'", want: true},
+		{query: "name:Jim*", want: false},
+		{query: "name=Jim Roe, email:jane*", want: true},
+		{query: "department=Engineering", wantErr: true},
+		{query: "jane", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := matches(tt.query, "jane@example.com", "Jane Doe")
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
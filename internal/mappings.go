@@ -0,0 +1,81 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"sort"
+
+	"github.com/awslabs/ssosync/internal/aws"
+
+	log "github.com/sirupsen/logrus"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// idMapping correlates a google user or group with the aws one it's synced to
+type idMapping struct {
+	GoogleID string `json:"googleId"`
+	Email    string `json:"email"`
+	AWSName  string `json:"awsName"`
+	AWSID    string `json:"awsId"`
+}
+
+// idMappings lists the correlation of the synced google users and groups
+type idMappings struct {
+	Users  []idMapping `json:"users"`
+	Groups []idMapping `json:"groups"`
+}
+
+// newIDMappings maps the google users to the aws users of the same username
+// and the google groups to the aws groups named by groupName. Those without
+// an aws user or group, such as skipped users, are left out.
+func newIDMappings(gUsers []*admin.User, gGroups []*admin.Group, groupName func(*admin.Group) string, awsUsers []*aws.User, awsGroups []*aws.Group) *idMappings {
+	userIDs := make(map[string]string, len(awsUsers))
+	for _, u := range awsUsers {
+		userIDs[u.Username] = u.ID
+	}
+	groupIDs := make(map[string]string, len(awsGroups))
+	for _, g := range awsGroups {
+		groupIDs[g.DisplayName] = g.ID
+	}
+
+	mappings := &idMappings{Users: []idMapping{}, Groups: []idMapping{}}
+	for _, u := range gUsers {
+		if id, found := userIDs[u.PrimaryEmail]; found {
+			mappings.Users = append(mappings.Users, idMapping{GoogleID: u.Id, Email: u.PrimaryEmail, AWSName: u.PrimaryEmail, AWSID: id})
+		}
+	}
+	for _, g := range gGroups {
+		name := groupName(g)
+		if id, found := groupIDs[name]; found {
+			mappings.Groups = append(mappings.Groups, idMapping{GoogleID: g.Id, Email: g.Email, AWSName: name, AWSID: id})
+		}
+	}
+
+	sort.Slice(mappings.Users, func(i, j int) bool { return mappings.Users[i].Email < mappings.Users[j].Email })
+	sort.Slice(mappings.Groups, func(i, j int) bool { return mappings.Groups[i].Email < mappings.Groups[j].Email })
+
+	return mappings
+}
+
+// writeMappings writes the id mappings of the synced users and groups to a
+// file when requested
+func (s *syncGSuite) writeMappings(gUsers []*admin.User, gGroups []*admin.Group, awsUsers []*aws.User, awsGroups []*aws.Group) error {
+	if s.cfg.ExportMappings == "" {
+		return nil
+	}
+
+	log.WithField("path", s.cfg.ExportMappings).Info("writing id mappings")
+	return writeJSONFile(s.cfg.ExportMappings, newIDMappings(gUsers, gGroups, s.groupDisplayName, awsUsers, awsGroups))
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/awslabs/ssosync/internal/aws"
+	"github.com/awslabs/ssosync/internal/config"
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+func Test_newIDMappings(t *testing.T) {
+	gUsers := []*admin.User{
+		{Id: "g-user-2", PrimaryEmail: "user-2@email.com"},
+		{Id: "g-user-1", PrimaryEmail: "user-1@email.com"},
+		// skipped, it has no aws user
+		{Id: "g-user-3", PrimaryEmail: "user-3@email.com"},
+	}
+	gGroups := []*admin.Group{
+		{Id: "g-group-1", Name: "group-1", Email: "group-1@email.com"},
+		{Id: "g-group-2", Name: "group-2", Email: "group-2@email.com"},
+	}
+	awsUsers := []*aws.User{
+		{ID: "a-user-1", Username: "user-1@email.com"},
+		{ID: "a-user-2", Username: "user-2@email.com"},
+		// unmanaged, it has no google user
+		{ID: "a-user-4", Username: "user-4@email.com"},
+	}
+	awsGroups := []*aws.Group{
+		{ID: "a-group-1", DisplayName: "sso-group-1"},
+		{ID: "a-group-2", DisplayName: "group-2"},
+	}
+	prefixed := func(g *admin.Group) string { return "sso-" + g.Name }
+
+	assert.Equal(t, &idMappings{
+		Users: []idMapping{
+			{GoogleID: "g-user-1", Email: "user-1@email.com", AWSName: "user-1@email.com", AWSID: "a-user-1"},
+			{GoogleID: "g-user-2", Email: "user-2@email.com", AWSName: "user-2@email.com", AWSID: "a-user-2"},
+		},
+		Groups: []idMapping{
+			{GoogleID: "g-group-1", Email: "group-1@email.com", AWSName: "sso-group-1", AWSID: "a-group-1"},
+		},
+	}, newIDMappings(gUsers, gGroups, prefixed, awsUsers, awsGroups))
+}
+
+func Test_writeMappings(t *testing.T) {
+	gUsers := []*admin.User{{Id: "g-user-1", PrimaryEmail: "user-1@email.com"}}
+	gGroups := []*admin.Group{{Id: "g-group-1", Name: "group-1", Email: "group-1@email.com"}}
+	awsUsers := []*aws.User{{ID: "a-user-1", Username: "user-1@email.com"}}
+	awsGroups := []*aws.Group{{ID: "a-group-1", DisplayName: "group-1"}}
+
+	// nothing is written by default
+	s := &syncGSuite{cfg: config.New()}
+	assert.NoError(t, s.writeMappings(gUsers, gGroups, awsUsers, awsGroups))
+
+	cfg := config.New()
+	cfg.ExportMappings = filepath.Join(t.TempDir(), "mappings.json")
+	s = &syncGSuite{cfg: cfg}
+	assert.NoError(t, s.writeMappings(gUsers, gGroups, awsUsers, awsGroups))
+
+	b, err := ioutil.ReadFile(cfg.ExportMappings)
+	assert.NoError(t, err)
+
+	var written idMappings
+	assert.NoError(t, json.Unmarshal(b, &written))
+	assert.Equal(t, []idMapping{{GoogleID: "g-user-1", Email: "user-1@email.com", AWSName: "user-1@email.com", AWSID: "a-user-1"}}, written.Users)
+	assert.Equal(t, []idMapping{{GoogleID: "g-group-1", Email: "group-1@email.com", AWSName: "group-1", AWSID: "a-group-1"}}, written.Groups)
+}
@@ -0,0 +1,349 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package okta reads the users and groups of an Okta org
+package okta
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/awslabs/ssosync/internal/google"
+
+	log "github.com/sirupsen/logrus"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+const (
+	// pageSize is the number of users or groups requested per page
+	pageSize = 200
+	// maxRetries is the number of times a rate limited call is retried
+	maxRetries = 3
+	// maxRateLimitWait bounds the wait for the rate limit to reset
+	maxRateLimitWait = time.Minute
+	// DefaultTimeout is the timeout of each call to the Okta API
+	DefaultTimeout = 30 * time.Second
+)
+
+// statusDeprovisioned is the status of the deleted Okta users
+const statusDeprovisioned = "DEPROVISIONED"
+
+// activeStatuses are the Okta user statuses synced as active users, the
+// users that can't sign in, such as staged or suspended ones, are synced as
+// suspended and the deprovisioned ones as deleted
+var activeStatuses = map[string]struct{}{
+	"ACTIVE":           {},
+	"RECOVERY":         {},
+	"PASSWORD_EXPIRED": {},
+	"LOCKED_OUT":       {},
+}
+
+// isActive reports whether the Okta user can sign in
+func isActive(u user) bool {
+	_, ok := activeStatuses[u.Status]
+	return ok
+}
+
+// ErrRateLimited is returned when the calls are still rate limited after the retries
+var ErrRateLimited = errors.New("okta rate limit exceeded")
+
+// Config specifies the org and credentials of the Okta client
+type Config struct {
+	// OrgURL is the url of the Okta org, such as https://example.okta.com
+	OrgURL string
+	// Token is the API token the calls are made with
+	Token string
+}
+
+// user is the part of an Okta user the sync uses
+type user struct {
+	ID      string `json:"id"`
+	Status  string `json:"status"`
+	Profile struct {
+		Login     string `json:"login"`
+		FirstName string `json:"firstName"`
+		LastName  string `json:"lastName"`
+	} `json:"profile"`
+}
+
+// group is the part of an Okta group the sync uses
+type group struct {
+	ID      string `json:"id"`
+	Profile struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	} `json:"profile"`
+}
+
+type client struct {
+	ctx        context.Context
+	httpClient *http.Client
+	orgURL     *url.URL
+	token      string
+
+	// now and sleep are replaced in tests
+	now   func() time.Time
+	sleep func(context.Context, time.Duration) error
+}
+
+// NewClient creates a new client for the Okta Users and Groups APIs, the
+// users and groups are returned in the shape of the Google directory the
+// sync works on
+func NewClient(ctx context.Context, httpClient *http.Client, cfg *Config) (google.Client, error) {
+	return newClient(ctx, httpClient, cfg)
+}
+
+func newClient(ctx context.Context, httpClient *http.Client, cfg *Config) (*client, error) {
+	orgURL, err := url.Parse(cfg.OrgURL)
+	if err != nil {
+		return nil, err
+	}
+	if orgURL.Scheme == "" || orgURL.Host == "" {
+		return nil, fmt.Errorf("invalid okta org url %q, expected https://org.okta.com", cfg.OrgURL)
+	}
+
+	return &client{
+		ctx:        ctx,
+		httpClient: httpClient,
+		orgURL:     orgURL,
+		token:      cfg.Token,
+		now:        time.Now,
+		sleep:      sleep,
+	}, nil
+}
+
+// sleep waits for the duration, it ends early when the context is done
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// GetUsers will get the users matching the query, an Okta search
+// expression such as profile.department eq "Engineering". As with Google,
+// an empty query gets no users and * gets all of them.
+func (c *client) GetUsers(query string) ([]*admin.User, error) {
+	if query == "" {
+		return []*admin.User{}, nil
+	}
+
+	var users []user
+	if err := c.list("/api/v1/users", search(query), &users); err != nil {
+		return nil, err
+	}
+
+	// the deprovisioned users are the deleted users, searches return them too
+	existing := make([]user, 0, len(users))
+	for _, u := range users {
+		if u.Status != statusDeprovisioned {
+			existing = append(existing, u)
+		}
+	}
+
+	return toGoogleUsers(existing), nil
+}
+
+// GetDeletedUsers will get the deprovisioned users
+func (c *client) GetDeletedUsers() ([]*admin.User, error) {
+	var users []user
+	if err := c.list("/api/v1/users", search(fmt.Sprintf("status eq %q", statusDeprovisioned)), &users); err != nil {
+		return nil, err
+	}
+
+	return toGoogleUsers(users), nil
+}
+
+// GetGroups will get the groups matching the query, an Okta search
+// expression such as profile.name sw "aws-". As with Google, an empty query
+// gets no groups and * gets all of them.
+func (c *client) GetGroups(query string) ([]*admin.Group, error) {
+	if query == "" {
+		return []*admin.Group{}, nil
+	}
+
+	var groups []group
+	if err := c.list("/api/v1/groups", search(query), &groups); err != nil {
+		return nil, err
+	}
+
+	gGroups := make([]*admin.Group, 0, len(groups))
+	for _, g := range groups {
+		gGroups = append(gGroups, toGoogleGroup(g))
+	}
+
+	return gGroups, nil
+}
+
+// GetGroupMembers will get the users of the group, Okta groups can't be
+// members of groups
+func (c *client) GetGroupMembers(g *admin.Group) ([]*admin.Member, error) {
+	var users []user
+	if err := c.list(path.Join("/api/v1/groups", g.Id, "users"), url.Values{}, &users); err != nil {
+		return nil, err
+	}
+
+	members := make([]*admin.Member, 0, len(users))
+	for _, u := range users {
+		if u.Status == statusDeprovisioned {
+			continue
+		}
+		status := "ACTIVE"
+		if !isActive(u) {
+			status = "SUSPENDED"
+		}
+		members = append(members, &admin.Member{Id: u.ID, Email: u.Profile.Login, Type: "USER", Status: status})
+	}
+
+	return members, nil
+}
+
+// search returns the parameters of a list filtered by the query
+func search(query string) url.Values {
+	params := url.Values{}
+	if query != "*" {
+		params.Set("search", query)
+	}
+	return params
+}
+
+// toGoogleUsers maps the Okta users to google users, the login is used as
+// the primary email as it's the username of the aws user
+func toGoogleUsers(users []user) []*admin.User {
+	gUsers := make([]*admin.User, 0, len(users))
+	for _, u := range users {
+		gUsers = append(gUsers, &admin.User{
+			Id:           u.ID,
+			PrimaryEmail: u.Profile.Login,
+			Name: &admin.UserName{
+				GivenName:  u.Profile.FirstName,
+				FamilyName: u.Profile.LastName,
+			},
+			Suspended: !isActive(u),
+		})
+	}
+	return gUsers
+}
+
+// toGoogleGroup maps the Okta group to a google group, Okta groups have no
+// email address so the name is used in its place
+func toGoogleGroup(g group) *admin.Group {
+	return &admin.Group{
+		Id:          g.ID,
+		Name:        g.Profile.Name,
+		Email:       g.Profile.Name,
+		Description: g.Profile.Description,
+	}
+}
+
+// nextLink matches the url of the next page in a Link header
+var nextLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// list gets every page of the resources at the path into v, a pointer to a
+// slice, following the next links of the responses
+func (c *client) list(p string, params url.Values, v interface{}) error {
+	params.Set("limit", strconv.Itoa(pageSize))
+	next := c.orgURL.ResolveReference(&url.URL{Path: p, RawQuery: params.Encode()}).String()
+
+	var all []json.RawMessage
+	for next != "" {
+		var page []json.RawMessage
+		resp, err := c.get(next, &page)
+		if err != nil {
+			return err
+		}
+		all = append(all, page...)
+
+		next = ""
+		for _, link := range resp.Header.Values("Link") {
+			if m := nextLink.FindStringSubmatch(link); m != nil {
+				next = m[1]
+			}
+		}
+	}
+
+	b, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// get reads the json response of the url into v, rate limited calls are
+// retried once the limit resets
+func (c *client) get(u string, v interface{}) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Authorization", "SSWS "+c.token)
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			resp.Body.Close()
+			if attempt >= maxRetries {
+				return nil, ErrRateLimited
+			}
+			wait := c.rateLimitWait(resp)
+			log.WithFields(log.Fields{"wait": wait, "attempt": attempt + 1}).Warn("okta rate limit reached, waiting for it to reset")
+			if err := c.sleep(c.ctx, wait); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		err = func() error {
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("okta returned status %d for %s", resp.StatusCode, req.URL.Path)
+			}
+			return json.NewDecoder(resp.Body).Decode(v)
+		}()
+		return resp, err
+	}
+}
+
+// rateLimitWait returns how long to wait before the rate limit resets, from
+// the X-Rate-Limit-Reset epoch seconds of the response
+func (c *client) rateLimitWait(resp *http.Response) time.Duration {
+	reset, err := strconv.ParseInt(resp.Header.Get("X-Rate-Limit-Reset"), 10, 64)
+	if err != nil {
+		return time.Second
+	}
+
+	wait := time.Unix(reset, 0).Sub(c.now())
+	if wait < time.Second {
+		return time.Second
+	}
+	if wait > maxRateLimitWait {
+		return maxRateLimitWait
+	}
+	return wait
+}
@@ -0,0 +1,190 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package okta
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *client {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	c, err := newClient(context.Background(), srv.Client(), &Config{OrgURL: srv.URL, Token: "token"})
+	assert.NoError(t, err)
+	return c
+}
+
+func TestNewClient(t *testing.T) {
+	_, err := NewClient(context.Background(), http.DefaultClient, &Config{OrgURL: "example.okta.com"})
+	assert.Error(t, err)
+
+	_, err = NewClient(context.Background(), http.DefaultClient, &Config{OrgURL: "https://example.okta.com"})
+	assert.NoError(t, err)
+}
+
+func TestClient_GetUsers(t *testing.T) {
+	var searches []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "SSWS token", r.Header.Get("Authorization"))
+		assert.Equal(t, "/api/v1/users", r.URL.Path)
+		assert.Equal(t, strconv.Itoa(pageSize), r.URL.Query().Get("limit"))
+		searches = append(searches, r.URL.Query().Get("search"))
+
+		// two pages, linked by the next link
+		if r.URL.Query().Get("after") == "" {
+			w.Header().Add("Link", fmt.Sprintf(`<http://%s/api/v1/users?limit=200>; rel="self"`, r.Host))
+			w.Header().Add("Link", fmt.Sprintf(`<http://%s/api/v1/users?after=u1&limit=200>; rel="next"`, r.Host))
+			w.Write([]byte(`[{"id": "u1", "status": "ACTIVE", "profile": {"login": "jane@example.com", "firstName": "Jane", "lastName": "Doe"}}]`))
+			return
+		}
+		w.Write([]byte(`[
+			{"id": "u2", "status": "SUSPENDED", "profile": {"login": "john@example.com", "firstName": "John", "lastName": "Roe"}},
+			{"id": "u3", "status": "STAGED", "profile": {"login": "jim@example.com", "firstName": "Jim", "lastName": "Poe"}},
+			{"id": "u4", "status": "LOCKED_OUT", "profile": {"login": "joe@example.com", "firstName": "Joe", "lastName": "Moe"}},
+			{"id": "u5", "status": "DEPROVISIONED", "profile": {"login": "jill@example.com", "firstName": "Jill", "lastName": "Lo"}}
+		]`))
+	})
+
+	// only the users that can sign in are active, the deprovisioned ones are deleted
+	users, err := c.GetUsers(`profile.department eq "Engineering"`)
+	assert.NoError(t, err)
+	assert.Equal(t, []*admin.User{
+		{Id: "u1", PrimaryEmail: "jane@example.com", Name: &admin.UserName{GivenName: "Jane", FamilyName: "Doe"}},
+		{Id: "u2", PrimaryEmail: "john@example.com", Name: &admin.UserName{GivenName: "John", FamilyName: "Roe"}, Suspended: true},
+		{Id: "u3", PrimaryEmail: "jim@example.com", Name: &admin.UserName{GivenName: "Jim", FamilyName: "Poe"}, Suspended: true},
+		{Id: "u4", PrimaryEmail: "joe@example.com", Name: &admin.UserName{GivenName: "Joe", FamilyName: "Moe"}},
+	}, users)
+	// the next page is requested as linked, without the search
+	assert.Equal(t, []string{`profile.department eq "Engineering"`, ""}, searches)
+
+	searches = nil
+	_, err = c.GetUsers("*")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"", ""}, searches)
+
+	// an empty query makes no call
+	searches = nil
+	users, err = c.GetUsers("")
+	assert.NoError(t, err)
+	assert.Empty(t, users)
+	assert.Empty(t, searches)
+
+	searches = nil
+	_, err = c.GetDeletedUsers()
+	assert.NoError(t, err)
+	assert.Equal(t, `status eq "DEPROVISIONED"`, searches[0])
+}
+
+func TestClient_GetGroupsAndMembers(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/groups":
+			w.Write([]byte(`[{"id": "g1", "profile": {"name": "aws-admins", "description": "admins"}}]`))
+		case "/api/v1/groups/g1/users":
+			w.Write([]byte(`[
+				{"id": "u1", "status": "ACTIVE", "profile": {"login": "jane@example.com"}},
+				{"id": "u2", "status": "SUSPENDED", "profile": {"login": "john@example.com"}},
+				{"id": "u3", "status": "PROVISIONED", "profile": {"login": "jim@example.com"}},
+				{"id": "u4", "status": "DEPROVISIONED", "profile": {"login": "jill@example.com"}}
+			]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	groups, err := c.GetGroups("*")
+	assert.NoError(t, err)
+	assert.Equal(t, []*admin.Group{{Id: "g1", Name: "aws-admins", Email: "aws-admins", Description: "admins"}}, groups)
+
+	members, err := c.GetGroupMembers(groups[0])
+	assert.NoError(t, err)
+	assert.Equal(t, []*admin.Member{
+		{Id: "u1", Email: "jane@example.com", Type: "USER", Status: "ACTIVE"},
+		{Id: "u2", Email: "john@example.com", Type: "USER", Status: "SUSPENDED"},
+		{Id: "u3", Email: "jim@example.com", Type: "USER", Status: "SUSPENDED"},
+	}, members)
+
+	_, err = c.GetGroupMembers(&admin.Group{Id: "missing"})
+	assert.Error(t, err)
+}
+
+func TestClient_RateLimited(t *testing.T) {
+	now := time.Unix(1000, 0)
+	calls := 0
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("X-Rate-Limit-Reset", "1005")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`[]`))
+	})
+	c.now = func() time.Time { return now }
+	var waits []time.Duration
+	c.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+
+	// the call is retried once the limit resets
+	groups, err := c.GetGroups("*")
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, []time.Duration{5 * time.Second}, waits)
+
+	// and given up on after the retries
+	c = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	waits = nil
+	c.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+	_, err = c.GetGroups("*")
+	assert.Equal(t, ErrRateLimited, err)
+	assert.Equal(t, []time.Duration{time.Second, time.Second, time.Second}, waits)
+}
+
+func TestClient_RateLimitWaitStopsWithContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Rate-Limit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err := newClient(ctx, srv.Client(), &Config{OrgURL: srv.URL, Token: "token"})
+	assert.NoError(t, err)
+
+	// the wait for the rate limit to reset ends with the sync
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	_, err = c.GetGroups("*")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, int64(time.Since(start)), int64(maxRateLimitWait))
+}
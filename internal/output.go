@@ -0,0 +1,359 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/awslabs/ssosync/internal/aws"
+
+	aws_sdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// objectPutter is the part of the S3 API used to write the plan and report
+type objectPutter interface {
+	PutObject(*s3.PutObjectInput) (*s3.PutObjectOutput, error)
+}
+
+// syncPlan lists the changes a sync is about to make
+type syncPlan struct {
+	AddUsers      []string            `json:"addUsers"`
+	UpdateUsers   []string            `json:"updateUsers"`
+	DeleteUsers   []string            `json:"deleteUsers"`
+	AddGroups     []string            `json:"addGroups"`
+	DeleteGroups  []string            `json:"deleteGroups"`
+	AddMembers    map[string][]string `json:"addMembers"`
+	RemoveMembers map[string][]string `json:"removeMembers"`
+	Impact        []accessImpact      `json:"impact,omitempty"`
+}
+
+// syncReport lists what a sync could not resolve, the access its deletions
+// removed and the aws users and groups missing from google
+type syncReport struct {
+	Unresolved map[string][]unresolvedMember `json:"unresolved"`
+	Impact     []accessImpact                `json:"impact,omitempty"`
+	Drift      []driftResource               `json:"drift,omitempty"`
+}
+
+// newSyncPlan returns the plan of the user, group and membership operations
+func newSyncPlan(addUsers, updateUsers, deleteUsers []*aws.User, addGroups, deleteGroups []*aws.Group, addMembers map[string][]*admin.User, removeMembers map[string][]*aws.User) *syncPlan {
+	usernames := func(users []*aws.User) []string {
+		names := make([]string, 0, len(users))
+		for _, u := range users {
+			names = append(names, u.Username)
+		}
+		return names
+	}
+
+	groupNames := func(groups []*aws.Group) []string {
+		names := make([]string, 0, len(groups))
+		for _, g := range groups {
+			names = append(names, g.DisplayName)
+		}
+		return names
+	}
+
+	added := make(map[string][]string)
+	for group, users := range addMembers {
+		names := make([]string, 0, len(users))
+		for _, u := range users {
+			names = append(names, u.PrimaryEmail)
+		}
+		sort.Strings(names)
+		added[group] = names
+	}
+
+	removed := make(map[string][]string)
+	for group, users := range removeMembers {
+		removed[group] = usernames(users)
+	}
+
+	return &syncPlan{
+		AddUsers:      usernames(addUsers),
+		UpdateUsers:   usernames(updateUsers),
+		DeleteUsers:   usernames(deleteUsers),
+		AddGroups:     groupNames(addGroups),
+		DeleteGroups:  groupNames(deleteGroups),
+		AddMembers:    added,
+		RemoveMembers: removed,
+	}
+}
+
+// conflicts returns the operations of the plan that contradict each other,
+// such as a member added to a deleted group. They can't all be applied and
+// usually mean a user or group was not matched between google and aws.
+func (p *syncPlan) conflicts() []string {
+	set := func(names []string) map[string]struct{} {
+		m := make(map[string]struct{}, len(names))
+		for _, n := range names {
+			m[n] = struct{}{}
+		}
+		return m
+	}
+	sortedKeys := func(m map[string][]string) []string {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	deletedUsers := set(p.DeleteUsers)
+	deletedGroups := set(p.DeleteGroups)
+
+	var conflicts []string
+	for _, u := range p.AddUsers {
+		if _, ok := deletedUsers[u]; ok {
+			conflicts = append(conflicts, fmt.Sprintf("user %s is both added and deleted", u))
+		}
+	}
+	for _, u := range p.UpdateUsers {
+		if _, ok := deletedUsers[u]; ok {
+			conflicts = append(conflicts, fmt.Sprintf("user %s is both updated and deleted", u))
+		}
+	}
+	for _, g := range p.AddGroups {
+		if _, ok := deletedGroups[g]; ok {
+			conflicts = append(conflicts, fmt.Sprintf("group %s is both added and deleted", g))
+		}
+	}
+
+	for _, g := range sortedKeys(p.AddMembers) {
+		_, groupDeleted := deletedGroups[g]
+		removed := set(p.RemoveMembers[g])
+		for _, u := range p.AddMembers[g] {
+			if groupDeleted {
+				conflicts = append(conflicts, fmt.Sprintf("user %s is added to group %s which is deleted", u, g))
+			}
+			if _, ok := deletedUsers[u]; ok {
+				conflicts = append(conflicts, fmt.Sprintf("user %s is added to group %s but is deleted", u, g))
+			}
+			if _, ok := removed[u]; ok {
+				conflicts = append(conflicts, fmt.Sprintf("user %s is both added to and removed from group %s", u, g))
+			}
+		}
+	}
+
+	return conflicts
+}
+
+// operations returns the operations of the plan, one line each and sorted
+func (p *syncPlan) operations() []string {
+	var ops []string
+	for _, u := range p.AddUsers {
+		ops = append(ops, "add user "+u)
+	}
+	for _, u := range p.UpdateUsers {
+		ops = append(ops, "update user "+u)
+	}
+	for _, u := range p.DeleteUsers {
+		ops = append(ops, "delete user "+u)
+	}
+	for _, g := range p.AddGroups {
+		ops = append(ops, "add group "+g)
+	}
+	for _, g := range p.DeleteGroups {
+		ops = append(ops, "delete group "+g)
+	}
+	for g, users := range p.AddMembers {
+		for _, u := range users {
+			ops = append(ops, fmt.Sprintf("add member %s to group %s", u, g))
+		}
+	}
+	for g, users := range p.RemoveMembers {
+		for _, u := range users {
+			ops = append(ops, fmt.Sprintf("remove member %s from group %s", u, g))
+		}
+	}
+
+	sort.Strings(ops)
+	return ops
+}
+
+// planSummarySamples is the number of names listed per category of the plan summary
+const planSummarySamples = 3
+
+// summary writes a table of the plan to w, with the count and a few names
+// of each category of operations
+func (p *syncPlan) summary(w io.Writer) error {
+	members := func(m map[string][]string) []string {
+		var names []string
+		for g, users := range m {
+			for _, u := range users {
+				names = append(names, u+" in "+g)
+			}
+		}
+		return names
+	}
+
+	rows := []struct {
+		category string
+		names    []string
+	}{
+		{"users created", p.AddUsers},
+		{"users updated", p.UpdateUsers},
+		{"users deleted", p.DeleteUsers},
+		{"groups created", p.AddGroups},
+		{"groups deleted", p.DeleteGroups},
+		{"members added", members(p.AddMembers)},
+		{"members removed", members(p.RemoveMembers)},
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "OPERATION\tCOUNT\tSAMPLE")
+	for _, r := range rows {
+		names := append([]string{}, r.names...)
+		sort.Strings(names)
+		sample := names
+		if len(sample) > planSummarySamples {
+			sample = sample[:planSummarySamples]
+		}
+		line := strings.Join(sample, ", ")
+		if len(names) > len(sample) {
+			line += ", ..."
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", r.category, len(names), line)
+	}
+
+	return tw.Flush()
+}
+
+// diffPlans returns the operations planned by the new plan only, prefixed
+// with +, and by the old plan only, prefixed with -
+func diffPlans(oldPlan, newPlan *syncPlan) []string {
+	oldOps := make(map[string]struct{})
+	for _, op := range oldPlan.operations() {
+		oldOps[op] = struct{}{}
+	}
+	newOps := make(map[string]struct{})
+	for _, op := range newPlan.operations() {
+		newOps[op] = struct{}{}
+	}
+
+	var diff []string
+	for _, op := range newPlan.operations() {
+		if _, ok := oldOps[op]; !ok {
+			diff = append(diff, "+ "+op)
+		}
+	}
+	for _, op := range oldPlan.operations() {
+		if _, ok := newOps[op]; !ok {
+			diff = append(diff, "- "+op)
+		}
+	}
+
+	return diff
+}
+
+// readPlan reads a plan written with the output plan option
+func readPlan(path string) (*syncPlan, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan syncPlan
+	if err := json.Unmarshal(b, &plan); err != nil {
+		return nil, fmt.Errorf("plan %s: %w", path, err)
+	}
+
+	return &plan, nil
+}
+
+// DiffPlans writes to w the operations that differ between two plans saved
+// by earlier runs, it reads the files only and makes no api calls
+func DiffPlans(oldPath, newPath string, w io.Writer) error {
+	oldPlan, err := readPlan(oldPath)
+	if err != nil {
+		return err
+	}
+
+	newPlan, err := readPlan(newPath)
+	if err != nil {
+		return err
+	}
+
+	diff := diffPlans(oldPlan, newPlan)
+	if len(diff) == 0 {
+		_, err := fmt.Fprintln(w, "the plans have the same operations")
+		return err
+	}
+
+	for _, line := range diff {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseS3URI splits an s3://bucket/key uri into its bucket and key
+func parseS3URI(uri string) (string, string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", err
+	}
+
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Scheme != "s3" || u.Host == "" || key == "" {
+		return "", "", fmt.Errorf("invalid s3 uri %q, expected s3://bucket/key", uri)
+	}
+
+	return u.Host, key, nil
+}
+
+// writeJSONFile writes v as json to the file at path
+func writeJSONFile(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// putS3Object writes v as json to the object at the s3 uri
+func putS3Object(p objectPutter, uri string, v interface{}) error {
+	bucket, key, err := parseS3URI(uri)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = p.PutObject(&s3.PutObjectInput{
+		Bucket:      aws_sdk.String(bucket),
+		Key:         aws_sdk.String(key),
+		Body:        bytes.NewReader(b),
+		ContentType: aws_sdk.String("application/json"),
+	})
+
+	return err
+}
@@ -0,0 +1,234 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/awslabs/ssosync/internal/aws"
+	"github.com/awslabs/ssosync/internal/config"
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// fakePutter records the objects written to it
+type fakePutter struct {
+	objects map[string][]byte
+}
+
+func (f *fakePutter) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	b, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	if f.objects == nil {
+		f.objects = make(map[string][]byte)
+	}
+	f.objects[*input.Bucket+"/"+*input.Key] = b
+	return &s3.PutObjectOutput{}, nil
+}
+
+func Test_parseS3URI(t *testing.T) {
+	bucket, key, err := parseS3URI("s3://bucket/path/to/plan.json")
+	assert.NoError(t, err)
+	assert.Equal(t, "bucket", bucket)
+	assert.Equal(t, "path/to/plan.json", key)
+
+	for _, uri := range []string{"https://bucket/plan.json", "s3://bucket", "s3:///plan.json"} {
+		_, _, err := parseS3URI(uri)
+		assert.Error(t, err, uri)
+	}
+}
+
+func Test_writePlan(t *testing.T) {
+	plan := newSyncPlan(
+		[]*aws.User{aws.NewUser("name-1", "lastname-1", "user-1@email.com", true)},
+		nil,
+		[]*aws.User{aws.NewUser("name-2", "lastname-2", "user-2@email.com", true)},
+		[]*aws.Group{aws.NewGroup("group-1")},
+		nil,
+		map[string][]*admin.User{"group-1": {{PrimaryEmail: "user-4@email.com"}, {PrimaryEmail: "user-1@email.com"}}},
+		map[string][]*aws.User{"group-2": {aws.NewUser("name-3", "lastname-3", "user-3@email.com", true)}},
+	)
+
+	// nothing is written when no uri is configured
+	putter := &fakePutter{}
+	s := &syncGSuite{cfg: config.New(), output: putter}
+	assert.NoError(t, s.writePlan(plan))
+	assert.Len(t, putter.objects, 0)
+
+	s.cfg.PlanS3URI = "s3://bucket/plan.json"
+	assert.NoError(t, s.writePlan(plan))
+
+	var written syncPlan
+	assert.NoError(t, json.Unmarshal(putter.objects["bucket/plan.json"], &written))
+	assert.Equal(t, *plan, written)
+	assert.Equal(t, []string{"user-1@email.com"}, written.AddUsers)
+	assert.Equal(t, []string{"user-1@email.com", "user-4@email.com"}, written.AddMembers["group-1"])
+	assert.Equal(t, []string{"user-3@email.com"}, written.RemoveMembers["group-2"])
+}
+
+func Test_writePlanFile(t *testing.T) {
+	plan := newSyncPlan(nil, nil, nil, []*aws.Group{aws.NewGroup("group-1")}, nil, nil, nil)
+
+	cfg := config.New()
+	cfg.OutputPlan = filepath.Join(t.TempDir(), "plan.json")
+
+	// the file is written without an s3 client
+	s := &syncGSuite{cfg: cfg}
+	assert.NoError(t, s.writePlan(plan))
+
+	b, err := ioutil.ReadFile(cfg.OutputPlan)
+	assert.NoError(t, err)
+
+	var written syncPlan
+	assert.NoError(t, json.Unmarshal(b, &written))
+	assert.Equal(t, []string{"group-1"}, written.AddGroups)
+}
+
+func Test_writeReport(t *testing.T) {
+	putter := &fakePutter{}
+	cfg := config.New()
+	cfg.ReportS3URI = "s3://bucket/report.json"
+
+	s := &syncGSuite{cfg: cfg, output: putter}
+	s.addUnresolved("group@email.com", "missing@email.com", unresolvedMissingUser)
+	assert.NoError(t, s.writeReport())
+
+	var written syncReport
+	assert.NoError(t, json.Unmarshal(putter.objects["bucket/report.json"], &written))
+	assert.Equal(t, []unresolvedMember{{Email: "missing@email.com", Reason: unresolvedMissingUser}}, written.Unresolved["group@email.com"])
+}
+
+func Test_syncPlanConflicts(t *testing.T) {
+	user := func(name string) *aws.User { return aws.NewUser("name", "lastname", name, true) }
+
+	// a consistent plan has no conflicts
+	plan := newSyncPlan(
+		[]*aws.User{user("user-1@email.com")},
+		[]*aws.User{user("user-2@email.com")},
+		[]*aws.User{user("user-3@email.com")},
+		[]*aws.Group{aws.NewGroup("group-1")},
+		[]*aws.Group{aws.NewGroup("group-old")},
+		map[string][]*admin.User{"group-1": {{PrimaryEmail: "user-1@email.com"}}},
+		map[string][]*aws.User{"group-2": {user("user-2@email.com")}},
+	)
+	assert.Empty(t, plan.conflicts())
+
+	plan = newSyncPlan(
+		[]*aws.User{user("user-1@email.com")},
+		[]*aws.User{user("user-2@email.com")},
+		[]*aws.User{user("user-1@email.com"), user("user-2@email.com"), user("user-3@email.com")},
+		[]*aws.Group{aws.NewGroup("group-1")},
+		[]*aws.Group{aws.NewGroup("group-1")},
+		map[string][]*admin.User{
+			"group-1": {{PrimaryEmail: "user-4@email.com"}},
+			"group-2": {{PrimaryEmail: "user-3@email.com"}, {PrimaryEmail: "user-4@email.com"}},
+		},
+		map[string][]*aws.User{"group-2": {user("user-4@email.com")}},
+	)
+	assert.Equal(t, []string{
+		"user user-1@email.com is both added and deleted",
+		"user user-2@email.com is both updated and deleted",
+		"group group-1 is both added and deleted",
+		"user user-4@email.com is added to group group-1 which is deleted",
+		"user user-3@email.com is added to group group-2 but is deleted",
+		"user user-4@email.com is both added to and removed from group group-2",
+	}, plan.conflicts())
+}
+
+func Test_checkPlan(t *testing.T) {
+	plan := newSyncPlan(nil, nil, nil, []*aws.Group{aws.NewGroup("group-1")}, []*aws.Group{aws.NewGroup("group-1")}, nil, nil)
+
+	// conflicts are only logged by default
+	s := &syncGSuite{cfg: config.New()}
+	assert.NoError(t, s.checkPlan(plan))
+
+	s.cfg.FailOnPlanConflicts = true
+	assert.EqualError(t, s.checkPlan(plan), "sync plan has 1 conflicting operations, the first is: group group-1 is both added and deleted")
+}
+
+func Test_diffPlans(t *testing.T) {
+	oldPlan := &syncPlan{
+		UpdateUsers:   []string{"user-1@email.com"},
+		AddGroups:     []string{"group-1"},
+		RemoveMembers: map[string][]string{"group-2": {"user-2@email.com"}},
+	}
+	newPlan := &syncPlan{
+		UpdateUsers:   []string{"user-1@email.com"},
+		DeleteUsers:   []string{"user-3@email.com"},
+		DeleteGroups:  []string{"group-2"},
+		AddMembers:    map[string][]string{"group-1": {"user-1@email.com"}},
+		RemoveMembers: map[string][]string{"group-2": {"user-2@email.com"}},
+	}
+
+	assert.Equal(t, []string{
+		"+ add member user-1@email.com to group group-1",
+		"+ delete group group-2",
+		"+ delete user user-3@email.com",
+		"- add group group-1",
+	}, diffPlans(oldPlan, newPlan))
+	assert.Empty(t, diffPlans(newPlan, newPlan))
+}
+
+func TestDiffPlans(t *testing.T) {
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "old.json")
+	newPath := filepath.Join(dir, "new.json")
+	assert.NoError(t, ioutil.WriteFile(oldPath, []byte(`{"addUsers": ["user-1@email.com"], "deleteUsers": [], "addMembers": {}}`), 0644))
+	assert.NoError(t, ioutil.WriteFile(newPath, []byte(`{"addUsers": [], "deleteUsers": ["user-2@email.com"], "addMembers": {}}`), 0644))
+
+	var buf bytes.Buffer
+	assert.NoError(t, DiffPlans(oldPath, newPath, &buf))
+	assert.Equal(t, "+ delete user user-2@email.com\n- add user user-1@email.com\n", buf.String())
+
+	buf.Reset()
+	assert.NoError(t, DiffPlans(oldPath, oldPath, &buf))
+	assert.Equal(t, "the plans have the same operations\n", buf.String())
+
+	// a missing or broken plan fails the diff
+	assert.Error(t, DiffPlans(filepath.Join(dir, "missing.json"), newPath, &buf))
+	assert.NoError(t, ioutil.WriteFile(newPath, []byte(`not json`), 0644))
+	assert.Error(t, DiffPlans(oldPath, newPath, &buf))
+}
+
+func Test_syncPlanSummary(t *testing.T) {
+	plan := &syncPlan{
+		AddUsers:     []string{"user-4@email.com", "user-1@email.com", "user-3@email.com", "user-2@email.com"},
+		UpdateUsers:  []string{"user-5@email.com"},
+		DeleteGroups: []string{"group-old"},
+		AddMembers: map[string][]string{
+			"group-1": {"user-1@email.com"},
+			"group-2": {"user-2@email.com"},
+		},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, plan.summary(&buf))
+	assert.Equal(t, `OPERATION        COUNT  SAMPLE
+users created    4      user-1@email.com, user-2@email.com, user-3@email.com, ...
+users updated    1      user-5@email.com
+users deleted    0      
+groups created   0      
+groups deleted   1      group-old
+members added    2      user-1@email.com in group-1, user-2@email.com in group-2
+members removed  0      
+`, buf.String())
+}
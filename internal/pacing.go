@@ -0,0 +1,85 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/awslabs/ssosync/internal/config"
+)
+
+// deletePacer spaces out the user and group deletions and the member
+// removals by a minimum interval, so a burst of them doesn't get throttled
+type deletePacer struct {
+	interval time.Duration
+	now      func() time.Time
+	sleep    func(context.Context, time.Duration) error
+
+	mu sync.Mutex
+	// next is the earliest time of the next destructive call
+	next time.Time
+}
+
+// newDeletePacer returns the pacer of the config, nil when it's disabled
+func newDeletePacer(cfg *config.Config) *deletePacer {
+	if cfg.DeleteInterval <= 0 {
+		return nil
+	}
+
+	return &deletePacer{interval: cfg.DeleteInterval, now: time.Now, sleep: sleepContext}
+}
+
+// wait blocks until the interval has passed since the previous destructive
+// call or the context is done. The slot is taken before waiting, so the
+// concurrent deletions are spaced out as well.
+func (p *deletePacer) wait(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	now := p.now()
+	at := p.next
+	if at.Before(now) {
+		at = now
+	}
+	p.next = at.Add(p.interval)
+	p.mu.Unlock()
+
+	return p.sleep(ctx, at.Sub(now))
+}
+
+// sleepContext blocks for the duration or until the context is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// paceDeletion waits for the turn of the next destructive call
+func (s *syncGSuite) paceDeletion() error {
+	return s.pacer.wait(s.context())
+}
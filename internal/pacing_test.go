@@ -0,0 +1,120 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/awslabs/ssosync/internal/config"
+
+	aws_sdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/identitystore"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is the time of a pacer, it only moves when the pacer sleeps
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) pace(p *deletePacer) {
+	p.now = func() time.Time { return c.now }
+	p.sleep = func(ctx context.Context, d time.Duration) error {
+		c.sleeps = append(c.sleeps, d)
+		c.now = c.now.Add(d)
+		return nil
+	}
+}
+
+func Test_deletePacer(t *testing.T) {
+	cfg := config.New()
+	assert.Nil(t, newDeletePacer(cfg))
+
+	// a nil pacer doesn't wait
+	var none *deletePacer
+	assert.NoError(t, none.wait(context.Background()))
+
+	cfg.DeleteInterval = time.Second
+	p := newDeletePacer(cfg)
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	clock.pace(p)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, p.wait(context.Background()))
+	}
+	assert.Equal(t, []time.Duration{0, time.Second, time.Second}, clock.sleeps)
+
+	// the interval is counted from the previous call
+	clock.sleeps = nil
+	clock.now = clock.now.Add(1500 * time.Millisecond)
+	assert.NoError(t, p.wait(context.Background()))
+	clock.now = clock.now.Add(200 * time.Millisecond)
+	assert.NoError(t, p.wait(context.Background()))
+	assert.Equal(t, []time.Duration{0, 800 * time.Millisecond}, clock.sleeps)
+
+	// the wait stops with the context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, sleepContext(ctx, time.Hour))
+}
+
+func Test_SyncGroupsUsersDeleteInterval(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := config.New()
+	cfg.IdentityStoreID = "test-identity-store-id"
+	cfg.DeleteInterval = time.Second
+
+	s, mockIdentityStoreClient, _ := newTestSyncGroupsUsers(ctrl, cfg)
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	clock.pace(s.(*syncGSuite).pacer)
+
+	var deletes []time.Time
+	mockIdentityStoreClient.EXPECT().DeleteUserWithContext(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(aws_sdk.Context, *identitystore.DeleteUserInput, ...request.Option) (*identitystore.DeleteUserOutput, error) {
+			deletes = append(deletes, clock.now)
+			return &identitystore.DeleteUserOutput{}, nil
+		})
+	mockIdentityStoreClient.EXPECT().CreateGroupWithContext(gomock.Any(), gomock.Any()).Return(&identitystore.CreateGroupOutput{GroupId: aws_sdk.String("group-1")}, nil)
+	mockIdentityStoreClient.EXPECT().CreateGroupMembership(gomock.Any()).Times(3).Return(&identitystore.CreateGroupMembershipOutput{}, nil)
+	mockIdentityStoreClient.EXPECT().IsMemberInGroups(gomock.Any()).Return(&identitystore.IsMemberInGroupsOutput{
+		Results: []*identitystore.GroupMembershipExistenceResult{{MembershipExists: aws_sdk.Bool(false)}},
+	}, nil)
+	mockIdentityStoreClient.EXPECT().GetGroupMembershipId(gomock.Any()).Return(&identitystore.GetGroupMembershipIdOutput{MembershipId: aws_sdk.String("membership-1")}, nil)
+	mockIdentityStoreClient.EXPECT().DeleteGroupMembership(gomock.Any()).DoAndReturn(
+		func(*identitystore.DeleteGroupMembershipInput) (*identitystore.DeleteGroupMembershipOutput, error) {
+			deletes = append(deletes, clock.now)
+			return &identitystore.DeleteGroupMembershipOutput{}, nil
+		})
+	mockIdentityStoreClient.EXPECT().DeleteGroupWithContext(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(aws_sdk.Context, *identitystore.DeleteGroupInput, ...request.Option) (*identitystore.DeleteGroupOutput, error) {
+			deletes = append(deletes, clock.now)
+			return &identitystore.DeleteGroupOutput{}, nil
+		})
+
+	assert.NoError(t, s.SyncGroupsUsers("*", ""))
+
+	// the user deletion, member removal and group deletion are a second apart
+	assert.Len(t, deletes, 3)
+	for i := 1; i < len(deletes); i++ {
+		assert.Equal(t, time.Second, deletes[i].Sub(deletes[i-1]))
+	}
+}
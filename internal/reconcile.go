@@ -0,0 +1,156 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/awslabs/ssosync/internal/aws"
+	"github.com/awslabs/ssosync/internal/config"
+
+	log "github.com/sirupsen/logrus"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// externalIDRepair is an aws user whose external id isn't the id of the
+// google user of the same email
+type externalIDRepair struct {
+	User       *aws.User
+	ExternalID string
+}
+
+// getExternalIDRepairs matches the scim users with the google users by
+// email, case insensitive, and returns the ones whose external id is missing
+// or another one, sorted by username
+func getExternalIDRepairs(scimUsers []*aws.User, googleUsers []*admin.User) []externalIDRepair {
+	googleIDs := make(map[string]string, len(googleUsers))
+	for _, u := range googleUsers {
+		if u.Id != "" {
+			googleIDs[strings.ToLower(u.PrimaryEmail)] = u.Id
+		}
+	}
+
+	repairs := make([]externalIDRepair, 0)
+	for _, u := range scimUsers {
+		id, found := googleIDs[strings.ToLower(u.Username)]
+		if !found || u.ExternalID == id {
+			continue
+		}
+		repairs = append(repairs, externalIDRepair{User: u, ExternalID: id})
+	}
+
+	sort.Slice(repairs, func(i, j int) bool {
+		return repairs[i].User.Username < repairs[j].User.Username
+	})
+	return repairs
+}
+
+// reconcileExternalIDs sets the external id of every aws user with a google
+// user of the same email to the id of that google user. Only the external id
+// is patched, the other attributes are left for the sync.
+func (s *syncGSuite) reconcileExternalIDs() error {
+	log.Info("get google users")
+	googleUsers, err := s.google.GetUsers("*")
+	if err != nil {
+		return err
+	}
+
+	log.Info("get existing aws users")
+	awsUsers, err := s.GetUsers()
+	if err != nil {
+		return err
+	}
+
+	googleEmails := make(map[string]struct{}, len(googleUsers))
+	for _, u := range googleUsers {
+		googleEmails[strings.ToLower(u.PrimaryEmail)] = struct{}{}
+	}
+
+	emails := make([]string, 0)
+	for _, u := range awsUsers {
+		if _, found := googleEmails[strings.ToLower(u.Username)]; !found || s.ignoreUser(u.Username) {
+			continue
+		}
+		emails = append(emails, u.Username)
+	}
+
+	// the identity store doesn't return the scim external id, so the
+	// matched users are looked up through scim, in batches
+	found, err := s.aws.FindUsersByEmails(s.context(), emails)
+	if err != nil {
+		log.Error("error getting the external ids of users")
+		return err
+	}
+	scimUsers := make([]*aws.User, 0, len(found))
+	for _, email := range emails {
+		if u, ok := found[email]; ok {
+			scimUsers = append(scimUsers, u)
+		}
+	}
+
+	repairs := getExternalIDRepairs(scimUsers, googleUsers)
+	log.WithFields(log.Fields{"matched": len(scimUsers), "repairs": len(repairs)}).Info("reconciling external ids")
+	for _, r := range repairs {
+		ll := log.WithFields(log.Fields{"user": r.User.Username, "from": r.User.ExternalID, "to": r.ExternalID})
+		if s.cfg.ReconcileDryRun {
+			ll.Info("would repair external id")
+			continue
+		}
+
+		ll.WithField("reasons", []updateReason{updateReasonExternalID}).Info("repairing external id")
+		if err := s.aws.UpdateUserExternalID(s.context(), r.User, r.ExternalID); err != nil {
+			return err
+		}
+		s.record(auditRecord{Operation: auditUpdateUser, User: r.User.Username, UserID: r.User.ID, ExternalID: r.ExternalID, Reasons: []updateReason{updateReasonExternalID}})
+	}
+
+	return nil
+}
+
+// ReconcileExternalIDs repairs the external ids of the existing aws users,
+// such as the ones created by older versions that didn't set them, without
+// syncing anything else
+func ReconcileExternalIDs(ctx context.Context, cfg *config.Config) error {
+	log.Info("Reconciling the external ids of AWS users with Google Workspace")
+
+	if err := validateConfig(cfg); err != nil {
+		return err
+	}
+
+	conn, err := connect(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	if err := checkIdentityStore(conn.identityStore, cfg); err != nil {
+		return err
+	}
+
+	c := New(cfg, conn.scim, conn.google, conn.identityStore)
+	c.(*syncGSuite).ctx = ctx
+
+	if cfg.AuditLogPath != "" {
+		f, err := os.OpenFile(cfg.AuditLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		c.(*syncGSuite).audit = newAuditLog(f)
+	}
+
+	return c.(*syncGSuite).reconcileExternalIDs()
+}
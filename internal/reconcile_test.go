@@ -0,0 +1,108 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"testing"
+
+	aws_sdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/identitystore"
+	"github.com/awslabs/ssosync/internal/aws"
+	"github.com/awslabs/ssosync/internal/config"
+	"github.com/awslabs/ssosync/internal/mocks"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+func Test_getExternalIDRepairs(t *testing.T) {
+	scimUsers := []*aws.User{
+		{ID: "a-user-3", Username: "user-3@email.com", ExternalID: "g-old"},
+		{ID: "a-user-1", Username: "User-1@email.com"},
+		{ID: "a-user-2", Username: "user-2@email.com", ExternalID: "g-user-2"},
+		{ID: "a-manual", Username: "manual@email.com"},
+	}
+	googleUsers := []*admin.User{
+		{Id: "g-user-1", PrimaryEmail: "user-1@email.com"},
+		{Id: "g-user-2", PrimaryEmail: "user-2@email.com"},
+		{Id: "g-user-3", PrimaryEmail: "user-3@email.com"},
+		// without an id there is nothing to set
+		{PrimaryEmail: "manual@email.com"},
+	}
+
+	// the emails are matched case insensitive, the users already set and
+	// the ones without a google user are left alone
+	assert.Equal(t, []externalIDRepair{
+		{User: scimUsers[1], ExternalID: "g-user-1"},
+		{User: scimUsers[0], ExternalID: "g-user-3"},
+	}, getExternalIDRepairs(scimUsers, googleUsers))
+
+	assert.Empty(t, getExternalIDRepairs(scimUsers, nil))
+}
+
+func Test_reconcileExternalIDs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := config.New()
+	cfg.IdentityStoreID = "test-identity-store-id"
+	cfg.IgnoreUsers = []string{"user-4@email.com"}
+
+	google := &fakeGoogleClient{
+		users: []*admin.User{
+			{Id: "g-user-1", PrimaryEmail: "user-1@email.com", Name: &admin.UserName{}},
+			{Id: "g-user-2", PrimaryEmail: "user-2@email.com", Name: &admin.UserName{}},
+			{Id: "g-user-4", PrimaryEmail: "user-4@email.com", Name: &admin.UserName{}},
+		},
+	}
+	awsClient := &fakeAWSClient{
+		users: map[string]*aws.User{
+			"user-1@email.com": {ID: "a-user-1", Username: "user-1@email.com"},
+			"user-2@email.com": {ID: "a-user-2", Username: "user-2@email.com", ExternalID: "g-user-2"},
+			"user-3@email.com": {ID: "a-user-3", Username: "user-3@email.com"},
+			"user-4@email.com": {ID: "a-user-4", Username: "user-4@email.com"},
+		},
+	}
+	sdkUsers := make([]*identitystore.User, 0)
+	for _, email := range []string{"user-1@email.com", "user-2@email.com", "user-3@email.com", "user-4@email.com"} {
+		u := awsClient.users[email]
+		sdkUsers = append(sdkUsers, &identitystore.User{UserId: aws_sdk.String(u.ID), UserName: aws_sdk.String(u.Username)})
+	}
+
+	mockIdentityStoreClient := mocks.NewMockIdentityStoreAPI(ctrl)
+	mockIdentityStoreClient.EXPECT().ListUsersPages(gomock.Any(), gomock.Any()).Times(2).DoAndReturn(
+		func(input *identitystore.ListUsersInput, fn func(*identitystore.ListUsersOutput, bool) bool) error {
+			fn(&identitystore.ListUsersOutput{Users: sdkUsers}, true)
+			return nil
+		})
+
+	s := New(cfg, awsClient, google, mockIdentityStoreClient).(*syncGSuite)
+	assert.NoError(t, s.reconcileExternalIDs())
+
+	// only the external id of the matched user without one is patched, the
+	// users missing from google or ignored aren't looked up
+	assert.Equal(t, map[string]string{"a-user-1": "g-user-1"}, awsClient.externalIDs)
+	assert.Empty(t, awsClient.updated)
+	assert.Equal(t, [][]string{{"user-1@email.com", "user-2@email.com"}}, awsClient.batches)
+	assert.Empty(t, awsClient.lookups)
+
+	// a dry run only logs the repairs
+	cfg.ReconcileDryRun = true
+	awsClient.externalIDs = nil
+	awsClient.users["user-1@email.com"].ExternalID = "g-stale"
+	assert.NoError(t, s.reconcileExternalIDs())
+	assert.Empty(t, awsClient.externalIDs)
+	assert.Equal(t, "g-stale", awsClient.users["user-1@email.com"].ExternalID)
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SyncStats counts the changes made in AWS by a sync
+type SyncStats struct {
+	UsersCreated       int
+	UsersUpdated       int
+	UsersDeleted       int
+	GroupsCreated      int
+	GroupsDeleted      int
+	MembershipsAdded   int
+	MembershipsRemoved int
+
+	// AssignmentsAffected counts the permission set assignments lost through
+	// the deletions, it's only set when their impact is reported
+	AssignmentsAffected int
+
+	// AWSOnlyUsers and AWSOnlyGroups count the aws users and groups missing
+	// from google, they're only set when the drift is reported
+	AWSOnlyUsers  int
+	AWSOnlyGroups int
+}
+
+// String summarises the stats in a single line
+func (s SyncStats) String() string {
+	summary := fmt.Sprintf("users created: %d, updated: %d, deleted: %d; groups created: %d, deleted: %d; memberships added: %d, removed: %d",
+		s.UsersCreated, s.UsersUpdated, s.UsersDeleted,
+		s.GroupsCreated, s.GroupsDeleted,
+		s.MembershipsAdded, s.MembershipsRemoved)
+	if s.AssignmentsAffected > 0 {
+		summary += fmt.Sprintf("; permission set assignments affected: %d", s.AssignmentsAffected)
+	}
+	if s.AWSOnlyUsers > 0 || s.AWSOnlyGroups > 0 {
+		summary += fmt.Sprintf("; aws only users: %d, groups: %d", s.AWSOnlyUsers, s.AWSOnlyGroups)
+	}
+	return summary
+}
+
+// Fields returns the stats as log fields
+func (s SyncStats) Fields() log.Fields {
+	return log.Fields{
+		"users_created":        s.UsersCreated,
+		"users_updated":        s.UsersUpdated,
+		"users_deleted":        s.UsersDeleted,
+		"groups_created":       s.GroupsCreated,
+		"groups_deleted":       s.GroupsDeleted,
+		"memberships_added":    s.MembershipsAdded,
+		"memberships_removed":  s.MembershipsRemoved,
+		"assignments_affected": s.AssignmentsAffected,
+	}
+}
+
+// MetricsNamespace is the CloudWatch namespace of the metrics of a run
+const MetricsNamespace = "SSOSync"
+
+// emfMetric is a metric declared in the CloudWatch Embedded Metric Format
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+// EMF returns the stats and the duration of the run as a CloudWatch Embedded
+// Metric Format log line, dimensioned by the sync method. Written to the
+// Lambda logs, it's turned into metrics without any api call.
+func (s SyncStats) EMF(syncMethod string, duration time.Duration, now time.Time) ([]byte, error) {
+	counts := []struct {
+		name  string
+		value int
+	}{
+		{"UsersCreated", s.UsersCreated},
+		{"UsersUpdated", s.UsersUpdated},
+		{"UsersDeleted", s.UsersDeleted},
+		{"GroupsCreated", s.GroupsCreated},
+		{"GroupsDeleted", s.GroupsDeleted},
+		{"MembershipsAdded", s.MembershipsAdded},
+		{"MembershipsRemoved", s.MembershipsRemoved},
+	}
+
+	line := map[string]interface{}{"SyncMethod": syncMethod}
+	metrics := make([]emfMetric, 0, len(counts)+1)
+	for _, c := range counts {
+		line[c.name] = c.value
+		metrics = append(metrics, emfMetric{Name: c.name, Unit: "Count"})
+	}
+	line["Duration"] = duration.Milliseconds()
+	metrics = append(metrics, emfMetric{Name: "Duration", Unit: "Milliseconds"})
+
+	line["_aws"] = map[string]interface{}{
+		"Timestamp": now.UnixNano() / int64(time.Millisecond),
+		"CloudWatchMetrics": []map[string]interface{}{{
+			"Namespace":  MetricsNamespace,
+			"Dimensions": [][]string{{"SyncMethod"}},
+			"Metrics":    metrics,
+		}},
+	}
+
+	return json.Marshal(line)
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncStats_EMF(t *testing.T) {
+	stats := SyncStats{UsersCreated: 2, UsersDeleted: 1, GroupsCreated: 1, MembershipsAdded: 3}
+
+	b, err := stats.EMF("groups", 1500*time.Millisecond, time.Date(2022, 9, 1, 10, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+
+	var line map[string]interface{}
+	assert.NoError(t, json.Unmarshal(b, &line))
+	assert.Equal(t, "groups", line["SyncMethod"])
+	assert.Equal(t, float64(2), line["UsersCreated"])
+	assert.Equal(t, float64(0), line["UsersUpdated"])
+	assert.Equal(t, float64(1500), line["Duration"])
+
+	assert.JSONEq(t, `{
+		"Timestamp": 1662026400000,
+		"CloudWatchMetrics": [{
+			"Namespace": "SSOSync",
+			"Dimensions": [["SyncMethod"]],
+			"Metrics": [
+				{"Name": "UsersCreated", "Unit": "Count"},
+				{"Name": "UsersUpdated", "Unit": "Count"},
+				{"Name": "UsersDeleted", "Unit": "Count"},
+				{"Name": "GroupsCreated", "Unit": "Count"},
+				{"Name": "GroupsDeleted", "Unit": "Count"},
+				{"Name": "MembershipsAdded", "Unit": "Count"},
+				{"Name": "MembershipsRemoved", "Unit": "Count"},
+				{"Name": "Duration", "Unit": "Milliseconds"}
+			]
+		}]
+	}`, mustMarshal(t, line["_aws"]))
+}
+
+func mustMarshal(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	assert.NoError(t, err)
+	return string(b)
+}
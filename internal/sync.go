@@ -17,18 +17,35 @@ package internal
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/awslabs/ssosync/internal/aws"
 	"github.com/awslabs/ssosync/internal/config"
 	"github.com/awslabs/ssosync/internal/google"
-	"github.com/hashicorp/go-retryablehttp"
+	"github.com/awslabs/ssosync/internal/localfile"
+	"github.com/awslabs/ssosync/internal/okta"
 
 	aws_sdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	aws_sdk_sess "github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/identitystore"
 	"github.com/aws/aws-sdk-go/service/identitystore/identitystoreiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssoadmin"
+	"github.com/aws/aws-sdk-go/service/ssoadmin/ssoadminiface"
 	log "github.com/sirupsen/logrus"
 	admin "google.golang.org/api/admin/directory/v1"
 )
@@ -38,6 +55,8 @@ type SyncGSuite interface {
 	SyncUsers(string) error
 	SyncGroups(string) error
 	SyncGroupsUsers(string, string) error
+	SyncGroupMetadata(string) error
+	Stats() SyncStats
 }
 
 // SyncGSuite is an object type that will synchronize real users and groups
@@ -47,9 +66,92 @@ type syncGSuite struct {
 	cfg                 *config.Config
 	identityStoreClient identitystoreiface.IdentityStoreAPI
 
+	// ctx is the context of the sync, the scim requests stop with it
+	ctx context.Context
+
 	users map[string]*aws.User
+
+	// mu guards unresolved, which is written by the concurrent member fetches
+	mu         sync.Mutex
+	unresolved map[string][]unresolvedMember
+
+	// output writes the plan and report, it's only set when they are requested
+	output objectPutter
+
+	// ssoAdmin lists the permission set assignments, it's only set when their impact is reported
+	ssoAdmin ssoadminiface.SSOAdminAPI
+	impact   []accessImpact
+
+	// drift lists the aws users and groups missing from google, it's only set when reported
+	drift []driftResource
+
+	// pacer spaces out the destructive calls, it's only set when an interval is configured
+	pacer *deletePacer
+
+	// summaryOut is where the plan summary is printed, stdout when not set
+	summaryOut io.Writer
+
+	// audit records each change made in AWS, it's only set when an audit log is requested
+	audit *auditLog
+
+	// cacheStore keeps the correlation cache, it's only set when the cache is requested
+	cacheStore objectStore
+	cache      *correlationCache
+	cacheHit   bool
+
+	// checkpointer keeps the hashes of the applied users and groups, it's only set when incremental sync is requested
+	checkpointer Checkpointer
+	checkpoint   *Checkpoint
+	applied      *Checkpoint
+
+	// patterns are the compiled group patterns of the config, see groupPatterns
+	patternsOnce sync.Once
+	patterns     *groupPatterns
+
+	// transitional holds the aws names of the google groups being deleted
+	// that are kept, they are never created
+	transitional map[string]struct{}
+
+	// undersized holds the aws names of the google groups with fewer members
+	// than MinGroupMembers, they are neither created, changed nor deleted
+	undersized map[string]struct{}
+
+	// unconfirmed holds the aws names of the google groups whose members
+	// could not be fetched, their aws members are kept as users
+	unconfirmed map[string]struct{}
+
+	// suspended holds, by aws group name, the emails of the suspended google
+	// members that keep their aws membership but are never added
+	suspended map[string]map[string]struct{}
+
+	stats SyncStats
 }
 
+// unresolvedMember is a google group member that could not be resolved to a user
+type unresolvedMember struct {
+	Email  string `json:"email"`
+	Reason string `json:"reason"`
+}
+
+const (
+	// unresolvedExternal is used for external members, they don't have users to sync
+	unresolvedExternal = "external member"
+	// unresolvedIgnored is used for members filtered out by --ignore-users
+	unresolvedIgnored = "ignored user"
+	// unresolvedNotIncluded is used for members left out by --include-users
+	unresolvedNotIncluded = "user not included"
+	// unresolvedMissingUser is used for members without a matching user
+	unresolvedMissingUser = "user not found"
+	// unresolvedMissingGroup is used for nested groups that are not known
+	unresolvedMissingGroup = "nested group not found"
+	// unresolvedNestedGroup is used for nested groups whose members are not synced
+	unresolvedNestedGroup = "nested group not expanded"
+	// unresolvedFetchFailed is used when the members of a group could not be fetched
+	unresolvedFetchFailed = "members could not be fetched"
+	// unresolvedGroupDeleting is used when the group is listed but is being deleted
+	unresolvedGroupDeleting = "group being deleted"
+)
+
 // New will create a new SyncGSuite object
 func New(cfg *config.Config, a aws.Client, g google.Client, ids identitystoreiface.IdentityStoreAPI) SyncGSuite {
 	return &syncGSuite{
@@ -58,6 +160,90 @@ func New(cfg *config.Config, a aws.Client, g google.Client, ids identitystoreifa
 		cfg:                 cfg,
 		identityStoreClient: ids,
 		users:               make(map[string]*aws.User),
+		unresolved:          make(map[string][]unresolvedMember),
+		pacer:               newDeletePacer(cfg),
+	}
+}
+
+// context returns the context of the sync, the background context when the
+// sync was not started with one
+func (s *syncGSuite) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// summaryOutput returns where the plan summary is printed
+func (s *syncGSuite) summaryOutput() io.Writer {
+	if s.summaryOut == nil {
+		return os.Stdout
+	}
+	return s.summaryOut
+}
+
+// Stats returns the changes made by the syncs run so far
+func (s *syncGSuite) Stats() SyncStats {
+	return s.stats
+}
+
+// addUnresolved records a member of the group that could not be resolved to a user
+func (s *syncGSuite) addUnresolved(group string, email string, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.unresolved == nil {
+		s.unresolved = make(map[string][]unresolvedMember)
+	}
+	s.unresolved[group] = append(s.unresolved[group], unresolvedMember{Email: email, Reason: reason})
+}
+
+// writePlan writes the plan to a file and to s3 when requested
+func (s *syncGSuite) writePlan(plan *syncPlan) error {
+	if s.cfg.OutputPlan != "" {
+		log.WithField("path", s.cfg.OutputPlan).Info("writing plan")
+		if err := writeJSONFile(s.cfg.OutputPlan, plan); err != nil {
+			return err
+		}
+	}
+
+	if s.cfg.PlanS3URI == "" || s.output == nil {
+		return nil
+	}
+
+	log.WithField("uri", s.cfg.PlanS3URI).Info("writing plan")
+	return putS3Object(s.output, s.cfg.PlanS3URI, plan)
+}
+
+// writeReport writes the report of the run to s3 when requested
+func (s *syncGSuite) writeReport() error {
+	if s.cfg.ReportS3URI == "" || s.output == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	log.WithField("uri", s.cfg.ReportS3URI).Info("writing report")
+	return putS3Object(s.output, s.cfg.ReportS3URI, &syncReport{Unresolved: s.unresolved, Impact: s.impact, Drift: s.drift})
+}
+
+// reportUnresolved logs the unresolved members of each group when enabled
+func (s *syncGSuite) reportUnresolved() {
+	if !s.cfg.ReportUnresolvedMembers {
+		return
+	}
+
+	groups := make([]string, 0, len(s.unresolved))
+	for group := range s.unresolved {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	for _, group := range groups {
+		for _, m := range s.unresolved[group] {
+			log.WithFields(log.Fields{"group": group, "member": m.Email, "reason": m.Reason}).Warn("unresolved group member")
+		}
 	}
 }
 
@@ -80,32 +266,8 @@ func (s *syncGSuite) SyncUsers(query string) error {
 		return err
 	}
 
-	for _, u := range deletedUsers {
-		log.WithFields(log.Fields{
-			"email": u.PrimaryEmail,
-		}).Info("deleting google user")
-
-		uu, err := s.aws.FindUserByEmail(u.PrimaryEmail)
-		if err != aws.ErrUserNotFound && err != nil {
-			log.WithFields(log.Fields{
-				"email": u.PrimaryEmail,
-			}).Warn("Error deleting google user")
-			return err
-		}
-
-		if err == aws.ErrUserNotFound {
-			log.WithFields(log.Fields{
-				"email": u.PrimaryEmail,
-			}).Debug("User already deleted")
-			continue
-		}
-		_, err = s.identityStoreClient.DeleteUser(&identitystore.DeleteUserInput{IdentityStoreId: &s.cfg.IdentityStoreID, UserId: &uu.ID})
-		if err != nil {
-			log.WithFields(log.Fields{
-				"email": u.PrimaryEmail,
-			}).Warn("Error deleting user")
-			return err
-		}
+	if err := s.deleteUsers(deletedUsers); err != nil {
+		return err
 	}
 
 	log.Debug("get active google users")
@@ -114,6 +276,27 @@ func (s *syncGSuite) SyncUsers(query string) error {
 		return err
 	}
 
+	// the unchanged users are still looked up, the groups sync needs them
+	var changed map[string]struct{}
+	if s.cfg.IncrementalSince > 0 {
+		since := time.Now().Add(-s.cfg.IncrementalSince)
+		changed = changedSince(googleUsers, since)
+		log.WithFields(log.Fields{"since": since, "users": len(changed)}).Info("only syncing the recently changed google users")
+	}
+
+	// find the aws users in batches, rather than one request per user
+	emails := make([]string, 0, len(googleUsers))
+	for _, u := range googleUsers {
+		if !s.ignoreUser(u.PrimaryEmail) {
+			emails = append(emails, u.PrimaryEmail)
+		}
+	}
+	found, err := s.aws.FindUsersByEmails(s.context(), emails)
+	if err != nil {
+		log.WithField("error", err).Warn("unable to find the aws users in batches, finding them one by one")
+		found = nil
+	}
+
 	for _, u := range googleUsers {
 		if s.ignoreUser(u.PrimaryEmail) {
 			continue
@@ -123,36 +306,58 @@ func (s *syncGSuite) SyncUsers(query string) error {
 			"email": u.PrimaryEmail,
 		})
 
-		ll.Debug("finding user")
-		uu, _ := s.aws.FindUserByEmail(u.PrimaryEmail)
+		var uu *aws.User
+		if found != nil {
+			uu = found[u.PrimaryEmail]
+		} else {
+			ll.Debug("finding user")
+			uu, _ = s.aws.FindUserByEmail(s.context(), u.PrimaryEmail)
+		}
+		if _, found := changed[u.PrimaryEmail]; changed != nil && !found {
+			if uu != nil {
+				s.users[uu.Username] = uu
+			}
+			continue
+		}
+
 		if uu != nil {
 			s.users[uu.Username] = uu
 			// Update the user when suspended state is changed
-			if uu.Active == u.Suspended {
-				log.Debug("Mismatch active/suspended, updating user")
-				// create new user object and update the user
-				_, err := s.aws.UpdateUser(aws.UpdateUser(
-					uu.ID,
-					u.Name.GivenName,
-					u.Name.FamilyName,
-					u.PrimaryEmail,
-					!u.Suspended))
+			if attributeAllowed(s.cfg.SyncAttributes, aws.AttributeActive) && uu.Active == u.Suspended {
+				ll.WithField("reasons", []updateReason{updateReasonStatus}).Info("Mismatch active/suspended, updating user")
+				// the update replaces the user, so it's sent with all the
+				// mapped attributes and keeps the ones that aren't mapped
+				updated := awsUserFromGoogle(u, newUserMapping(s.cfg))
+				updated.ID = uu.ID
+				if updated.ExternalID == "" {
+					updated.ExternalID = uu.ExternalID
+				}
+				updated.Enterprise = uu.Enterprise
+				_, err := s.aws.UpdateUser(s.context(), updated)
 				if err != nil {
 					return err
 				}
+				s.stats.UsersUpdated++
+				s.record(auditRecord{Operation: auditUpdateUser, User: uu.Username, UserID: uu.ID, ExternalID: updated.ExternalID, Reasons: []updateReason{updateReasonStatus}})
+			}
+			continue
+		}
+
+		nu := awsUserFromGoogle(u, newUserMapping(s.cfg))
+		if ok, err := s.validUser(nu); !ok {
+			if err != nil {
+				return err
 			}
 			continue
 		}
 
 		ll.Info("creating user")
-		uu, err := s.aws.CreateUser(aws.NewUser(
-			u.Name.GivenName,
-			u.Name.FamilyName,
-			u.PrimaryEmail,
-			!u.Suspended))
+		uu, err := s.createUser(nu)
 		if err != nil {
 			return err
 		}
+		s.stats.UsersCreated++
+		s.record(auditRecord{Operation: auditCreateUser, User: uu.Username, UserID: uu.ID, ExternalID: nu.ExternalID})
 
 		s.users[uu.Username] = uu
 	}
@@ -160,6 +365,146 @@ func (s *syncGSuite) SyncUsers(query string) error {
 	return nil
 }
 
+// changedSince returns the emails of the users created or logged in since the
+// time. The directory api can't search users by their update time, so this
+// only saves the creates and updates of the other users, and changes that
+// come without a login, such as a suspension or an admin renaming the user,
+// are missed until the next full sync. Deletions don't depend on it, they
+// come from the deleted users.
+func changedSince(users []*admin.User, since time.Time) map[string]struct{} {
+	changed := make(map[string]struct{})
+	for _, u := range users {
+		for _, v := range []string{u.CreationTime, u.LastLoginTime} {
+			t, err := time.Parse(time.RFC3339, v)
+			if err == nil && !t.Before(since) {
+				changed[u.PrimaryEmail] = struct{}{}
+				break
+			}
+		}
+	}
+	return changed
+}
+
+// deleteUsers deletes the aws users of the deleted google users, by up to
+// UserDeleteConcurrency workers. The first failure stops the remaining
+// deletions, unless configured to continue on them, they are then all
+// returned at the end.
+func (s *syncGSuite) deleteUsers(deletedUsers []*admin.User) error {
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+	forEachConcurrently(len(deletedUsers), s.cfg.UserDeleteConcurrency, func(i int) {
+		mu.Lock()
+		stop := len(errs) > 0 && !s.cfg.ContinueOnUserDeleteError
+		mu.Unlock()
+		if stop {
+			return
+		}
+
+		uu, err := s.deleteUser(deletedUsers[i])
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("user %s: %w", deletedUsers[i].PrimaryEmail, err))
+			return
+		}
+		switch {
+		case uu == nil:
+		case s.cfg.UserDeleteStrategy == config.UserDeleteStrategyDeactivate:
+			s.stats.UsersUpdated++
+			s.record(auditRecord{Operation: auditUpdateUser, User: uu.Username, UserID: uu.ID, ExternalID: uu.ExternalID, Reasons: []updateReason{updateReasonDeleted}})
+		default:
+			s.stats.UsersDeleted++
+			s.record(auditRecord{Operation: auditDeleteUser, User: uu.Username, UserID: uu.ID, ExternalID: uu.ExternalID})
+		}
+	})
+
+	switch {
+	case len(errs) == 0:
+		return nil
+	case !s.cfg.ContinueOnUserDeleteError:
+		return errors.Unwrap(errs[0])
+	}
+
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Errorf("%d user deletions failed: %s", len(errs), strings.Join(msgs, "; "))
+}
+
+// unmanagedUserAction returns what is done with the aws users missing from
+// google, the users to delete are disabled instead when they are deactivated
+func (s *syncGSuite) unmanagedUserAction() string {
+	if s.cfg.UnmanagedUserAction == config.UnmanagedUserActionDelete && s.cfg.UserDeleteStrategy == config.UserDeleteStrategyDeactivate {
+		return config.UnmanagedUserActionDisable
+	}
+	return s.cfg.UnmanagedUserAction
+}
+
+// deleteUser deletes the aws user of the deleted google user, or deactivates
+// it with the deactivate strategy. It returns the changed user or nil when it
+// was already deleted or inactive.
+func (s *syncGSuite) deleteUser(u *admin.User) (*aws.User, error) {
+	log.WithFields(log.Fields{
+		"email": u.PrimaryEmail,
+	}).Info("deleting google user")
+
+	uu, err := s.aws.FindUserByEmail(s.context(), u.PrimaryEmail)
+	if err != aws.ErrUserNotFound && err != nil {
+		log.WithFields(log.Fields{
+			"email": u.PrimaryEmail,
+		}).Warn("Error deleting google user")
+		return nil, err
+	}
+
+	if err == aws.ErrUserNotFound {
+		log.WithFields(log.Fields{
+			"email": u.PrimaryEmail,
+		}).Debug("User already deleted")
+		return nil, nil
+	}
+
+	if s.cfg.UserDeleteStrategy == config.UserDeleteStrategyDeactivate {
+		return s.deactivateUser(uu)
+	}
+
+	if err := s.paceDeletion(); err != nil {
+		return nil, err
+	}
+	_, err = s.identityStoreClient.DeleteUserWithContext(s.context(), &identitystore.DeleteUserInput{IdentityStoreId: &s.cfg.IdentityStoreID, UserId: &uu.ID})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"email": u.PrimaryEmail,
+		}).Warn("Error deleting user")
+		return nil, err
+	}
+
+	return uu, nil
+}
+
+// deactivateUser sets the aws user inactive and keeps it, with its
+// permission set assignments. It returns nil when the user is already
+// inactive, so later runs don't deactivate it again.
+func (s *syncGSuite) deactivateUser(uu *aws.User) (*aws.User, error) {
+	if !uu.Active {
+		log.WithField("email", uu.Username).Debug("User already deactivated")
+		return nil, nil
+	}
+
+	log.WithField("email", uu.Username).Info("deactivating user")
+	deactivated := *uu
+	deactivated.Active = false
+	if _, err := s.aws.UpdateUser(s.context(), &deactivated); err != nil {
+		log.WithField("email", uu.Username).Warn("Error deactivating user")
+		return nil, err
+	}
+
+	return &deactivated, nil
+}
+
 // SyncGroups will sync groups from Google -> AWS SSO
 // References:
 // * https://developers.google.com/admin-sdk/directory/v1/guides/search-groups
@@ -179,37 +524,73 @@ func (s *syncGSuite) SyncGroups(query string) error {
 		return err
 	}
 
+	// this sync method names groups by their email unless configured otherwise
+	source, other := s.groupSource(googleGroupEmail), googleGroupName
+	if s.cfg.GroupDisplayNameSource == config.GroupDisplayNameSourceName {
+		other = googleGroupEmail
+	}
+
+	if s.cfg.SyncGroupAliases {
+		googleGroups = append(googleGroups, s.groupAliases(googleGroups, source)...)
+	}
+
 	correlatedGroups := make(map[string]*aws.Group)
+	var memberErrs memberErrors
 
 	for _, g := range googleGroups {
 		if s.ignoreGroup(g.Email) || !s.includeGroup(g.Email) {
 			continue
 		}
 
+		name := awsGroupName(source(g), s.cfg.GroupNamePrefix, s.cfg.GroupNameSuffix)
+		if s.protectedGroup(name) {
+			log.WithField("group", name).Warn("google group is named as a protected aws group, ignoring it")
+			continue
+		}
 		log := log.WithFields(log.Fields{
-			"group": g.Email,
+			"group": name,
 		})
 
 		log.Debug("Check group")
 		var group *aws.Group
 
-		gg, err := s.aws.FindGroupByDisplayName(g.Email)
+		gg, err := s.aws.FindGroupByDisplayName(s.context(), name)
 		if err != nil && err != aws.ErrGroupNotFound {
 			return err
 		}
 
+		// groups created by the groups sync method are named by the other attribute
+		previousName := awsGroupName(other(g), s.cfg.GroupNamePrefix, s.cfg.GroupNameSuffix)
+		if gg == nil && s.cfg.MigrateGroupNames && previousName != name {
+			previous, err := s.aws.FindGroupByDisplayName(s.context(), previousName)
+			if err != nil && err != aws.ErrGroupNotFound {
+				return err
+			}
+			if previous != nil {
+				log.WithField("previous", previousName).Info("Renaming group in AWS")
+				if err := s.aws.UpdateGroupDisplayName(s.context(), previous, name); err != nil {
+					return err
+				}
+				s.record(auditRecord{Operation: auditUpdateGroup, Group: name, GroupID: previous.ID})
+				previous.DisplayName = name
+				gg = previous
+			}
+		}
+
 		if gg != nil {
 			log.Debug("Found group")
 			correlatedGroups[gg.DisplayName] = gg
 			group = gg
 		} else {
 			log.Info("Creating group in AWS")
-			newGroup := aws.NewGroup(g.Email)
-			createGroupOutput, err := s.identityStoreClient.CreateGroup(&identitystore.CreateGroupInput{IdentityStoreId: &s.cfg.IdentityStoreID, DisplayName: &g.Email})
+			newGroup := aws.NewGroup(name)
+			createGroupOutput, err := s.identityStoreClient.CreateGroupWithContext(s.context(), &identitystore.CreateGroupInput{IdentityStoreId: &s.cfg.IdentityStoreID, DisplayName: &name})
 			if err != nil {
 				return err
 			}
 			newGroup.ID = *createGroupOutput.GroupId
+			s.stats.GroupsCreated++
+			s.record(auditRecord{Operation: auditCreateGroup, Group: newGroup.DisplayName, GroupID: newGroup.ID})
 			correlatedGroups[newGroup.DisplayName] = newGroup
 			group = newGroup
 		}
@@ -226,6 +607,11 @@ func (s *syncGSuite) SyncGroups(query string) error {
 		for _, m := range groupMembers {
 			if _, ok := s.users[m.Email]; ok {
 				memberList[m.Email] = m
+			} else if m.Type == "GROUP" {
+				// this sync method doesn't expand nested groups
+				s.addUnresolved(g.Email, m.Email, unresolvedNestedGroup)
+			} else {
+				s.addUnresolved(g.Email, m.Email, unresolvedMissingUser)
 			}
 		}
 
@@ -239,22 +625,24 @@ func (s *syncGSuite) SyncGroups(query string) error {
 			if _, ok := memberList[u.Username]; ok {
 				if !*b {
 					log.WithField("user", u.Username).Info("Adding user to group")
-					_, err = s.identityStoreClient.CreateGroupMembership(
-						&identitystore.CreateGroupMembershipInput{
-							IdentityStoreId: &s.cfg.IdentityStoreID,
-							GroupId:         &group.ID,
-							MemberId:        &identitystore.MemberId{UserId: &u.ID},
-						},
-					)
-					if err != nil {
+					err = s.addMember(u, group)
+					if err == nil {
+						s.stats.MembershipsAdded++
+						s.record(auditRecord{Operation: auditAddMember, User: u.Username, UserID: u.ID, Group: group.DisplayName, GroupID: group.ID})
+					}
+					if err := s.memberError(&memberErrs, err, u.Username, group.DisplayName); err != nil {
 						return err
 					}
 				}
 			} else {
 				if *b {
 					log.WithField("user", u.Username).Warn("Removing user from group")
-					err := s.RemoveUserFromGroup(&u.ID, &group.ID)
-					if err != nil {
+					err := s.removeMember(u, group)
+					if err == nil {
+						s.stats.MembershipsRemoved++
+						s.record(auditRecord{Operation: auditRemoveMember, User: u.Username, UserID: u.ID, Group: group.DisplayName, GroupID: group.ID})
+					}
+					if err := s.memberError(&memberErrs, err, u.Username, group.DisplayName); err != nil {
 						return err
 					}
 				}
@@ -262,7 +650,12 @@ func (s *syncGSuite) SyncGroups(query string) error {
 		}
 	}
 
-	return nil
+	s.reportUnresolved()
+	if err := s.writeReport(); err != nil {
+		return err
+	}
+
+	return memberErrs.err()
 }
 
 // SyncGroupsUsers will sync groups and its members from Google -> AWS SSO SCIM
@@ -285,6 +678,16 @@ func (s *syncGSuite) SyncGroups(query string) error {
 //  5) validate equals aws an google groups members
 //  6) delete groups in aws, these were deleted in google
 func (s *syncGSuite) SyncGroupsUsers(queryGroups string, queryUsers string) error {
+	s.loadCache(time.Now())
+	s.loadCheckpoint()
+	err := s.syncGroupsUsers(queryGroups, queryUsers)
+	s.saveCache(err)
+	s.saveCheckpoint(err, time.Now())
+	return err
+}
+
+// syncGroupsUsers is SyncGroupsUsers without the correlation cache and checkpoint handling
+func (s *syncGSuite) syncGroupsUsers(queryGroups string, queryUsers string) error {
 
 	log.WithField("queryGroup", queryGroups).Info("get google groups")
 	log.WithField("queryUsers", queryUsers).Info("get google users")
@@ -297,23 +700,60 @@ func (s *syncGSuite) SyncGroupsUsers(queryGroups string, queryUsers string) erro
 	log.WithField("googleGroups", googleGroups).Debug("Groups to sync")
 	log.WithField("googleUsers", googleUsers).Debug("Users to sync")
 
+	if s.cfg.MaxUsers > 0 && len(googleUsers) > s.cfg.MaxUsers {
+		return fmt.Errorf("google returned %d users, more than the maximum of %d", len(googleUsers), s.cfg.MaxUsers)
+	}
+
 	log.Info("get existing aws groups")
-	awsGroups, err := s.GetGroups()
+	awsGroups, err := s.listGroups()
 	if err != nil {
 		log.Error("error getting aws groups")
 		return err
 	}
+	awsGroups = s.withoutProtectedGroups(awsGroups)
+
+	if err := s.checkEmptySource("groups", len(googleGroups), len(awsGroups)); err != nil {
+		return err
+	}
+
+	if s.cfg.MigrateGroupNames {
+		log.Info("migrating aws groups named by email to their group name")
+		err = s.migrateGroupNames(awsGroups, googleGroups, s.groupDisplayName, s.prefixedGroupName(googleGroupEmail))
+		if err != nil {
+			return err
+		}
+	}
 
 	log.Info("get existing aws users")
-	awsUsers, err := s.GetUsers()
+	awsUsers, err := s.listUsers()
 	if err != nil {
 		log.Error("error getting aws users")
 		return err
 	}
 
+	if err := s.checkEmptySource("users", len(googleUsers), len(awsUsers)); err != nil {
+		return err
+	}
+
+	// the users and groups applied by the last run and unchanged in google
+	// since are not looked up through SCIM again
+	hashedUsers, hashedGroups := userHashes(googleUsers, newUserMapping(s.cfg)), groupHashes(googleGroupsUsers)
+	unchangedUsers, unchangedGroups := s.unchangedSinceCheckpoint(hashedUsers, hashedGroups)
+	googleUsersMap := make(map[string]*admin.User)
+	for _, u := range googleUsers {
+		googleUsersMap[u.PrimaryEmail] = u
+	}
+
 	log.Info("get active status for aws users")
+	awsManagers := make(map[string]string)
+	awsExternalIDs := make(map[string]string)
 	for _, awsUser := range awsUsers {
-		scimUser, err := s.aws.FindUserByEmail(awsUser.Username)
+		if _, found := unchangedUsers[awsUser.Username]; found {
+			awsUser.Active = !googleUsersMap[awsUser.Username].Suspended
+			continue
+		}
+
+		scimUser, err := s.aws.FindUserByEmail(s.context(), awsUser.Username)
 
 		if err != nil {
 			log.Error("error getting active status for user " + awsUser.ID)
@@ -321,6 +761,15 @@ func (s *syncGSuite) SyncGroupsUsers(queryGroups string, queryUsers string) erro
 		}
 
 		awsUser.Active = scimUser.Active
+		awsManagers[awsUser.Username] = scimUser.ManagerID()
+		awsExternalIDs[awsUser.Username] = scimUser.ExternalID
+	}
+
+	if s.cfg.BackfillExternalIDs && s.runsPhase(config.PhaseUsers) {
+		log.Info("backfilling external ids of aws users")
+		if err := s.backfillExternalIDs(awsUsers, googleUsersMap, awsExternalIDs); err != nil {
+			return err
+		}
 	}
 
 	log.Info("preparing map of user id's to user")
@@ -333,8 +782,74 @@ func (s *syncGSuite) SyncGroupsUsers(queryGroups string, queryUsers string) erro
 	}
 
 	// create list of changes by operations
-	addAWSUsers, delAWSUsers, updateAWSUsers, _ := getUserOperations(awsUsers, googleUsers)
-	addAWSGroups, delAWSGroups, equalAWSGroups := getGroupOperations(awsGroups, googleGroups)
+	addAWSUsers, delAWSUsers, updateAWSUsers, _ := getUserOperationsChunked(awsUsers, googleUsers, newUserMapping(s.cfg), s.unmanagedUserAction(), s.cfg.ReconcileChunkSize)
+	delAWSUsers = withoutGroupMembers(delAWSUsers, awsGroupsUsers, s.unconfirmed)
+	addAWSGroups, delAWSGroups, equalAWSGroups := getGroupOperations(awsGroups, googleGroups, s.groupSource(googleGroupName), s.cfg.GroupNamePrefix, s.cfg.GroupNameSuffix)
+	addAWSGroups = withoutGroups(addAWSGroups, s.transitional, unresolvedGroupDeleting)
+	addAWSGroups = withoutGroups(addAWSGroups, s.undersized, "too few members")
+
+	// list of users to to be removed in aws groups
+	deleteUsersFromGroup, _ := getGroupUsersOperations(googleGroupsUsers, awsGroupsUsers)
+	deleteUsersFromGroup = withoutMembers(deleteUsersFromGroup, s.suspended)
+	addUsersToGroup := getGroupAddMembers(googleGroupsUsers, awsGroupsUsers)
+
+	// the changes of the phases not requested are left out before they are
+	// planned, their data was still read as the requested phases depend on it
+	skippedUsers := make(map[string]struct{})
+	if !s.runsPhase(config.PhaseUsers) {
+		log.Info("skipping the user changes, the users phase is not requested")
+		// the users not created can't be added to groups
+		for _, awsUser := range addAWSUsers {
+			skippedUsers[awsUser.Username] = struct{}{}
+		}
+		for group, users := range addUsersToGroup {
+			addUsersToGroup[group] = withoutGoogleUsers(users, skippedUsers)
+		}
+		addAWSUsers, updateAWSUsers, delAWSUsers = nil, nil, nil
+	}
+	if !s.runsPhase(config.PhaseGroups) {
+		log.Info("skipping the group changes, the groups phase is not requested")
+		// the members of the groups not created can't be added
+		for _, awsGroup := range addAWSGroups {
+			delete(addUsersToGroup, awsGroup.DisplayName)
+		}
+		addAWSGroups, delAWSGroups = nil, nil
+	}
+	if !s.runsPhase(config.PhaseMembers) {
+		log.Info("skipping the member changes, the members phase is not requested")
+		equalAWSGroups, addUsersToGroup, deleteUsersFromGroup = nil, nil, nil
+	}
+
+	if err := s.reportAccessImpact(delAWSUsers, delAWSGroups, awsUsers, awsGroups, deleteUsersFromGroup); err != nil {
+		return err
+	}
+	s.reportDrift(awsUsers, googleUsers, awsGroups, googleGroups)
+
+	// the plan shows the access the deletions remove, before anything is applied
+	plan := newSyncPlan(addAWSUsers, updateAWSUsers, delAWSUsers, addAWSGroups, delAWSGroups,
+		addUsersToGroup, deleteUsersFromGroup)
+	plan.Impact = s.impact
+	if err := s.writePlan(plan); err != nil {
+		return err
+	}
+
+	if s.cfg.VerbosePlan {
+		logPlan(plan)
+	}
+
+	if s.cfg.PlanSummary {
+		if err := plan.summary(s.summaryOutput()); err != nil {
+			return err
+		}
+	}
+
+	if err := s.checkPlan(plan); err != nil {
+		return err
+	}
+
+	if err := s.checkDeletions(len(delAWSUsers)+len(delAWSGroups), len(awsUsers)+len(awsGroups)); err != nil {
+		return err
+	}
 
 	log.Info("syncing changes")
 	// delete aws users (deleted in google)
@@ -344,107 +859,180 @@ func (s *syncGSuite) SyncGroupsUsers(queryGroups string, queryUsers string) erro
 		log := log.WithFields(log.Fields{"user": awsUser.Username})
 
 		log.Debug("finding user")
-		awsUserFull, err := s.aws.FindUserByEmail(awsUser.Username)
+		awsUserFull, err := s.aws.FindUserByEmail(s.context(), awsUser.Username)
 		if err != nil {
 			return err
 		}
 
 		log.Warn("deleting user")
-		_, err = s.identityStoreClient.DeleteUser(
+		if err := s.paceDeletion(); err != nil {
+			return err
+		}
+		_, err = s.identityStoreClient.DeleteUserWithContext(s.context(),
 			&identitystore.DeleteUserInput{IdentityStoreId: &s.cfg.IdentityStoreID, UserId: &awsUserFull.ID},
 		)
 		if err != nil {
 			log.WithField("user", awsUser).Error("error deleting user")
 			return err
 		}
+		s.stats.UsersDeleted++
+		s.record(auditRecord{Operation: auditDeleteUser, User: awsUserFull.Username, UserID: awsUserFull.ID, ExternalID: awsUserFull.ExternalID})
 	}
 
 	// update aws users (updated in google)
 	log.Debug("updating aws users updated in google")
+	unappliedUsers := make(map[string]struct{})
 	for _, awsUser := range updateAWSUsers {
 
 		log := log.WithFields(log.Fields{"user": awsUser.Username})
 
 		log.Debug("finding user")
-		awsUserFull, err := s.aws.FindUserByEmail(awsUser.Username)
+		awsUserFull, err := s.aws.FindUserByEmail(s.context(), awsUser.Username)
 		if err != nil {
 			return err
 		}
 
+		if ok, err := s.validUser(awsUser); !ok {
+			if err != nil {
+				return err
+			}
+			unappliedUsers[awsUser.Username] = struct{}{}
+			continue
+		}
+
 		log.Warn("updating user")
-		_, err = s.aws.UpdateUser(aws.UpdateUser(
+		updated := aws.UpdateUser(
 			awsUserFull.ID,
 			awsUser.Name.GivenName,
 			awsUser.Name.FamilyName,
 			awsUser.Username,
-			awsUser.Active))
+			awsUser.Active)
+		updated.Emails = awsUser.Emails
+		updated.PhoneNumbers = awsUser.PhoneNumbers
+		updated.ExternalID = awsUser.ExternalID
+		if updated.ExternalID == "" {
+			// identity store users carry no scim external id, the disabled
+			// unmanaged ones keep theirs
+			updated.ExternalID = awsUserFull.ExternalID
+		}
+		updated.Title = awsUser.Title
+		updated.UserType = awsUser.UserType
+		updated.Locale = awsUser.Locale
+		updated.Timezone = awsUser.Timezone
+		updated.PreferredLanguage = awsUser.PreferredLanguage
+		// the replace drops any attribute that isn't sent, keep the manager
+		// so syncManagers only has to patch the ones that changed
+		updated.Enterprise = awsUserFull.Enterprise
+		_, err = s.aws.UpdateUser(s.context(), updated)
 		if err != nil {
 		 	log.WithField("user", awsUser).Error("error updating user")
 			return err
 		}
+		s.stats.UsersUpdated++
+		// the users left in the update are either in google or unmanaged
+		reasons := []updateReason{updateReasonUnmanaged}
+		if gUser, found := googleUsersMap[awsUser.Username]; found {
+			reasons = getUserUpdateReasons(awsUserFull, gUser, newUserMapping(s.cfg))
+		}
+		s.record(auditRecord{Operation: auditUpdateUser, User: awsUser.Username, UserID: awsUserFull.ID, ExternalID: updated.ExternalID, Reasons: reasons})
 	}
 
 	// add aws users (added in google)
 	log.Debug("creating aws users added in google")
+	var createdUsers []*aws.User
 	for _, awsUser := range addAWSUsers {
 
 		log := log.WithFields(log.Fields{"user": awsUser.Username})
 
+		if ok, err := s.validUser(awsUser); !ok {
+			if err != nil {
+				return err
+			}
+			skippedUsers[awsUser.Username] = struct{}{}
+			continue
+		}
+
 		log.Info("creating user")
-		_, err := s.aws.CreateUser(awsUser)
+		created, err := s.createUser(awsUser)
 		if err != nil {
-			errHTTP := new(aws.ErrHTTPNotOK)
-			if errors.As(err, &errHTTP) && errHTTP.StatusCode == 409 {
+			if isUserConflict(err) {
 				log.WithField("user", awsUser.Username).Warn("user already exists")
 				continue
 			}
 			log.WithField("user", awsUser).Error("error creating user")
 			return err
 		}
+		s.stats.UsersCreated++
+		s.record(auditRecord{Operation: auditCreateUser, User: created.Username, UserID: created.ID, ExternalID: awsUser.ExternalID})
+		createdUsers = append(createdUsers, created)
+	}
+
+	// set aws managers, once all the users exist
+	if s.cfg.SyncManager && s.runsPhase(config.PhaseUsers) {
+		log.Debug("syncing managers of aws users")
+		// the managers of the unchanged users were applied by the last run
+		managersSkipped := make(map[string]struct{})
+		for _, users := range []map[string]struct{}{skippedUsers, unchangedUsers} {
+			for email := range users {
+				managersSkipped[email] = struct{}{}
+			}
+		}
+		if err := s.syncManagers(googleUsers, awsManagers, managersSkipped); err != nil {
+			return err
+		}
 	}
 
 	// add aws groups (added in google)
 	log.Debug("creating aws groups added in google")
+	var memberErrs memberErrors
+	var createdGroups []*aws.Group
 	for _, awsGroup := range addAWSGroups {
 
 		log := log.WithFields(log.Fields{"group": awsGroup.DisplayName})
 
 		log.Info("creating group")
-		newAwsGroup, err := s.identityStoreClient.CreateGroup(
+		newAwsGroup, err := s.identityStoreClient.CreateGroupWithContext(s.context(),
 			&identitystore.CreateGroupInput{IdentityStoreId: &s.cfg.IdentityStoreID, DisplayName: &awsGroup.DisplayName},
 		)
 		if err != nil {
 			log.Error("creating group")
 			return err
 		}
+		s.stats.GroupsCreated++
+		s.record(auditRecord{Operation: auditCreateGroup, Group: awsGroup.DisplayName, GroupID: aws_sdk.StringValue(newAwsGroup.GroupId)})
+		createdGroup := &aws.Group{ID: aws_sdk.StringValue(newAwsGroup.GroupId), DisplayName: awsGroup.DisplayName}
+		createdGroups = append(createdGroups, createdGroup)
 
-		// add members of the new group
+		if !s.runsPhase(config.PhaseMembers) {
+			continue
+		}
+
+		// add members of the new group, the google members are already
+		// deduplicated when a user is both a direct and a nested member
 		for _, googleUser := range googleGroupsUsers[awsGroup.DisplayName] {
+			if _, skipped := skippedUsers[googleUser.PrimaryEmail]; skipped {
+				continue
+			}
 
 			// equivalent aws user of google user on the fly
 			log.Debug("finding user")
-			awsUserFull, err := s.aws.FindUserByEmail(googleUser.PrimaryEmail)
+			awsUserFull, err := s.aws.FindUserByEmail(s.context(), googleUser.PrimaryEmail)
 			if err != nil {
 				return err
 			}
 
 			log.WithField("user", awsUserFull.Username).Info("adding user to group")
-			_, err = s.identityStoreClient.CreateGroupMembership(
-				&identitystore.CreateGroupMembershipInput{
-					IdentityStoreId: &s.cfg.IdentityStoreID,
-					GroupId:         newAwsGroup.GroupId,
-					MemberId:        &identitystore.MemberId{UserId: &awsUserFull.ID},
-				},
-			)
-			if err != nil {
+			err = s.addMember(awsUserFull, createdGroup)
+			if err == nil {
+				s.stats.MembershipsAdded++
+				s.record(auditRecord{Operation: auditAddMember, User: awsUserFull.Username, UserID: awsUserFull.ID, Group: awsGroup.DisplayName, GroupID: aws_sdk.StringValue(newAwsGroup.GroupId)})
+			}
+			if err := s.memberError(&memberErrs, err, awsUserFull.Username, awsGroup.DisplayName); err != nil {
 				return err
 			}
 		}
 	}
 
-	// list of users to to be removed in aws groups
-	deleteUsersFromGroup, _ := getGroupUsersOperations(googleGroupsUsers, awsGroupsUsers)
-
 	// validate groups members are equal in aws and google
 	log.Debug("validating groups members, equals in aws and google")
 	for _, awsGroup := range equalAWSGroups {
@@ -452,10 +1040,20 @@ func (s *syncGSuite) SyncGroupsUsers(queryGroups string, queryUsers string) erro
 		// add members of the new group
 		log := log.WithFields(log.Fields{"group": awsGroup.DisplayName})
 
-		for _, googleUser := range googleGroupsUsers[awsGroup.DisplayName] {
+		members := googleGroupsUsers[awsGroup.DisplayName]
+		_, assumed := unchangedGroups[awsGroup.DisplayName]
+		if assumed {
+			members = s.equalMembersToVerify(members)
+			log.WithField("verifying", len(members)).Debug("members unchanged since the checkpoint")
+		}
+
+		for _, googleUser := range members {
+			if _, skipped := skippedUsers[googleUser.PrimaryEmail]; skipped {
+				continue
+			}
 
 			log.WithField("user", googleUser.PrimaryEmail).Debug("finding user")
-			awsUserFull, err := s.aws.FindUserByEmail(googleUser.PrimaryEmail)
+			awsUserFull, err := s.aws.FindUserByEmail(s.context(), googleUser.PrimaryEmail)
 			if err != nil {
 				return err
 			}
@@ -467,24 +1065,36 @@ func (s *syncGSuite) SyncGroupsUsers(queryGroups string, queryUsers string) erro
 			}
 
 			if !*b {
+				if assumed {
+					log.WithField("user", awsUserFull.Username).Warn("membership lost in aws since the checkpoint")
+				}
 				log.WithField("user", awsUserFull.Username).Info("adding user to group")
-				_, err = s.identityStoreClient.CreateGroupMembership(
-					&identitystore.CreateGroupMembershipInput{
-						IdentityStoreId: &s.cfg.IdentityStoreID,
-						GroupId:         &awsGroup.ID,
-						MemberId:        &identitystore.MemberId{UserId: &awsUserFull.ID},
-					},
-				)
-				if err != nil {
+				err = s.addMember(awsUserFull, awsGroup)
+				if err == nil {
+					s.stats.MembershipsAdded++
+					s.record(auditRecord{Operation: auditAddMember, User: awsUserFull.Username, UserID: awsUserFull.ID, Group: awsGroup.DisplayName, GroupID: awsGroup.ID})
+				}
+				if err := s.memberError(&memberErrs, err, awsUserFull.Username, awsGroup.DisplayName); err != nil {
 					return err
 				}
 			}
 		}
 
-		for _, awsUser := range deleteUsersFromGroup[awsGroup.DisplayName] {
-			log.WithField("user", awsUser.Username).Warn("removing user from group")
-			err := s.RemoveUserFromGroup(&awsUser.ID, &awsGroup.ID)
-			if err != nil {
+		_, transitional := s.transitional[awsGroup.DisplayName]
+		if len(googleGroupsUsers[awsGroup.DisplayName]) == 0 && len(deleteUsersFromGroup[awsGroup.DisplayName]) > 0 &&
+			s.cfg.EmptyGroupAction == config.EmptyGroupActionKeep && !transitional {
+			log.Warn("google group has no members, keeping existing aws members")
+			continue
+		}
+
+		for _, awsUser := range deleteUsersFromGroup[awsGroup.DisplayName] {
+			log.WithField("user", awsUser.Username).Warn("removing user from group")
+			err := s.removeMember(awsUser, awsGroup)
+			if err == nil {
+				s.stats.MembershipsRemoved++
+				s.record(auditRecord{Operation: auditRemoveMember, User: awsUser.Username, UserID: awsUser.ID, Group: awsGroup.DisplayName, GroupID: awsGroup.ID})
+			}
+			if err := s.memberError(&memberErrs, err, awsUser.Username, awsGroup.DisplayName); err != nil {
 				return err
 			}
 		}
@@ -497,19 +1107,52 @@ func (s *syncGSuite) SyncGroupsUsers(queryGroups string, queryUsers string) erro
 		log := log.WithFields(log.Fields{"group": awsGroup.DisplayName})
 
 		log.Debug("finding group")
-		awsGroupFull, err := s.aws.FindGroupByDisplayName(awsGroup.DisplayName)
+		awsGroupFull, err := s.aws.FindGroupByDisplayName(s.context(), awsGroup.DisplayName)
 		if err != nil {
 			return err
 		}
 
+		if s.cfg.PurgeOrphanedMemberships {
+			purged, err := s.purgeMemberships(&memberErrs, awsGroupFull, awsGroupsUsers[awsGroup.DisplayName])
+			if err != nil {
+				return err
+			}
+			if !purged {
+				log.Warn("not all members could be removed, keeping group")
+				continue
+			}
+		}
+
 		log.Warn("deleting group")
-		_, err = s.identityStoreClient.DeleteGroup(
+		if err := s.paceDeletion(); err != nil {
+			return err
+		}
+		_, err = s.identityStoreClient.DeleteGroupWithContext(s.context(),
 			&identitystore.DeleteGroupInput{IdentityStoreId: &s.cfg.IdentityStoreID, GroupId: &awsGroupFull.ID},
 		)
 		if err != nil {
 			log.Error("deleting group")
 			return err
 		}
+		s.stats.GroupsDeleted++
+		s.record(auditRecord{Operation: auditDeleteGroup, Group: awsGroupFull.DisplayName, GroupID: awsGroupFull.ID})
+	}
+
+	if err := s.writeReport(); err != nil {
+		return err
+	}
+
+	if err := s.writeMappings(googleUsers, googleGroups, append(awsUsers, createdUsers...), append(awsGroups, createdGroups...)); err != nil {
+		return err
+	}
+
+	if err := memberErrs.err(); err != nil {
+		return err
+	}
+
+	// the checkpoint would assume the changes of the skipped phases applied
+	if s.checkpointer != nil && len(s.cfg.OnlyPhases) == 0 {
+		s.applied = appliedCheckpoint(hashedUsers, hashedGroups, googleGroupsUsers, skippedUsers, unappliedUsers)
 	}
 
 	log.Info("sync completed")
@@ -517,6 +1160,492 @@ func (s *syncGSuite) SyncGroupsUsers(queryGroups string, queryUsers string) erro
 	return nil
 }
 
+// runsPhase reports whether the changes of the phase are applied, all the
+// phases are when none is requested
+func (s *syncGSuite) runsPhase(phase string) bool {
+	if len(s.cfg.OnlyPhases) == 0 {
+		return true
+	}
+	for _, p := range s.cfg.OnlyPhases {
+		if p == phase {
+			return true
+		}
+	}
+	return false
+}
+
+// purgeMemberships removes the remaining aws members of a group before it's
+// deleted, so no membership is left pointing at it. It reports whether all
+// of them were removed, failures are handled as other membership changes.
+func (s *syncGSuite) purgeMemberships(errs *memberErrors, group *aws.Group, members []*aws.User) (bool, error) {
+	purged := true
+	for _, awsUser := range members {
+		log.WithFields(log.Fields{"group": group.DisplayName, "user": awsUser.Username}).Warn("removing user from deleted group")
+		err := s.removeMember(awsUser, group)
+		if err == nil {
+			s.stats.MembershipsRemoved++
+			s.record(auditRecord{Operation: auditRemoveMember, User: awsUser.Username, UserID: awsUser.ID, Group: group.DisplayName, GroupID: group.ID})
+		} else {
+			purged = false
+		}
+		if err := s.memberError(errs, err, awsUser.Username, group.DisplayName); err != nil {
+			return false, err
+		}
+	}
+	return purged, nil
+}
+
+// SyncGroupMetadata reconciles only the aws groups with the google groups,
+// named the way the configured sync method names them. Groups are created,
+// renamed with --migrate-group-names and deleted, users and members are left
+// untouched. As with the users_groups sync method, its groups are never deleted.
+func (s *syncGSuite) SyncGroupMetadata(query string) error {
+	usersGroups := s.cfg.SyncMethod != config.DefaultSyncMethod
+
+	log.WithField("query", query).Info("get google groups")
+	gGroups, err := s.google.GetGroups(query)
+	if err != nil {
+		return err
+	}
+
+	googleGroups := make([]*admin.Group, 0, len(gGroups))
+	for _, g := range gGroups {
+		if s.ignoreGroup(g.Email) || (usersGroups && !s.includeGroup(g.Email)) {
+			continue
+		}
+		googleGroups = append(googleGroups, g)
+	}
+
+	// groups are renamed from the naming of the other attribute
+	byEmail := usersGroups
+	switch s.cfg.GroupDisplayNameSource {
+	case config.GroupDisplayNameSourceEmail:
+		byEmail = true
+	case config.GroupDisplayNameSourceName:
+		byEmail = false
+	}
+	source, previous := googleGroupName, googleGroupEmail
+	if byEmail {
+		source, previous = googleGroupEmail, googleGroupName
+	}
+
+	if s.cfg.SyncGroupAliases {
+		googleGroups = append(googleGroups, s.groupAliases(googleGroups, source)...)
+	}
+
+	log.Info("get existing aws groups")
+	awsGroups, err := s.GetGroups()
+	if err != nil {
+		return err
+	}
+	awsGroups = s.withoutProtectedGroups(awsGroups)
+
+	if err := s.checkEmptySource("groups", len(googleGroups), len(awsGroups)); err != nil {
+		return err
+	}
+
+	if s.cfg.MigrateGroupNames {
+		log.Info("migrating aws groups named by the other sync method")
+		if err := s.migrateGroupNames(awsGroups, googleGroups, s.prefixedGroupName(source), s.prefixedGroupName(previous)); err != nil {
+			return err
+		}
+	}
+
+	addAWSGroups, delAWSGroups, _ := getGroupOperations(awsGroups, googleGroups, source, s.cfg.GroupNamePrefix, s.cfg.GroupNameSuffix)
+	addAWSGroups = withoutGroups(addAWSGroups, s.protectedGroups(addAWSGroups), "protected")
+	if usersGroups {
+		delAWSGroups = nil
+	}
+
+	if err := s.checkDeletions(len(delAWSGroups), len(awsGroups)); err != nil {
+		return err
+	}
+
+	for _, awsGroup := range addAWSGroups {
+		log := log.WithFields(log.Fields{"group": awsGroup.DisplayName})
+
+		log.Info("creating group")
+		out, err := s.identityStoreClient.CreateGroupWithContext(s.context(),
+			&identitystore.CreateGroupInput{IdentityStoreId: &s.cfg.IdentityStoreID, DisplayName: &awsGroup.DisplayName},
+		)
+		if err != nil {
+			log.Error("creating group")
+			return err
+		}
+		s.stats.GroupsCreated++
+		s.record(auditRecord{Operation: auditCreateGroup, Group: awsGroup.DisplayName, GroupID: aws_sdk.StringValue(out.GroupId)})
+	}
+
+	for _, awsGroup := range delAWSGroups {
+		log := log.WithFields(log.Fields{"group": awsGroup.DisplayName})
+
+		log.Debug("finding group")
+		awsGroupFull, err := s.aws.FindGroupByDisplayName(s.context(), awsGroup.DisplayName)
+		if err != nil {
+			return err
+		}
+
+		log.Warn("deleting group")
+		if err := s.paceDeletion(); err != nil {
+			return err
+		}
+		_, err = s.identityStoreClient.DeleteGroupWithContext(s.context(),
+			&identitystore.DeleteGroupInput{IdentityStoreId: &s.cfg.IdentityStoreID, GroupId: &awsGroupFull.ID},
+		)
+		if err != nil {
+			log.Error("deleting group")
+			return err
+		}
+		s.stats.GroupsDeleted++
+		s.record(auditRecord{Operation: auditDeleteGroup, Group: awsGroupFull.DisplayName, GroupID: awsGroupFull.ID})
+	}
+
+	log.Info("group metadata sync completed")
+
+	return nil
+}
+
+// googleManager returns the email of the manager in the relations of the
+// google user, or an empty string when they have none
+func googleManager(u *admin.User) string {
+	if u.Relations == nil {
+		return ""
+	}
+
+	// relations are left undecoded by the directory api
+	b, err := json.Marshal(u.Relations)
+	if err != nil {
+		return ""
+	}
+
+	var relations []admin.UserRelation
+	if err := json.Unmarshal(b, &relations); err != nil {
+		return ""
+	}
+
+	for _, r := range relations {
+		if r.Type == "manager" {
+			return r.Value
+		}
+	}
+
+	return ""
+}
+
+// googleEmployeeNumber returns the employee id of the google user, the
+// external id of type organization, empty when it has none
+func googleEmployeeNumber(u *admin.User) string {
+	if u.ExternalIds == nil {
+		return ""
+	}
+
+	// the external ids are not typed by the admin sdk, so decode them again
+	var externalIDs []admin.UserExternalId
+	if err := decodeGoogleField(u.ExternalIds, &externalIDs); err != nil {
+		log.WithFields(log.Fields{"user": u.PrimaryEmail, "error": err}).Warn("ignoring unreadable external ids")
+		return ""
+	}
+
+	for _, id := range externalIDs {
+		if id.Type == "organization" {
+			return id.Value
+		}
+	}
+	return ""
+}
+
+// syncManagers sets the manager of each aws user to the aws user of their
+// google manager. It runs once the users are created so managers added in
+// the same run can be referenced; current holds the manager ids by username.
+// With the employeeNumber manager key the relations are first resolved to
+// the emails of the google users holding those employee ids.
+func (s *syncGSuite) syncManagers(googleUsers []*admin.User, current map[string]string, skipped map[string]struct{}) error {
+	var emails map[string]string
+	if s.cfg.ManagerKey == config.ManagerKeyEmployeeNumber {
+		emails = make(map[string]string)
+		for _, u := range googleUsers {
+			if number := googleEmployeeNumber(u); number != "" {
+				emails[number] = u.PrimaryEmail
+			}
+		}
+	}
+
+	users := make(map[string]*aws.User)
+	resolve := func(email string) (*aws.User, error) {
+		if u, found := users[email]; found {
+			return u, nil
+		}
+		u, err := s.aws.FindUserByEmail(s.context(), email)
+		if err != nil {
+			return nil, err
+		}
+		users[email] = u
+		return u, nil
+	}
+
+	for _, u := range googleUsers {
+		if _, found := skipped[u.PrimaryEmail]; found {
+			continue
+		}
+
+		log := log.WithFields(log.Fields{"user": u.PrimaryEmail})
+
+		managerID := ""
+		if email := googleManager(u); email != "" {
+			if emails != nil {
+				number := email
+				if email = emails[number]; email == "" {
+					log.WithField("manager", number).Warn("manager is not a synced google user, skipping")
+					continue
+				}
+			}
+			manager, err := resolve(email)
+			if err == aws.ErrUserNotFound {
+				log.WithField("manager", email).Warn("manager is not an aws user, skipping")
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			managerID = manager.ID
+		}
+
+		if managerID == current[u.PrimaryEmail] {
+			continue
+		}
+
+		user, err := resolve(u.PrimaryEmail)
+		if err != nil {
+			return err
+		}
+
+		log.WithField("manager", managerID).WithField("reasons", []updateReason{updateReasonManager}).Info("updating manager")
+		if err := s.aws.UpdateUserManager(s.context(), &aws.User{ID: user.ID, Username: u.PrimaryEmail}, managerID); err != nil {
+			return err
+		}
+		s.record(auditRecord{Operation: auditUpdateUser, User: u.PrimaryEmail, UserID: user.ID, ExternalID: user.ExternalID, Reasons: []updateReason{updateReasonManager}})
+	}
+
+	return nil
+}
+
+// backfillExternalIDs sets the external id of the managed aws users that
+// have none to the id of their google user, so later runs don't depend on
+// the email alone. current holds the external ids by username of the users
+// looked up through SCIM, the others were applied by the last run.
+func (s *syncGSuite) backfillExternalIDs(awsUsers []*aws.User, googleUsers map[string]*admin.User, current map[string]string) error {
+	for _, awsUser := range awsUsers {
+		gUser, managed := googleUsers[awsUser.Username]
+		externalID, looked := current[awsUser.Username]
+		if !managed || !looked || externalID != "" || gUser.Id == "" {
+			continue
+		}
+
+		log.WithFields(log.Fields{"user": awsUser.Username, "external_id": gUser.Id, "reasons": []updateReason{updateReasonExternalID}}).Info("backfilling external id")
+		if err := s.aws.UpdateUserExternalID(s.context(), awsUser, gUser.Id); err != nil {
+			return err
+		}
+		current[awsUser.Username] = gUser.Id
+		s.record(auditRecord{Operation: auditUpdateUser, User: awsUser.Username, UserID: awsUser.ID, ExternalID: gUser.Id, Reasons: []updateReason{updateReasonExternalID}})
+	}
+
+	return nil
+}
+
+// checkEmptySource fails when google returned none of the users or groups
+// that aws has, as it's more likely an upstream failure than an empty directory
+func (s *syncGSuite) checkEmptySource(kind string, googleCount int, awsCount int) error {
+	if s.cfg.AllowEmptySource || googleCount > 0 || awsCount == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("google returned no %s while aws has %d, refusing to delete them all", kind, awsCount)
+}
+
+// checkDeletions fails when the number of deletions is above the configured
+// thresholds, which usually means google returned an incomplete directory
+func (s *syncGSuite) checkDeletions(deletions int, population int) error {
+	if s.cfg.MaxDeletions > 0 && deletions > s.cfg.MaxDeletions {
+		return fmt.Errorf("sync would delete %d users and groups, more than the maximum of %d", deletions, s.cfg.MaxDeletions)
+	}
+
+	if s.cfg.MaxDeletionsPercent > 0 && population > 0 && deletions*100 > s.cfg.MaxDeletionsPercent*population {
+		return fmt.Errorf("sync would delete %d of %d users and groups, more than the maximum of %d%%", deletions, population, s.cfg.MaxDeletionsPercent)
+	}
+
+	return nil
+}
+
+// logPlan logs each operation of the plan, so the changes of a live run can
+// be read in the logs before they are made
+func logPlan(plan *syncPlan) {
+	ops := plan.operations()
+	log.WithField("operations", len(ops)).Info("sync plan")
+	for _, op := range ops {
+		log.WithField("operation", op).Info("planned")
+	}
+}
+
+// checkPlan logs the conflicting operations of the plan, the sync is
+// aborted on them when --fail-on-plan-conflicts is set
+func (s *syncGSuite) checkPlan(plan *syncPlan) error {
+	conflicts := plan.conflicts()
+	for _, c := range conflicts {
+		log.WithField("conflict", c).Warn("conflicting operations in the sync plan")
+	}
+
+	if s.cfg.FailOnPlanConflicts && len(conflicts) > 0 {
+		return fmt.Errorf("sync plan has %d conflicting operations, the first is: %s", len(conflicts), conflicts[0])
+	}
+
+	return nil
+}
+
+// createUser creates the user through the configured backend. The Identity
+// Store api has no status, so suspended users are then disabled through SCIM.
+func (s *syncGSuite) createUser(u *aws.User) (*aws.User, error) {
+	if s.cfg.UserBackend != config.UserBackendIdentityStore {
+		return s.aws.CreateUser(s.context(), u)
+	}
+
+	input := &identitystore.CreateUserInput{
+		IdentityStoreId: &s.cfg.IdentityStoreID,
+		UserName:        aws_sdk.String(u.Username),
+	}
+	if attributeAllowed(s.cfg.SyncAttributes, aws.AttributeName) {
+		input.Name = &identitystore.Name{
+			GivenName:  aws_sdk.String(u.Name.GivenName),
+			FamilyName: aws_sdk.String(u.Name.FamilyName),
+		}
+	}
+	if attributeAllowed(s.cfg.SyncAttributes, aws.AttributeDisplayName) {
+		input.DisplayName = aws_sdk.String(u.DisplayName)
+	}
+	if attributeAllowed(s.cfg.SyncAttributes, aws.AttributeEmails) {
+		for _, e := range u.Emails {
+			input.Emails = append(input.Emails, &identitystore.Email{
+				Value:   aws_sdk.String(e.Value),
+				Type:    aws_sdk.String(e.Type),
+				Primary: aws_sdk.Bool(e.Primary),
+			})
+		}
+	}
+	if attributeAllowed(s.cfg.SyncAttributes, aws.AttributeAddresses) {
+		for _, a := range u.Addresses {
+			input.Addresses = append(input.Addresses, &identitystore.Address{Type: aws_sdk.String(a.Type)})
+		}
+	}
+	if u.Title != "" && attributeAllowed(s.cfg.SyncAttributes, aws.AttributeTitle) {
+		input.Title = aws_sdk.String(u.Title)
+	}
+	if u.UserType != "" && attributeAllowed(s.cfg.SyncAttributes, aws.AttributeUserType) {
+		input.UserType = aws_sdk.String(u.UserType)
+	}
+	if u.Locale != "" && attributeAllowed(s.cfg.SyncAttributes, aws.AttributeLocale) {
+		input.Locale = aws_sdk.String(u.Locale)
+	}
+	if u.Timezone != "" && attributeAllowed(s.cfg.SyncAttributes, aws.AttributeTimezone) {
+		input.Timezone = aws_sdk.String(u.Timezone)
+	}
+	if u.PreferredLanguage != "" && attributeAllowed(s.cfg.SyncAttributes, aws.AttributePreferredLanguage) {
+		input.PreferredLanguage = aws_sdk.String(u.PreferredLanguage)
+	}
+	if attributeAllowed(s.cfg.SyncAttributes, aws.AttributePhoneNumbers) {
+		for _, p := range u.PhoneNumbers {
+			input.PhoneNumbers = append(input.PhoneNumbers, &identitystore.PhoneNumber{
+				Value:   aws_sdk.String(p.Value),
+				Type:    aws_sdk.String(p.Type),
+				Primary: aws_sdk.Bool(p.Primary),
+			})
+		}
+	}
+
+	out, err := s.identityStoreClient.CreateUser(input)
+	if err != nil {
+		return nil, err
+	}
+
+	created := *u
+	created.ID = *out.UserId
+
+	// the identity store api keys external ids by issuer, so the scim
+	// external id is set through scim as for the other users
+	if u.ExternalID != "" {
+		log.WithField("user", u.Username).Debug("setting external id")
+		if err := s.aws.UpdateUserExternalID(s.context(), &created, u.ExternalID); err != nil {
+			return nil, err
+		}
+	}
+
+	if !u.Active && attributeAllowed(s.cfg.SyncAttributes, aws.AttributeActive) {
+		log.WithField("user", u.Username).Debug("disabling suspended user")
+		// the replace drops any attribute that isn't sent, so the whole
+		// created user is sent with only active flipped
+		disabled := created
+		disabled.Active = false
+		if _, err := s.aws.UpdateUser(s.context(), &disabled); err != nil {
+			return nil, err
+		}
+	}
+
+	return &created, nil
+}
+
+// isUserConflict reports whether the user create failed as the user already
+// exists, which either backend can report
+func isUserConflict(err error) bool {
+	errHTTP := new(aws.ErrHTTPNotOK)
+	if errors.As(err, &errHTTP) && errHTTP.StatusCode == 409 {
+		return true
+	}
+
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == identitystore.ErrCodeConflictException
+}
+
+// validUser reports whether the user has the fields required by the SCIM
+// endpoint. Invalid users are skipped with a warning, or fail the sync
+// when configured to.
+func (s *syncGSuite) validUser(u *aws.User) (bool, error) {
+	err := aws.ValidateUser(u)
+	if err == nil {
+		return true, nil
+	}
+
+	if s.cfg.InvalidUserAction == config.InvalidUserActionFail {
+		return false, fmt.Errorf("user %s: %w", u.Username, err)
+	}
+
+	log.WithFields(log.Fields{"user": u.Username, "error": err}).Warn("skipping invalid user")
+	return false, nil
+}
+
+// forEachConcurrently calls fn for every index below n using up to
+// concurrency goroutines, and returns once all calls are done
+func forEachConcurrently(n int, concurrency int, fn func(i int)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
 // getGoogleGroupsAndUsers return a list of google users members of googleGroups
 // and a map of google groups and its users' list
 func (s *syncGSuite) getGoogleGroupsAndUsers(queryGroups string, queryUsers string) ([]*admin.Group, []*admin.User, map[string][]*admin.User, error) {
@@ -562,6 +1691,10 @@ func (s *syncGSuite) getGoogleGroupsAndUsers(queryGroups string, queryUsers stri
                 	log.WithField("id", u.PrimaryEmail).Debug("ignoring user")
 			continue
 		}
+		if !s.includeUser(u.PrimaryEmail) {
+			log.WithField("id", u.PrimaryEmail).Debug("user not included")
+			continue
+		}
                 _, ok := gUniqUsers[u.PrimaryEmail]
                 if !ok {
                 	log.WithField("id", u.PrimaryEmail).Debug("adding user")
@@ -581,13 +1714,38 @@ func (s *syncGSuite) getGoogleGroupsAndUsers(queryGroups string, queryUsers stri
                         log.WithField("group", g.Email).Debug("ignoring group")
                         continue
                 }
+		if s.protectedGroup(s.groupDisplayName(g)) {
+			log.WithField("group", g.Email).Warn("google group is named as a protected aws group, ignoring it")
+			continue
+		}
                 filteredGoogleGroups = append(filteredGoogleGroups, g)
         }
         gGroups = filteredGoogleGroups
 
         log.Debug("for each group retrieve the group members")
-	for _, g := range gGroups {
-
+	s.transitional = make(map[string]struct{})
+	s.undersized = make(map[string]struct{})
+	s.unconfirmed = make(map[string]struct{})
+	s.suspended = make(map[string]map[string]struct{})
+	deleting := make(map[string]struct{})
+	type groupMembers struct {
+		users []*admin.User
+		err   error
+	}
+	fetched := make([]groupMembers, len(gGroups))
+	ids := newGoogleIDs(gUserDetailCache, gGroupDetailCache)
+	forEachConcurrently(len(gGroups), s.cfg.GoogleMemberFetchConcurrency, func(i int) {
+		if s.ignoreGroup(gGroups[i].Email) {
+			return
+		}
+
+		log.WithField("group", gGroups[i].Name).Debug("get group members from google")
+		users, err := s.getGoogleUsersInGroup(gGroups[i], gUserDetailCache, gGroupDetailCache, ids)
+		fetched[i] = groupMembers{users: users, err: err}
+	})
+
+	for i, g := range gGroups {
+
 		log := log.WithFields(log.Fields{"group": g.Name})
 
 		if s.ignoreGroup(g.Email) {
@@ -595,67 +1753,348 @@ func (s *syncGSuite) getGoogleGroupsAndUsers(queryGroups string, queryUsers stri
 			continue
 		}
 
-		log.Debug("get group members from google")
-		membersUsers := s.getGoogleUsersInGroup(g, gUserDetailCache, gGroupDetailCache)
+		membersUsers, err := fetched[i].users, fetched[i].err
+		if google.IsGroupNotFound(err) {
+			log.WithField("action", s.cfg.TransitionalGroupAction).Warn("google group is being deleted")
+			s.addUnresolved(g.Email, g.Email, unresolvedGroupDeleting)
+			switch s.cfg.TransitionalGroupAction {
+			case config.TransitionalGroupActionDelete:
+				deleting[g.Email] = struct{}{}
+			case config.TransitionalGroupActionDeactivate:
+				gGroupsUsers[s.groupDisplayName(g)] = []*admin.User{}
+				s.transitional[s.groupDisplayName(g)] = struct{}{}
+			default:
+				s.transitional[s.groupDisplayName(g)] = struct{}{}
+			}
+			continue
+		}
+		if err != nil {
+			// without a confirmed member list we must not touch the aws group membership
+			log.WithField("error", err).Warn("unable to confirm group members, membership will not be changed")
+			s.addUnresolved(g.Email, g.Email, unresolvedFetchFailed)
+			s.unconfirmed[s.groupDisplayName(g)] = struct{}{}
+			continue
+		}
 
-		// If we've not seen the user email address before add it to the list of unique users
-		// also, we need to deduplicate the list of members.
+		// we need to deduplicate the list of members.
 		gUniqMembers := make(map[string]*admin.User)
                 for _, m := range membersUsers {
-			_, ok := gUniqUsers[m.PrimaryEmail]
-			if !ok {
-				gUniqUsers[m.PrimaryEmail] = gUserDetailCache[m.PrimaryEmail]
-			}
-
-			_, ok = gUniqMembers[m.PrimaryEmail]
+			_, ok := gUniqMembers[m.PrimaryEmail]
                         if !ok {
                                 gUniqMembers[m.PrimaryEmail] = gUserDetailCache[m.PrimaryEmail]
                         }
 		}
 
+		// If we've not seen the user email address before add it to the list of unique users,
+		// the members of groups too small to be synced are still synced users
+		for email, member := range gUniqMembers {
+			if _, ok := gUniqUsers[email]; !ok {
+				gUniqUsers[email] = member
+			}
+		}
+
+		// groups too small to be synced are left as they are in aws
+		if len(gUniqMembers) < s.cfg.MinGroupMembers {
+			log.WithField("members", len(gUniqMembers)).Info("google group has too few members, skipping it")
+			s.undersized[s.groupDisplayName(g)] = struct{}{}
+			continue
+		}
+
 	        gMembers := make([]*admin.User, 0)
-	        for _, member := range gUniqMembers {
+	        for email, member := range gUniqMembers {
+			// suspended members are synced as inactive users, but only keep
+			// the memberships they already have
+			if s.cfg.GoogleMembersIncludeSuspendedSeparately && member.Suspended {
+				s.addSuspendedMember(s.groupDisplayName(g), email)
+				continue
+			}
                         gMembers = append(gMembers, member)
                 }
-		gGroupsUsers[g.Name] = gMembers
+		gGroupsUsers[s.groupDisplayName(g)] = gMembers
+	}
+
+	// groups being deleted are synced as if they were already gone
+	if len(deleting) > 0 {
+		remaining := make([]*admin.Group, 0, len(gGroups))
+		for _, g := range gGroups {
+			if _, found := deleting[g.Email]; !found {
+				remaining = append(remaining, g)
+			}
+		}
+		gGroups = remaining
+	}
+
+	if s.cfg.SyncGroupAliases {
+		parents := make(map[string]string)
+		for _, g := range gGroups {
+			parents[g.Id] = s.groupDisplayName(g)
+		}
+
+		for _, alias := range s.groupAliases(gGroups, googleGroupName) {
+			// alias groups share the membership of their group, including when it's unconfirmed
+			if members, found := gGroupsUsers[parents[alias.Id]]; found {
+				gGroupsUsers[s.groupDisplayName(alias)] = members
+			}
+			if _, found := s.undersized[parents[alias.Id]]; found {
+				s.undersized[s.groupDisplayName(alias)] = struct{}{}
+			}
+			if _, found := s.unconfirmed[parents[alias.Id]]; found {
+				s.unconfirmed[s.groupDisplayName(alias)] = struct{}{}
+			}
+			if suspended, found := s.suspended[parents[alias.Id]]; found {
+				s.suspended[s.groupDisplayName(alias)] = suspended
+			}
+			gGroups = append(gGroups, alias)
+		}
 	}
 
 	for _, user := range gUniqUsers {
 		gUsers = append(gUsers, user)
 	}
 
+	s.reportUnresolved()
+
 	return gGroups, gUsers, gGroupsUsers, nil
 }
 
+// addSuspendedMember records a suspended member of the aws group
+func (s *syncGSuite) addSuspendedMember(group string, email string) {
+	if _, found := s.suspended[group]; !found {
+		s.suspended[group] = make(map[string]struct{})
+	}
+	s.suspended[group][email] = struct{}{}
+}
+
+// withoutMembers returns the members to remove from each aws group, less
+// the ones kept for that group
+func withoutMembers(members map[string][]*aws.User, kept map[string]map[string]struct{}) map[string][]*aws.User {
+	remaining := make(map[string][]*aws.User, len(members))
+	for group, users := range members {
+		for _, u := range users {
+			if _, found := kept[group][u.Username]; found {
+				log.WithFields(log.Fields{"group": group, "user": u.Username}).Info("keeping suspended member in group")
+				continue
+			}
+			remaining[group] = append(remaining[group], u)
+		}
+	}
+	return remaining
+}
+
+// withoutGoogleUsers returns the google users less the ones of the emails
+func withoutGoogleUsers(users []*admin.User, emails map[string]struct{}) []*admin.User {
+	kept := make([]*admin.User, 0, len(users))
+	for _, u := range users {
+		if _, found := emails[u.PrimaryEmail]; !found {
+			kept = append(kept, u)
+		}
+	}
+	return kept
+}
+
+// withoutGroupMembers returns the users less the aws members of the groups,
+// the users of a group whose google members are unknown may still be in it
+func withoutGroupMembers(users []*aws.User, members map[string][]*aws.User, groups map[string]struct{}) []*aws.User {
+	if len(groups) == 0 {
+		return users
+	}
+
+	kept := make(map[string]struct{})
+	for group := range groups {
+		for _, u := range members[group] {
+			kept[u.Username] = struct{}{}
+		}
+	}
+
+	remaining := make([]*aws.User, 0, len(users))
+	for _, u := range users {
+		if _, found := kept[u.Username]; found {
+			log.WithField("user", u.Username).Warn("keeping user, a group with unconfirmed members may include them")
+			continue
+		}
+		remaining = append(remaining, u)
+	}
+	return remaining
+}
+
+// googleGroupName returns the name of the google group
+func googleGroupName(g *admin.Group) string {
+	return g.Name
+}
+
+// groupSource returns how aws groups are named from google groups, by the
+// configured source or else by def, the convention of the sync method
+func (s *syncGSuite) groupSource(def func(*admin.Group) string) func(*admin.Group) string {
+	switch s.cfg.GroupDisplayNameSource {
+	case config.GroupDisplayNameSourceEmail:
+		return googleGroupEmail
+	case config.GroupDisplayNameSourceName:
+		return googleGroupName
+	}
+	return def
+}
+
+// groupDisplayName returns the name of the aws group of the google group for
+// the groups sync method, with the configured prefix and suffix
+func (s *syncGSuite) groupDisplayName(g *admin.Group) string {
+	return s.prefixedGroupName(s.groupSource(googleGroupName))(g)
+}
+
+// prefixedGroupName returns how aws groups are named from google groups by
+// source, with the configured prefix and suffix
+func (s *syncGSuite) prefixedGroupName(source func(*admin.Group) string) func(*admin.Group) string {
+	return func(g *admin.Group) string {
+		return awsGroupName(source(g), s.cfg.GroupNamePrefix, s.cfg.GroupNameSuffix)
+	}
+}
+
+// awsGroupName returns the aws display name of a google group name
+func awsGroupName(name string, prefix string, suffix string) string {
+	return prefix + name + suffix
+}
+
+// stripGroupName returns the google group name of an aws display name, false
+// when it lacks the prefix or suffix, such groups are not managed by the sync
+func stripGroupName(displayName string, prefix string, suffix string) (string, bool) {
+	if len(displayName) < len(prefix)+len(suffix) ||
+		!strings.HasPrefix(displayName, prefix) || !strings.HasSuffix(displayName, suffix) {
+		return "", false
+	}
+	return displayName[len(prefix) : len(displayName)-len(suffix)], true
+}
+
+// googleGroupEmail returns the email address of the google group
+func googleGroupEmail(g *admin.Group) string {
+	return g.Email
+}
+
+// groupAliases returns a group for each alias of the google groups, named and
+// addressed by the alias and sharing the id of its group so members are
+// fetched from it. Aliases colliding with the name of another group or
+// alias, or that are ignored, are skipped so nothing is synced twice.
+func (s *syncGSuite) groupAliases(groups []*admin.Group, name func(*admin.Group) string) []*admin.Group {
+	names := make(map[string]struct{})
+	for _, g := range groups {
+		names[name(g)] = struct{}{}
+	}
+
+	aliases := make([]*admin.Group, 0)
+	for _, g := range groups {
+		for _, alias := range g.Aliases {
+			if _, found := names[alias]; found {
+				log.WithFields(log.Fields{"group": g.Email, "alias": alias}).Warn("alias is already synced as a group, skipping")
+				continue
+			}
+			if s.ignoreGroup(alias) {
+				log.WithField("alias", alias).Debug("ignoring alias")
+				continue
+			}
+			if s.protectedGroup(awsGroupName(alias, s.cfg.GroupNamePrefix, s.cfg.GroupNameSuffix)) {
+				log.WithField("alias", alias).Warn("alias is named as a protected aws group, ignoring it")
+				continue
+			}
+
+			names[alias] = struct{}{}
+			aliases = append(aliases, &admin.Group{Id: g.Id, Name: alias, Email: alias})
+		}
+	}
+
+	return aliases
+}
+
+// migrateGroupNames renames aws groups that were named after the previous
+// naming convention of a google group to the current one, so they correlate
+// rather than being created again. Groups that already exist under the
+// current name are left alone.
+func (s *syncGSuite) migrateGroupNames(awsGroups []*aws.Group, googleGroups []*admin.Group, current func(*admin.Group) string, previous func(*admin.Group) string) error {
+	byName := make(map[string]*aws.Group)
+	for _, awsGroup := range awsGroups {
+		byName[awsGroup.DisplayName] = awsGroup
+	}
+
+	for _, g := range googleGroups {
+		currentName, previousName := current(g), previous(g)
+		if currentName == previousName {
+			continue
+		}
+		if _, found := byName[currentName]; found {
+			continue
+		}
+
+		awsGroup, found := byName[previousName]
+		if !found {
+			continue
+		}
+
+		log.WithFields(log.Fields{"group": previousName, "name": currentName}).Info("renaming group")
+		if err := s.aws.UpdateGroupDisplayName(s.context(), awsGroup, currentName); err != nil {
+			return err
+		}
+		s.record(auditRecord{Operation: auditUpdateGroup, Group: currentName, GroupID: awsGroup.ID})
+
+		delete(byName, previousName)
+		awsGroup.DisplayName = currentName
+		byName[currentName] = awsGroup
+	}
+
+	return nil
+}
+
+// withoutGroups returns the groups whose display name is not in names
+func withoutGroups(groups []*aws.Group, names map[string]struct{}, reason string) []*aws.Group {
+	if len(names) == 0 {
+		return groups
+	}
+
+	kept := make([]*aws.Group, 0, len(groups))
+	for _, g := range groups {
+		if _, found := names[g.DisplayName]; found {
+			log.WithFields(log.Fields{"group": g.DisplayName, "reason": reason}).Info("not creating group")
+			continue
+		}
+		kept = append(kept, g)
+	}
+	return kept
+}
+
 // getGroupOperations returns the groups of AWS that must be added, deleted and are equals
-func getGroupOperations(awsGroups []*aws.Group, googleGroups []*admin.Group) (add []*aws.Group, delete []*aws.Group, equals []*aws.Group) {
+// aws groups are named by the name of the google group with the prefix and suffix,
+// groups without them are neither correlated nor deleted
+func getGroupOperations(awsGroups []*aws.Group, googleGroups []*admin.Group, name func(*admin.Group) string, prefix string, suffix string) (add []*aws.Group, delete []*aws.Group, equals []*aws.Group) {
 
  	log.Debug("getGroupOperations()")
 	awsMap := make(map[string]*aws.Group)
 	googleMap := make(map[string]struct{})
 
 	for _, awsGroup := range awsGroups {
-		awsMap[awsGroup.DisplayName] = awsGroup
+		if name, ok := stripGroupName(awsGroup.DisplayName, prefix, suffix); ok {
+			awsMap[name] = awsGroup
+		}
 	}
 
 	for _, gGroup := range googleGroups {
-		googleMap[gGroup.Name] = struct{}{}
+		googleMap[name(gGroup)] = struct{}{}
 	}
 
 	// AWS Groups found and not found in google
 	for _, gGroup := range googleGroups {
-		if _, found := awsMap[gGroup.Name]; found {	
+		if _, found := awsMap[name(gGroup)]; found {	
 		 	log.WithField("gGroup", gGroup).Debug("equals")
-			equals = append(equals, awsMap[gGroup.Name])
+			equals = append(equals, awsMap[name(gGroup)])
 		} else {
 		 	log.WithField("gGroup", gGroup).Debug("add")
-			add = append(add, aws.NewGroup(gGroup.Name))
+			add = append(add, aws.NewGroup(awsGroupName(name(gGroup), prefix, suffix)))
 		}
 	}
 
 	// Google Groups founds and not in aws
 	for _, awsGroup := range awsGroups {
-		if _, found := googleMap[awsGroup.DisplayName]; !found {
+		name, ok := stripGroupName(awsGroup.DisplayName, prefix, suffix)
+		if !ok {
+			log.WithField("awsGroup", awsGroup).Debug("unmanaged, no group name prefix or suffix")
+			continue
+		}
+		if _, found := googleMap[name]; !found {
 		 	log.WithField("awsGroup", awsGroup).Debug("delete")
 			delete = append(delete, aws.NewGroup(awsGroup.DisplayName))
 		}
@@ -665,179 +2104,1212 @@ func getGroupOperations(awsGroups []*aws.Group, googleGroups []*admin.Group) (ad
 }
 
 // getUserOperations returns the users of AWS that must be added, deleted, updated and are equals
-func getUserOperations(awsUsers []*aws.User, googleUsers []*admin.User) (add []*aws.User, delete []*aws.User, update []*aws.User, equals []*aws.User) {
+// only the attributes in the allowlist are compared, an empty allowlist compares all
+// aws users missing from google are handled according to unmanagedAction
+func getUserOperations(awsUsers []*aws.User, googleUsers []*admin.User, mapping userMapping, unmanagedAction string) (add []*aws.User, delete []*aws.User, update []*aws.User, equals []*aws.User) {
+
+	log.Debug("getUserOperations()")
+	awsMap := make(map[string]*aws.User)
+	googleMap := make(map[string]struct{})
+
+	for _, awsUser := range awsUsers {
+		awsMap[awsUser.Username] = awsUser
+	}
+
+	for _, gUser := range googleUsers {
+		googleMap[gUser.PrimaryEmail] = struct{}{}
+	}
+
+	// AWS Users found and not found in google
+	for _, gUser := range googleUsers {
+		if awsUser, found := awsMap[gUser.PrimaryEmail]; found {
+			if reasons := getUserUpdateReasons(awsUser, gUser, mapping); len(reasons) > 0 {
+				log.WithFields(log.Fields{"user": gUser.PrimaryEmail, "reasons": reasons}).Info("update")
+				log.WithField("gUser", gUser).Debug("update")
+				log.WithField("awsUser", awsUser).Debug("update")
+				update = append(update, awsUserFromGoogle(gUser, mapping))
+
+			} else {
+			        log.WithField("awsUser", awsUser).Debug("equals")
+				equals = append(equals, awsUser)
+			}
+		} else {
+		        log.WithField("gUser", gUser).Debug("add")
+			add = append(add, awsUserFromGoogle(gUser, mapping))
+		}
+	}
+
+	// Google Users founds and not in aws
+	for _, awsUser := range awsUsers {
+		if _, found := googleMap[awsUser.Username]; !found {
+			switch unmanagedAction {
+			case config.UnmanagedUserActionIgnore:
+				log.WithField("awsUser", awsUser).Debug("ignore")
+			case config.UnmanagedUserActionDisable:
+				if !awsUser.Active {
+					continue
+				}
+				log.WithFields(log.Fields{"user": awsUser.Username, "reasons": []updateReason{updateReasonUnmanaged}}).Info("update")
+				// the update replaces the user, only active is changed
+				disabled := *awsUser
+				disabled.Active = false
+				update = append(update, &disabled)
+			default:
+				log.WithField("awsUser", awsUser).Debug("delete")
+				delete = append(delete, aws.NewUser(awsUser.Name.GivenName, awsUser.Name.FamilyName, awsUser.Username, awsUser.Active))
+			}
+		}
+	}
+
+	return add, delete, update, equals
+}
+
+// userChunk holds the aws and google users of one alphabetical range
+type userChunk struct {
+	aws    []*aws.User
+	google []*admin.User
+}
+
+// chunkUsers splits the users into alphabetical ranges of at most size google
+// users. Ranges never overlap and together cover every user, so a user is
+// only missing from google if it's missing from the google side of its range.
+func chunkUsers(awsUsers []*aws.User, googleUsers []*admin.User, size int) []userChunk {
+	sortedAWS := make([]*aws.User, len(awsUsers))
+	copy(sortedAWS, awsUsers)
+	sort.Slice(sortedAWS, func(i, j int) bool { return sortedAWS[i].Username < sortedAWS[j].Username })
+
+	sortedGoogle := make([]*admin.User, len(googleUsers))
+	copy(sortedGoogle, googleUsers)
+	sort.Slice(sortedGoogle, func(i, j int) bool { return sortedGoogle[i].PrimaryEmail < sortedGoogle[j].PrimaryEmail })
+
+	chunks := make([]userChunk, 0)
+	a, g := 0, 0
+	for g < len(sortedGoogle) {
+		end := g + size
+		if end > len(sortedGoogle) {
+			end = len(sortedGoogle)
+		}
+		// keep users with the same email in the same range
+		for end < len(sortedGoogle) && sortedGoogle[end].PrimaryEmail == sortedGoogle[end-1].PrimaryEmail {
+			end++
+		}
+
+		chunk := userChunk{google: sortedGoogle[g:end]}
+		if end == len(sortedGoogle) {
+			// the last range is open ended
+			chunk.aws = sortedAWS[a:]
+			a = len(sortedAWS)
+		} else {
+			bound := sortedGoogle[end].PrimaryEmail
+			start := a
+			for a < len(sortedAWS) && sortedAWS[a].Username < bound {
+				a++
+			}
+			chunk.aws = sortedAWS[start:a]
+		}
+
+		chunks = append(chunks, chunk)
+		g = end
+	}
+
+	if a < len(sortedAWS) {
+		chunks = append(chunks, userChunk{aws: sortedAWS[a:]})
+	}
+
+	return chunks
+}
+
+// getUserOperationsChunked returns the same operations as getUserOperations,
+// comparing the users in alphabetical batches of size google users so the
+// lookup maps stay small. A size of 0 or less compares all users at once.
+func getUserOperationsChunked(awsUsers []*aws.User, googleUsers []*admin.User, mapping userMapping, unmanagedAction string, size int) (add []*aws.User, delete []*aws.User, update []*aws.User, equals []*aws.User) {
+	if size <= 0 {
+		return getUserOperations(awsUsers, googleUsers, mapping, unmanagedAction)
+	}
+
+	chunks := chunkUsers(awsUsers, googleUsers, size)
+	for i, chunk := range chunks {
+		log.WithFields(log.Fields{"chunk": i + 1, "chunks": len(chunks)}).Debug("reconciling users")
+
+		a, d, u, e := getUserOperations(chunk.aws, chunk.google, mapping, unmanagedAction)
+		add = append(add, a...)
+		delete = append(delete, d...)
+		update = append(update, u...)
+		equals = append(equals, e...)
+	}
+
+	return add, delete, update, equals
+}
+
+// updateReason describes why a user has been selected for update
+type updateReason string
+
+const (
+	// updateReasonName is used when the given or family name has changed
+	updateReasonName updateReason = "name change"
+	// updateReasonEmail is used when the primary email has changed
+	updateReasonEmail updateReason = "email change"
+	// updateReasonStatus is used when the user has been suspended or re-activated
+	updateReasonStatus updateReason = "status change"
+	// updateReasonPhone is used when the primary phone number has changed
+	updateReasonPhone updateReason = "phone change"
+	// updateReasonTitle is used when the title has changed
+	updateReasonTitle updateReason = "title change"
+	// updateReasonUserType is used when the user type has changed
+	updateReasonUserType updateReason = "user type change"
+	// updateReasonLocale is used when the locale has changed
+	updateReasonLocale updateReason = "locale change"
+	// updateReasonTimezone is used when the timezone has changed
+	updateReasonTimezone updateReason = "timezone change"
+	// updateReasonPreferredLanguage is used when the preferred language has changed
+	updateReasonPreferredLanguage updateReason = "preferred language change"
+	// updateReasonUnmanaged is used when a user missing from google is disabled
+	updateReasonUnmanaged updateReason = "not in google"
+	// updateReasonDeleted is used when the user deleted in google is deactivated
+	updateReasonDeleted updateReason = "deleted in google"
+	// updateReasonManager is used when the manager has changed
+	updateReasonManager updateReason = "manager change"
+	// updateReasonExternalID is used when the external id is set or repaired
+	updateReasonExternalID updateReason = "external id change"
+)
+
+// userMapping is how google users are turned into aws users and compared
+// with them
+type userMapping struct {
+	// attributes is the allowlist of the managed attributes, empty manages all
+	attributes []string
+	// allEmails syncs all the emails of the users instead of the primary one
+	allEmails bool
+	// allPhones syncs all the phone numbers of the users instead of the primary one
+	allPhones bool
+	// externalID sets the google user id as the external id of the users
+	externalID bool
+	// title syncs the title of the google organization of the users
+	title bool
+	// userTypeSource is the attribute of the google organization the user type is synced from
+	userTypeSource string
+	// locale syncs the locale from the first google language of the users
+	locale bool
+	// preferredLanguage syncs the preferred language from the first google language of the users
+	preferredLanguage bool
+	// timezoneField is the schema.field of the google custom schemas the timezone is synced from
+	timezoneField string
+}
+
+// newUserMapping returns the user mapping of the config
+func newUserMapping(cfg *config.Config) userMapping {
+	return userMapping{
+		attributes: cfg.SyncAttributes,
+		allEmails:  cfg.SyncAllEmails,
+		allPhones:  cfg.SyncAllPhones,
+		externalID: cfg.BackfillExternalIDs,
+
+		title:          cfg.SyncTitle,
+		userTypeSource: cfg.UserTypeSource,
+
+		locale:            cfg.SyncLocale,
+		preferredLanguage: cfg.SyncPreferredLanguage,
+		timezoneField:     cfg.TimezoneField,
+	}
+}
+
+// syncedAttributes returns the optional SCIM attributes of the mapping,
+// the ones cleared in google are removed from the aws users
+func (m userMapping) syncedAttributes() []string {
+	var synced []string
+	if m.title {
+		synced = append(synced, aws.AttributeTitle)
+	}
+	if m.userTypeSource != config.UserTypeSourceNone && m.userTypeSource != "" {
+		synced = append(synced, aws.AttributeUserType)
+	}
+	if m.locale {
+		synced = append(synced, aws.AttributeLocale)
+	}
+	if m.timezoneField != "" {
+		synced = append(synced, aws.AttributeTimezone)
+	}
+	if m.preferredLanguage {
+		synced = append(synced, aws.AttributePreferredLanguage)
+	}
+	return synced
+}
+
+// getUserUpdateReasons compares the AWS user with its Google counterpart and
+// returns the reasons an update is required, an empty list means they are equal
+// only attributes in the allowlist are compared, an empty allowlist compares all
+func getUserUpdateReasons(awsUser *aws.User, gUser *admin.User, mapping userMapping) []updateReason {
+	reasons := make([]updateReason, 0)
+
+	if attributeAllowed(mapping.attributes, aws.AttributeName) &&
+		(awsUser.Name.GivenName != gUser.Name.GivenName ||
+			awsUser.Name.FamilyName != gUser.Name.FamilyName) {
+		reasons = append(reasons, updateReasonName)
+	}
+
+	// users created outside of ssosync may not have a primary email, so only
+	// flag a change when there is one to compare against
+	if attributeAllowed(mapping.attributes, aws.AttributeEmails) {
+		if mapping.allEmails {
+			if !sameEmails(awsUser.Emails, googleEmails(gUser, true)) {
+				reasons = append(reasons, updateReasonEmail)
+			}
+		} else if primary, ok := primaryEmail(awsUser.Emails, gUser.PrimaryEmail); ok && primary != gUser.PrimaryEmail {
+			reasons = append(reasons, updateReasonEmail)
+		}
+	}
+
+	if attributeAllowed(mapping.attributes, aws.AttributeActive) && awsUser.Active == gUser.Suspended {
+		reasons = append(reasons, updateReasonStatus)
+	}
+
+	if attributeAllowed(mapping.attributes, aws.AttributePhoneNumbers) {
+		current := awsUser.PhoneNumbers
+		if !mapping.allPhones {
+			current = primaryPhoneNumbers(current)
+		}
+		if !samePhoneNumbers(current, googlePhoneNumbers(gUser, mapping.allPhones)) {
+			reasons = append(reasons, updateReasonPhone)
+		}
+	}
+
+	if mapping.title && attributeAllowed(mapping.attributes, aws.AttributeTitle) &&
+		awsUser.Title != googleOrganization(gUser).Title {
+		reasons = append(reasons, updateReasonTitle)
+	}
+
+	if mapping.userTypeSource != config.UserTypeSourceNone && mapping.userTypeSource != "" &&
+		attributeAllowed(mapping.attributes, aws.AttributeUserType) &&
+		awsUser.UserType != googleUserType(gUser, mapping.userTypeSource) {
+		reasons = append(reasons, updateReasonUserType)
+	}
+
+	if mapping.locale && attributeAllowed(mapping.attributes, aws.AttributeLocale) &&
+		awsUser.Locale != googleLanguage(gUser) {
+		reasons = append(reasons, updateReasonLocale)
+	}
+
+	if mapping.timezoneField != "" && attributeAllowed(mapping.attributes, aws.AttributeTimezone) &&
+		awsUser.Timezone != googleTimezone(gUser, mapping.timezoneField) {
+		reasons = append(reasons, updateReasonTimezone)
+	}
+
+	if mapping.preferredLanguage && attributeAllowed(mapping.attributes, aws.AttributePreferredLanguage) &&
+		awsUser.PreferredLanguage != googleLanguage(gUser) {
+		reasons = append(reasons, updateReasonPreferredLanguage)
+	}
+
+	return reasons
+}
+
+// awsUserFromGoogle returns the aws user of the google user
+func awsUserFromGoogle(gUser *admin.User, mapping userMapping) *aws.User {
+	u := aws.NewUser(gUser.Name.GivenName, gUser.Name.FamilyName, gUser.PrimaryEmail, !gUser.Suspended)
+	if mapping.allEmails {
+		u.Emails = googleEmails(gUser, true)
+	}
+	u.PhoneNumbers = googlePhoneNumbers(gUser, mapping.allPhones)
+	if mapping.externalID {
+		u.ExternalID = gUser.Id
+	}
+	if mapping.title {
+		u.Title = googleOrganization(gUser).Title
+	}
+	u.UserType = googleUserType(gUser, mapping.userTypeSource)
+	if mapping.locale {
+		u.Locale = googleLanguage(gUser)
+	}
+	if mapping.preferredLanguage {
+		u.PreferredLanguage = googleLanguage(gUser)
+	}
+	if mapping.timezoneField != "" {
+		u.Timezone = googleTimezone(gUser, mapping.timezoneField)
+	}
+	return u
+}
+
+// googleOrganization returns the organization of the google user flagged
+// primary, the first one when none is, empty when it has none
+func googleOrganization(gUser *admin.User) admin.UserOrganization {
+	if gUser.Organizations == nil {
+		return admin.UserOrganization{}
+	}
+
+	// the organizations are not typed by the admin sdk, so decode them again
+	var organizations []admin.UserOrganization
+	if err := decodeGoogleField(gUser.Organizations, &organizations); err != nil {
+		log.WithFields(log.Fields{"user": gUser.PrimaryEmail, "error": err}).Warn("ignoring unreadable organizations")
+		return admin.UserOrganization{}
+	}
+	if len(organizations) == 0 {
+		return admin.UserOrganization{}
+	}
+
+	for _, o := range organizations {
+		if o.Primary {
+			return o
+		}
+	}
+	return organizations[0]
+}
+
+// googleUserType returns the attribute of the organization of the google
+// user the user type is synced from, empty when it's not synced
+func googleUserType(gUser *admin.User, source string) string {
+	switch source {
+	case config.UserTypeSourceEmployeeType:
+		// the employee type of the admin console is the description
+		return googleOrganization(gUser).Description
+	case config.UserTypeSourceDepartment:
+		return googleOrganization(gUser).Department
+	case config.UserTypeSourceCostCenter:
+		return googleOrganization(gUser).CostCenter
+	}
+	return ""
+}
+
+// googleLanguage returns the first language of the google user, its
+// custom language when it has no language code, empty when it has none.
+// Google has no locale, so the language is used for both the locale and
+// the preferred language.
+func googleLanguage(gUser *admin.User) string {
+	if gUser.Languages == nil {
+		return ""
+	}
+
+	// the languages are not typed by the admin sdk, so decode them again
+	var languages []admin.UserLanguage
+	if err := decodeGoogleField(gUser.Languages, &languages); err != nil {
+		log.WithFields(log.Fields{"user": gUser.PrimaryEmail, "error": err}).Warn("ignoring unreadable languages")
+		return ""
+	}
+	for _, l := range languages {
+		if l.LanguageCode != "" {
+			return l.LanguageCode
+		}
+		if l.CustomLanguage != "" {
+			return l.CustomLanguage
+		}
+	}
+	return ""
+}
+
+// googleTimezone returns the schema.field of the custom schemas of the
+// google user, empty when it's not set or not a string
+func googleTimezone(gUser *admin.User, field string) string {
+	parts := strings.SplitN(field, ".", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	raw, found := gUser.CustomSchemas[parts[0]]
+	if !found {
+		return ""
+	}
+
+	var schema map[string]interface{}
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		log.WithFields(log.Fields{"user": gUser.PrimaryEmail, "schema": parts[0], "error": err}).Warn("ignoring unreadable custom schema")
+		return ""
+	}
+	timezone, _ := schema[parts[1]].(string)
+	return timezone
+}
+
+// googleEmails returns the primary email of the google user, flagged
+// primary, followed by its other emails when all are requested
+func googleEmails(gUser *admin.User, all bool) []aws.UserEmail {
+	emails := []aws.UserEmail{{Value: gUser.PrimaryEmail, Type: "work", Primary: true}}
+	if !all || gUser.Emails == nil {
+		return emails
+	}
+
+	// the emails are not typed by the admin sdk, so decode them again
+	var decoded []admin.UserEmail
+	if err := decodeGoogleField(gUser.Emails, &decoded); err != nil {
+		log.WithFields(log.Fields{"user": gUser.PrimaryEmail, "error": err}).Warn("ignoring unreadable emails")
+		return emails
+	}
+
+	seen := map[string]struct{}{strings.ToLower(gUser.PrimaryEmail): {}}
+	for _, e := range decoded {
+		if _, found := seen[strings.ToLower(e.Address)]; found || e.Address == "" {
+			continue
+		}
+		seen[strings.ToLower(e.Address)] = struct{}{}
+		emails = append(emails, aws.UserEmail{Value: e.Address, Type: scimType(e.Type)})
+	}
+
+	return emails
+}
+
+// googlePhoneNumbers returns the primary phone number of the google user,
+// the first one when none is flagged primary, or all of them when requested
+// with that one flagged primary, and nil when it has none
+func googlePhoneNumbers(gUser *admin.User, all bool) []aws.UserPhoneNumber {
+	if gUser.Phones == nil {
+		return nil
+	}
+
+	// the phones are not typed by the admin sdk, so decode them again
+	var phones []admin.UserPhone
+	if err := decodeGoogleField(gUser.Phones, &phones); err != nil {
+		log.WithFields(log.Fields{"user": gUser.PrimaryEmail, "error": err}).Warn("ignoring unreadable phone numbers")
+		return nil
+	}
+
+	numbers := make([]aws.UserPhoneNumber, 0, len(phones))
+	for _, p := range phones {
+		if p.Value == "" {
+			continue
+		}
+		numbers = append(numbers, aws.UserPhoneNumber{Value: p.Value, Type: scimType(p.Type), Primary: p.Primary})
+	}
+	if len(numbers) == 0 {
+		return nil
+	}
+
+	primary := primaryPhoneNumbers(numbers)[0]
+	if !all {
+		return []aws.UserPhoneNumber{primary}
+	}
+
+	// only one of them can be primary
+	for i := range numbers {
+		numbers[i].Primary = numbers[i].Value == primary.Value && numbers[i].Type == primary.Type
+	}
+	return numbers
+}
+
+// decodeGoogleField decodes a field the admin sdk leaves untyped into v
+func decodeGoogleField(field interface{}, v interface{}) error {
+	b, err := json.Marshal(field)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// scimType returns the SCIM type of a google email or phone number type,
+// custom types are sent as other
+func scimType(t string) string {
+	if t == "" || t == "custom" {
+		return "other"
+	}
+	return t
+}
+
+// primaryPhoneNumbers returns the phone number flagged primary, the first
+// one when none is, as the only one synced
+func primaryPhoneNumbers(numbers []aws.UserPhoneNumber) []aws.UserPhoneNumber {
+	if len(numbers) == 0 {
+		return nil
+	}
+
+	for _, n := range numbers {
+		if n.Primary {
+			return []aws.UserPhoneNumber{n}
+		}
+	}
+
+	first := numbers[0]
+	first.Primary = true
+	return []aws.UserPhoneNumber{first}
+}
+
+// sameEmails reports whether both lists hold the same emails, in any order
+func sameEmails(a []aws.UserEmail, b []aws.UserEmail) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	count := make(map[aws.UserEmail]int)
+	for _, e := range a {
+		e.Value = strings.ToLower(e.Value)
+		count[e]++
+	}
+	for _, e := range b {
+		e.Value = strings.ToLower(e.Value)
+		if count[e] == 0 {
+			return false
+		}
+		count[e]--
+	}
+	return true
+}
+
+// samePhoneNumbers reports whether both lists hold the same phone numbers, in any order
+func samePhoneNumbers(a []aws.UserPhoneNumber, b []aws.UserPhoneNumber) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	count := make(map[aws.UserPhoneNumber]int)
+	for _, n := range a {
+		count[n]++
+	}
+	for _, n := range b {
+		if count[n] == 0 {
+			return false
+		}
+		count[n]--
+	}
+	return true
+}
+
+// primaryEmail returns the email flagged primary, users can have several
+// of them, so the preferred one wins and the lowest one otherwise, the
+// same email is picked no matter the order the emails are returned in
+func primaryEmail(emails []aws.UserEmail, prefer string) (string, bool) {
+	primary, found := "", false
+	for _, email := range emails {
+		if !email.Primary {
+			continue
+		}
+		if email.Value == prefer {
+			return email.Value, true
+		}
+		if !found || email.Value < primary {
+			primary, found = email.Value, true
+		}
+	}
+
+	return primary, found
+}
+
+// attributeAllowed reports whether the attribute is managed, an empty
+// allowlist allows all attributes
+func attributeAllowed(attributes []string, name string) bool {
+	if len(attributes) == 0 {
+		return true
+	}
+
+	for _, a := range attributes {
+		if a == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getGroupAddMembers returns, by group, the google members that are not
+// members of the aws group yet, all of them for groups not in aws
+func getGroupAddMembers(gGroupsUsers map[string][]*admin.User, awsGroupsUsers map[string][]*aws.User) map[string][]*admin.User {
+	add := make(map[string][]*admin.User)
+	for group, gUsers := range gGroupsUsers {
+		members := make(map[string]struct{})
+		for _, u := range awsGroupsUsers[group] {
+			members[u.Username] = struct{}{}
+		}
+
+		for _, u := range gUsers {
+			if _, found := members[u.PrimaryEmail]; !found {
+				add[group] = append(add[group], u)
+			}
+		}
+	}
+
+	return add
+}
+
+// groupUsersOperations returns the groups and its users of AWS that must be delete from these groups and what are equals
+func getGroupUsersOperations(gGroupsUsers map[string][]*admin.User, awsGroupsUsers map[string][]*aws.User) (delete map[string][]*aws.User, equals map[string][]*aws.User) {
+
+ 	log.Debug("getGroupUsersOperations()")
+	mbG := make(map[string]map[string]struct{})
+
+	// get user in google groups that are in aws groups and
+	// users in aws groups that aren't in google groups
+	for gGroupName, gGroupUsers := range gGroupsUsers {
+		mbG[gGroupName] = make(map[string]struct{})
+		for _, gUser := range gGroupUsers {
+			mbG[gGroupName][gUser.PrimaryEmail] = struct{}{}
+		}
+	}
+
+	delete = make(map[string][]*aws.User)
+	equals = make(map[string][]*aws.User)
+	for awsGroupName, awsGroupUsers := range awsGroupsUsers {
+		// groups whose google members could not be confirmed are left untouched
+		if _, found := gGroupsUsers[awsGroupName]; !found {
+			continue
+		}
+		for _, awsUser := range awsGroupUsers {
+			// users that exist in aws groups but doesn't in google groups
+			if _, found := mbG[awsGroupName][awsUser.Username]; found {
+				equals[awsGroupName] = append(equals[awsGroupName], awsUser)
+			} else {
+				delete[awsGroupName] = append(delete[awsGroupName], awsUser)
+			}
+		}
+	}
+
+	return
+}
+
+// DoSync will create a logger and run the sync with the paths
+// given to do the sync. It returns the changes made, also when
+// the sync fails part way through.
+func DoSync(ctx context.Context, cfg *config.Config) (stats SyncStats, err error) {
+	log.Info("Syncing AWS users and groups from Google Workspace SAML Application")
+
+	if err := validateConfig(cfg); err != nil {
+		return SyncStats{}, err
+	}
+
+	if cfg.SyncTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.SyncTimeout)
+		defer cancel()
+	}
+	defer func() {
+		err = timeoutError(ctx, cfg, err)
+	}()
+
+	conn, err := connect(ctx, cfg)
+	if err != nil {
+		return SyncStats{}, err
+	}
+	if err := checkIdentityStore(conn.identityStore, cfg); err != nil {
+		return SyncStats{}, err
+	}
+
+	// Initialize sync client with
+	// 1. SCIM API client
+	// 2. Google Directory API client
+	// 3. Identity Store Public API client
+	c := New(cfg, conn.scim, conn.google, conn.identityStore)
+	c.(*syncGSuite).ctx = ctx
+
+	// the plan and report are written to s3 as lambda has no filesystem to keep them
+	if cfg.PlanS3URI != "" || cfg.ReportS3URI != "" {
+		c.(*syncGSuite).output = s3.New(conn.sess)
+	}
+
+	if cfg.CorrelationCacheS3URI != "" {
+		c.(*syncGSuite).cacheStore = s3.New(conn.sess)
+	}
+
+	c.(*syncGSuite).checkpointer = newCheckpointer(conn.sess, cfg)
+
+	if cfg.ReportPermissionSetImpact {
+		c.(*syncGSuite).ssoAdmin = ssoadmin.New(conn.sess)
+	}
+
+	if cfg.AuditLogPath != "" {
+		f, err := os.OpenFile(cfg.AuditLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return c.Stats(), err
+		}
+		defer f.Close()
+		c.(*syncGSuite).audit = newAuditLog(f)
+	}
+
+	if cfg.SyncGroupMetadataOnly {
+		log.WithField("sync_method", cfg.SyncMethod).Info("syncing group metadata only")
+		return c.Stats(), c.SyncGroupMetadata(cfg.GroupMatch)
+	}
+
+	log.WithField("sync_method", cfg.SyncMethod).Info("syncing")
+	if cfg.SyncMethod == config.DefaultSyncMethod {
+		err = c.SyncGroupsUsers(cfg.GroupMatch, cfg.UserMatch)
+		if err != nil {
+			return c.Stats(), err
+		}
+	} else {
+		err = c.SyncUsers(cfg.UserMatch)
+		if err != nil {
+			return c.Stats(), err
+		}
+
+		err = c.SyncGroups(cfg.GroupMatch)
+		if err != nil {
+			return c.Stats(), err
+		}
+	}
+
+	return c.Stats(), nil
+}
+
+// timeoutError tells a failure caused by the sync timeout from the error of
+// the call it interrupted
+func timeoutError(ctx context.Context, cfg *config.Config, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("sync timed out after %s: %w", cfg.SyncTimeout, err)
+	}
+	return err
+}
+
+// validateConfig checks the options that only accept a fixed set of values
+func validateConfig(cfg *config.Config) error {
+	switch cfg.InvalidUserAction {
+	case config.InvalidUserActionSkip, config.InvalidUserActionFail:
+	default:
+		return fmt.Errorf("unsupported invalid user action %q, expected any of skip,fail", cfg.InvalidUserAction)
+	}
+
+	switch cfg.UserBackend {
+	case config.UserBackendSCIM, config.UserBackendIdentityStore:
+	default:
+		return fmt.Errorf("unsupported user backend %q, expected any of scim,identitystore", cfg.UserBackend)
+	}
+
+	for _, phase := range cfg.OnlyPhases {
+		switch phase {
+		case config.PhaseUsers, config.PhaseGroups, config.PhaseMembers:
+		default:
+			return fmt.Errorf("unsupported phase %q, expected any of users,groups,members", phase)
+		}
+	}
+
+	if _, err := compileGroupPatterns(cfg); err != nil {
+		return err
+	}
+
+	switch cfg.SourceProvider {
+	case config.SourceProviderGoogle:
+	case config.SourceProviderOkta:
+		if cfg.OktaOrgURL == "" || cfg.OktaAPIToken == "" {
+			return errors.New("the okta source provider needs an okta org url and api token")
+		}
+	case config.SourceProviderFile:
+		if cfg.SourceFile == "" {
+			return errors.New("the file source provider needs a source file")
+		}
+	default:
+		return fmt.Errorf("unsupported source provider %q, expected any of google,okta,file", cfg.SourceProvider)
+	}
+
+	if cfg.CheckpointTable != "" && cfg.CheckpointBucket != "" {
+		return errors.New("set either a checkpoint table or a checkpoint bucket, not both")
+	}
+
+	switch cfg.VerifyEqualMembers {
+	case config.VerifyEqualMembersNone, config.VerifyEqualMembersAll:
+	case config.VerifyEqualMembersSample:
+		if cfg.VerifyEqualMembersPercent < 0 || cfg.VerifyEqualMembersPercent > 100 {
+			return fmt.Errorf("invalid verify equal members percent %d, expected 0 to 100", cfg.VerifyEqualMembersPercent)
+		}
+	default:
+		return fmt.Errorf("unsupported verify equal members %q, expected any of none,sample,all", cfg.VerifyEqualMembers)
+	}
+
+	switch cfg.GoogleListProjection {
+	case config.GoogleListProjectionBasic, config.GoogleListProjectionFull:
+	case config.GoogleListProjectionCustom:
+		if cfg.GoogleCustomFieldMask == "" {
+			return errors.New("the custom google list projection needs a custom field mask")
+		}
+	default:
+		return fmt.Errorf("unsupported google list projection %q, expected any of basic,full,custom", cfg.GoogleListProjection)
+	}
+
+	if cfg.GooglePageSize < 0 || cfg.GooglePageSize > google.MaxUsersPageSize {
+		return fmt.Errorf("google page size %d is out of range, expected 1 to %d, or 0 for the api default", cfg.GooglePageSize, google.MaxUsersPageSize)
+	}
+
+	if cfg.SCIMTimeout < 0 || cfg.SyncTimeout < 0 {
+		return errors.New("the scim and sync timeouts can't be negative, expected a duration such as 30s or 0 for no timeout")
+	}
+
+	if cfg.IncrementalSince < 0 {
+		return fmt.Errorf("incremental since %s is negative, expected a duration such as 24h or 0 for a full sync", cfg.IncrementalSince)
+	}
+
+	if cfg.ThrottleCooldownWindow <= 0 {
+		return fmt.Errorf("throttle cooldown window %s is not positive, expected a duration such as 1m", cfg.ThrottleCooldownWindow)
+	}
+
+	if cfg.CorrelationCacheMaxAge < 0 {
+		return fmt.Errorf("correlation cache max age %d is negative, expected a number of minutes or 0 for no limit", cfg.CorrelationCacheMaxAge)
+	}
+
+	switch cfg.UserUpdateStrategy {
+	case config.UserUpdateStrategyReplace, config.UserUpdateStrategyPatch:
+	default:
+		return fmt.Errorf("unsupported user update strategy %q, expected any of replace,patch", cfg.UserUpdateStrategy)
+	}
+
+	switch cfg.UserDeleteStrategy {
+	case config.UserDeleteStrategyDelete, config.UserDeleteStrategyDeactivate:
+	default:
+		return fmt.Errorf("unsupported user delete strategy %q, expected any of delete,deactivate", cfg.UserDeleteStrategy)
+	}
+
+	switch cfg.GroupDisplayNameSource {
+	case "", config.GroupDisplayNameSourceEmail, config.GroupDisplayNameSourceName:
+	default:
+		return fmt.Errorf("unsupported group display name source %q, expected any of email,name", cfg.GroupDisplayNameSource)
+	}
+
+	switch cfg.ManagerKey {
+	case config.ManagerKeyEmail, config.ManagerKeyEmployeeNumber:
+	default:
+		return fmt.Errorf("unsupported manager key %q, expected any of email,employeeNumber", cfg.ManagerKey)
+	}
+
+	switch cfg.TransitionalGroupAction {
+	case config.TransitionalGroupActionSkip, config.TransitionalGroupActionDeactivate, config.TransitionalGroupActionDelete:
+	default:
+		return fmt.Errorf("unsupported transitional group action %q, expected any of skip,deactivate,delete", cfg.TransitionalGroupAction)
+	}
+
+	switch cfg.UserTypeSource {
+	case config.UserTypeSourceNone, config.UserTypeSourceEmployeeType, config.UserTypeSourceDepartment, config.UserTypeSourceCostCenter:
+	default:
+		return fmt.Errorf("unsupported user type source %q, expected any of none,employee-type,department,cost-center", cfg.UserTypeSource)
+	}
+
+	if cfg.TimezoneField != "" {
+		if parts := strings.SplitN(cfg.TimezoneField, ".", 2); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("unsupported timezone field %q, expected schema.field", cfg.TimezoneField)
+		}
+		if cfg.GoogleListProjection == config.GoogleListProjectionBasic {
+			return fmt.Errorf("timezone field %q needs the full or custom google list projection", cfg.TimezoneField)
+		}
+	}
+
+	switch cfg.UnmanagedUserAction {
+	case config.UnmanagedUserActionDelete, config.UnmanagedUserActionDisable, config.UnmanagedUserActionIgnore:
+	default:
+		return fmt.Errorf("unsupported unmanaged user action %q, expected any of delete,disable,ignore", cfg.UnmanagedUserAction)
+	}
+
+	for _, a := range cfg.SyncAttributes {
+		if !aws.IsManagedUserAttribute(a) {
+			return fmt.Errorf("unsupported sync attribute %q, expected any of %s", a, strings.Join(aws.ManagedUserAttributes, ","))
+		}
+	}
+
+	if _, _, err := loadSCIMClientTLS(cfg); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// connections are the clients a sync talks to
+type connections struct {
+	sess          *aws_sdk_sess.Session
+	google        google.Client
+	scim          aws.Client
+	identityStore identitystoreiface.IdentityStoreAPI
+}
+
+// connect creates the google, scim and identity store clients of the config,
+// no call is made to the apis
+func connect(ctx context.Context, cfg *config.Config) (*connections, error) {
+	// Initialize AWS session
+	sess, err := aws_sdk_sess.NewSession(&aws_sdk.Config{
+		// AWS Region to send requests to, provided by config
+		Region: &cfg.Region,
+	})
+
+	if err != nil {
+		log.WithField("error", err).Warn("Problem establising a session for Identity Store")
+		return nil, err
+	}
+	sess.Handlers.Build.PushBack(contextHandler(ctx))
+
+	cooldown := newThrottleCooldown(cfg)
+	if cooldown != nil {
+		cooldownHandlers(&sess.Handlers, cooldown)
+	}
+
+	sourceClient, err := newSourceClient(ctx, cfg, func() (google.Client, error) {
+		secrets := func() credentialsSecrets {
+			return config.NewSecrets(secretsmanager.New(sess))
+		}
+		return newGoogleClient(ctx, cfg, secrets, google.NewClient)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient, err := newSCIMHTTPClient(cfg, cooldown)
+	if err != nil {
+		log.WithField("error", err).Warn("Problem configuring the SCIM transport")
+		return nil, err
+	}
+
+	awsScimClient, err := aws.NewClient(
+		httpClient,
+		&aws.Config{
+			Endpoint: cfg.SCIMEndpoint,
+			Token:    cfg.SCIMAccessToken,
+
+			DisableCreateFallbackFind: cfg.SCIMDisableCreateFallbackFind,
+			Attributes:                cfg.SyncAttributes,
+			UnmarshalRetries:          cfg.SCIMUnmarshalRetries,
+			PatchUpdates:              cfg.UserUpdateStrategy == config.UserUpdateStrategyPatch,
+			TraceQueries:              cfg.TraceQueries,
+			SyncedAttributes:          newUserMapping(cfg).syncedAttributes(),
+		})
+	if err != nil {
+		log.WithField("error", err).Warn("Problem establising a SCIM connection to AWS IAM Identity Center")
+		return nil, err
+	}
+
+	// Initialize AWS Identity Store Public API Client with session
+	identityStoreClient := newRetryingIdentityStore(ctx, identitystore.New(sess), cfg.IdentityStoreMaxRetries)
+
+	return &connections{
+		sess:          sess,
+		google:        sourceClient,
+		scim:          awsScimClient,
+		identityStore: identityStoreClient,
+	}, nil
+}
+
+// newSourceClient returns the client of the directory the users and groups
+// are synced from, the google client is only built when it's the source
+func newSourceClient(ctx context.Context, cfg *config.Config, newGoogle func() (google.Client, error)) (google.Client, error) {
+	switch cfg.SourceProvider {
+	case config.SourceProviderGoogle:
+		return newGoogle()
+	case config.SourceProviderOkta:
+		return okta.NewClient(ctx, &http.Client{Timeout: okta.DefaultTimeout}, &okta.Config{OrgURL: cfg.OktaOrgURL, Token: cfg.OktaAPIToken})
+	case config.SourceProviderFile:
+		return localfile.NewClient(cfg.SourceFile)
+	}
+
+	return nil, fmt.Errorf("unsupported source provider %q, expected any of google,okta,file", cfg.SourceProvider)
+}
+
+// googleClientFunc creates a google client, it's google.NewClient
+type googleClientFunc func(ctx context.Context, adminEmail string, serviceAccountKey []byte, cfg *google.Config) (google.Client, error)
+
+// newGoogleClient creates the client of the google directory with newClient,
+// with the credentials of the config
+func newGoogleClient(ctx context.Context, cfg *config.Config, secrets func() credentialsSecrets, newClient googleClientFunc) (google.Client, error) {
+	creds, err := loadGoogleCredentials(cfg, secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	subjects, err := google.ParseSubjects(cfg.GoogleDelegationSubjects)
+	if err != nil {
+		return nil, err
+	}
+
+	googleClient, err := newClient(ctx, cfg.GoogleAdmin, creds, &google.Config{
+		RetryCodes:          cfg.GoogleRetryCodes,
+		CompactGroupQueries: cfg.GoogleGroupQueryExpansion,
+		Subjects:            subjects,
+		Projection:          cfg.GoogleListProjection,
+		CustomFieldMask:     cfg.GoogleCustomFieldMask,
+		PageSize:            cfg.GooglePageSize,
+	})
+	if err != nil {
+		log.WithField("error", err).Warn("Problem establising a connection to Google directory")
+		return nil, err
+	}
+
+	return googleClient, nil
+}
+
+// checkIdentityStore runs a test query against the identity store
+func checkIdentityStore(identityStoreClient identitystoreiface.IdentityStoreAPI, cfg *config.Config) error {
+	response, err := identityStoreClient.ListGroups(
+		&identitystore.ListGroupsInput{IdentityStoreId: &cfg.IdentityStoreID})
+
+	if err != nil {
+		err = interpretIdentityStoreError(err)
+		log.WithField("error", err).Warn("Problem performing test query against Identity Store")
+		return err
+	}
+	log.WithField("Groups", response).Info("Test call for groups successful")
 
-	log.Debug("getUserOperations()")
-	awsMap := make(map[string]*aws.User)
-	googleMap := make(map[string]struct{})
+	return nil
+}
 
-	for _, awsUser := range awsUsers {
-		awsMap[awsUser.Username] = awsUser
+// checkConnections makes a lightweight call to the identity store and the
+// scim endpoint, to confirm the credentials work
+func checkConnections(ctx context.Context, conn *connections, cfg *config.Config) error {
+	if err := checkIdentityStore(conn.identityStore, cfg); err != nil {
+		return err
 	}
 
-	for _, gUser := range googleUsers {
-		googleMap[gUser.PrimaryEmail] = struct{}{}
+	if err := conn.scim.CheckConnection(ctx); err != nil {
+		log.WithField("error", err).Warn("Problem performing test query against the SCIM endpoint")
+		return err
 	}
+	log.Info("Test call for users successful")
 
-	// AWS Users found and not found in google
-	for _, gUser := range googleUsers {
-		if awsUser, found := awsMap[gUser.PrimaryEmail]; found {
-			if awsUser.Active == gUser.Suspended ||
-				awsUser.Name.GivenName != gUser.Name.GivenName ||
-				awsUser.Name.FamilyName != gUser.Name.FamilyName {
-				log.WithField("gUser", gUser).Debug("update")
-				log.WithField("awsUser", awsUser).Debug("update")
-				update = append(update, aws.NewUser(gUser.Name.GivenName, gUser.Name.FamilyName, gUser.PrimaryEmail, !gUser.Suspended))
+	return nil
+}
 
-			} else {
-			        log.WithField("awsUser", awsUser).Debug("equals")
-				equals = append(equals, awsUser)
-			}
-		} else {
-		        log.WithField("gUser", gUser).Debug("add")
-			add = append(add, aws.NewUser(gUser.Name.GivenName, gUser.Name.FamilyName, gUser.PrimaryEmail, !gUser.Suspended))
-		}
+// Validate checks the config and the connections to the identity store and
+// the scim endpoint without syncing anything. The source client is built, so
+// its credentials are read, but no call is made to the source.
+func Validate(ctx context.Context, cfg *config.Config) error {
+	log.Info("Validating the configuration")
+
+	if err := validateConfig(cfg); err != nil {
+		return err
 	}
 
-	// Google Users founds and not in aws
-	for _, awsUser := range awsUsers {
-		if _, found := googleMap[awsUser.Username]; !found {
-			log.WithField("awsUser", awsUser).Debug("delete")
-			delete = append(delete, aws.NewUser(awsUser.Name.GivenName, awsUser.Name.FamilyName, awsUser.Username, awsUser.Active))
-		}
+	conn, err := connect(ctx, cfg)
+	if err != nil {
+		return err
 	}
 
-	return add, delete, update, equals
+	return checkConnections(ctx, conn, cfg)
 }
 
-// groupUsersOperations returns the groups and its users of AWS that must be delete from these groups and what are equals
-func getGroupUsersOperations(gGroupsUsers map[string][]*admin.User, awsGroupsUsers map[string][]*aws.User) (delete map[string][]*aws.User, equals map[string][]*aws.User) {
+// credentialsSecrets is the part of config.Secrets used to read the google credentials
+type credentialsSecrets interface {
+	GoogleCredentials(string) (string, error)
+}
 
- 	log.Debug("getGroupUsersOperations()")
-	mbG := make(map[string]map[string]struct{})
+// secretsManagerScheme prefixes a secret name given as the google credentials
+const secretsManagerScheme = "secretsmanager://"
 
-	// get user in google groups that are in aws groups and
-	// users in aws groups that aren't in google groups
-	for gGroupName, gGroupUsers := range gGroupsUsers {
-		mbG[gGroupName] = make(map[string]struct{})
-		for _, gUser := range gGroupUsers {
-			mbG[gGroupName][gUser.PrimaryEmail] = struct{}{}
-		}
+// credentialsSecret returns the secret named by the google credentials of a
+// cli run, given as secretsmanager://name or as a secret arn
+func credentialsSecret(creds string) (string, bool) {
+	if strings.HasPrefix(creds, secretsManagerScheme) {
+		return strings.TrimPrefix(creds, secretsManagerScheme), true
 	}
 
-	delete = make(map[string][]*aws.User)
-	equals = make(map[string][]*aws.User)
-	for awsGroupName, awsGroupUsers := range awsGroupsUsers {
-		for _, awsUser := range awsGroupUsers {
-			// users that exist in aws groups but doesn't in google groups
-			if _, found := mbG[awsGroupName][awsUser.Username]; found {
-				equals[awsGroupName] = append(equals[awsGroupName], awsUser)
-			} else {
-				delete[awsGroupName] = append(delete[awsGroupName], awsUser)
-			}
-		}
+	// arn:partition:secretsmanager:region:account:secret:name
+	parts := strings.SplitN(creds, ":", 4)
+	if len(parts) == 4 && parts[0] == "arn" && parts[2] == "secretsmanager" {
+		return creds, true
 	}
 
-	return
+	return "", false
 }
 
-// DoSync will create a logger and run the sync with the paths
-// given to do the sync.
-func DoSync(ctx context.Context, cfg *config.Config) error {
-	log.Info("Syncing AWS users and groups from Google Workspace SAML Application")
-
-	creds := []byte(cfg.GoogleCredentials)
+// loadGoogleCredentials returns the google credentials JSON. It's read from
+// the secret when one is configured, otherwise it's the config value in
+// lambda and, elsewhere, the secret or the file it names.
+func loadGoogleCredentials(cfg *config.Config, secrets func() credentialsSecrets) ([]byte, error) {
+	secret := cfg.GoogleCredentialsSecret
+	if secret == "" && !cfg.IsLambda {
+		secret, _ = credentialsSecret(cfg.GoogleCredentials)
+	}
 
-	if !cfg.IsLambda {
-		b, err := ioutil.ReadFile(cfg.GoogleCredentials)
+	if secret != "" {
+		log.WithField("secret", secret).Debug("reading google credentials from secrets manager")
+		creds, err := secrets().GoogleCredentials(secret)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("cannot read google credentials secret %s: %w", secret, err)
 		}
-		creds = b
+		return []byte(creds), nil
 	}
 
-	// create a http client with retry and backoff capabilities
-	retryClient := retryablehttp.NewClient()
+	if cfg.IsLambda {
+		return []byte(cfg.GoogleCredentials), nil
+	}
 
-	// https://github.com/hashicorp/go-retryablehttp/issues/6
-	if cfg.Debug {
-		retryClient.Logger = log.StandardLogger()
-	} else {
-		retryClient.Logger = nil
+	return ioutil.ReadFile(cfg.GoogleCredentials)
+}
+
+func (s *syncGSuite) ignoreUser(name string) bool {
+	for _, u := range s.cfg.IgnoreUsers {
+		if u == name {
+			return true
+		}
 	}
 
-	httpClient := retryClient.StandardClient()
+	return false
+}
 
-	googleClient, err := google.NewClient(ctx, cfg.GoogleAdmin, creds)
-	if err != nil {
-	        log.WithField("error", err).Warn("Problem establising a connection to Google directory")
-		return err
+// groupPattern matches the display names of aws groups
+type groupPattern func(displayName string) bool
+
+// namePattern returns the pattern of a group given as /regular expression/
+// or by display name, an empty name matches none
+func namePattern(entry string) (groupPattern, error) {
+	if len(entry) < 2 || !strings.HasPrefix(entry, "/") || !strings.HasSuffix(entry, "/") {
+		return func(displayName string) bool { return entry != "" && entry == displayName }, nil
 	}
 
-	awsScimClient, err := aws.NewClient(
-		httpClient,
-		&aws.Config{
-			Endpoint: cfg.SCIMEndpoint,
-			Token:    cfg.SCIMAccessToken,
-		})
+	re, err := regexp.Compile(entry[1 : len(entry)-1])
 	if err != nil {
-	        log.WithField("error", err).Warn("Problem establising a SCIM connection to AWS IAM Identity Center")
-		return err
+		return nil, err
 	}
+	return re.MatchString, nil
+}
 
-	// Initialize AWS session
-	sess, err := aws_sdk_sess.NewSession(&aws_sdk.Config{
-		// AWS Region to send requests to, provided by config
-		Region: &cfg.Region,
-	})
-
-	if err != nil {
-	        log.WithField("error", err).Warn("Problem establising a session for Identity Store")
-		return err
+// globPattern returns the pattern of the groups matching a glob such as eng-*
+func globPattern(glob string) (groupPattern, error) {
+	if _, err := path.Match(glob, ""); err != nil {
+		return nil, err
 	}
+	return func(displayName string) bool {
+		matched, _ := path.Match(glob, displayName)
+		return matched
+	}, nil
+}
 
-	// Initialize AWS Identity Store Public API Client with session
-	identityStoreClient := identitystore.New(sess)
+// membershipRoute routes the membership changes of the matching groups to a backend
+type membershipRoute struct {
+	match   groupPattern
+	backend string
+}
 
-	response, err := identityStoreClient.ListGroups(
-                &identitystore.ListGroupsInput{IdentityStoreId: &cfg.IdentityStoreID})
+// groupPatterns are the compiled --protected-groups and --membership-backend
+type groupPatterns struct {
+	protected []groupPattern
+	routes    []membershipRoute
+}
 
-	if err != nil {
-	        log.WithField("error", err).Warn("Problem performing test query against Identity Store")
-		return err
+// compileGroupPatterns compiles the group patterns of the config, validateConfig
+// rejects the invalid ones with it and the sync compiles them once to match
+// the groups
+func compileGroupPatterns(cfg *config.Config) (*groupPatterns, error) {
+	patterns := &groupPatterns{}
+	for _, g := range cfg.ProtectedGroups {
+		match, err := namePattern(g)
+		if err != nil {
+			return nil, fmt.Errorf("invalid protected group pattern %q: %w", g, err)
+		}
+		patterns.protected = append(patterns.protected, match)
 	}
-	log.WithField("Groups", response).Info("Test call for groups successful")
-
-	// Initialize sync client with
-	// 1. SCIM API client
-	// 2. Google Directory API client
-	// 3. Identity Store Public API client
-	c := New(cfg, awsScimClient, googleClient, identityStoreClient)
 
-	log.WithField("sync_method", cfg.SyncMethod).Info("syncing")
-	if cfg.SyncMethod == config.DefaultSyncMethod {
-		err = c.SyncGroupsUsers(cfg.GroupMatch, cfg.UserMatch)
-		if err != nil {
-			return err
+	for _, route := range cfg.MembershipBackends {
+		parts := strings.SplitN(route, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid membership backend %q, expected pattern=backend", route)
 		}
-	} else {
-		err = c.SyncUsers(cfg.UserMatch)
+		match, err := globPattern(strings.TrimSpace(parts[0]))
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("invalid membership backend pattern %q: %w", parts[0], err)
 		}
-
-		err = c.SyncGroups(cfg.GroupMatch)
-		if err != nil {
-			return err
+		backend := strings.TrimSpace(parts[1])
+		switch backend {
+		case config.MembershipBackendSCIM, config.MembershipBackendIdentityStore:
+		default:
+			return nil, fmt.Errorf("unsupported membership backend %q, expected any of scim,identitystore", parts[1])
 		}
+		patterns.routes = append(patterns.routes, membershipRoute{match: match, backend: backend})
 	}
 
-	return nil
+	return patterns, nil
 }
 
-func (s *syncGSuite) ignoreUser(name string) bool {
-	for _, u := range s.cfg.IgnoreUsers {
-		if u == name {
+// groupPatterns returns the group patterns of the config, compiled on first use
+func (s *syncGSuite) groupPatterns() *groupPatterns {
+	s.patternsOnce.Do(func() {
+		patterns, err := compileGroupPatterns(s.cfg)
+		if err != nil {
+			// validateConfig rejects them before a sync
+			log.WithField("error", err).Warn("invalid group patterns, none is matched")
+			patterns = &groupPatterns{}
+		}
+		s.patterns = patterns
+	})
+	return s.patterns
+}
+
+// protectedGroup reports whether the aws group is in --protected-groups, by
+// display name or matching a /regular expression/, it's then never deleted
+// nor changed
+func (s *syncGSuite) protectedGroup(displayName string) bool {
+	for _, match := range s.groupPatterns().protected {
+		if match(displayName) {
 			return true
 		}
 	}
@@ -845,6 +3317,31 @@ func (s *syncGSuite) ignoreUser(name string) bool {
 	return false
 }
 
+// protectedGroups returns the display names of the groups that are protected
+func (s *syncGSuite) protectedGroups(groups []*aws.Group) map[string]struct{} {
+	names := make(map[string]struct{})
+	for _, g := range groups {
+		if s.protectedGroup(g.DisplayName) {
+			names[g.DisplayName] = struct{}{}
+		}
+	}
+	return names
+}
+
+// withoutProtectedGroups returns the aws groups that aren't protected, the
+// protected ones are left out of the sync so they can't be deleted or changed
+func (s *syncGSuite) withoutProtectedGroups(groups []*aws.Group) []*aws.Group {
+	kept := make([]*aws.Group, 0, len(groups))
+	for _, g := range groups {
+		if s.protectedGroup(g.DisplayName) {
+			log.WithField("group", g.DisplayName).Debug("protected group, leaving it untouched")
+			continue
+		}
+		kept = append(kept, g)
+	}
+	return kept
+}
+
 func (s *syncGSuite) ignoreGroup(name string) bool {
 	for _, g := range s.cfg.IgnoreGroups {
 		if g == name {
@@ -855,6 +3352,22 @@ func (s *syncGSuite) ignoreGroup(name string) bool {
 	return false
 }
 
+// includeUser reports whether the user is in --include-users, all users are
+// included when it's empty
+func (s *syncGSuite) includeUser(name string) bool {
+	if len(s.cfg.IncludeUsers) == 0 {
+		return true
+	}
+
+	for _, u := range s.cfg.IncludeUsers {
+		if u == name {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (s *syncGSuite) includeGroup(name string) bool {
 	for _, g := range s.cfg.IncludeGroups {
 		if g == name {
@@ -950,11 +3463,39 @@ func ConvertSdkUserObjToNative(user *identitystore.User) *aws.User {
 	userAddresses := make([]aws.UserAddress, 0)
 
 	for _, address := range user.Addresses {
+		if address.Type == nil {
+			// as with emails, addresses without a type can't be synced
+			continue
+		}
 		userAddresses = append(userAddresses, aws.UserAddress{
 			Type: *address.Type,
 		})
 	}
 
+	// Convert phone numbers into native PhoneNumber object
+	var userPhoneNumbers []aws.UserPhoneNumber
+
+	for _, phone := range user.PhoneNumbers {
+		if phone.Value == nil {
+			continue
+		}
+		userPhoneNumbers = append(userPhoneNumbers, aws.UserPhoneNumber{
+			Value:   *phone.Value,
+			Type:    aws_sdk.StringValue(phone.Type),
+			Primary: aws_sdk.BoolValue(phone.Primary),
+		})
+	}
+
+	// users created by AWS Control Tower may have no name nor display
+	// name, they are kept with empty ones
+	name := user.Name
+	if name == nil {
+		name = &identitystore.Name{}
+	}
+	if name.FamilyName == nil || name.GivenName == nil || user.DisplayName == nil {
+		log.WithField("user", aws_sdk.StringValue(user.UserName)).Debug("identity store user has no name or display name, using empty ones")
+	}
+
 	return &aws.User{
 		ID:       *user.UserId,
 		Schemas:  []string{"urn:ietf:params:scim:schemas:core:2.0:User"},
@@ -963,12 +3504,20 @@ func ConvertSdkUserObjToNative(user *identitystore.User) *aws.User {
 			FamilyName string `json:"familyName"`
 			GivenName  string `json:"givenName"`
 		}{
-			FamilyName: *user.Name.FamilyName,
-			GivenName:  *user.Name.GivenName,
+			FamilyName: aws_sdk.StringValue(name.FamilyName),
+			GivenName:  aws_sdk.StringValue(name.GivenName),
 		},
-		DisplayName: *user.DisplayName,
+		DisplayName: aws_sdk.StringValue(user.DisplayName),
+		Title:       aws_sdk.StringValue(user.Title),
+		UserType:    aws_sdk.StringValue(user.UserType),
 		Emails:      userEmails,
 		Addresses:   userAddresses,
+
+		PhoneNumbers: userPhoneNumbers,
+
+		Locale:            aws_sdk.StringValue(user.Locale),
+		Timezone:          aws_sdk.StringValue(user.Timezone),
+		PreferredLanguage: aws_sdk.StringValue(user.PreferredLanguage),
 	}
 }
 
@@ -986,44 +3535,96 @@ func CreateUserIDtoUserObjMap(awsUsers []*aws.User) map[string]*aws.User {
 
 // ListGroupMembershipPagesCallbackFn
 // Handler for Paginated Group Membership List
+//
+// Deprecated: each group is now paged with its own callback, so that the
+// groups can be fetched concurrently, and this is no longer set.
 var ListGroupMembershipPagesCallbackFn func(page *identitystore.ListGroupMembershipsOutput, lastPage bool) bool
 
+// GetGroupMembershipsLists returns the members of each aws group, the groups
+// are fetched concurrently by up to MembershipFetchConcurrency workers and
+// the first error stops the remaining fetches
 func (s *syncGSuite) GetGroupMembershipsLists(awsGroups []*aws.Group, awsUsersMap map[string]*aws.User) (map[string][]*aws.User, error) {
 	awsGroupsUsers := make(map[string][]*aws.User)
-	curGroup := &aws.Group{}
+	var mu sync.Mutex
 
-	ListGroupMembershipPagesCallbackFn = func(page *identitystore.ListGroupMembershipsOutput, lastPage bool) bool {
-		for _, member := range page.GroupMemberships { // For every member in the group
-			userID := member.MemberId.UserId
-			user := awsUsersMap[*userID]
+	concurrency := s.cfg.MembershipFetchConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
 
-			// Append new user onto existing list of users
-			awsGroupsUsers[curGroup.DisplayName] = append(awsGroupsUsers[curGroup.DisplayName], user)
-		}
+	ctx, cancel := context.WithCancel(s.context())
+	defer cancel()
 
-		return !lastPage
-	}
+	groups := make(chan *aws.Group)
+	errs := make(chan error, 1)
 
-	// For every group, get the members and assign in awsGroupsUsers map
-	for _, group := range awsGroups {
-		curGroup = group
-		awsGroupsUsers[curGroup.DisplayName] = make([]*aws.User, 0)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range groups {
+				members, err := s.getGroupMembership(ctx, group, awsUsersMap)
+				if err != nil {
+					select {
+					case errs <- err:
+					default:
+					}
+					cancel()
+					return
+				}
 
-		// Get User ID of every member in group
-		err := s.identityStoreClient.ListGroupMembershipsPages(
-			&identitystore.ListGroupMembershipsInput{
-				IdentityStoreId: &s.cfg.IdentityStoreID,
-				GroupId:         &group.ID,
-			}, ListGroupMembershipPagesCallbackFn)
+				mu.Lock()
+				awsGroupsUsers[group.DisplayName] = members
+				mu.Unlock()
+			}
+		}()
+	}
 
-		if err != nil {
-			return nil, err
+feed:
+	for _, group := range awsGroups {
+		select {
+		case groups <- group:
+		case <-ctx.Done():
+			break feed
 		}
 	}
+	close(groups)
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		return nil, err
+	default:
+	}
 
 	return awsGroupsUsers, nil
 }
 
+// getGroupMembership returns the users that are members of the aws group
+func (s *syncGSuite) getGroupMembership(ctx context.Context, group *aws.Group, awsUsersMap map[string]*aws.User) ([]*aws.User, error) {
+	members := make([]*aws.User, 0)
+
+	// Get User ID of every member in group
+	err := s.identityStoreClient.ListGroupMembershipsPagesWithContext(ctx,
+		&identitystore.ListGroupMembershipsInput{
+			IdentityStoreId: &s.cfg.IdentityStoreID,
+			GroupId:         &group.ID,
+		}, func(page *identitystore.ListGroupMembershipsOutput, lastPage bool) bool {
+			for _, member := range page.GroupMemberships { // For every member in the group
+				members = append(members, awsUsersMap[*member.MemberId.UserId])
+			}
+
+			return !lastPage
+		})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
 func (s *syncGSuite) IsUserInGroup(user *aws.User, group *aws.Group) (*bool, error) {
 	isUserInGroupOutput, err := s.identityStoreClient.IsMemberInGroups(
 		&identitystore.IsMemberInGroupsInput{
@@ -1042,6 +3643,97 @@ func (s *syncGSuite) IsUserInGroup(user *aws.User, group *aws.Group) (*bool, err
 	return isUserInGroup, nil
 }
 
+// memberErrors collects the group membership errors of a run that
+// continues past them
+type memberErrors []error
+
+func (e memberErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d group membership changes failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// err returns the collected errors as one, or nil when there are none
+func (e memberErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// memberError returns err when the sync should stop on it. When configured to
+// continue past membership errors it's logged and collected instead, until
+// more than the maximum number of errors are collected.
+func (s *syncGSuite) memberError(errs *memberErrors, err error, user string, group string) error {
+	if err == nil || !s.cfg.ContinueOnMemberError {
+		return err
+	}
+
+	*errs = append(*errs, fmt.Errorf("user %s in group %s: %w", user, group, err))
+	if s.cfg.MaxErrors > 0 && len(*errs) > s.cfg.MaxErrors {
+		log.WithField("max_errors", s.cfg.MaxErrors).Error("too many group membership changes failed, aborting")
+		return *errs
+	}
+
+	log.WithFields(log.Fields{"user": user, "group": group, "error": err}).Error("changing group membership, continuing")
+	return nil
+}
+
+// AddUserToGroup creates the group membership of the user, a membership
+// that already exists is not an error so repeated adds converge
+func (s *syncGSuite) AddUserToGroup(userID *string, groupID *string) error {
+	_, err := s.identityStoreClient.CreateGroupMembership(
+		&identitystore.CreateGroupMembershipInput{
+			IdentityStoreId: &s.cfg.IdentityStoreID,
+			GroupId:         groupID,
+			MemberId:        &identitystore.MemberId{UserId: userID},
+		},
+	)
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == identitystore.ErrCodeConflictException {
+		log.WithFields(log.Fields{"user": *userID, "group": *groupID}).Debug("user already in group")
+		return nil
+	}
+
+	return err
+}
+
+// membershipBackend returns the api the membership changes of the aws group
+// are made with, the first matching pattern=backend of --membership-backend
+// wins and the Identity Store is used by default
+func (s *syncGSuite) membershipBackend(group string) string {
+	for _, route := range s.groupPatterns().routes {
+		if route.match(group) {
+			return route.backend
+		}
+	}
+
+	return config.MembershipBackendIdentityStore
+}
+
+// addMember adds the user to the aws group through its membership backend
+func (s *syncGSuite) addMember(u *aws.User, g *aws.Group) error {
+	if s.membershipBackend(g.DisplayName) == config.MembershipBackendSCIM {
+		return s.aws.AddUserToGroup(s.context(), u, g)
+	}
+	return s.AddUserToGroup(&u.ID, &g.ID)
+}
+
+// removeMember removes the user from the aws group through its membership backend
+func (s *syncGSuite) removeMember(u *aws.User, g *aws.Group) error {
+	if err := s.paceDeletion(); err != nil {
+		return err
+	}
+
+	if s.membershipBackend(g.DisplayName) == config.MembershipBackendSCIM {
+		return s.aws.RemoveUserFromGroup(s.context(), u, g)
+	}
+	return s.RemoveUserFromGroup(&u.ID, &g.ID)
+}
+
 func (s *syncGSuite) RemoveUserFromGroup(userID *string, groupID *string) error {
 	memberIDOutput, err := s.identityStoreClient.GetGroupMembershipId(
 		&identitystore.GetGroupMembershipIdInput{
@@ -1071,18 +3763,67 @@ func (s *syncGSuite) RemoveUserFromGroup(userID *string, groupID *string) error
 	return nil
 }
 
-func (s *syncGSuite) getGoogleUsersInGroup(group *admin.Group, userCache map[string]*admin.User, groupCache map[string]*admin.Group) []*admin.User {
+// googleIDs holds the emails of the google users and groups by id
+type googleIDs struct {
+	users  map[string]string
+	groups map[string]string
+}
+
+// newGoogleIDs indexes the emails of the cached users and groups by id, once
+// per run rather than once per member
+func newGoogleIDs(userCache map[string]*admin.User, groupCache map[string]*admin.Group) googleIDs {
+	ids := googleIDs{
+		users:  make(map[string]string, len(userCache)),
+		groups: make(map[string]string, len(groupCache)),
+	}
+	for email, u := range userCache {
+		if u.Id != "" {
+			ids.users[u.Id] = email
+		}
+	}
+	for email, g := range groupCache {
+		if g.Id != "" {
+			ids.groups[g.Id] = email
+		}
+	}
+	return ids
+}
+
+// memberEmail returns the email of the user or nested group of the member
+// id, empty when there is none
+func (ids googleIDs) memberEmail(m *admin.Member) string {
+	if m.Type == "GROUP" {
+		return ids.groups[m.Id]
+	}
+	return ids.users[m.Id]
+}
+
+func (s *syncGSuite) getGoogleUsersInGroup(group *admin.Group, userCache map[string]*admin.User, groupCache map[string]*admin.Group, ids googleIDs) ([]*admin.User, error) {
 	log.WithField("Email:", group.Email).Debug("getGoogleGroupMembers()")
 
 	 // retrieve the members of the group
 	groupMembers, err := s.google.GetGroupMembers(group)
 	if err != nil {
-		return nil
+		return nil, err
 	}
         membersUsers := make([]*admin.User, 0)
 
 	// process the members of the group
         for _, m := range groupMembers {
+		// some members, such as derived ones, are listed with their id only
+		if m.Email == "" && m.Id != "" {
+			email := ids.memberEmail(m)
+			if email == "" {
+				log.WithField("member_id", m.Id).Warn("member has no email and its id matches no user or group")
+				s.addUnresolved(group.Email, m.Id, unresolvedMissingUser)
+				continue
+			}
+			log.WithFields(log.Fields{"member_id": m.Id, "email": email}).Debug("resolved member by id")
+			resolved := *m
+			resolved.Email = email
+			m = &resolved
+		}
+
         	log.WithField("email", m.Email).Debug("processing member")
                 // Ignore Owners aren't relevant in Identity Store
 		// so are treated as group members.
@@ -1091,9 +3832,11 @@ func (s *syncGSuite) getGoogleUsersInGroup(group *admin.Group, userCache map[str
                 }
 
                 // Ignore any external members, since they don't have users
-                // that can be synced
-                if m.Type == "USER" && m.Status != "ACTIVE" {
+                // that can be synced, suspended members are kept if asked
+                suspended := s.cfg.GoogleMembersIncludeSuspendedSeparately && m.Status == "SUSPENDED"
+                if m.Type == "USER" && m.Status != "ACTIVE" && !suspended {
                         log.WithField("id", m.Email).Warn("ignoring external user")
+			s.addUnresolved(group.Email, m.Email, unresolvedExternal)
                         continue
                 }
 
@@ -1103,17 +3846,33 @@ func (s *syncGSuite) getGoogleUsersInGroup(group *admin.Group, userCache map[str
 		    	log.WithField("Email:", m.Email).Debug("calling getGoogleGroupMembers() for nested group")
 			_, found := groupCache[m.Email]
 			if found {
-                        	membersUsers = append (membersUsers, s.getGoogleUsersInGroup(groupCache[m.Email], userCache, groupCache)...)
+				nestedUsers, err := s.getGoogleUsersInGroup(groupCache[m.Email], userCache, groupCache, ids)
+				if google.IsGroupNotFound(err) {
+					log.WithField("id", m.Email).Warn("nested group is being deleted")
+					s.addUnresolved(group.Email, m.Email, unresolvedMissingGroup)
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				membersUsers = append(membersUsers, nestedUsers...)
 			} else {
                         	log.WithField("id", m.Email).Warn("missing nested group")
+				s.addUnresolved(group.Email, m.Email, unresolvedMissingGroup)
 			}
                         continue
                 }
                 // Remove any users that should be ignored
                 if s.ignoreUser(m.Email) {
                         log.WithField("id", m.Email).Debug("ignoring user")
+			s.addUnresolved(group.Email, m.Email, unresolvedIgnored)
                         continue
                 }
+		if !s.includeUser(m.Email) {
+			log.WithField("id", m.Email).Debug("user not included")
+			s.addUnresolved(group.Email, m.Email, unresolvedNotIncluded)
+			continue
+		}
 
                 // Find the group member in the cache of UserDetails
                 _, found := userCache[m.Email]
@@ -1121,9 +3880,10 @@ func (s *syncGSuite) getGoogleUsersInGroup(group *admin.Group, userCache map[str
                         membersUsers = append(membersUsers, userCache[m.Email])
                 } else {
                         log.WithField("id", m.Email).Warn("missing user")
+			s.addUnresolved(group.Email, m.Email, unresolvedMissingUser)
                         continue
                 }
         }
 
-        return membersUsers
+        return membersUsers, nil
 }
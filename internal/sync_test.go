@@ -16,21 +16,34 @@
 package internal
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"io/ioutil"
 	"log"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"testing"
+	"time"
 
 	aws_sdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/identitystore"
+	"github.com/aws/aws-sdk-go/service/ssoadmin"
 	"github.com/awslabs/ssosync/internal/aws"
 	"github.com/awslabs/ssosync/internal/config"
+	"github.com/awslabs/ssosync/internal/google"
 	"github.com/awslabs/ssosync/internal/mocks"
 	"github.com/golang/mock/gomock"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/googleapi"
 )
 
 // toJSON return a json pretty of the stc
@@ -42,6 +55,151 @@ func toJSON(stc interface{}) []byte {
 	return JSON
 }
 
+// fakeGoogleClient is an in-memory google.Client used to drive the sync logic
+type fakeGoogleClient struct {
+	users        []*admin.User
+	deletedUsers []*admin.User
+	groups       []*admin.Group
+	members      map[string][]*admin.Member
+	memberErrs   map[string]error
+}
+
+func (f *fakeGoogleClient) GetUsers(query string) ([]*admin.User, error) {
+	if query == "" {
+		return []*admin.User{}, nil
+	}
+	return f.users, nil
+}
+
+func (f *fakeGoogleClient) GetDeletedUsers() ([]*admin.User, error) {
+	return f.deletedUsers, nil
+}
+
+func (f *fakeGoogleClient) GetGroups(query string) ([]*admin.Group, error) {
+	if query == "" {
+		return []*admin.Group{}, nil
+	}
+	return f.groups, nil
+}
+
+func (f *fakeGoogleClient) GetGroupMembers(g *admin.Group) ([]*admin.Member, error) {
+	if err, ok := f.memberErrs[g.Email]; ok {
+		return nil, err
+	}
+	return f.members[g.Email], nil
+}
+
+// fakeAWSClient is an in-memory aws.Client recording created users and group renames
+type fakeAWSClient struct {
+	groups   map[string]*aws.Group
+	users    map[string]*aws.User
+	renames  map[string]string
+	managers map[string]string
+	created  []*aws.User
+	updated  []*aws.User
+	checkErr error
+	added    map[string][]string
+	removed  map[string][]string
+	lookups  map[string]int
+	// batches are the emails of each batch lookup
+	batches [][]string
+	// externalIDs are the external ids set, by user id
+	externalIDs map[string]string
+}
+
+func (f *fakeAWSClient) AddUserToGroup(ctx context.Context, u *aws.User, g *aws.Group) error {
+	if f.added == nil {
+		f.added = make(map[string][]string)
+	}
+	f.added[g.DisplayName] = append(f.added[g.DisplayName], u.Username)
+	return nil
+}
+
+func (f *fakeAWSClient) RemoveUserFromGroup(ctx context.Context, u *aws.User, g *aws.Group) error {
+	if f.removed == nil {
+		f.removed = make(map[string][]string)
+	}
+	f.removed[g.DisplayName] = append(f.removed[g.DisplayName], u.Username)
+	return nil
+}
+
+func (f *fakeAWSClient) CheckConnection(ctx context.Context) error {
+	return f.checkErr
+}
+
+func (f *fakeAWSClient) CreateUser(ctx context.Context, u *aws.User) (*aws.User, error) {
+	if f.users == nil {
+		f.users = make(map[string]*aws.User)
+	}
+	u.ID = "id-" + u.Username
+	f.users[u.Username] = u
+	f.created = append(f.created, u)
+	return u, nil
+}
+
+func (f *fakeAWSClient) FindGroupByDisplayName(ctx context.Context, name string) (*aws.Group, error) {
+	if g, ok := f.groups[name]; ok {
+		return g, nil
+	}
+	return nil, aws.ErrGroupNotFound
+}
+
+func (f *fakeAWSClient) FindUserByEmail(ctx context.Context, email string) (*aws.User, error) {
+	if f.lookups == nil {
+		f.lookups = make(map[string]int)
+	}
+	f.lookups[email]++
+	if u, ok := f.users[email]; ok {
+		return u, nil
+	}
+	return nil, aws.ErrUserNotFound
+}
+
+func (f *fakeAWSClient) FindUsersByEmails(ctx context.Context, emails []string) (map[string]*aws.User, error) {
+	f.batches = append(f.batches, emails)
+	users := make(map[string]*aws.User)
+	for _, email := range emails {
+		if u, ok := f.users[email]; ok {
+			users[email] = u
+		}
+	}
+	return users, nil
+}
+
+func (f *fakeAWSClient) UpdateGroupDisplayName(ctx context.Context, g *aws.Group, name string) error {
+	if f.renames == nil {
+		f.renames = make(map[string]string)
+	}
+	f.renames[g.DisplayName] = name
+	return nil
+}
+
+func (f *fakeAWSClient) UpdateUser(ctx context.Context, u *aws.User) (*aws.User, error) {
+	f.updated = append(f.updated, u)
+	return u, nil
+}
+
+func (f *fakeAWSClient) UpdateUserExternalID(ctx context.Context, u *aws.User, externalID string) error {
+	if f.externalIDs == nil {
+		f.externalIDs = make(map[string]string)
+	}
+	f.externalIDs[u.ID] = externalID
+	for _, existing := range f.users {
+		if existing.ID == u.ID {
+			existing.ExternalID = externalID
+		}
+	}
+	return nil
+}
+
+func (f *fakeAWSClient) UpdateUserManager(ctx context.Context, u *aws.User, managerID string) error {
+	if f.managers == nil {
+		f.managers = make(map[string]string)
+	}
+	f.managers[u.ID] = managerID
+	return nil
+}
+
 func Test_getGroupOperations(t *testing.T) {
 	type args struct {
 		awsGroups    []*aws.Group
@@ -104,6 +262,22 @@ func Test_getGroupOperations(t *testing.T) {
 			},
 			wantEquals: nil,
 		},
+		{
+			name: "group recreated in google",
+			args: args{
+				awsGroups: []*aws.Group{
+					{ID: "aws-1", DisplayName: "Group-1"},
+				},
+				googleGroups: []*admin.Group{
+					{Id: "recreated-id", Name: "Group-1", Email: "group-1@email.com"},
+				},
+			},
+			wantAdd:    nil,
+			wantDelete: nil,
+			wantEquals: []*aws.Group{
+				{ID: "aws-1", DisplayName: "Group-1"},
+			},
+		},
 		{
 			name: "add one, delete one and one equal",
 			args: args{
@@ -129,7 +303,7 @@ func Test_getGroupOperations(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotAdd, gotDelete, gotEquals := getGroupOperations(tt.args.awsGroups, tt.args.googleGroups)
+			gotAdd, gotDelete, gotEquals := getGroupOperations(tt.args.awsGroups, tt.args.googleGroups, googleGroupName, "", "")
 			if !reflect.DeepEqual(gotAdd, tt.wantAdd) {
 				t.Errorf("getGroupOperations() gotAdd = %s, want %s", toJSON(gotAdd), toJSON(tt.wantAdd))
 			}
@@ -145,8 +319,9 @@ func Test_getGroupOperations(t *testing.T) {
 
 func Test_getUserOperations(t *testing.T) {
 	type args struct {
-		awsUsers    []*aws.User
-		googleUsers []*admin.User
+		awsUsers        []*aws.User
+		googleUsers     []*admin.User
+		unmanagedAction string
 	}
 	tests := []struct {
 		name       string
@@ -282,10 +457,73 @@ func Test_getUserOperations(t *testing.T) {
 				aws.NewUser("name-2", "lastname-2", "user-2@email.com", true),
 			},
 		},
+		{
+			name: "disable two unmanaged aws users",
+			args: args{
+				awsUsers: []*aws.User{
+					aws.NewUser("name-1", "lastname-1", "user-1@email.com", true),
+					aws.NewUser("name-2", "lastname-2", "user-2@email.com", true),
+					aws.NewUser("name-3", "lastname-3", "user-3@email.com", false),
+				},
+				googleUsers:     nil,
+				unmanagedAction: config.UnmanagedUserActionDisable,
+			},
+			wantAdd:    nil,
+			wantDelete: nil,
+			wantUpdate: []*aws.User{
+				aws.NewUser("name-1", "lastname-1", "user-1@email.com", false),
+				aws.NewUser("name-2", "lastname-2", "user-2@email.com", false),
+			},
+			wantEquals: nil,
+		},
+		{
+			name: "re-enable a disabled user back in google",
+			args: args{
+				awsUsers: []*aws.User{
+					aws.NewUser("name-1", "lastname-1", "user-1@email.com", false),
+				},
+				googleUsers: []*admin.User{
+					{
+						Name: &admin.UserName{
+							GivenName:  "name-1",
+							FamilyName: "lastname-1",
+						},
+						Suspended:    false,
+						PrimaryEmail: "user-1@email.com",
+					},
+				},
+				unmanagedAction: config.UnmanagedUserActionDisable,
+			},
+			wantAdd:    nil,
+			wantDelete: nil,
+			wantUpdate: []*aws.User{
+				aws.NewUser("name-1", "lastname-1", "user-1@email.com", true),
+			},
+			wantEquals: nil,
+		},
+		{
+			name: "ignore two unmanaged aws users",
+			args: args{
+				awsUsers: []*aws.User{
+					aws.NewUser("name-1", "lastname-1", "user-1@email.com", true),
+					aws.NewUser("name-2", "lastname-2", "user-2@email.com", true),
+				},
+				googleUsers:     nil,
+				unmanagedAction: config.UnmanagedUserActionIgnore,
+			},
+			wantAdd:    nil,
+			wantDelete: nil,
+			wantUpdate: nil,
+			wantEquals: nil,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotAdd, gotDelete, gotUpdate, gotEquals := getUserOperations(tt.args.awsUsers, tt.args.googleUsers)
+			action := tt.args.unmanagedAction
+			if action == "" {
+				action = config.UnmanagedUserActionDelete
+			}
+			gotAdd, gotDelete, gotUpdate, gotEquals := getUserOperations(tt.args.awsUsers, tt.args.googleUsers, userMapping{}, action)
 			if !reflect.DeepEqual(gotAdd, tt.wantAdd) {
 				t.Errorf("getUserOperations() gotAdd = %s, want %s", toJSON(gotAdd), toJSON(tt.wantAdd))
 			}
@@ -302,6 +540,333 @@ func Test_getUserOperations(t *testing.T) {
 	}
 }
 
+func Test_getUserUpdateReasons(t *testing.T) {
+	gUser := func(given string, family string, email string, suspended bool) *admin.User {
+		return &admin.User{
+			Name: &admin.UserName{
+				GivenName:  given,
+				FamilyName: family,
+			},
+			Suspended:    suspended,
+			PrimaryEmail: email,
+		}
+	}
+
+	otherEmail := aws.NewUser("name-1", "lastname-1", "user-1@email.com", true)
+	otherEmail.Emails[0].Value = "old-1@email.com"
+
+	// an inconsistent user with two primary emails, in either order
+	twoPrimaries := aws.NewUser("name-1", "lastname-1", "user-1@email.com", true)
+	twoPrimaries.Emails = append([]aws.UserEmail{{Value: "old-1@email.com", Type: "work", Primary: true}}, twoPrimaries.Emails...)
+	twoPrimariesReversed := aws.NewUser("name-1", "lastname-1", "user-1@email.com", true)
+	twoPrimariesReversed.Emails = append(twoPrimariesReversed.Emails, aws.UserEmail{Value: "old-1@email.com", Type: "work", Primary: true})
+
+	tests := []struct {
+		name    string
+		awsUser *aws.User
+		gUser   *admin.User
+		want    []updateReason
+	}{
+		{
+			name:    "no change",
+			awsUser: aws.NewUser("name-1", "lastname-1", "user-1@email.com", true),
+			gUser:   gUser("name-1", "lastname-1", "user-1@email.com", false),
+			want:    []updateReason{},
+		},
+		{
+			name:    "given name change",
+			awsUser: aws.NewUser("name-1", "lastname-1", "user-1@email.com", true),
+			gUser:   gUser("name-2", "lastname-1", "user-1@email.com", false),
+			want:    []updateReason{updateReasonName},
+		},
+		{
+			name:    "family name change",
+			awsUser: aws.NewUser("name-1", "lastname-1", "user-1@email.com", true),
+			gUser:   gUser("name-1", "lastname-2", "user-1@email.com", false),
+			want:    []updateReason{updateReasonName},
+		},
+		{
+			name:    "email change",
+			awsUser: otherEmail,
+			gUser:   gUser("name-1", "lastname-1", "user-1@email.com", false),
+			want:    []updateReason{updateReasonEmail},
+		},
+		{
+			name:    "two primary emails, one matches",
+			awsUser: twoPrimaries,
+			gUser:   gUser("name-1", "lastname-1", "user-1@email.com", false),
+			want:    []updateReason{},
+		},
+		{
+			name:    "two primary emails in reverse order, one matches",
+			awsUser: twoPrimariesReversed,
+			gUser:   gUser("name-1", "lastname-1", "user-1@email.com", false),
+			want:    []updateReason{},
+		},
+		{
+			name:    "two primary emails, none matches",
+			awsUser: twoPrimaries,
+			gUser:   gUser("name-1", "lastname-1", "new-1@email.com", false),
+			want:    []updateReason{updateReasonEmail},
+		},
+		{
+			name:    "status change",
+			awsUser: aws.NewUser("name-1", "lastname-1", "user-1@email.com", true),
+			gUser:   gUser("name-1", "lastname-1", "user-1@email.com", true),
+			want:    []updateReason{updateReasonStatus},
+		},
+		{
+			name:    "name and status change",
+			awsUser: aws.NewUser("name-1", "lastname-1", "user-1@email.com", false),
+			gUser:   gUser("name-2", "lastname-1", "user-1@email.com", false),
+			want:    []updateReason{updateReasonName, updateReasonStatus},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, getUserUpdateReasons(tt.awsUser, tt.gUser, userMapping{}))
+		})
+	}
+}
+
+func Test_getUserUpdateReasonsPhoneNumbers(t *testing.T) {
+	gUser := &admin.User{
+		Name:         &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1"},
+		PrimaryEmail: "user-1@email.com",
+		// as decoded from the directory api
+		Phones: []interface{}{
+			map[string]interface{}{"value": "+1 555 0100", "type": "home"},
+			map[string]interface{}{"value": "+1 555 0101", "type": "work", "primary": true},
+		},
+	}
+
+	awsUser := aws.NewUser("name-1", "lastname-1", "user-1@email.com", true)
+	assert.Equal(t, []updateReason{updateReasonPhone}, getUserUpdateReasons(awsUser, gUser, userMapping{}))
+
+	// excluded attributes are not compared
+	assert.Empty(t, getUserUpdateReasons(awsUser, gUser, userMapping{attributes: []string{aws.AttributeName, aws.AttributeEmails, aws.AttributeActive}}))
+
+	// only the primary phone number is synced
+	synced := awsUserFromGoogle(gUser, userMapping{})
+	assert.Equal(t, []aws.UserPhoneNumber{{Value: "+1 555 0101", Type: "work", Primary: true}}, synced.PhoneNumbers)
+	assert.Empty(t, getUserUpdateReasons(synced, gUser, userMapping{}))
+
+	// without a phone number flagged primary, the first one is
+	gUser.Phones = []interface{}{map[string]interface{}{"value": "+1 555 0100", "type": "home"}}
+	assert.Equal(t, []aws.UserPhoneNumber{{Value: "+1 555 0100", Type: "home", Primary: true}}, googlePhoneNumbers(gUser, false))
+
+	gUser.Phones = nil
+	assert.Nil(t, googlePhoneNumbers(gUser, false))
+}
+
+func Test_awsUserFromGoogleAllEmailsAndPhones(t *testing.T) {
+	gUser := &admin.User{
+		Name:         &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1"},
+		PrimaryEmail: "user-1@email.com",
+		// as decoded from the directory api
+		Emails: []interface{}{
+			map[string]interface{}{"address": "user-1@email.com", "primary": true},
+			map[string]interface{}{"address": "alias-1@email.com", "type": "work"},
+			map[string]interface{}{"address": "user-1@home.com", "type": "home"},
+			map[string]interface{}{"address": "user-1@custom.com", "type": "custom", "customType": "lab"},
+		},
+		Phones: []interface{}{
+			map[string]interface{}{"value": "+1 555 0100", "type": "home"},
+			map[string]interface{}{"value": "+1 555 0101", "type": "work", "primary": true},
+			map[string]interface{}{"value": "+1 555 0102", "type": "mobile"},
+		},
+	}
+
+	primaryOnly := awsUserFromGoogle(gUser, userMapping{})
+	assert.Equal(t, []aws.UserEmail{{Value: "user-1@email.com", Type: "work", Primary: true}}, primaryOnly.Emails)
+	assert.Equal(t, []aws.UserPhoneNumber{{Value: "+1 555 0101", Type: "work", Primary: true}}, primaryOnly.PhoneNumbers)
+
+	mapping := userMapping{allEmails: true, allPhones: true}
+	all := awsUserFromGoogle(gUser, mapping)
+	assert.Equal(t, []aws.UserEmail{
+		{Value: "user-1@email.com", Type: "work", Primary: true},
+		{Value: "alias-1@email.com", Type: "work"},
+		{Value: "user-1@home.com", Type: "home"},
+		{Value: "user-1@custom.com", Type: "other"},
+	}, all.Emails)
+	assert.Equal(t, []aws.UserPhoneNumber{
+		{Value: "+1 555 0100", Type: "home"},
+		{Value: "+1 555 0101", Type: "work", Primary: true},
+		{Value: "+1 555 0102", Type: "mobile"},
+	}, all.PhoneNumbers)
+
+	// the identity store may return them in another order
+	reordered := awsUserFromGoogle(gUser, mapping)
+	reordered.Emails[0], reordered.Emails[3] = reordered.Emails[3], reordered.Emails[0]
+	reordered.PhoneNumbers[0], reordered.PhoneNumbers[2] = reordered.PhoneNumbers[2], reordered.PhoneNumbers[0]
+	assert.Empty(t, getUserUpdateReasons(reordered, gUser, mapping))
+
+	// a missing email or phone number is an update
+	missing := awsUserFromGoogle(gUser, mapping)
+	missing.Emails = missing.Emails[:2]
+	missing.PhoneNumbers = missing.PhoneNumbers[1:]
+	assert.Equal(t, []updateReason{updateReasonEmail, updateReasonPhone}, getUserUpdateReasons(missing, gUser, mapping))
+
+	// only the primary ones are compared by default
+	assert.Empty(t, getUserUpdateReasons(missing, gUser, userMapping{}))
+}
+
+func Test_awsUserFromGoogleTitleAndUserType(t *testing.T) {
+	gUser := &admin.User{
+		Name:         &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1"},
+		PrimaryEmail: "user-1@email.com",
+		// as decoded from the directory api
+		Organizations: []interface{}{
+			map[string]interface{}{"title": "Intern", "department": "Sales"},
+			map[string]interface{}{"title": "Engineer", "department": "R&D", "costCenter": "cc-1", "description": "Contractor", "primary": true},
+		},
+	}
+
+	// neither is synced by default
+	synced := awsUserFromGoogle(gUser, userMapping{})
+	assert.Empty(t, synced.Title)
+	assert.Empty(t, synced.UserType)
+
+	// the primary organization is used
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{source: config.UserTypeSourceNone, want: ""},
+		{source: config.UserTypeSourceEmployeeType, want: "Contractor"},
+		{source: config.UserTypeSourceDepartment, want: "R&D"},
+		{source: config.UserTypeSourceCostCenter, want: "cc-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			mapping := userMapping{title: true, userTypeSource: tt.source}
+			synced := awsUserFromGoogle(gUser, mapping)
+			assert.Equal(t, "Engineer", synced.Title)
+			assert.Equal(t, tt.want, synced.UserType)
+			assert.Empty(t, getUserUpdateReasons(synced, gUser, mapping))
+		})
+	}
+
+	// a changed title or user type is an update, only when it's synced
+	mapping := userMapping{title: true, userTypeSource: config.UserTypeSourceEmployeeType}
+	changed := awsUserFromGoogle(gUser, mapping)
+	changed.Title = "Intern"
+	changed.UserType = "Employee"
+	assert.Equal(t, []updateReason{updateReasonTitle, updateReasonUserType}, getUserUpdateReasons(changed, gUser, mapping))
+	assert.Empty(t, getUserUpdateReasons(changed, gUser, userMapping{userTypeSource: config.UserTypeSourceNone}))
+	assert.Empty(t, getUserUpdateReasons(changed, gUser, userMapping{title: true, userTypeSource: config.UserTypeSourceEmployeeType, attributes: []string{aws.AttributeName}}))
+
+	// without an organization they are empty
+	gUser.Organizations = nil
+	synced = awsUserFromGoogle(gUser, mapping)
+	assert.Empty(t, synced.Title)
+	assert.Empty(t, synced.UserType)
+
+	// and removed from the aws users when they're synced
+	assert.Equal(t, []string{aws.AttributeTitle, aws.AttributeUserType}, mapping.syncedAttributes())
+	assert.Empty(t, userMapping{userTypeSource: config.UserTypeSourceNone}.syncedAttributes())
+}
+
+func Test_awsUserFromGoogleLocale(t *testing.T) {
+	gUser := &admin.User{
+		Name:         &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1"},
+		PrimaryEmail: "user-1@email.com",
+		// as decoded from the directory api
+		Languages: []interface{}{
+			map[string]interface{}{"languageCode": "fr-CA"},
+			map[string]interface{}{"languageCode": "en"},
+		},
+		CustomSchemas: map[string]googleapi.RawMessage{
+			"Location": googleapi.RawMessage(`{"Timezone": "America/Toronto", "Floor": 3}`),
+		},
+	}
+
+	// none are synced by default
+	synced := awsUserFromGoogle(gUser, userMapping{})
+	assert.Empty(t, synced.Locale)
+	assert.Empty(t, synced.Timezone)
+	assert.Empty(t, synced.PreferredLanguage)
+
+	mapping := userMapping{locale: true, preferredLanguage: true, timezoneField: "Location.Timezone"}
+	synced = awsUserFromGoogle(gUser, mapping)
+	assert.Equal(t, "fr-CA", synced.Locale)
+	assert.Equal(t, "America/Toronto", synced.Timezone)
+	assert.Equal(t, "fr-CA", synced.PreferredLanguage)
+	assert.Empty(t, getUserUpdateReasons(synced, gUser, mapping))
+
+	// a changed one is an update, only when it's synced
+	changed := awsUserFromGoogle(gUser, mapping)
+	changed.Locale = "en"
+	changed.Timezone = "Europe/Paris"
+	changed.PreferredLanguage = "en"
+	assert.Equal(t, []updateReason{updateReasonLocale, updateReasonTimezone, updateReasonPreferredLanguage}, getUserUpdateReasons(changed, gUser, mapping))
+	assert.Empty(t, getUserUpdateReasons(changed, gUser, userMapping{}))
+	assert.Empty(t, getUserUpdateReasons(changed, gUser, userMapping{locale: true, preferredLanguage: true, timezoneField: "Location.Timezone", attributes: []string{aws.AttributeName}}))
+
+	// a field that isn't a string or a missing schema is no timezone
+	assert.Equal(t, []string{aws.AttributeLocale, aws.AttributeTimezone, aws.AttributePreferredLanguage}, mapping.syncedAttributes())
+	assert.Empty(t, awsUserFromGoogle(gUser, userMapping{timezoneField: "Location.Floor"}).Timezone)
+	assert.Empty(t, awsUserFromGoogle(gUser, userMapping{timezoneField: "Desk.Timezone"}).Timezone)
+
+	// without languages or custom schemas they are empty
+	gUser.Languages = nil
+	gUser.CustomSchemas = nil
+	synced = awsUserFromGoogle(gUser, mapping)
+	assert.Empty(t, synced.Locale)
+	assert.Empty(t, synced.Timezone)
+	assert.Empty(t, synced.PreferredLanguage)
+}
+
+func Test_primaryEmail(t *testing.T) {
+	emails := []aws.UserEmail{
+		{Value: "b@email.com", Type: "work", Primary: true},
+		{Value: "c@email.com", Type: "home"},
+		{Value: "a@email.com", Type: "work", Primary: true},
+	}
+	reversed := []aws.UserEmail{emails[2], emails[1], emails[0]}
+
+	for _, e := range [][]aws.UserEmail{emails, reversed} {
+		// the preferred email wins, the lowest one otherwise
+		primary, ok := primaryEmail(e, "b@email.com")
+		assert.True(t, ok)
+		assert.Equal(t, "b@email.com", primary)
+
+		primary, ok = primaryEmail(e, "c@email.com")
+		assert.True(t, ok)
+		assert.Equal(t, "a@email.com", primary)
+	}
+
+	_, ok := primaryEmail([]aws.UserEmail{{Value: "c@email.com", Type: "home"}}, "c@email.com")
+	assert.False(t, ok)
+}
+
+func Test_getUserOperationsAttributeAllowlist(t *testing.T) {
+	awsUsers := []*aws.User{
+		aws.NewUser("name-1", "lastname-1", "user-1@email.com", true),
+		aws.NewUser("name-2", "lastname-2", "user-2@email.com", true),
+	}
+	googleUsers := []*admin.User{
+		{
+			Name:         &admin.UserName{GivenName: "renamed-1", FamilyName: "lastname-1"},
+			PrimaryEmail: "user-1@email.com",
+		},
+		{
+			Name:         &admin.UserName{GivenName: "name-2", FamilyName: "lastname-2"},
+			Suspended:    true,
+			PrimaryEmail: "user-2@email.com",
+		},
+	}
+
+	// the name is not managed so only the suspended user is updated
+	_, _, update, equals := getUserOperations(awsUsers, googleUsers, userMapping{attributes: []string{aws.AttributeActive}}, config.UnmanagedUserActionDelete)
+	assert.Equal(t, []*aws.User{aws.NewUser("name-2", "lastname-2", "user-2@email.com", false)}, update)
+	assert.Equal(t, []*aws.User{awsUsers[0]}, equals)
+
+	// with every attribute managed both users are updated
+	_, _, update, _ = getUserOperations(awsUsers, googleUsers, userMapping{}, config.UnmanagedUserActionDelete)
+	assert.Len(t, update, 2)
+}
+
 func Test_getGroupUsersOperations(t *testing.T) {
 	type args struct {
 		gGroupsUsers   map[string][]*admin.User
@@ -346,6 +911,38 @@ func Test_getGroupUsersOperations(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "confirmed empty google group removes all members",
+			args: args{
+				gGroupsUsers: map[string][]*admin.User{
+					"group-1": {},
+				},
+				awsGroupsUsers: map[string][]*aws.User{
+					"group-1": {
+						aws.NewUser("name-1", "lastname-1", "user-1@email.com", true),
+					},
+				},
+			},
+			wantDelete: map[string][]*aws.User{
+				"group-1": {
+					aws.NewUser("name-1", "lastname-1", "user-1@email.com", true),
+				},
+			},
+			wantEquals: map[string][]*aws.User{},
+		},
+		{
+			name: "unconfirmed google group is left untouched",
+			args: args{
+				gGroupsUsers: map[string][]*admin.User{},
+				awsGroupsUsers: map[string][]*aws.User{
+					"group-1": {
+						aws.NewUser("name-1", "lastname-1", "user-1@email.com", true),
+					},
+				},
+			},
+			wantDelete: map[string][]*aws.User{},
+			wantEquals: map[string][]*aws.User{},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -578,6 +1175,7 @@ func Test_GetUsersWithoutPagination(t *testing.T) {
 				GivenName:  "User",
 			},
 			DisplayName: "User 1",
+			Title:       "Example title",
 			Emails: []aws.UserEmail{
 				{Primary: true, Type: "work", Value: "user-1@example.com"},
 			},
@@ -778,6 +1376,7 @@ func Test_ConvertSdkUserObjToNative(t *testing.T) {
 			GivenName:  "User",
 		},
 		DisplayName: "User 1",
+		Title:       "Example title",
 		Emails: []aws.UserEmail{
 			{Primary: true, Type: "work", Value: "user-1@example.com"},
 		},
@@ -789,6 +1388,41 @@ func Test_ConvertSdkUserObjToNative(t *testing.T) {
 	assert.True(t, reflect.DeepEqual(expectedOutput, actualOutput))
 }
 
+func Test_ConvertSdkUserObjToNativeAddressWithoutType(t *testing.T) {
+	sampleInput := &identitystore.User{
+		UserId:   aws_sdk.String("user-1-test-id"),
+		UserName: aws_sdk.String("user-1@example.com"),
+		Addresses: []*identitystore.Address{
+			{Country: aws_sdk.String("Canada")},
+			{Type: aws_sdk.String("Home"), Country: aws_sdk.String("Canada")},
+		},
+	}
+
+	var actualOutput *aws.User
+	assert.NotPanics(t, func() { actualOutput = ConvertSdkUserObjToNative(sampleInput) })
+	assert.Equal(t, []aws.UserAddress{{Type: "Home"}}, actualOutput.Addresses)
+}
+
+func Test_ConvertSdkUserObjToNativeWithoutName(t *testing.T) {
+	// users created by AWS Control Tower can have no name nor display name
+	users := []*identitystore.User{
+		{UserId: aws_sdk.String("user-1-test-id"), UserName: aws_sdk.String("user-1@example.com")},
+		{
+			UserId:   aws_sdk.String("user-2-test-id"),
+			UserName: aws_sdk.String("user-2@example.com"),
+			Name:     &identitystore.Name{GivenName: aws_sdk.String("User")},
+		},
+	}
+
+	for _, u := range users {
+		var actualOutput *aws.User
+		assert.NotPanics(t, func() { actualOutput = ConvertSdkUserObjToNative(u) })
+		assert.Equal(t, *u.UserName, actualOutput.Username)
+		assert.Equal(t, "", actualOutput.Name.FamilyName)
+		assert.Equal(t, "", actualOutput.DisplayName)
+	}
+}
+
 func Test_CreateUserIDtoUserObjMap(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -876,19 +1510,6 @@ func Test_CreateUserIDtoUserObjMap(t *testing.T) {
 }
 
 func Test_GetGroupMembershipsLists(t *testing.T) {
-	ctrl := gomock.NewController(t)
-	defer ctrl.Finish()
-
-	mockIdentityStoreClient := mocks.NewMockIdentityStoreAPI(ctrl)
-
-	mockClient := &syncGSuite{
-		aws:                 nil,
-		google:              nil,
-		cfg:                 &config.Config{IdentityStoreID: "test-identity-store-id"},
-		identityStoreClient: mockIdentityStoreClient,
-		users:               make(map[string]*aws.User),
-	}
-
 	sampleGroupsInput := []*aws.Group{
 		{ID: "a", DisplayName: "a"},
 		{ID: "b", DisplayName: "b"},
@@ -906,50 +1527,110 @@ func Test_GetGroupMembershipsLists(t *testing.T) {
 	expectedOutput["b"] = []*aws.User{{ID: "2"}, {ID: "3"}, {ID: "4"}}
 	expectedOutput["c"] = []*aws.User{}
 
-	sampleResponseGroupA := &identitystore.ListGroupMembershipsOutput{
-		GroupMemberships: []*identitystore.GroupMembership{
-			{GroupId: aws_sdk.String("a"), MemberId: &identitystore.MemberId{UserId: aws_sdk.String("1")}},
-			{GroupId: aws_sdk.String("a"), MemberId: &identitystore.MemberId{UserId: aws_sdk.String("2")}},
+	sampleResponses := map[string]*identitystore.ListGroupMembershipsOutput{
+		"a": {
+			GroupMemberships: []*identitystore.GroupMembership{
+				{GroupId: aws_sdk.String("a"), MemberId: &identitystore.MemberId{UserId: aws_sdk.String("1")}},
+				{GroupId: aws_sdk.String("a"), MemberId: &identitystore.MemberId{UserId: aws_sdk.String("2")}},
+			},
 		},
-	}
-
-	sampleResponseGroupB := &identitystore.ListGroupMembershipsOutput{
-		GroupMemberships: []*identitystore.GroupMembership{
-			{GroupId: aws_sdk.String("b"), MemberId: &identitystore.MemberId{UserId: aws_sdk.String("2")}},
-			{GroupId: aws_sdk.String("b"), MemberId: &identitystore.MemberId{UserId: aws_sdk.String("3")}},
-			{GroupId: aws_sdk.String("b"), MemberId: &identitystore.MemberId{UserId: aws_sdk.String("4")}},
+		"b": {
+			GroupMemberships: []*identitystore.GroupMembership{
+				{GroupId: aws_sdk.String("b"), MemberId: &identitystore.MemberId{UserId: aws_sdk.String("2")}},
+				{GroupId: aws_sdk.String("b"), MemberId: &identitystore.MemberId{UserId: aws_sdk.String("3")}},
+				{GroupId: aws_sdk.String("b"), MemberId: &identitystore.MemberId{UserId: aws_sdk.String("4")}},
+			},
+		},
+		"c": {
+			GroupMemberships: []*identitystore.GroupMembership{},
 		},
 	}
 
-	sampleResponseGroupC := &identitystore.ListGroupMembershipsOutput{
-		GroupMemberships: []*identitystore.GroupMembership{},
-	}
-
-	callbackWithSampleRespGroupA := func(inp *identitystore.ListGroupMembershipsInput, callback func(output *identitystore.ListGroupMembershipsOutput, lastPage bool) bool) {
-		ListGroupMembershipPagesCallbackFn(sampleResponseGroupA, false)
-	}
-
-	callbackWithSampleRespGroupB := func(inp *identitystore.ListGroupMembershipsInput, callback func(output *identitystore.ListGroupMembershipsOutput, lastPage bool) bool) {
-		ListGroupMembershipPagesCallbackFn(sampleResponseGroupB, false)
+	// the responses are picked by group as the calls can happen in any order
+	callbackWithSampleResp := func(ctx aws_sdk.Context, inp *identitystore.ListGroupMembershipsInput, callback func(output *identitystore.ListGroupMembershipsOutput, lastPage bool) bool, opts ...request.Option) error {
+		callback(sampleResponses[*inp.GroupId], true)
+		return nil
 	}
 
-	callbackWithSampleRespGroupC := func(inp *identitystore.ListGroupMembershipsInput, callback func(output *identitystore.ListGroupMembershipsOutput, lastPage bool) bool) {
-		ListGroupMembershipPagesCallbackFn(sampleResponseGroupC, false)
-	}
+	for _, concurrency := range []int{0, 1, 2, 5} {
+		t.Run(strconv.Itoa(concurrency), func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
 
-	mockIdentityStoreClient.EXPECT().ListGroupMembershipsPages(gomock.Any(), gomock.Any()).MaxTimes(1).
-		Do(callbackWithSampleRespGroupA).Return(nil)
+			mockIdentityStoreClient := mocks.NewMockIdentityStoreAPI(ctrl)
 
-	mockIdentityStoreClient.EXPECT().ListGroupMembershipsPages(gomock.Any(), gomock.Any()).MaxTimes(1).
-		Do(callbackWithSampleRespGroupB).Return(nil)
+			mockClient := &syncGSuite{
+				aws:                 nil,
+				google:              nil,
+				cfg:                 &config.Config{IdentityStoreID: "test-identity-store-id", MembershipFetchConcurrency: concurrency},
+				identityStoreClient: mockIdentityStoreClient,
+				users:               make(map[string]*aws.User),
+			}
 
-	mockIdentityStoreClient.EXPECT().ListGroupMembershipsPages(gomock.Any(), gomock.Any()).MaxTimes(1).
-		Do(callbackWithSampleRespGroupC).Return(nil)
+			mockIdentityStoreClient.EXPECT().ListGroupMembershipsPagesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Times(3).
+				DoAndReturn(callbackWithSampleResp)
 
-	actualOutput, err := mockClient.GetGroupMembershipsLists(sampleGroupsInput, sampleUsersMapInput)
+			actualOutput, err := mockClient.GetGroupMembershipsLists(sampleGroupsInput, sampleUsersMapInput)
 
-	assert.True(t, reflect.DeepEqual(expectedOutput, actualOutput))
-	assert.Nil(t, err)
+			assert.True(t, reflect.DeepEqual(expectedOutput, actualOutput))
+			assert.Nil(t, err)
+		})
+	}
+}
+
+func Test_GetGroupMembershipsListsContext(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	type ctxKey struct{}
+	mockIdentityStoreClient := mocks.NewMockIdentityStoreAPI(ctrl)
+
+	mockClient := &syncGSuite{
+		cfg:                 &config.Config{IdentityStoreID: "test-identity-store-id", MembershipFetchConcurrency: 2},
+		identityStoreClient: mockIdentityStoreClient,
+		users:               make(map[string]*aws.User),
+		ctx:                 context.WithValue(context.Background(), ctxKey{}, "sync"),
+	}
+
+	// the calls are made within the context of the sync
+	mockIdentityStoreClient.EXPECT().ListGroupMembershipsPagesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Times(2).
+		DoAndReturn(func(ctx aws_sdk.Context, inp *identitystore.ListGroupMembershipsInput, callback func(output *identitystore.ListGroupMembershipsOutput, lastPage bool) bool, opts ...request.Option) error {
+			assert.Equal(t, "sync", ctx.Value(ctxKey{}))
+			callback(&identitystore.ListGroupMembershipsOutput{}, true)
+			return nil
+		})
+
+	_, err := mockClient.GetGroupMembershipsLists([]*aws.Group{{ID: "a", DisplayName: "a"}, {ID: "b", DisplayName: "b"}}, map[string]*aws.User{})
+	assert.NoError(t, err)
+}
+
+func Test_GetGroupMembershipsListsError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockIdentityStoreClient := mocks.NewMockIdentityStoreAPI(ctrl)
+
+	mockClient := &syncGSuite{
+		cfg:                 &config.Config{IdentityStoreID: "test-identity-store-id", MembershipFetchConcurrency: 2},
+		identityStoreClient: mockIdentityStoreClient,
+		users:               make(map[string]*aws.User),
+	}
+
+	groups := make([]*aws.Group, 0)
+	for i := 0; i < 20; i++ {
+		groups = append(groups, &aws.Group{ID: strconv.Itoa(i), DisplayName: strconv.Itoa(i)})
+	}
+
+	failure := errors.New("throttled")
+	mockIdentityStoreClient.EXPECT().ListGroupMembershipsPagesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).MinTimes(1).MaxTimes(20).
+		DoAndReturn(func(ctx aws_sdk.Context, inp *identitystore.ListGroupMembershipsInput, callback func(output *identitystore.ListGroupMembershipsOutput, lastPage bool) bool, opts ...request.Option) error {
+			return failure
+		})
+
+	actualOutput, err := mockClient.GetGroupMembershipsLists(groups, map[string]*aws.User{})
+
+	assert.Nil(t, actualOutput)
+	assert.Equal(t, failure, err)
 }
 
 func Test_IsUserInGroup(t *testing.T) {
@@ -1042,3 +1723,2819 @@ func Test_RemoveUserFromGroup(t *testing.T) {
 
 	assert.Nil(t, err)
 }
+
+func Test_getGoogleGroupsAndUsersMemberFetchError(t *testing.T) {
+	user := &admin.User{
+		Name:         &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1"},
+		PrimaryEmail: "user-1@email.com",
+	}
+
+	google := &fakeGoogleClient{
+		users: []*admin.User{user},
+		groups: []*admin.Group{
+			{Name: "empty", Email: "empty@email.com"},
+			{Name: "broken", Email: "broken@email.com"},
+			{Name: "not-found", Email: "not-found@email.com"},
+			{Name: "full", Email: "full@email.com"},
+		},
+		members: map[string][]*admin.Member{
+			"full@email.com": {{Email: "user-1@email.com", Type: "USER", Status: "ACTIVE"}},
+		},
+		memberErrs: map[string]error{
+			"broken@email.com": errors.New("backend error"),
+			// a 404 that isn't of the group being deleted is a failed fetch too
+			"not-found@email.com": &googleapi.Error{Code: 404, Message: "Not Found"},
+		},
+	}
+
+	s := &syncGSuite{
+		google: google,
+		cfg:    config.New(),
+		users:  make(map[string]*aws.User),
+	}
+
+	_, _, gGroupsUsers, err := s.getGoogleGroupsAndUsers("*", "")
+	assert.NoError(t, err)
+
+	// a successful fetch with no members is a confirmed empty group
+	members, found := gGroupsUsers["empty"]
+	assert.True(t, found)
+	assert.Len(t, members, 0)
+
+	// a failed fetch must not look like an empty group
+	_, found = gGroupsUsers["broken"]
+	assert.False(t, found)
+	assert.Equal(t, map[string]struct{}{"broken": {}, "not-found": {}}, s.unconfirmed)
+	assert.Equal(t, []unresolvedMember{{Email: "not-found@email.com", Reason: unresolvedFetchFailed}}, s.unresolved["not-found@email.com"])
+
+	assert.Equal(t, []*admin.User{user}, gGroupsUsers["full"])
+}
+
+func Test_getGoogleGroupsAndUsersMinGroupMembers(t *testing.T) {
+	user1 := &admin.User{
+		Name:         &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1"},
+		PrimaryEmail: "user-1@email.com",
+	}
+	user2 := &admin.User{
+		Name:         &admin.UserName{GivenName: "name-2", FamilyName: "lastname-2"},
+		PrimaryEmail: "user-2@email.com",
+	}
+	user4 := &admin.User{
+		Name:         &admin.UserName{GivenName: "name-4", FamilyName: "lastname-4"},
+		PrimaryEmail: "user-4@email.com",
+	}
+	member := &aws.User{ID: "id-user-3", Username: "user-3@email.com"}
+
+	cfg := config.New()
+	cfg.MinGroupMembers = 2
+	cfg.IgnoreUsers = []string{"ignored@email.com"}
+
+	s := &syncGSuite{
+		google: &fakeGoogleClient{
+			users: []*admin.User{user1, user2, user4},
+			groups: []*admin.Group{
+				{Name: "tiny", Email: "tiny@email.com"},
+				{Name: "tiny-new", Email: "tiny-new@email.com"},
+				{Name: "full", Email: "full@email.com"},
+			},
+			members: map[string][]*admin.Member{
+				// the ignored member doesn't count
+				"tiny@email.com": {
+					{Email: "user-1@email.com", Type: "USER", Status: "ACTIVE"},
+					{Email: "ignored@email.com", Type: "USER", Status: "ACTIVE"},
+				},
+				"tiny-new@email.com": {{Email: "user-4@email.com", Type: "USER", Status: "ACTIVE"}},
+				"full@email.com": {
+					{Email: "user-1@email.com", Type: "USER", Status: "ACTIVE"},
+					{Email: "user-2@email.com", Type: "USER", Status: "ACTIVE"},
+				},
+			},
+		},
+		cfg:   cfg,
+		users: make(map[string]*aws.User),
+	}
+
+	googleGroups, gUsers, gGroupsUsers, err := s.getGoogleGroupsAndUsers("*", "")
+	assert.NoError(t, err)
+	assert.Len(t, googleGroups, 3)
+	assert.Len(t, gGroupsUsers["full"], 2)
+	assert.NotContains(t, gGroupsUsers, "tiny")
+	assert.Equal(t, map[string]struct{}{"tiny": {}, "tiny-new": {}}, s.undersized)
+
+	// the users of the small groups are still synced, even when in no other group
+	assert.ElementsMatch(t, []*admin.User{user1, user2, user4}, gUsers)
+
+	awsGroups := []*aws.Group{{ID: "1", DisplayName: "tiny"}, {ID: "2", DisplayName: "full"}}
+	add, del, _ := getGroupOperations(awsGroups, googleGroups, googleGroupName, "", "")
+	// the small groups are neither created nor deleted, nor are their members removed
+	assert.Empty(t, withoutGroups(add, s.undersized, "too few members"))
+	assert.Empty(t, del)
+
+	removed, _ := getGroupUsersOperations(gGroupsUsers, map[string][]*aws.User{"tiny": {member}})
+	assert.Empty(t, removed)
+}
+
+func Test_getGoogleGroupsAndUsersSuspendedMembers(t *testing.T) {
+	active := &admin.User{
+		Name:         &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1"},
+		PrimaryEmail: "user-1@email.com",
+	}
+	suspended := &admin.User{
+		Name:         &admin.UserName{GivenName: "name-2", FamilyName: "lastname-2"},
+		PrimaryEmail: "user-2@email.com",
+		Suspended:    true,
+	}
+	google := &fakeGoogleClient{
+		users:  []*admin.User{active, suspended},
+		groups: []*admin.Group{{Name: "group", Email: "group@email.com"}},
+		members: map[string][]*admin.Member{
+			"group@email.com": {
+				{Email: "user-1@email.com", Type: "USER", Status: "ACTIVE"},
+				{Email: "user-2@email.com", Type: "USER", Status: "SUSPENDED"},
+			},
+		},
+	}
+	awsGroupsUsers := map[string][]*aws.User{
+		"group": {
+			{ID: "id-user-1", Username: "user-1@email.com"},
+			{ID: "id-user-2", Username: "user-2@email.com"},
+		},
+	}
+
+	t.Run("suspended members are removed by default", func(t *testing.T) {
+		s := &syncGSuite{google: google, cfg: config.New(), users: make(map[string]*aws.User)}
+
+		_, gUsers, gGroupsUsers, err := s.getGoogleGroupsAndUsers("*", "")
+		assert.NoError(t, err)
+		assert.Equal(t, []*admin.User{active}, gUsers)
+		assert.Equal(t, []*admin.User{active}, gGroupsUsers["group"])
+
+		removed, _ := getGroupUsersOperations(gGroupsUsers, awsGroupsUsers)
+		removed = withoutMembers(removed, s.suspended)
+		assert.Equal(t, []*aws.User{awsGroupsUsers["group"][1]}, removed["group"])
+	})
+
+	t.Run("suspended members are kept but not added", func(t *testing.T) {
+		cfg := config.New()
+		cfg.GoogleMembersIncludeSuspendedSeparately = true
+		s := &syncGSuite{google: google, cfg: cfg, users: make(map[string]*aws.User)}
+
+		_, gUsers, gGroupsUsers, err := s.getGoogleGroupsAndUsers("*", "")
+		assert.NoError(t, err)
+		// the user is still synced, as inactive, so it isn't deleted
+		assert.ElementsMatch(t, []*admin.User{active, suspended}, gUsers)
+		assert.False(t, awsUserFromGoogle(suspended, newUserMapping(cfg)).Active)
+		assert.Equal(t, []*admin.User{active}, gGroupsUsers["group"])
+		assert.Equal(t, map[string]map[string]struct{}{"group": {"user-2@email.com": {}}}, s.suspended)
+
+		removed, _ := getGroupUsersOperations(gGroupsUsers, awsGroupsUsers)
+		assert.Empty(t, withoutMembers(removed, s.suspended))
+
+		// nor is the suspended user added to groups it isn't a member of
+		assert.Equal(t, []*admin.User{active}, getGroupAddMembers(gGroupsUsers, map[string][]*aws.User{"group": {}})["group"])
+	})
+}
+
+func Test_getGoogleGroupsAndUsersTransitionalGroup(t *testing.T) {
+	user := &admin.User{
+		Name:         &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1"},
+		PrimaryEmail: "user-1@email.com",
+	}
+	member := &aws.User{ID: "id-user-1", Username: "user-1@email.com"}
+
+	tests := []struct {
+		action      string
+		wantGroups  int
+		wantMembers bool
+		wantDelete  []*aws.Group
+		wantRemoved map[string][]*aws.User
+	}{
+		{
+			action:      config.TransitionalGroupActionSkip,
+			wantGroups:  3,
+			wantRemoved: map[string][]*aws.User{},
+		},
+		{
+			action:      config.TransitionalGroupActionDeactivate,
+			wantGroups:  3,
+			wantMembers: true,
+			wantRemoved: map[string][]*aws.User{"deleting": {member}},
+		},
+		{
+			action:      config.TransitionalGroupActionDelete,
+			wantGroups:  1,
+			wantDelete:  []*aws.Group{aws.NewGroup("deleting")},
+			wantRemoved: map[string][]*aws.User{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			cfg := config.New()
+			cfg.TransitionalGroupAction = tt.action
+
+			s := &syncGSuite{
+				google: &fakeGoogleClient{
+					users: []*admin.User{user},
+					groups: []*admin.Group{
+						{Name: "deleting", Email: "deleting@email.com"},
+						{Name: "deleting-new", Email: "deleting-new@email.com"},
+						{Name: "full", Email: "full@email.com"},
+					},
+					members: map[string][]*admin.Member{
+						"full@email.com": {{Email: "user-1@email.com", Type: "USER", Status: "ACTIVE"}},
+					},
+					memberErrs: map[string]error{
+						"deleting@email.com":     &googleapi.Error{Code: 404, Message: "Resource Not Found: groupKey"},
+						"deleting-new@email.com": &googleapi.Error{Code: 404, Message: "Resource Not Found: groupKey"},
+					},
+				},
+				cfg:   cfg,
+				users: make(map[string]*aws.User),
+			}
+
+			googleGroups, _, gGroupsUsers, err := s.getGoogleGroupsAndUsers("*", "")
+			assert.NoError(t, err)
+			assert.Len(t, googleGroups, tt.wantGroups)
+
+			members, found := gGroupsUsers["deleting"]
+			assert.Equal(t, tt.wantMembers, found)
+			assert.Len(t, members, 0)
+			assert.Equal(t, []unresolvedMember{{Email: "deleting@email.com", Reason: unresolvedGroupDeleting}}, s.unresolved["deleting@email.com"])
+
+			awsGroups := []*aws.Group{{ID: "1", DisplayName: "deleting"}, {ID: "2", DisplayName: "full"}}
+			add, del, _ := getGroupOperations(awsGroups, googleGroups, googleGroupName, "", "")
+			// groups being deleted are never created
+			assert.Empty(t, withoutGroups(add, s.transitional, unresolvedGroupDeleting))
+			assert.Equal(t, tt.wantDelete, del)
+
+			removed, _ := getGroupUsersOperations(gGroupsUsers, map[string][]*aws.User{"deleting": {member}})
+			assert.Equal(t, tt.wantRemoved, removed)
+		})
+	}
+}
+
+func Test_getGroupOperationsNameAffixes(t *testing.T) {
+	awsGroups := []*aws.Group{
+		{ID: "1", DisplayName: "GSuite-Group-1-aws"},
+		{ID: "2", DisplayName: "GSuite-Group-3-aws"},
+		{ID: "3", DisplayName: "AWSControlTowerAdmins"},
+		{ID: "4", DisplayName: "Group-2"},
+	}
+	googleGroups := []*admin.Group{
+		{Name: "Group-1"},
+		{Name: "Group-2"},
+	}
+
+	add, del, equals := getGroupOperations(awsGroups, googleGroups, googleGroupName, "GSuite-", "-aws")
+
+	// existing prefixed groups correlate, groups without the affixes are left alone
+	assert.Equal(t, []*aws.Group{aws.NewGroup("GSuite-Group-2-aws")}, add)
+	assert.Equal(t, []*aws.Group{aws.NewGroup("GSuite-Group-3-aws")}, del)
+	assert.Equal(t, []*aws.Group{awsGroups[0]}, equals)
+}
+
+func Test_protectedGroups(t *testing.T) {
+	awsGroups := []*aws.Group{
+		{ID: "1", DisplayName: "AWSControlTowerAdmins"},
+		{ID: "2", DisplayName: "AWSSecurityAuditors"},
+		{ID: "3", DisplayName: "Custom"},
+		{ID: "4", DisplayName: "Stale"},
+	}
+	googleGroups := []*admin.Group{
+		{Id: "g1", Name: "AWSControlTowerAdmins", Email: "ct@email.com"},
+		{Id: "g2", Name: "Group-1", Email: "group-1@email.com"},
+	}
+
+	t.Run("the control tower groups are protected by default", func(t *testing.T) {
+		s := &syncGSuite{
+			google: &fakeGoogleClient{groups: googleGroups},
+			cfg:    config.New(),
+			users:  make(map[string]*aws.User),
+		}
+
+		gGroups, _, _, err := s.getGoogleGroupsAndUsers("*", "")
+		assert.NoError(t, err)
+		assert.Equal(t, []*admin.Group{googleGroups[1]}, gGroups)
+
+		add, del, equals := getGroupOperations(s.withoutProtectedGroups(awsGroups), gGroups, googleGroupName, "", "")
+		assert.Equal(t, []*aws.Group{aws.NewGroup("Group-1")}, add)
+		assert.Equal(t, []*aws.Group{aws.NewGroup("Custom"), aws.NewGroup("Stale")}, del)
+		assert.Empty(t, equals)
+	})
+
+	t.Run("configured groups replace the defaults", func(t *testing.T) {
+		cfg := config.New()
+		cfg.ProtectedGroups = []string{"Custom"}
+		s := &syncGSuite{cfg: cfg}
+
+		_, del, _ := getGroupOperations(s.withoutProtectedGroups(awsGroups), nil, googleGroupName, "", "")
+		assert.Equal(t, []*aws.Group{aws.NewGroup("AWSControlTowerAdmins"), aws.NewGroup("AWSSecurityAuditors"), aws.NewGroup("Stale")}, del)
+		assert.Empty(t, withoutGroups([]*aws.Group{aws.NewGroup("Custom")}, s.protectedGroups([]*aws.Group{aws.NewGroup("Custom")}), "protected"))
+
+		// an empty value protects none
+		s = &syncGSuite{cfg: &config.Config{ProtectedGroups: []string{""}}}
+		assert.Equal(t, awsGroups, s.withoutProtectedGroups(awsGroups))
+	})
+
+	t.Run("regular expressions match display names", func(t *testing.T) {
+		cfg := config.New()
+		cfg.ProtectedGroups = []string{"/^AWS.*Admins$/", "Stale"}
+		s := &syncGSuite{cfg: cfg}
+
+		// the protected groups absent from google are not deleted
+		_, del, _ := getGroupOperations(s.withoutProtectedGroups(awsGroups), nil, googleGroupName, "", "")
+		assert.Equal(t, []*aws.Group{aws.NewGroup("AWSSecurityAuditors"), aws.NewGroup("Custom")}, del)
+
+		// nor created
+		add, _, _ := getGroupOperations(nil, []*admin.Group{{Name: "AWSLogArchiveAdmins"}, {Name: "Group-1"}}, googleGroupName, "", "")
+		assert.Equal(t, []*aws.Group{aws.NewGroup("Group-1")}, withoutGroups(add, s.protectedGroups(add), "protected"))
+	})
+}
+
+func Test_validateConfigProtectedGroups(t *testing.T) {
+	cfg := config.New()
+	cfg.ProtectedGroups = []string{"/^AWS.*Admins$/", "Custom"}
+	assert.NoError(t, validateConfig(cfg))
+
+	cfg.ProtectedGroups = []string{"/^AWS(/"}
+	assert.EqualError(t, validateConfig(cfg), "invalid protected group pattern \"/^AWS(/\": error parsing regexp: missing closing ): `^AWS(`")
+}
+
+func Test_compileGroupPatterns(t *testing.T) {
+	cfg := config.New()
+	cfg.ProtectedGroups = []string{"/^AWS.*Admins$/", "Custom", ""}
+	cfg.MembershipBackends = []string{"eng-*=scim", " ops = identitystore"}
+
+	patterns, err := compileGroupPatterns(cfg)
+	assert.NoError(t, err)
+	assert.Len(t, patterns.protected, 3)
+	assert.True(t, patterns.protected[0]("AWSControlTowerAdmins"))
+	assert.False(t, patterns.protected[0]("Admins"))
+	assert.True(t, patterns.protected[1]("Custom"))
+	assert.False(t, patterns.protected[1]("Custom-2"))
+	assert.False(t, patterns.protected[2](""))
+	if assert.Len(t, patterns.routes, 2) {
+		assert.True(t, patterns.routes[0].match("eng-small"))
+		assert.Equal(t, config.MembershipBackendSCIM, patterns.routes[0].backend)
+		assert.True(t, patterns.routes[1].match("ops"))
+		assert.Equal(t, config.MembershipBackendIdentityStore, patterns.routes[1].backend)
+	}
+
+	// the sync compiles them once
+	s := &syncGSuite{cfg: cfg}
+	assert.True(t, s.protectedGroup("Custom"))
+	cfg.ProtectedGroups = nil
+	assert.True(t, s.protectedGroup("Custom"))
+	assert.Same(t, s.groupPatterns(), s.groupPatterns())
+}
+
+func Test_stripGroupName(t *testing.T) {
+	tests := []struct {
+		displayName string
+		prefix      string
+		suffix      string
+		want        string
+		wantOK      bool
+	}{
+		{displayName: "Admins", want: "Admins", wantOK: true},
+		{displayName: "GSuite-Admins", prefix: "GSuite-", want: "Admins", wantOK: true},
+		{displayName: "Admins (gw)", suffix: " (gw)", want: "Admins", wantOK: true},
+		{displayName: "Admins", prefix: "GSuite-"},
+		{displayName: "GSuite-", prefix: "GSuite-", suffix: "-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.displayName, func(t *testing.T) {
+			got, ok := stripGroupName(tt.displayName, tt.prefix, tt.suffix)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_getGoogleGroupsAndUsersGroupNamePrefix(t *testing.T) {
+	user := &admin.User{
+		Name:         &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1"},
+		PrimaryEmail: "user-1@email.com",
+	}
+
+	cfg := config.New()
+	cfg.GroupNamePrefix = "GSuite-"
+
+	s := &syncGSuite{
+		google: &fakeGoogleClient{
+			users:  []*admin.User{user},
+			groups: []*admin.Group{{Name: "admins", Email: "admins@email.com"}},
+			members: map[string][]*admin.Member{
+				"admins@email.com": {{Email: "user-1@email.com", Type: "USER", Status: "ACTIVE"}},
+			},
+		},
+		cfg:   cfg,
+		users: make(map[string]*aws.User),
+	}
+
+	googleGroups, _, gGroupsUsers, err := s.getGoogleGroupsAndUsers("*", "")
+	assert.NoError(t, err)
+
+	// the members are keyed by the aws group name so they line up with the aws members
+	assert.Equal(t, []*admin.User{user}, gGroupsUsers["GSuite-admins"])
+
+	member := &aws.User{ID: "id-user-2", Username: "user-2@email.com"}
+	awsGroups := []*aws.Group{{ID: "1", DisplayName: "GSuite-admins"}}
+	_, _, equals := getGroupOperations(awsGroups, googleGroups, googleGroupName, cfg.GroupNamePrefix, cfg.GroupNameSuffix)
+	assert.Equal(t, awsGroups, equals)
+
+	del, _ := getGroupUsersOperations(gGroupsUsers, map[string][]*aws.User{"GSuite-admins": {member}})
+	assert.Equal(t, map[string][]*aws.User{"GSuite-admins": {member}}, del)
+}
+
+func Test_GroupDisplayNameSourceAcrossSyncMethods(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{source: config.GroupDisplayNameSourceEmail, want: "admins@email.com"},
+		{source: config.GroupDisplayNameSourceName, want: "Admins"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			cfg := config.New()
+			cfg.IdentityStoreID = "test-identity-store-id"
+			cfg.GroupDisplayNameSource = tt.source
+			cfg.IncludeGroups = []string{"admins@email.com"}
+
+			google := &fakeGoogleClient{groups: []*admin.Group{{Id: "1", Name: "Admins", Email: "admins@email.com"}}}
+			mockIdentityStoreClient := mocks.NewMockIdentityStoreAPI(ctrl)
+
+			var created []string
+			mockIdentityStoreClient.EXPECT().CreateGroupWithContext(gomock.Any(), gomock.Any()).Times(2).DoAndReturn(
+				func(ctx aws_sdk.Context, input *identitystore.CreateGroupInput, opts ...request.Option) (*identitystore.CreateGroupOutput, error) {
+					created = append(created, *input.DisplayName)
+					return &identitystore.CreateGroupOutput{GroupId: aws_sdk.String("group-1")}, nil
+				})
+			mockIdentityStoreClient.EXPECT().ListGroupsPages(gomock.Any(), gomock.Any()).Return(nil)
+			mockIdentityStoreClient.EXPECT().ListUsersPages(gomock.Any(), gomock.Any()).Return(nil)
+
+			s := New(cfg, &fakeAWSClient{}, google, mockIdentityStoreClient)
+			assert.NoError(t, s.SyncGroups("*"))
+			assert.NoError(t, New(cfg, &fakeAWSClient{}, google, mockIdentityStoreClient).SyncGroupsUsers("*", ""))
+
+			assert.Equal(t, []string{tt.want, tt.want}, created)
+		})
+	}
+}
+
+func Test_migrateGroupNames(t *testing.T) {
+	awsGroups := []*aws.Group{
+		{ID: "1", DisplayName: "admins@email.com"},
+		{ID: "2", DisplayName: "devs@email.com"},
+		{ID: "3", DisplayName: "Devs"},
+	}
+	googleGroups := []*admin.Group{
+		{Name: "Admins", Email: "admins@email.com"},
+		{Name: "Devs", Email: "devs@email.com"},
+		{Name: "same@email.com", Email: "same@email.com"},
+	}
+
+	client := &fakeAWSClient{}
+	s := &syncGSuite{aws: client, cfg: config.New()}
+
+	err := s.migrateGroupNames(awsGroups, googleGroups, googleGroupName, googleGroupEmail)
+	assert.NoError(t, err)
+
+	// groups already present under the current name are not renamed
+	assert.Equal(t, map[string]string{"admins@email.com": "Admins"}, client.renames)
+	assert.Equal(t, "Admins", awsGroups[0].DisplayName)
+	assert.Equal(t, "devs@email.com", awsGroups[1].DisplayName)
+}
+
+func Test_SyncGroupsMigrateGroupNames(t *testing.T) {
+	tests := []struct {
+		name        string
+		groups      map[string]*aws.Group
+		wantRenames map[string]string
+	}{
+		{
+			name:        "group named by the groups_users method is renamed",
+			groups:      map[string]*aws.Group{"Admins": {ID: "1", DisplayName: "Admins"}},
+			wantRenames: map[string]string{"Admins": "admins@email.com"},
+		},
+		{
+			name: "group already named by email is kept",
+			groups: map[string]*aws.Group{
+				"Admins":           {ID: "1", DisplayName: "Admins"},
+				"admins@email.com": {ID: "2", DisplayName: "admins@email.com"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			google := &fakeGoogleClient{
+				groups: []*admin.Group{{Name: "Admins", Email: "admins@email.com"}},
+			}
+			awsClient := &fakeAWSClient{groups: tt.groups}
+
+			cfg := config.New()
+			cfg.MigrateGroupNames = true
+			cfg.IncludeGroups = []string{"admins@email.com"}
+
+			// no group is created, the calls would be unexpected
+			s := &syncGSuite{
+				aws:                 awsClient,
+				google:              google,
+				cfg:                 cfg,
+				identityStoreClient: mocks.NewMockIdentityStoreAPI(ctrl),
+				users:               make(map[string]*aws.User),
+			}
+
+			assert.NoError(t, s.SyncGroups("*"))
+			assert.Equal(t, tt.wantRenames, awsClient.renames)
+			assert.Equal(t, 0, s.Stats().GroupsCreated)
+		})
+	}
+}
+
+func Test_getUserOperationsChunked(t *testing.T) {
+	usernames := func(users []*aws.User) []string {
+		names := make([]string, 0, len(users))
+		for _, u := range users {
+			names = append(names, u.Username)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	googleUser := func(email string, suspended bool) *admin.User {
+		return &admin.User{
+			Name:         &admin.UserName{GivenName: email, FamilyName: email},
+			PrimaryEmail: email,
+			Suspended:    suspended,
+		}
+	}
+
+	awsUsers := []*aws.User{
+		aws.NewUser("a@email.com", "a@email.com", "a@email.com", true),
+		aws.NewUser("c@email.com", "c@email.com", "c@email.com", true),
+		aws.NewUser("d@email.com", "d@email.com", "d@email.com", true),
+		aws.NewUser("f@email.com", "f@email.com", "f@email.com", true),
+		aws.NewUser("z@email.com", "z@email.com", "z@email.com", true),
+	}
+	googleUsers := []*admin.User{
+		googleUser("e@email.com", false),
+		googleUser("c@email.com", false),
+		googleUser("b@email.com", false),
+		googleUser("d@email.com", true),
+		googleUser("f@email.com", false),
+		googleUser("g@email.com", false),
+	}
+
+	wantAdd, wantDelete, wantUpdate, wantEquals := getUserOperations(awsUsers, googleUsers, userMapping{}, config.UnmanagedUserActionDelete)
+
+	for _, size := range []int{0, 1, 2, 3, 4, 100} {
+		t.Run(strconv.Itoa(size), func(t *testing.T) {
+			add, del, update, equals := getUserOperationsChunked(awsUsers, googleUsers, userMapping{}, config.UnmanagedUserActionDelete, size)
+			assert.Equal(t, usernames(wantAdd), usernames(add))
+			assert.Equal(t, usernames(wantDelete), usernames(del))
+			assert.Equal(t, usernames(wantUpdate), usernames(update))
+			assert.Equal(t, usernames(wantEquals), usernames(equals))
+		})
+	}
+
+	// without google users every aws user is deleted
+	_, del, _, _ := getUserOperationsChunked(awsUsers, nil, userMapping{}, config.UnmanagedUserActionDelete, 2)
+	assert.Equal(t, usernames(awsUsers), usernames(del))
+}
+
+func Test_chunkUsers(t *testing.T) {
+	googleUsers := []*admin.User{
+		{PrimaryEmail: "a@email.com"},
+		{PrimaryEmail: "b@email.com"},
+		{PrimaryEmail: "b@email.com"},
+		{PrimaryEmail: "c@email.com"},
+	}
+
+	// duplicate emails are kept in the same range
+	chunks := chunkUsers(nil, googleUsers, 2)
+	assert.Len(t, chunks, 2)
+	assert.Len(t, chunks[0].google, 3)
+	assert.Len(t, chunks[1].google, 1)
+}
+
+func Test_includeUser(t *testing.T) {
+	tests := []struct {
+		name         string
+		includeUsers []string
+		user         string
+		want         bool
+	}{
+		{name: "empty list includes all", user: "user-1@email.com", want: true},
+		{name: "listed user", includeUsers: []string{"user-1@email.com", "user-2@email.com"}, user: "user-2@email.com", want: true},
+		{name: "unlisted user", includeUsers: []string{"user-1@email.com"}, user: "user-2@email.com", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.New()
+			cfg.IncludeUsers = tt.includeUsers
+			s := &syncGSuite{cfg: cfg}
+
+			assert.Equal(t, tt.want, s.includeUser(tt.user))
+		})
+	}
+}
+
+func Test_getGoogleGroupsAndUsersIncludeUsers(t *testing.T) {
+	included := &admin.User{
+		Name:         &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1"},
+		PrimaryEmail: "user-1@email.com",
+	}
+	excluded := &admin.User{
+		Name:         &admin.UserName{GivenName: "name-2", FamilyName: "lastname-2"},
+		PrimaryEmail: "user-2@email.com",
+	}
+
+	google := &fakeGoogleClient{
+		users:  []*admin.User{included, excluded},
+		groups: []*admin.Group{{Name: "group", Email: "group@email.com"}},
+		members: map[string][]*admin.Member{
+			"group@email.com": {
+				{Email: "user-1@email.com", Type: "USER", Status: "ACTIVE"},
+				{Email: "user-2@email.com", Type: "USER", Status: "ACTIVE"},
+			},
+		},
+	}
+
+	cfg := config.New()
+	cfg.IncludeUsers = []string{"user-1@email.com"}
+
+	s := &syncGSuite{
+		google: google,
+		cfg:    cfg,
+		users:  make(map[string]*aws.User),
+	}
+
+	_, gUsers, gGroupsUsers, err := s.getGoogleGroupsAndUsers("*", "*")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []*admin.User{included}, gUsers)
+	assert.Equal(t, []*admin.User{included}, gGroupsUsers["group"])
+	assert.Equal(t, []unresolvedMember{{Email: "user-2@email.com", Reason: unresolvedNotIncluded}}, s.unresolved["group@email.com"])
+}
+
+func Test_getGoogleGroupsAndUsersUnresolvedMembers(t *testing.T) {
+	user := &admin.User{
+		Name:         &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1"},
+		PrimaryEmail: "user-1@email.com",
+	}
+
+	google := &fakeGoogleClient{
+		users: []*admin.User{user},
+		groups: []*admin.Group{
+			{Name: "group", Email: "group@email.com"},
+			{Name: "broken", Email: "broken@email.com"},
+		},
+		members: map[string][]*admin.Member{
+			"group@email.com": {
+				{Email: "user-1@email.com", Type: "USER", Status: "ACTIVE"},
+				{Email: "external@other.com", Type: "USER", Status: "UNKNOWN"},
+				{Email: "ignored@email.com", Type: "USER", Status: "ACTIVE"},
+				{Email: "missing@email.com", Type: "USER", Status: "ACTIVE"},
+				{Email: "nested@email.com", Type: "GROUP"},
+			},
+		},
+		memberErrs: map[string]error{
+			"broken@email.com": errors.New("backend error"),
+		},
+	}
+
+	cfg := config.New()
+	cfg.IgnoreUsers = []string{"ignored@email.com"}
+	cfg.ReportUnresolvedMembers = true
+
+	s := &syncGSuite{
+		google: google,
+		cfg:    cfg,
+		users:  make(map[string]*aws.User),
+	}
+
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	_, _, _, err := s.getGoogleGroupsAndUsers("*", "")
+	assert.NoError(t, err)
+
+	assert.Equal(t, map[string][]unresolvedMember{
+		"group@email.com": {
+			{Email: "external@other.com", Reason: unresolvedExternal},
+			{Email: "ignored@email.com", Reason: unresolvedIgnored},
+			{Email: "missing@email.com", Reason: unresolvedMissingUser},
+			{Email: "nested@email.com", Reason: unresolvedMissingGroup},
+		},
+		"broken@email.com": {
+			{Email: "broken@email.com", Reason: unresolvedFetchFailed},
+		},
+	}, s.unresolved)
+
+	reported := 0
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "unresolved group member" {
+			reported++
+		}
+	}
+	assert.Equal(t, 5, reported)
+}
+
+func Test_SyncGroupsUnresolvedMembers(t *testing.T) {
+	google := &fakeGoogleClient{
+		groups: []*admin.Group{{Name: "group", Email: "group@email.com"}},
+		members: map[string][]*admin.Member{
+			"group@email.com": {
+				{Email: "missing@email.com", Type: "USER", Status: "ACTIVE"},
+				{Email: "nested@email.com", Type: "GROUP"},
+			},
+		},
+	}
+	awsClient := &fakeAWSClient{
+		groups: map[string]*aws.Group{"group@email.com": {ID: "group", DisplayName: "group@email.com"}},
+	}
+
+	cfg := config.New()
+	cfg.IncludeGroups = []string{"group@email.com"}
+
+	s := &syncGSuite{
+		aws:    awsClient,
+		google: google,
+		cfg:    cfg,
+		users:  make(map[string]*aws.User),
+	}
+
+	assert.NoError(t, s.SyncGroups("*"))
+
+	// a nested group is not a user that is missing
+	assert.Equal(t, []unresolvedMember{
+		{Email: "missing@email.com", Reason: unresolvedMissingUser},
+		{Email: "nested@email.com", Reason: unresolvedNestedGroup},
+	}, s.unresolved["group@email.com"])
+}
+
+func Test_getGoogleGroupsAndUsersMemberByID(t *testing.T) {
+	user1 := &admin.User{
+		Id:           "user-1-id",
+		Name:         &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1"},
+		PrimaryEmail: "user-1@email.com",
+	}
+	user2 := &admin.User{
+		Id:           "user-2-id",
+		Name:         &admin.UserName{GivenName: "name-2", FamilyName: "lastname-2"},
+		PrimaryEmail: "user-2@email.com",
+	}
+
+	// members listed with an id and no email are resolved by their id
+	google := &fakeGoogleClient{
+		users: []*admin.User{user1, user2},
+		groups: []*admin.Group{
+			{Id: "parent-id", Name: "parent", Email: "parent@email.com"},
+			{Id: "child-id", Name: "child", Email: "child@email.com"},
+		},
+		members: map[string][]*admin.Member{
+			"parent@email.com": {
+				{Id: "user-1-id", Type: "USER", Status: "ACTIVE"},
+				{Id: "child-id", Type: "GROUP"},
+				{Id: "unknown-id", Type: "USER", Status: "ACTIVE"},
+			},
+			"child@email.com": {
+				{Id: "user-2-id", Type: "USER", Status: "ACTIVE"},
+			},
+		},
+	}
+
+	s := &syncGSuite{
+		google: google,
+		cfg:    config.New(),
+		users:  make(map[string]*aws.User),
+	}
+
+	_, _, gGroupsUsers, err := s.getGoogleGroupsAndUsers("*", "")
+	assert.NoError(t, err)
+
+	assert.ElementsMatch(t, []*admin.User{user1, user2}, gGroupsUsers["parent"])
+	assert.ElementsMatch(t, []*admin.User{user2}, gGroupsUsers["child"])
+	assert.Equal(t, []unresolvedMember{{Email: "unknown-id", Reason: unresolvedMissingUser}}, s.unresolved["parent@email.com"])
+
+	// the listed members are left as they are
+	assert.Equal(t, "", google.members["parent@email.com"][0].Email)
+}
+
+func Test_getGoogleGroupsAndUsersNestedGroups(t *testing.T) {
+	user1 := &admin.User{
+		Name:         &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1"},
+		PrimaryEmail: "user-1@email.com",
+	}
+	user2 := &admin.User{
+		Name:         &admin.UserName{GivenName: "name-2", FamilyName: "lastname-2"},
+		PrimaryEmail: "user-2@email.com",
+	}
+
+	google := &fakeGoogleClient{
+		users: []*admin.User{user1, user2},
+		groups: []*admin.Group{
+			{Name: "parent", Email: "parent@email.com"},
+			{Name: "child", Email: "child@email.com"},
+		},
+		members: map[string][]*admin.Member{
+			"parent@email.com": {
+				{Email: "user-1@email.com", Type: "USER", Status: "ACTIVE"},
+				{Email: "child@email.com", Type: "GROUP"},
+			},
+			"child@email.com": {
+				{Email: "user-2@email.com", Type: "USER", Status: "ACTIVE"},
+			},
+		},
+	}
+
+	s := &syncGSuite{
+		google: google,
+		cfg:    config.New(),
+		users:  make(map[string]*aws.User),
+	}
+
+	_, _, gGroupsUsers, err := s.getGoogleGroupsAndUsers("*", "*")
+	assert.NoError(t, err)
+
+	// the identity store only takes users as members, the nested group is flattened
+	assert.ElementsMatch(t, []*admin.User{user1, user2}, gGroupsUsers["parent"])
+}
+
+func Test_getGoogleGroupsAndUsersGroupAliases(t *testing.T) {
+	user := &admin.User{
+		Name:         &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1"},
+		PrimaryEmail: "user-1@email.com",
+	}
+
+	google := &fakeGoogleClient{
+		users: []*admin.User{user},
+		groups: []*admin.Group{
+			{Id: "1", Name: "admins", Email: "admins@email.com", Aliases: []string{"root@email.com", "devs", "ignored@email.com"}},
+			{Id: "2", Name: "devs", Email: "devs@email.com"},
+		},
+		members: map[string][]*admin.Member{
+			"admins@email.com": {{Email: "user-1@email.com", Type: "USER", Status: "ACTIVE"}},
+		},
+	}
+
+	cfg := config.New()
+	cfg.SyncGroupAliases = true
+	cfg.IgnoreGroups = []string{"ignored@email.com"}
+
+	s := &syncGSuite{
+		google: google,
+		cfg:    cfg,
+		users:  make(map[string]*aws.User),
+	}
+
+	groups, _, gGroupsUsers, err := s.getGoogleGroupsAndUsers("*", "")
+	assert.NoError(t, err)
+
+	// aliases colliding with a group or ignored are not synced
+	assert.Len(t, groups, 3)
+	assert.Equal(t, "root@email.com", groups[2].Name)
+	assert.Equal(t, []*admin.User{user}, gGroupsUsers["root@email.com"])
+
+	// the alias group correlates with the existing aws group on the next run
+	awsGroups := []*aws.Group{aws.NewGroup("admins"), aws.NewGroup("devs"), aws.NewGroup("root@email.com")}
+	add, del, equals := getGroupOperations(awsGroups, groups, googleGroupName, "", "")
+	assert.Len(t, add, 0)
+	assert.Len(t, del, 0)
+	assert.Len(t, equals, 3)
+}
+
+func Test_SyncUsersSkipsInvalidUsers(t *testing.T) {
+	google := &fakeGoogleClient{
+		users: []*admin.User{
+			{Name: &admin.UserName{GivenName: "", FamilyName: "lastname-1"}, PrimaryEmail: "user-1@email.com"},
+			{Name: &admin.UserName{GivenName: "name-2", FamilyName: "lastname-2"}, PrimaryEmail: "user-2@email.com"},
+		},
+	}
+
+	client := &fakeAWSClient{}
+	s := &syncGSuite{
+		aws:    client,
+		google: google,
+		cfg:    config.New(),
+		users:  make(map[string]*aws.User),
+	}
+
+	err := s.SyncUsers("*")
+	assert.NoError(t, err)
+	assert.Len(t, client.created, 1)
+	assert.Equal(t, "user-2@email.com", client.created[0].Username)
+
+	// the sync fails on the invalid user when configured to
+	client = &fakeAWSClient{}
+	s.aws = client
+	s.cfg.InvalidUserAction = config.InvalidUserActionFail
+
+	err = s.SyncUsers("*")
+	assert.True(t, errors.Is(err, aws.ErrUserFieldEmpty))
+	assert.Len(t, client.created, 0)
+}
+
+func Test_SyncUsersStatusKeepsAttributes(t *testing.T) {
+	google := &fakeGoogleClient{
+		users: []*admin.User{
+			{
+				Name:          &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1"},
+				PrimaryEmail:  "user-1@email.com",
+				Suspended:     true,
+				Organizations: []interface{}{map[string]interface{}{"title": "engineer", "primary": true}},
+				Languages:     []interface{}{map[string]interface{}{"languageCode": "fr-CA"}},
+			},
+		},
+	}
+
+	client := &fakeAWSClient{
+		users: map[string]*aws.User{
+			"user-1@email.com": {
+				ID:         "id-user-1",
+				Username:   "user-1@email.com",
+				Active:     true,
+				ExternalID: "g-user-1",
+				Enterprise: &aws.EnterpriseUser{Manager: &aws.ManagerRef{Value: "id-boss"}},
+			},
+		},
+	}
+	cfg := config.New()
+	cfg.SyncTitle = true
+	cfg.SyncLocale = true
+	var buf bytes.Buffer
+	s := &syncGSuite{
+		aws:    client,
+		google: google,
+		cfg:    cfg,
+		users:  make(map[string]*aws.User),
+		audit:  newAuditLog(&buf),
+	}
+
+	err := s.SyncUsers("*")
+	assert.NoError(t, err)
+
+	// the replace sends the mapped attributes and keeps the others
+	if assert.Len(t, client.updated, 1) {
+		updated := client.updated[0]
+		assert.Equal(t, "id-user-1", updated.ID)
+		assert.False(t, updated.Active)
+		assert.Equal(t, "engineer", updated.Title)
+		assert.Equal(t, "fr-CA", updated.Locale)
+		assert.Equal(t, "g-user-1", updated.ExternalID)
+		assert.Equal(t, "id-boss", updated.ManagerID())
+	}
+	assert.Contains(t, buf.String(), `"externalId":"g-user-1"`)
+}
+
+func Test_AddUserToGroup(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockIdentityStoreClient := mocks.NewMockIdentityStoreAPI(ctrl)
+
+	mockClient := &syncGSuite{
+		cfg:                 &config.Config{IdentityStoreID: "test-identity-store-id"},
+		identityStoreClient: mockIdentityStoreClient,
+		users:               make(map[string]*aws.User),
+	}
+
+	sampleUserInput := "test-user-id"
+	sampleGroupInput := "test-group-id"
+
+	expected := &identitystore.CreateGroupMembershipInput{
+		IdentityStoreId: &mockClient.cfg.IdentityStoreID,
+		GroupId:         &sampleGroupInput,
+		MemberId:        &identitystore.MemberId{UserId: &sampleUserInput},
+	}
+
+	mockIdentityStoreClient.EXPECT().CreateGroupMembership(expected).Times(1).Return(&identitystore.CreateGroupMembershipOutput{}, nil)
+	assert.NoError(t, mockClient.AddUserToGroup(&sampleUserInput, &sampleGroupInput))
+
+	// an existing membership is not an error
+	conflict := awserr.New(identitystore.ErrCodeConflictException, "membership exists", nil)
+	mockIdentityStoreClient.EXPECT().CreateGroupMembership(expected).Times(1).Return(nil, conflict)
+	assert.NoError(t, mockClient.AddUserToGroup(&sampleUserInput, &sampleGroupInput))
+
+	failure := awserr.New(identitystore.ErrCodeInternalServerException, "failure", nil)
+	mockIdentityStoreClient.EXPECT().CreateGroupMembership(expected).Times(1).Return(nil, failure)
+	assert.Error(t, mockClient.AddUserToGroup(&sampleUserInput, &sampleGroupInput))
+}
+
+func Test_membershipBackend(t *testing.T) {
+	s := &syncGSuite{cfg: &config.Config{MembershipBackends: []string{"eng-*=scim", "eng-large=identitystore", "ops = scim"}}}
+
+	tests := []struct {
+		group string
+		want  string
+	}{
+		{group: "eng-small", want: config.MembershipBackendSCIM},
+		// the first matching pattern wins
+		{group: "eng-large", want: config.MembershipBackendSCIM},
+		{group: "ops", want: config.MembershipBackendSCIM},
+		{group: "sales", want: config.MembershipBackendIdentityStore},
+	}
+	for _, tt := range tests {
+		t.Run(tt.group, func(t *testing.T) {
+			assert.Equal(t, tt.want, s.membershipBackend(tt.group))
+		})
+	}
+}
+
+func Test_addMemberRoutesByBackend(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockIdentityStoreClient := mocks.NewMockIdentityStoreAPI(ctrl)
+	scim := &fakeAWSClient{}
+
+	s := &syncGSuite{
+		aws: scim,
+		cfg: &config.Config{
+			IdentityStoreID:    "test-identity-store-id",
+			MembershipBackends: []string{"large-*=scim"},
+		},
+		identityStoreClient: mockIdentityStoreClient,
+		users:               make(map[string]*aws.User),
+	}
+
+	user := &aws.User{ID: "user-id", Username: "user@email.com"}
+	large := &aws.Group{ID: "large-id", DisplayName: "large-group"}
+	small := &aws.Group{ID: "small-id", DisplayName: "small-group"}
+
+	mockIdentityStoreClient.EXPECT().CreateGroupMembership(&identitystore.CreateGroupMembershipInput{
+		IdentityStoreId: aws_sdk.String("test-identity-store-id"),
+		GroupId:         aws_sdk.String("small-id"),
+		MemberId:        &identitystore.MemberId{UserId: aws_sdk.String("user-id")},
+	}).Times(1).Return(&identitystore.CreateGroupMembershipOutput{}, nil)
+	mockIdentityStoreClient.EXPECT().GetGroupMembershipId(gomock.Any()).Times(1).Return(&identitystore.GetGroupMembershipIdOutput{MembershipId: aws_sdk.String("membership-id")}, nil)
+	mockIdentityStoreClient.EXPECT().DeleteGroupMembership(gomock.Any()).Times(1).Return(&identitystore.DeleteGroupMembershipOutput{}, nil)
+
+	assert.NoError(t, s.addMember(user, large))
+	assert.NoError(t, s.addMember(user, small))
+	assert.NoError(t, s.removeMember(user, large))
+	assert.NoError(t, s.removeMember(user, small))
+
+	assert.Equal(t, map[string][]string{"large-group": {"user@email.com"}}, scim.added)
+	assert.Equal(t, map[string][]string{"large-group": {"user@email.com"}}, scim.removed)
+}
+
+func Test_validateConfigMembershipBackends(t *testing.T) {
+	tests := []struct {
+		name     string
+		backends []string
+		wantErr  bool
+	}{
+		{name: "none"},
+		{name: "valid", backends: []string{"eng-*=scim", "ops=identitystore"}},
+		{name: "missing backend", backends: []string{"eng-*"}, wantErr: true},
+		{name: "unsupported backend", backends: []string{"eng-*=ldap"}, wantErr: true},
+		{name: "bad pattern", backends: []string{"eng-[=scim"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.New()
+			cfg.MembershipBackends = tt.backends
+			err := validateConfig(cfg)
+			assert.Equal(t, tt.wantErr, err != nil, err)
+		})
+	}
+}
+
+func Test_validateConfigOnlyPhases(t *testing.T) {
+	cfg := config.New()
+	cfg.OnlyPhases = []string{config.PhaseMembers, config.PhaseUsers}
+	assert.NoError(t, validateConfig(cfg))
+
+	cfg.OnlyPhases = []string{"memberships"}
+	assert.EqualError(t, validateConfig(cfg), `unsupported phase "memberships", expected any of users,groups,members`)
+}
+
+func Test_validateConfigThrottleCooldownWindow(t *testing.T) {
+	cfg := config.New()
+	assert.NoError(t, validateConfig(cfg))
+
+	cfg.ThrottleCooldownWindow = 0
+	assert.EqualError(t, validateConfig(cfg), "throttle cooldown window 0s is not positive, expected a duration such as 1m")
+
+	cfg.ThrottleCooldownWindow = -time.Minute
+	assert.EqualError(t, validateConfig(cfg), "throttle cooldown window -1m0s is not positive, expected a duration such as 1m")
+}
+
+func Test_validateConfigTimezoneField(t *testing.T) {
+	cfg := config.New()
+	cfg.TimezoneField = "Location.Timezone"
+	assert.EqualError(t, validateConfig(cfg), `timezone field "Location.Timezone" needs the full or custom google list projection`)
+
+	cfg.GoogleListProjection = config.GoogleListProjectionFull
+	assert.NoError(t, validateConfig(cfg))
+
+	cfg.TimezoneField = "Timezone"
+	assert.EqualError(t, validateConfig(cfg), `unsupported timezone field "Timezone", expected schema.field`)
+}
+
+func Test_validateConfigManagerKey(t *testing.T) {
+	cfg := config.New()
+	cfg.ManagerKey = config.ManagerKeyEmployeeNumber
+	assert.NoError(t, validateConfig(cfg))
+
+	cfg.ManagerKey = "employeeId"
+	assert.EqualError(t, validateConfig(cfg), `unsupported manager key "employeeId", expected any of email,employeeNumber`)
+}
+
+func Test_getGoogleGroupsAndUsersDirectAndNestedMember(t *testing.T) {
+	user1 := &admin.User{
+		Name:         &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1"},
+		PrimaryEmail: "u1@email.com",
+	}
+	user2 := &admin.User{
+		Name:         &admin.UserName{GivenName: "name-2", FamilyName: "lastname-2"},
+		PrimaryEmail: "u2@email.com",
+	}
+
+	google := &fakeGoogleClient{
+		users: []*admin.User{user1, user2},
+		groups: []*admin.Group{
+			{Name: "parent", Email: "parent@email.com"},
+			{Name: "child", Email: "child@email.com"},
+		},
+		members: map[string][]*admin.Member{
+			"parent@email.com": {
+				{Email: "u1@email.com", Type: "USER", Status: "ACTIVE"},
+				{Email: "child@email.com", Type: "GROUP"},
+			},
+			"child@email.com": {
+				{Email: "u1@email.com", Type: "USER", Status: "ACTIVE"},
+				{Email: "u2@email.com", Type: "USER", Status: "ACTIVE"},
+			},
+		},
+	}
+
+	s := &syncGSuite{
+		google: google,
+		cfg:    config.New(),
+		users:  make(map[string]*aws.User),
+	}
+
+	_, _, gGroupsUsers, err := s.getGoogleGroupsAndUsers("*", "")
+	assert.NoError(t, err)
+
+	// u1 is listed twice in the flattened group but is a member once
+	assert.ElementsMatch(t, []*admin.User{user1, user2}, gGroupsUsers["parent"])
+}
+
+func Test_SyncGroupsContinueOnMemberError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockIdentityStoreClient := mocks.NewMockIdentityStoreAPI(ctrl)
+
+	google := &fakeGoogleClient{
+		groups: []*admin.Group{
+			{Name: "group-1", Email: "group-1@email.com"},
+			{Name: "group-2", Email: "group-2@email.com"},
+			{Name: "group-3", Email: "group-3@email.com"},
+		},
+		members: map[string][]*admin.Member{
+			"group-1@email.com": {{Email: "user-1@email.com", Type: "USER", Status: "ACTIVE"}},
+			"group-2@email.com": {{Email: "user-1@email.com", Type: "USER", Status: "ACTIVE"}},
+			"group-3@email.com": {{Email: "user-1@email.com", Type: "USER", Status: "ACTIVE"}},
+		},
+	}
+
+	awsClient := &fakeAWSClient{
+		groups: map[string]*aws.Group{
+			"group-1@email.com": {ID: "group-1", DisplayName: "group-1@email.com"},
+			"group-2@email.com": {ID: "group-2", DisplayName: "group-2@email.com"},
+			"group-3@email.com": {ID: "group-3", DisplayName: "group-3@email.com"},
+		},
+	}
+
+	cfg := config.New()
+	cfg.IdentityStoreID = "test-identity-store-id"
+	cfg.ContinueOnMemberError = true
+	cfg.IncludeGroups = []string{"group-1@email.com", "group-2@email.com", "group-3@email.com"}
+
+	s := &syncGSuite{
+		aws:                 awsClient,
+		google:              google,
+		cfg:                 cfg,
+		identityStoreClient: mockIdentityStoreClient,
+		users: map[string]*aws.User{
+			"user-1@email.com": {ID: "user-1", Username: "user-1@email.com"},
+		},
+	}
+
+	mockIdentityStoreClient.EXPECT().IsMemberInGroups(gomock.Any()).Times(3).Return(&identitystore.IsMemberInGroupsOutput{
+		Results: []*identitystore.GroupMembershipExistenceResult{{MembershipExists: aws_sdk.Bool(false)}},
+	}, nil)
+
+	added := make([]string, 0)
+	mockIdentityStoreClient.EXPECT().CreateGroupMembership(gomock.Any()).Times(3).DoAndReturn(
+		func(input *identitystore.CreateGroupMembershipInput) (*identitystore.CreateGroupMembershipOutput, error) {
+			if *input.GroupId == "group-2" {
+				return nil, errors.New("throttled")
+			}
+			added = append(added, *input.GroupId)
+			return &identitystore.CreateGroupMembershipOutput{}, nil
+		})
+
+	err := s.SyncGroups("*")
+
+	// the first and third group are still applied
+	assert.Equal(t, []string{"group-1", "group-3"}, added)
+	assert.Equal(t, 2, s.Stats().MembershipsAdded)
+
+	var errs memberErrors
+	if assert.True(t, errors.As(err, &errs)) {
+		assert.Len(t, errs, 1)
+		assert.Contains(t, err.Error(), "group-2@email.com")
+	}
+}
+
+func Test_getGoogleGroupsAndUsersConcurrentMemberFetch(t *testing.T) {
+	users := make([]*admin.User, 0)
+	groups := make([]*admin.Group, 0)
+	members := make(map[string][]*admin.Member)
+	for i := 0; i < 20; i++ {
+		email := "user-" + strconv.Itoa(i) + "@email.com"
+		users = append(users, &admin.User{
+			Name:         &admin.UserName{GivenName: "name", FamilyName: "lastname"},
+			PrimaryEmail: email,
+		})
+
+		group := &admin.Group{Name: "group-" + strconv.Itoa(i), Email: "group-" + strconv.Itoa(i) + "@email.com"}
+		groups = append(groups, group)
+		members[group.Email] = []*admin.Member{
+			{Email: email, Type: "USER", Status: "ACTIVE"},
+			{Email: "external-" + strconv.Itoa(i) + "@other.com", Type: "USER", Status: "UNKNOWN"},
+		}
+	}
+
+	google := &fakeGoogleClient{users: users, groups: groups, members: members}
+
+	for _, concurrency := range []int{1, 8} {
+		t.Run(strconv.Itoa(concurrency), func(t *testing.T) {
+			cfg := config.New()
+			cfg.GoogleMemberFetchConcurrency = concurrency
+
+			s := &syncGSuite{
+				google: google,
+				cfg:    cfg,
+				users:  make(map[string]*aws.User),
+			}
+
+			gotGroups, gotUsers, gGroupsUsers, err := s.getGoogleGroupsAndUsers("*", "")
+			assert.NoError(t, err)
+			assert.Len(t, gotGroups, 20)
+			assert.Len(t, gotUsers, 20)
+			assert.Len(t, s.unresolved, 20)
+
+			for i, g := range groups {
+				assert.Equal(t, []*admin.User{users[i]}, gGroupsUsers[g.Name])
+			}
+		})
+	}
+}
+
+// syncGroupsUsersFixture is the state of google and aws in the SyncGroupsUsers
+// tests: user-1 and group-1 are new, user-2 was renamed and is managed by
+// user-1, user-3 and group-old were removed from google and user-3 is moved
+// out of group-2
+type syncGroupsUsersFixture struct {
+	googleUsers  []*admin.User
+	googleGroups []*admin.Group
+	sdkUsers     []*identitystore.User
+	aws          *fakeAWSClient
+}
+
+// newTestSyncGroupsUsers returns a sync of the fixture, with the reads of
+// the identity store expected
+func newTestSyncGroupsUsers(ctrl *gomock.Controller, cfg *config.Config) (SyncGSuite, *mocks.MockIdentityStoreAPI, *syncGroupsUsersFixture) {
+	mockIdentityStoreClient := mocks.NewMockIdentityStoreAPI(ctrl)
+
+	googleUsers := []*admin.User{
+		{Name: &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1"}, PrimaryEmail: "user-1@email.com"},
+		{
+			Name:         &admin.UserName{GivenName: "name-2", FamilyName: "renamed-2"},
+			PrimaryEmail: "user-2@email.com",
+			Relations:    []interface{}{map[string]interface{}{"type": "manager", "value": "user-1@email.com"}},
+		},
+	}
+	googleGroups := []*admin.Group{
+		{Name: "group-1", Email: "group-1@email.com"},
+		{Name: "group-2", Email: "group-2@email.com"},
+	}
+	google := &fakeGoogleClient{
+		users:  googleUsers,
+		groups: googleGroups,
+		members: map[string][]*admin.Member{
+			"group-1@email.com": {
+				{Email: "user-1@email.com", Type: "USER", Status: "ACTIVE"},
+				{Email: "user-2@email.com", Type: "USER", Status: "ACTIVE"},
+			},
+			"group-2@email.com": {{Email: "user-2@email.com", Type: "USER", Status: "ACTIVE"}},
+		},
+	}
+
+	sdkUsers := []*identitystore.User{
+		{
+			UserId:      aws_sdk.String("id-user-2"),
+			UserName:    aws_sdk.String("user-2@email.com"),
+			DisplayName: aws_sdk.String("name-2 lastname-2"),
+			Name:        &identitystore.Name{GivenName: aws_sdk.String("name-2"), FamilyName: aws_sdk.String("lastname-2")},
+			Emails:      []*identitystore.Email{{Value: aws_sdk.String("user-2@email.com"), Type: aws_sdk.String("work"), Primary: aws_sdk.Bool(true)}},
+		},
+		{
+			UserId:      aws_sdk.String("id-user-3"),
+			UserName:    aws_sdk.String("user-3@email.com"),
+			DisplayName: aws_sdk.String("name-3 lastname-3"),
+			Name:        &identitystore.Name{GivenName: aws_sdk.String("name-3"), FamilyName: aws_sdk.String("lastname-3")},
+			Emails:      []*identitystore.Email{{Value: aws_sdk.String("user-3@email.com"), Type: aws_sdk.String("work"), Primary: aws_sdk.Bool(true)}},
+		},
+	}
+	sdkGroups := []*identitystore.Group{
+		{GroupId: aws_sdk.String("group-2"), DisplayName: aws_sdk.String("group-2")},
+		{GroupId: aws_sdk.String("group-old"), DisplayName: aws_sdk.String("group-old")},
+	}
+
+	awsClient := &fakeAWSClient{
+		groups: map[string]*aws.Group{
+			"group-old": {ID: "group-old", DisplayName: "group-old"},
+		},
+		users: map[string]*aws.User{
+			"user-2@email.com": {ID: "id-user-2", Username: "user-2@email.com", Active: true},
+			"user-3@email.com": {ID: "id-user-3", Username: "user-3@email.com", Active: true},
+		},
+	}
+
+	s := New(cfg, awsClient, google, mockIdentityStoreClient)
+
+	mockIdentityStoreClient.EXPECT().ListGroupsPages(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(input *identitystore.ListGroupsInput, fn func(*identitystore.ListGroupsOutput, bool) bool) error {
+			fn(&identitystore.ListGroupsOutput{Groups: sdkGroups}, true)
+			return nil
+		})
+	mockIdentityStoreClient.EXPECT().ListUsersPages(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(input *identitystore.ListUsersInput, fn func(*identitystore.ListUsersOutput, bool) bool) error {
+			fn(&identitystore.ListUsersOutput{Users: sdkUsers}, true)
+			return nil
+		})
+	mockIdentityStoreClient.EXPECT().ListGroupMembershipsPagesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Times(2).DoAndReturn(
+		func(ctx aws_sdk.Context, input *identitystore.ListGroupMembershipsInput, fn func(*identitystore.ListGroupMembershipsOutput, bool) bool, opts ...request.Option) error {
+			out := &identitystore.ListGroupMembershipsOutput{}
+			if *input.GroupId == "group-2" {
+				out.GroupMemberships = []*identitystore.GroupMembership{
+					{GroupId: aws_sdk.String("group-2"), MemberId: &identitystore.MemberId{UserId: aws_sdk.String("id-user-3")}},
+				}
+			}
+			fn(out, true)
+			return nil
+		})
+
+	return s, mockIdentityStoreClient, &syncGroupsUsersFixture{googleUsers: googleUsers, googleGroups: googleGroups, sdkUsers: sdkUsers, aws: awsClient}
+}
+
+// expectSyncGroupsUsersChanges expects the identity store changes of the fixture
+func expectSyncGroupsUsersChanges(mockIdentityStoreClient *mocks.MockIdentityStoreAPI) {
+	mockIdentityStoreClient.EXPECT().DeleteUserWithContext(gomock.Any(), gomock.Any()).Return(&identitystore.DeleteUserOutput{}, nil)
+	mockIdentityStoreClient.EXPECT().CreateGroupWithContext(gomock.Any(), gomock.Any()).Return(&identitystore.CreateGroupOutput{GroupId: aws_sdk.String("group-1")}, nil)
+	mockIdentityStoreClient.EXPECT().CreateGroupMembership(gomock.Any()).Times(3).Return(&identitystore.CreateGroupMembershipOutput{}, nil)
+	mockIdentityStoreClient.EXPECT().IsMemberInGroups(gomock.Any()).Return(&identitystore.IsMemberInGroupsOutput{
+		Results: []*identitystore.GroupMembershipExistenceResult{{MembershipExists: aws_sdk.Bool(false)}},
+	}, nil)
+	mockIdentityStoreClient.EXPECT().GetGroupMembershipId(gomock.Any()).Return(&identitystore.GetGroupMembershipIdOutput{MembershipId: aws_sdk.String("membership-1")}, nil)
+	mockIdentityStoreClient.EXPECT().DeleteGroupMembership(gomock.Any()).Return(&identitystore.DeleteGroupMembershipOutput{}, nil)
+	mockIdentityStoreClient.EXPECT().DeleteGroupWithContext(gomock.Any(), gomock.Any()).Return(&identitystore.DeleteGroupOutput{}, nil)
+}
+
+// fixtureStats are the changes of the fixture
+var fixtureStats = SyncStats{
+	UsersCreated:       1,
+	UsersUpdated:       1,
+	UsersDeleted:       1,
+	GroupsCreated:      1,
+	GroupsDeleted:      1,
+	MembershipsAdded:   3,
+	MembershipsRemoved: 1,
+}
+
+func Test_SyncGroupsUsersOptions(t *testing.T) {
+	// the outputs of the run, set up by the cases that check them
+	var (
+		buf   bytes.Buffer
+		hook  *logtest.Hook
+		store *fakeObjectStore
+	)
+
+	tests := []struct {
+		name string
+		// configure sets the options of the case
+		configure func(t *testing.T, cfg *config.Config)
+		// setup prepares the sync of the fixture before it runs
+		setup func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture)
+		// expect expects the identity store changes, the ones of the
+		// fixture when nil
+		expect  func(mockIdentityStoreClient *mocks.MockIdentityStoreAPI)
+		wantErr bool
+		check   func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture)
+	}{
+		{
+			name: "stats",
+			check: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				assert.Equal(t, fixtureStats, s.Stats())
+			},
+		},
+		{
+			name: "file source",
+			configure: func(t *testing.T, cfg *config.Config) {
+				cfg.SourceProvider = config.SourceProviderFile
+				cfg.SourceFile = filepath.Join("testdata", "directory.json")
+			},
+			setup: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				// the export holds the same directory as the google fixture
+				src, err := newSourceClient(context.Background(), s.cfg, nil)
+				assert.NoError(t, err)
+				s.google = src
+			},
+			check: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				assert.Equal(t, fixtureStats, s.Stats())
+			},
+		},
+		{
+			name:      "managers",
+			configure: func(t *testing.T, cfg *config.Config) { cfg.SyncManager = true },
+			check: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				// user-1 is only created in this run, its id is resolved once it exists
+				assert.Equal(t, map[string]string{"id-user-2": "id-user-1@email.com"}, f.aws.managers)
+			},
+		},
+		{
+			name:      "updated users keep their manager",
+			configure: func(t *testing.T, cfg *config.Config) { cfg.SyncManager = true },
+			setup: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				f.aws.users["user-2@email.com"].Enterprise = &aws.EnterpriseUser{Manager: &aws.ManagerRef{Value: "id-user-1@email.com"}}
+			},
+			check: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				// the manager is unchanged so it isn't patched, the rename must keep it
+				assert.Empty(t, f.aws.managers)
+				assert.Equal(t, "id-user-1@email.com", f.aws.updated[0].ManagerID())
+			},
+		},
+		{
+			name:      "backfill external ids",
+			configure: func(t *testing.T, cfg *config.Config) { cfg.BackfillExternalIDs = true },
+			setup: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				f.googleUsers[0].Id = "g-user-1"
+				f.googleUsers[1].Id = "g-user-2"
+			},
+			check: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				// user-3 isn't in google, user-1 is created with its external id and
+				// the update of user-2 keeps the backfilled one
+				assert.Equal(t, map[string]string{"id-user-2": "g-user-2"}, f.aws.externalIDs)
+				assert.Equal(t, "g-user-1", f.aws.created[0].ExternalID)
+				assert.Equal(t, "g-user-2", f.aws.updated[0].ExternalID)
+			},
+		},
+		{
+			name: "output plan",
+			configure: func(t *testing.T, cfg *config.Config) {
+				cfg.OutputPlan = filepath.Join(t.TempDir(), "plan.json")
+			},
+			check: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				b, err := ioutil.ReadFile(s.cfg.OutputPlan)
+				assert.NoError(t, err)
+
+				var plan syncPlan
+				assert.NoError(t, json.Unmarshal(b, &plan))
+				assert.Equal(t, syncPlan{
+					AddUsers:     []string{"user-1@email.com"},
+					UpdateUsers:  []string{"user-2@email.com"},
+					DeleteUsers:  []string{"user-3@email.com"},
+					AddGroups:    []string{"group-1"},
+					DeleteGroups: []string{"group-old"},
+					AddMembers: map[string][]string{
+						"group-1": {"user-1@email.com", "user-2@email.com"},
+						"group-2": {"user-2@email.com"},
+					},
+					RemoveMembers: map[string][]string{"group-2": {"user-3@email.com"}},
+				}, plan)
+			},
+		},
+		{
+			name:      "verbose plan",
+			configure: func(t *testing.T, cfg *config.Config) { cfg.VerbosePlan = true },
+			setup: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				hook = logtest.NewGlobal()
+				t.Cleanup(hook.Reset)
+			},
+			check: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				planned := make([]string, 0)
+				firstChange := -1
+				for i, entry := range hook.AllEntries() {
+					switch entry.Message {
+					case "planned":
+						assert.Equal(t, -1, firstChange, "operation logged after a change was made")
+						planned = append(planned, entry.Data["operation"].(string))
+					case "deleting user", "updating user", "creating user", "creating group":
+						if firstChange == -1 {
+							firstChange = i
+						}
+					}
+				}
+
+				assert.NotEqual(t, -1, firstChange)
+				assert.Equal(t, []string{
+					"add group group-1",
+					"add member user-1@email.com to group group-1",
+					"add member user-2@email.com to group group-1",
+					"add member user-2@email.com to group group-2",
+					"add user user-1@email.com",
+					"delete group group-old",
+					"delete user user-3@email.com",
+					"remove member user-3@email.com from group group-2",
+					"update user user-2@email.com",
+				}, planned)
+			},
+		},
+		{
+			name:      "plan summary",
+			configure: func(t *testing.T, cfg *config.Config) { cfg.PlanSummary = true },
+			setup:     func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) { s.summaryOut = &buf },
+			check: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				for _, row := range []string{
+					"users created    1      user-1@email.com",
+					"users updated    1      user-2@email.com",
+					"users deleted    1      user-3@email.com",
+					"groups created   1      group-1",
+					"groups deleted   1      group-old",
+					"members added    3      user-1@email.com in group-1, user-2@email.com in group-1, user-2@email.com in group-2",
+					"members removed  1      user-3@email.com in group-2",
+				} {
+					assert.Contains(t, buf.String(), row)
+				}
+			},
+		},
+		{
+			name:  "audit log",
+			setup: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) { s.audit = newAuditLog(&buf) },
+			check: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				operations := make(map[string]int)
+				var reasons []updateReason
+				scanner := bufio.NewScanner(&buf)
+				for scanner.Scan() {
+					var r auditRecord
+					assert.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+					assert.False(t, r.Time.IsZero())
+					operations[r.Operation]++
+					reasons = append(reasons, r.Reasons...)
+				}
+
+				// one record per change counted in the stats
+				assert.Equal(t, map[string]int{
+					auditCreateUser:   1,
+					auditUpdateUser:   1,
+					auditDeleteUser:   1,
+					auditCreateGroup:  1,
+					auditDeleteGroup:  1,
+					auditAddMember:    3,
+					auditRemoveMember: 1,
+				}, operations)
+				// the update of the renamed user-2 records why it was made
+				assert.Equal(t, []updateReason{updateReasonName}, reasons)
+			},
+		},
+		{
+			name:      "correlation cache",
+			configure: func(t *testing.T, cfg *config.Config) { cfg.CorrelationCacheS3URI = testCacheURI },
+			setup: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				store = &fakeObjectStore{}
+				s.cacheStore = store
+			},
+			check: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				// the listing is cached with the changes of the run to fetch again
+				var saved correlationCache
+				assert.NoError(t, json.Unmarshal(store.objects["bucket/cache.json"], &saved))
+				assert.Equal(t, "test-identity-store-id", saved.IdentityStoreID)
+				assert.NotEmpty(t, saved.Users)
+				for _, u := range saved.Users {
+					assert.NotEqual(t, "user-3@email.com", u.Username)
+				}
+				assert.Equal(t, []string{"group-1"}, saved.ChangedGroups)
+			},
+		},
+		{
+			name:      "report drift",
+			configure: func(t *testing.T, cfg *config.Config) { cfg.ReportDrift = true },
+			check: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				assert.Equal(t, []driftResource{
+					{Kind: driftUser, Name: "user-3@email.com", ID: "id-user-3", Action: driftActionDelete},
+					{Kind: driftGroup, Name: "group-old", ID: "group-old", Action: driftActionDelete},
+				}, s.drift)
+				assert.Equal(t, 1, s.Stats().AWSOnlyUsers)
+				assert.Equal(t, 1, s.Stats().AWSOnlyGroups)
+				assert.Contains(t, s.Stats().String(), "aws only users: 1, groups: 1")
+			},
+		},
+		{
+			name: "no drift by default",
+			check: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				assert.Empty(t, s.drift)
+				assert.Zero(t, s.Stats().AWSOnlyUsers)
+			},
+		},
+		{
+			name:      "permission set impact",
+			configure: func(t *testing.T, cfg *config.Config) { cfg.SSOInstanceArn = "arn:instance" },
+			setup: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				s.ssoAdmin = &fakeSSOAdmin{assignments: map[string]map[string][]*ssoadmin.AccountAssignment{
+					"arn:ps-admin": {
+						"111111111111": {
+							assignment("111111111111", "arn:ps-admin", ssoadmin.PrincipalTypeGroup, "group-2"),
+							assignment("111111111111", "arn:ps-admin", ssoadmin.PrincipalTypeGroup, "group-old"),
+							assignment("111111111111", "arn:ps-admin", ssoadmin.PrincipalTypeUser, "id-user-3"),
+						},
+					},
+				}}
+			},
+			check: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				// user-3 is deleted and loses the same assignment directly and through
+				// group-2, it's counted once; group-old is deleted
+				assert.Equal(t, 2, s.Stats().AssignmentsAffected)
+				assert.Len(t, s.impact, 2)
+			},
+		},
+		{
+			name: "plan permission set impact",
+			configure: func(t *testing.T, cfg *config.Config) {
+				cfg.SSOInstanceArn = "arn:instance"
+				cfg.OutputPlan = filepath.Join(t.TempDir(), "plan.json")
+				cfg.MaxDeletions = 1
+			},
+			setup: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				s.ssoAdmin = &fakeSSOAdmin{assignments: map[string]map[string][]*ssoadmin.AccountAssignment{
+					"arn:ps-admin": {
+						"111111111111": {assignment("111111111111", "arn:ps-admin", ssoadmin.PrincipalTypeUser, "id-user-3")},
+					},
+				}}
+			},
+			// the plan is written even when the deletions are then refused
+			expect:  func(*mocks.MockIdentityStoreAPI) {},
+			wantErr: true,
+			check: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				b, err := ioutil.ReadFile(s.cfg.OutputPlan)
+				assert.NoError(t, err)
+
+				var plan syncPlan
+				assert.NoError(t, json.Unmarshal(b, &plan))
+				assert.Equal(t, []accessImpact{{
+					Principal:   "user-3@email.com",
+					Change:      impactUserDeleted,
+					Assignments: []permissionSetAssignment{{AccountID: "111111111111", PermissionSetArn: "arn:ps-admin"}},
+				}}, plan.Impact)
+			},
+		},
+		{
+			name: "only members",
+			configure: func(t *testing.T, cfg *config.Config) {
+				cfg.OnlyPhases = []string{config.PhaseMembers}
+				cfg.PlanSummary = true
+				cfg.BackfillExternalIDs = true
+				// user-3 and group-old would be deleted by the other phases
+				cfg.MaxDeletions = 1
+			},
+			setup: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				f.googleUsers[1].Id = "g-user-2"
+				s.summaryOut = &buf
+			},
+			// only the members of group-2 change, group-1 and user-1 aren't created
+			expect: func(mockIdentityStoreClient *mocks.MockIdentityStoreAPI) {
+				mockIdentityStoreClient.EXPECT().IsMemberInGroups(gomock.Any()).Return(&identitystore.IsMemberInGroupsOutput{
+					Results: []*identitystore.GroupMembershipExistenceResult{{MembershipExists: aws_sdk.Bool(false)}},
+				}, nil)
+				mockIdentityStoreClient.EXPECT().CreateGroupMembership(gomock.Any()).Return(&identitystore.CreateGroupMembershipOutput{}, nil)
+				mockIdentityStoreClient.EXPECT().GetGroupMembershipId(gomock.Any()).Return(&identitystore.GetGroupMembershipIdOutput{MembershipId: aws_sdk.String("membership-1")}, nil)
+				mockIdentityStoreClient.EXPECT().DeleteGroupMembership(gomock.Any()).Return(&identitystore.DeleteGroupMembershipOutput{}, nil)
+			},
+			check: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				assert.Equal(t, SyncStats{MembershipsAdded: 1, MembershipsRemoved: 1}, s.Stats())
+				assert.Empty(t, f.aws.created)
+				assert.Empty(t, f.aws.updated)
+				assert.Empty(t, f.aws.externalIDs)
+
+				// only the applied changes are planned
+				for _, row := range []string{
+					"users created    0",
+					"users updated    0",
+					"users deleted    0",
+					"groups created   0",
+					"groups deleted   0",
+					"members added    1      user-2@email.com in group-2",
+					"members removed  1      user-3@email.com in group-2",
+				} {
+					assert.Contains(t, buf.String(), row)
+				}
+			},
+		},
+		{
+			name: "only users and groups",
+			configure: func(t *testing.T, cfg *config.Config) {
+				cfg.OnlyPhases = []string{config.PhaseUsers, config.PhaseGroups}
+				cfg.PlanSummary = true
+			},
+			setup: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) { s.summaryOut = &buf },
+			// group-1 is created without its members
+			expect: func(mockIdentityStoreClient *mocks.MockIdentityStoreAPI) {
+				mockIdentityStoreClient.EXPECT().DeleteUserWithContext(gomock.Any(), gomock.Any()).Return(&identitystore.DeleteUserOutput{}, nil)
+				mockIdentityStoreClient.EXPECT().CreateGroupWithContext(gomock.Any(), gomock.Any()).Return(&identitystore.CreateGroupOutput{GroupId: aws_sdk.String("group-1")}, nil)
+				mockIdentityStoreClient.EXPECT().DeleteGroupWithContext(gomock.Any(), gomock.Any()).Return(&identitystore.DeleteGroupOutput{}, nil)
+			},
+			check: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				assert.Equal(t, SyncStats{
+					UsersCreated:  1,
+					UsersUpdated:  1,
+					UsersDeleted:  1,
+					GroupsCreated: 1,
+					GroupsDeleted: 1,
+				}, s.Stats())
+				assert.Contains(t, buf.String(), "members added    0")
+				assert.Contains(t, buf.String(), "members removed  0")
+			},
+		},
+		{
+			name:      "deactivate",
+			configure: func(t *testing.T, cfg *config.Config) { cfg.UserDeleteStrategy = config.UserDeleteStrategyDeactivate },
+			setup: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				f.sdkUsers[1].Title = aws_sdk.String("engineer")
+				f.sdkUsers[1].PhoneNumbers = []*identitystore.PhoneNumber{{Value: aws_sdk.String("+1 555 0100"), Type: aws_sdk.String("work")}}
+				f.aws.users["user-3@email.com"].ExternalID = "g-user-3"
+			},
+			// the changes of the fixture, user-3 is deactivated instead of deleted
+			expect: func(mockIdentityStoreClient *mocks.MockIdentityStoreAPI) {
+				mockIdentityStoreClient.EXPECT().CreateGroupWithContext(gomock.Any(), gomock.Any()).Return(&identitystore.CreateGroupOutput{GroupId: aws_sdk.String("group-1")}, nil)
+				mockIdentityStoreClient.EXPECT().CreateGroupMembership(gomock.Any()).Times(3).Return(&identitystore.CreateGroupMembershipOutput{}, nil)
+				mockIdentityStoreClient.EXPECT().IsMemberInGroups(gomock.Any()).Return(&identitystore.IsMemberInGroupsOutput{
+					Results: []*identitystore.GroupMembershipExistenceResult{{MembershipExists: aws_sdk.Bool(false)}},
+				}, nil)
+				mockIdentityStoreClient.EXPECT().GetGroupMembershipId(gomock.Any()).Return(&identitystore.GetGroupMembershipIdOutput{MembershipId: aws_sdk.String("membership-1")}, nil)
+				mockIdentityStoreClient.EXPECT().DeleteGroupMembership(gomock.Any()).Return(&identitystore.DeleteGroupMembershipOutput{}, nil)
+				mockIdentityStoreClient.EXPECT().DeleteGroupWithContext(gomock.Any(), gomock.Any()).Return(&identitystore.DeleteGroupOutput{}, nil)
+			},
+			check: func(t *testing.T, s *syncGSuite, f *syncGroupsUsersFixture) {
+				assert.Equal(t, 0, s.Stats().UsersDeleted)
+
+				var deactivated *aws.User
+				for _, u := range f.aws.updated {
+					if u.Username == "user-3@email.com" {
+						deactivated = u
+					}
+				}
+				if assert.NotNil(t, deactivated) {
+					assert.Equal(t, "id-user-3", deactivated.ID)
+					assert.False(t, deactivated.Active)
+					// the replace keeps the attributes of the user
+					assert.Equal(t, "name-3", deactivated.Name.GivenName)
+					assert.Equal(t, "engineer", deactivated.Title)
+					assert.Equal(t, "g-user-3", deactivated.ExternalID)
+					assert.Equal(t, []aws.UserPhoneNumber{{Value: "+1 555 0100", Type: "work"}}, deactivated.PhoneNumbers)
+					assert.Equal(t, []aws.UserEmail{{Value: "user-3@email.com", Type: "work", Primary: true}}, deactivated.Emails)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			buf.Reset()
+
+			cfg := config.New()
+			cfg.IdentityStoreID = "test-identity-store-id"
+			if tt.configure != nil {
+				tt.configure(t, cfg)
+			}
+
+			s, mockIdentityStoreClient, f := newTestSyncGroupsUsers(ctrl, cfg)
+			if tt.setup != nil {
+				tt.setup(t, s.(*syncGSuite), f)
+			}
+			if tt.expect != nil {
+				tt.expect(mockIdentityStoreClient)
+			} else {
+				expectSyncGroupsUsersChanges(mockIdentityStoreClient)
+			}
+
+			err := s.SyncGroupsUsers("*", "")
+			assert.Equal(t, tt.wantErr, err != nil, err)
+			tt.check(t, s.(*syncGSuite), f)
+		})
+	}
+}
+
+func Test_SyncGroupsUsersMemberFetchError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := config.New()
+	cfg.IdentityStoreID = "test-identity-store-id"
+
+	s, mockIdentityStoreClient, _ := newTestSyncGroupsUsers(ctrl, cfg)
+	s.(*syncGSuite).google.(*fakeGoogleClient).memberErrs = map[string]error{
+		"group-2@email.com": errors.New("backend error"),
+	}
+
+	// user-3 is only in aws, but as a member of group-2 it may still be a
+	// google member, so neither the user nor its membership is removed
+	mockIdentityStoreClient.EXPECT().CreateGroupWithContext(gomock.Any(), gomock.Any()).Return(&identitystore.CreateGroupOutput{GroupId: aws_sdk.String("group-1")}, nil)
+	mockIdentityStoreClient.EXPECT().CreateGroupMembership(gomock.Any()).Times(2).Return(&identitystore.CreateGroupMembershipOutput{}, nil)
+	mockIdentityStoreClient.EXPECT().DeleteGroupWithContext(gomock.Any(), gomock.Any()).Return(&identitystore.DeleteGroupOutput{}, nil)
+
+	assert.NoError(t, s.SyncGroupsUsers("*", ""))
+	assert.Equal(t, 0, s.Stats().UsersDeleted)
+	assert.Equal(t, 0, s.Stats().MembershipsRemoved)
+}
+
+func Test_SyncGroupsUsersMaxDeletions(t *testing.T) {
+	cfgs := map[string]func(*config.Config){
+		"absolute":   func(cfg *config.Config) { cfg.MaxDeletions = 1 },
+		"percentage": func(cfg *config.Config) { cfg.MaxDeletionsPercent = 25 },
+	}
+	for name, set := range cfgs {
+		t.Run(name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			cfg := config.New()
+			cfg.IdentityStoreID = "test-identity-store-id"
+			set(cfg)
+
+			// user-3 and group-old are 2 of the 4 aws users and groups, the
+			// sync stops before any change as no other calls are expected
+			s, _, _ := newTestSyncGroupsUsers(ctrl, cfg)
+
+			err := s.SyncGroupsUsers("*", "")
+			assert.Error(t, err)
+			assert.Equal(t, SyncStats{}, s.Stats())
+		})
+	}
+}
+
+func Test_checkDeletions(t *testing.T) {
+	tests := []struct {
+		name       string
+		max        int
+		maxPercent int
+		deletions  int
+		population int
+		wantErr    bool
+	}{
+		{name: "no limits", deletions: 100, population: 100},
+		{name: "under absolute limit", max: 5, deletions: 5, population: 100},
+		{name: "over absolute limit", max: 5, deletions: 6, population: 100, wantErr: true},
+		{name: "under percentage limit", maxPercent: 10, deletions: 10, population: 100},
+		{name: "over percentage limit", maxPercent: 10, deletions: 11, population: 100, wantErr: true},
+		{name: "percentage of small population", maxPercent: 50, deletions: 2, population: 3, wantErr: true},
+		{name: "empty population", maxPercent: 10, deletions: 0, population: 0},
+		{name: "both limits, over percentage", max: 50, maxPercent: 10, deletions: 20, population: 100, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.New()
+			cfg.MaxDeletions = tt.max
+			cfg.MaxDeletionsPercent = tt.maxPercent
+			s := &syncGSuite{cfg: cfg}
+
+			err := s.checkDeletions(tt.deletions, tt.population)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_SyncGroupsUsersEmptySource(t *testing.T) {
+	sdkUsers := []*identitystore.User{
+		{
+			UserId:      aws_sdk.String("id-user-1"),
+			UserName:    aws_sdk.String("user-1@email.com"),
+			DisplayName: aws_sdk.String("name-1 lastname-1"),
+			Name:        &identitystore.Name{GivenName: aws_sdk.String("name-1"), FamilyName: aws_sdk.String("lastname-1")},
+		},
+	}
+	sdkGroups := []*identitystore.Group{
+		{GroupId: aws_sdk.String("group-1"), DisplayName: aws_sdk.String("group-1")},
+	}
+
+	tests := []struct {
+		name        string
+		groups      []*identitystore.Group
+		allowEmpty  bool
+		wantErr     bool
+		wantDeleted int
+	}{
+		{name: "empty groups", groups: sdkGroups, wantErr: true},
+		{name: "empty users", wantErr: true},
+		{name: "allowed", allowEmpty: true, wantDeleted: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockIdentityStoreClient := mocks.NewMockIdentityStoreAPI(ctrl)
+
+			cfg := config.New()
+			cfg.IdentityStoreID = "test-identity-store-id"
+			cfg.AllowEmptySource = tt.allowEmpty
+
+			awsClient := &fakeAWSClient{
+				users: map[string]*aws.User{
+					"user-1@email.com": {ID: "id-user-1", Username: "user-1@email.com", Active: true},
+				},
+			}
+			s := New(cfg, awsClient, &fakeGoogleClient{}, mockIdentityStoreClient)
+
+			mockIdentityStoreClient.EXPECT().ListGroupsPages(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(input *identitystore.ListGroupsInput, fn func(*identitystore.ListGroupsOutput, bool) bool) error {
+					fn(&identitystore.ListGroupsOutput{Groups: tt.groups}, true)
+					return nil
+				})
+			mockIdentityStoreClient.EXPECT().ListUsersPages(gomock.Any(), gomock.Any()).MaxTimes(1).DoAndReturn(
+				func(input *identitystore.ListUsersInput, fn func(*identitystore.ListUsersOutput, bool) bool) error {
+					fn(&identitystore.ListUsersOutput{Users: sdkUsers}, true)
+					return nil
+				})
+			mockIdentityStoreClient.EXPECT().DeleteUserWithContext(gomock.Any(), gomock.Any()).Times(tt.wantDeleted).Return(&identitystore.DeleteUserOutput{}, nil)
+
+			err := s.SyncGroupsUsers("*", "*")
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantDeleted, s.Stats().UsersDeleted)
+		})
+	}
+}
+
+func Test_SyncGroupsMaxErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxErrors int
+		wantCalls int
+		wantErrs  int
+	}{
+		{name: "no limit", maxErrors: 0, wantCalls: 3, wantErrs: 3},
+		{name: "below threshold", maxErrors: 3, wantCalls: 3, wantErrs: 3},
+		{name: "abort at threshold", maxErrors: 1, wantCalls: 2, wantErrs: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockIdentityStoreClient := mocks.NewMockIdentityStoreAPI(ctrl)
+
+			google := &fakeGoogleClient{
+				groups: []*admin.Group{
+					{Name: "group-1", Email: "group-1@email.com"},
+					{Name: "group-2", Email: "group-2@email.com"},
+					{Name: "group-3", Email: "group-3@email.com"},
+				},
+				members: map[string][]*admin.Member{
+					"group-1@email.com": {{Email: "user-1@email.com", Type: "USER", Status: "ACTIVE"}},
+					"group-2@email.com": {{Email: "user-1@email.com", Type: "USER", Status: "ACTIVE"}},
+					"group-3@email.com": {{Email: "user-1@email.com", Type: "USER", Status: "ACTIVE"}},
+				},
+			}
+
+			awsClient := &fakeAWSClient{
+				groups: map[string]*aws.Group{
+					"group-1@email.com": {ID: "group-1", DisplayName: "group-1@email.com"},
+					"group-2@email.com": {ID: "group-2", DisplayName: "group-2@email.com"},
+					"group-3@email.com": {ID: "group-3", DisplayName: "group-3@email.com"},
+				},
+			}
+
+			cfg := config.New()
+			cfg.IdentityStoreID = "test-identity-store-id"
+			cfg.ContinueOnMemberError = true
+			cfg.MaxErrors = tt.maxErrors
+			cfg.IncludeGroups = []string{"group-1@email.com", "group-2@email.com", "group-3@email.com"}
+
+			s := &syncGSuite{
+				aws:                 awsClient,
+				google:              google,
+				cfg:                 cfg,
+				identityStoreClient: mockIdentityStoreClient,
+				users: map[string]*aws.User{
+					"user-1@email.com": {ID: "user-1", Username: "user-1@email.com"},
+				},
+			}
+
+			mockIdentityStoreClient.EXPECT().IsMemberInGroups(gomock.Any()).Times(tt.wantCalls).Return(&identitystore.IsMemberInGroupsOutput{
+				Results: []*identitystore.GroupMembershipExistenceResult{{MembershipExists: aws_sdk.Bool(false)}},
+			}, nil)
+			mockIdentityStoreClient.EXPECT().CreateGroupMembership(gomock.Any()).Times(tt.wantCalls).Return(nil, errors.New("unavailable"))
+
+			err := s.SyncGroups("*")
+
+			var errs memberErrors
+			if assert.True(t, errors.As(err, &errs)) {
+				assert.Len(t, errs, tt.wantErrs)
+			}
+		})
+	}
+}
+
+func Test_googleManager(t *testing.T) {
+	tests := []struct {
+		name      string
+		relations interface{}
+		want      string
+	}{
+		{name: "no relations"},
+		{
+			name:      "decoded relations",
+			relations: []interface{}{map[string]interface{}{"type": "manager", "value": "boss@email.com"}},
+			want:      "boss@email.com",
+		},
+		{
+			name: "typed relations",
+			relations: []*admin.UserRelation{
+				{Type: "assistant", Value: "assistant@email.com"},
+				{Type: "manager", Value: "boss@email.com"},
+			},
+			want: "boss@email.com",
+		},
+		{
+			name:      "other relations",
+			relations: []*admin.UserRelation{{Type: "dotted_line_manager", Value: "other@email.com"}},
+		},
+		{name: "unexpected shape", relations: "manager"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, googleManager(&admin.User{Relations: tt.relations}))
+		})
+	}
+}
+
+func Test_syncManagers(t *testing.T) {
+	awsClient := &fakeAWSClient{
+		users: map[string]*aws.User{
+			"boss@email.com":   {ID: "id-boss", Username: "boss@email.com"},
+			"user-1@email.com": {ID: "id-user-1", Username: "user-1@email.com"},
+			"user-2@email.com": {ID: "id-user-2", Username: "user-2@email.com"},
+			"user-3@email.com": {ID: "id-user-3", Username: "user-3@email.com"},
+			"user-4@email.com": {ID: "id-user-4", Username: "user-4@email.com"},
+		},
+	}
+	s := &syncGSuite{aws: awsClient, cfg: config.New()}
+
+	managedBy := func(email string, manager string) *admin.User {
+		u := &admin.User{PrimaryEmail: email}
+		if manager != "" {
+			u.Relations = []*admin.UserRelation{{Type: "manager", Value: manager}}
+		}
+		return u
+	}
+	googleUsers := []*admin.User{
+		managedBy("user-1@email.com", "boss@email.com"),
+		managedBy("user-2@email.com", "boss@email.com"),
+		managedBy("user-3@email.com", ""),
+		managedBy("user-4@email.com", "external@email.com"),
+		managedBy("skipped@email.com", "boss@email.com"),
+	}
+	current := map[string]string{
+		"user-2@email.com": "id-boss",
+		"user-3@email.com": "id-boss",
+	}
+	skipped := map[string]struct{}{"skipped@email.com": {}}
+
+	err := s.syncManagers(googleUsers, current, skipped)
+	assert.NoError(t, err)
+
+	// user-2 is unchanged, user-3 no longer has a manager and the manager
+	// of user-4 is not synced
+	assert.Equal(t, map[string]string{"id-user-1": "id-boss", "id-user-3": ""}, awsClient.managers)
+
+	// the relations hold the employee ids of the managers with that key
+	awsClient.managers = nil
+	s.cfg.ManagerKey = config.ManagerKeyEmployeeNumber
+	boss := &admin.User{
+		PrimaryEmail: "boss@email.com",
+		ExternalIds:  []interface{}{map[string]interface{}{"type": "organization", "value": "E-1"}},
+	}
+	googleUsers = []*admin.User{
+		boss,
+		managedBy("user-1@email.com", "E-1"),
+		managedBy("user-2@email.com", "E-1"),
+		managedBy("user-4@email.com", "E-404"),
+	}
+
+	err = s.syncManagers(googleUsers, current, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"id-user-1": "id-boss"}, awsClient.managers)
+}
+
+func Test_googleEmployeeNumber(t *testing.T) {
+	assert.Empty(t, googleEmployeeNumber(&admin.User{}))
+	assert.Empty(t, googleEmployeeNumber(&admin.User{ExternalIds: []interface{}{map[string]interface{}{"type": "account", "value": "A-1"}}}))
+	assert.Equal(t, "E-1", googleEmployeeNumber(&admin.User{ExternalIds: []interface{}{
+		map[string]interface{}{"type": "account", "value": "A-1"},
+		map[string]interface{}{"type": "organization", "value": "E-1"},
+	}}))
+	assert.Empty(t, googleEmployeeNumber(&admin.User{ExternalIds: "E-1"}))
+}
+
+func Test_backfillExternalIDs(t *testing.T) {
+	awsClient := &fakeAWSClient{}
+	s := &syncGSuite{aws: awsClient, cfg: config.New()}
+
+	awsUsers := []*aws.User{
+		{ID: "id-user-1", Username: "user-1@email.com"},
+		{ID: "id-user-2", Username: "user-2@email.com"},
+		{ID: "id-user-3", Username: "user-3@email.com"},
+		{ID: "id-user-4", Username: "user-4@email.com"},
+		{ID: "id-unmanaged", Username: "unmanaged@email.com"},
+	}
+	googleUsers := map[string]*admin.User{
+		"user-1@email.com": {Id: "g-user-1", PrimaryEmail: "user-1@email.com", Name: &admin.UserName{}},
+		"user-2@email.com": {Id: "g-user-2", PrimaryEmail: "user-2@email.com", Name: &admin.UserName{}},
+		"user-3@email.com": {Id: "g-user-3", PrimaryEmail: "user-3@email.com", Name: &admin.UserName{}},
+		"user-4@email.com": {PrimaryEmail: "user-4@email.com", Name: &admin.UserName{}},
+	}
+	// user-3 wasn't looked up as it's unchanged since the checkpoint
+	current := map[string]string{
+		"user-1@email.com":    "",
+		"user-2@email.com":    "g-user-2",
+		"user-4@email.com":    "",
+		"unmanaged@email.com": "",
+	}
+
+	err := s.backfillExternalIDs(awsUsers, googleUsers, current)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"id-user-1": "g-user-1"}, awsClient.externalIDs)
+	assert.Equal(t, "g-user-1", current["user-1@email.com"])
+
+	// the next run finds them set and changes nothing
+	awsClient.externalIDs = nil
+	err = s.backfillExternalIDs(awsUsers, googleUsers, current)
+	assert.NoError(t, err)
+	assert.Empty(t, awsClient.externalIDs)
+
+	// and the external ids don't make the users differ from google
+	mapping := userMapping{externalID: true}
+	for _, gUser := range googleUsers {
+		awsUser := awsUserFromGoogle(gUser, mapping)
+		assert.Empty(t, getUserUpdateReasons(awsUser, gUser, mapping))
+	}
+}
+
+// fakeSecrets returns the google credentials stored by secret name
+type fakeSecrets map[string]string
+
+func (f fakeSecrets) GoogleCredentials(secretArn string) (string, error) {
+	if v, ok := f[secretArn]; ok {
+		return v, nil
+	}
+	return "", errors.New("secret not found")
+}
+
+func Test_newSourceClient(t *testing.T) {
+	googleClient := &fakeGoogleClient{}
+	built := 0
+	newGoogle := func() (google.Client, error) {
+		built++
+		return googleClient, nil
+	}
+
+	tests := []struct {
+		name       string
+		provider   string
+		wantGoogle bool
+		wantErr    bool
+	}{
+		{name: "google", provider: config.SourceProviderGoogle, wantGoogle: true},
+		{name: "okta", provider: config.SourceProviderOkta},
+		{name: "file", provider: config.SourceProviderFile},
+		{name: "unsupported", provider: "ldap", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			built = 0
+			cfg := config.New()
+			cfg.SourceProvider = tt.provider
+			cfg.OktaOrgURL = "https://example.okta.com"
+			cfg.OktaAPIToken = "token"
+			cfg.SourceFile = filepath.Join("testdata", "directory.json")
+
+			client, err := newSourceClient(context.Background(), cfg, newGoogle)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Error(t, validateConfig(cfg))
+				return
+			}
+			assert.NoError(t, err)
+			assert.NoError(t, validateConfig(cfg))
+
+			// the google client, and its credentials, are only needed for google
+			if tt.wantGoogle {
+				assert.Equal(t, 1, built)
+				assert.Same(t, googleClient, client)
+			} else {
+				assert.Equal(t, 0, built)
+				assert.NotNil(t, client)
+			}
+		})
+	}
+
+	// okta needs its org and token
+	cfg := config.New()
+	cfg.SourceProvider = config.SourceProviderOkta
+	assert.Error(t, validateConfig(cfg))
+
+	// and file its source file
+	cfg = config.New()
+	cfg.SourceProvider = config.SourceProviderFile
+	assert.Error(t, validateConfig(cfg))
+}
+
+func Test_loadGoogleCredentials(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "credentials.json")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`{"type": "file"}`), 0600))
+
+	arn := "arn:aws:secretsmanager:eu-west-1:123456789012:secret:ssosync/google-AbCdEf"
+	secrets := fakeSecrets{"ssosync/google": `{"type": "secret"}`, arn: `{"type": "arn"}`}
+
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		want    string
+		wantErr bool
+	}{
+		{name: "file", cfg: config.Config{GoogleCredentials: file}, want: `{"type": "file"}`},
+		{name: "lambda", cfg: config.Config{IsLambda: true, GoogleCredentials: `{"type": "lambda"}`}, want: `{"type": "lambda"}`},
+		{name: "secret", cfg: config.Config{GoogleCredentials: file, GoogleCredentialsSecret: "ssosync/google"}, want: `{"type": "secret"}`},
+		{name: "secret in lambda", cfg: config.Config{IsLambda: true, GoogleCredentialsSecret: "ssosync/google"}, want: `{"type": "secret"}`},
+		{name: "missing secret", cfg: config.Config{GoogleCredentialsSecret: "ssosync/other"}, wantErr: true},
+		{name: "secret scheme", cfg: config.Config{GoogleCredentials: "secretsmanager://ssosync/google"}, want: `{"type": "secret"}`},
+		{name: "secret arn", cfg: config.Config{GoogleCredentials: arn}, want: `{"type": "arn"}`},
+		{name: "other arn is a file", cfg: config.Config{GoogleCredentials: "arn:aws:s3:::bucket/credentials.json"}, wantErr: true},
+		{name: "lambda value is not a secret", cfg: config.Config{IsLambda: true, GoogleCredentials: "secretsmanager://ssosync/google"}, want: "secretsmanager://ssosync/google"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			creds, err := loadGoogleCredentials(&cfg, func() credentialsSecrets { return secrets })
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(creds))
+		})
+	}
+}
+
+func Test_newGoogleClient(t *testing.T) {
+	secrets := fakeSecrets{"ssosync/google": `{"type": "secret"}`}
+
+	cfg := config.New()
+	cfg.GoogleAdmin = "admin@email.com"
+	cfg.GoogleCredentials = "secretsmanager://ssosync/google"
+
+	// the credentials read are the key of the google client
+	var gotAdmin, gotKey string
+	newClient := func(ctx context.Context, adminEmail string, serviceAccountKey []byte, cfg *google.Config) (google.Client, error) {
+		gotAdmin, gotKey = adminEmail, string(serviceAccountKey)
+		return &fakeGoogleClient{}, nil
+	}
+
+	_, err := newGoogleClient(context.Background(), cfg, func() credentialsSecrets { return secrets }, newClient)
+	assert.NoError(t, err)
+	assert.Equal(t, "admin@email.com", gotAdmin)
+	assert.Equal(t, `{"type": "secret"}`, gotKey)
+}
+
+func Test_SyncUsersIdentityStoreBackend(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockIdentityStoreClient := mocks.NewMockIdentityStoreAPI(ctrl)
+
+	google := &fakeGoogleClient{
+		deletedUsers: []*admin.User{{PrimaryEmail: "user-3@email.com"}},
+		users: []*admin.User{
+			{Name: &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1"}, PrimaryEmail: "user-1@email.com"},
+			{Id: "g-user-2", Name: &admin.UserName{GivenName: "name-2", FamilyName: "lastname-2"}, PrimaryEmail: "user-2@email.com", Suspended: true},
+		},
+	}
+	awsClient := &fakeAWSClient{
+		users: map[string]*aws.User{
+			"user-3@email.com": {ID: "id-user-3", Username: "user-3@email.com"},
+		},
+	}
+
+	cfg := config.New()
+	cfg.IdentityStoreID = "test-identity-store-id"
+	cfg.UserBackend = config.UserBackendIdentityStore
+	cfg.BackfillExternalIDs = true
+
+	s := &syncGSuite{
+		aws:                 awsClient,
+		google:              google,
+		cfg:                 cfg,
+		identityStoreClient: mockIdentityStoreClient,
+		users:               make(map[string]*aws.User),
+	}
+
+	mockIdentityStoreClient.EXPECT().DeleteUserWithContext(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx aws_sdk.Context, input *identitystore.DeleteUserInput, opts ...request.Option) (*identitystore.DeleteUserOutput, error) {
+			assert.Equal(t, "id-user-3", *input.UserId)
+			return &identitystore.DeleteUserOutput{}, nil
+		})
+
+	created := make([]*identitystore.CreateUserInput, 0)
+	mockIdentityStoreClient.EXPECT().CreateUser(gomock.Any()).Times(2).DoAndReturn(
+		func(input *identitystore.CreateUserInput) (*identitystore.CreateUserOutput, error) {
+			created = append(created, input)
+			return &identitystore.CreateUserOutput{UserId: aws_sdk.String("id-" + *input.UserName)}, nil
+		})
+
+	err := s.SyncUsers("*")
+	assert.NoError(t, err)
+
+	// no user is created through scim, the suspended one is only disabled
+	assert.Len(t, awsClient.created, 0)
+	if assert.Len(t, awsClient.updated, 1) {
+		assert.Equal(t, "id-user-2@email.com", awsClient.updated[0].ID)
+		assert.False(t, awsClient.updated[0].Active)
+		// disabling the user must not drop its other attributes
+		assert.Equal(t, "g-user-2", awsClient.updated[0].ExternalID)
+		assert.Equal(t, "lastname-2", awsClient.updated[0].Name.FamilyName)
+	}
+	assert.Equal(t, map[string]string{"id-user-2@email.com": "g-user-2"}, awsClient.externalIDs)
+
+	if assert.Len(t, created, 2) {
+		assert.Equal(t, "test-identity-store-id", *created[0].IdentityStoreId)
+		assert.Equal(t, "name-1", *created[0].Name.GivenName)
+		assert.Equal(t, "lastname-1", *created[0].Name.FamilyName)
+		assert.Equal(t, "name-1 lastname-1", *created[0].DisplayName)
+		assert.Equal(t, "user-1@email.com", *created[0].Emails[0].Value)
+	}
+	assert.Equal(t, "id-user-1@email.com", s.users["user-1@email.com"].ID)
+}
+
+func Test_isUserConflict(t *testing.T) {
+	assert.True(t, isUserConflict(&aws.ErrHTTPNotOK{StatusCode: 409}))
+	assert.True(t, isUserConflict(awserr.New(identitystore.ErrCodeConflictException, "exists", nil)))
+	assert.False(t, isUserConflict(&aws.ErrHTTPNotOK{StatusCode: 500}))
+	assert.False(t, isUserConflict(awserr.New(identitystore.ErrCodeThrottlingException, "slow down", nil)))
+	assert.False(t, isUserConflict(errors.New("failed")))
+}
+
+func Test_SyncGroupMetadata(t *testing.T) {
+	tests := []struct {
+		name        string
+		syncMethod  string
+		wantCreated []string
+		wantDeleted int
+	}{
+		{name: "groups", syncMethod: config.DefaultSyncMethod, wantCreated: []string{"group-1"}, wantDeleted: 1},
+		{name: "users_groups", syncMethod: "users_groups", wantCreated: []string{"group-1@email.com", "group-2@email.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			cfg := config.New()
+			cfg.IdentityStoreID = "test-identity-store-id"
+			cfg.SyncMethod = tt.syncMethod
+			cfg.IncludeGroups = []string{"group-1@email.com", "group-2@email.com"}
+
+			google := &fakeGoogleClient{groups: []*admin.Group{
+				{Name: "group-1", Email: "group-1@email.com"},
+				{Name: "group-2", Email: "group-2@email.com"},
+			}}
+			awsClient := &fakeAWSClient{groups: map[string]*aws.Group{
+				"group-old": {ID: "group-old", DisplayName: "group-old"},
+			}}
+
+			// only group operations are expected, any user or member call fails the test
+			mockIdentityStoreClient := mocks.NewMockIdentityStoreAPI(ctrl)
+			mockIdentityStoreClient.EXPECT().ListGroupsPages(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(input *identitystore.ListGroupsInput, fn func(*identitystore.ListGroupsOutput, bool) bool) error {
+					fn(&identitystore.ListGroupsOutput{Groups: []*identitystore.Group{
+						{GroupId: aws_sdk.String("group-2"), DisplayName: aws_sdk.String("group-2")},
+						{GroupId: aws_sdk.String("group-old"), DisplayName: aws_sdk.String("group-old")},
+					}}, true)
+					return nil
+				})
+
+			var created []string
+			mockIdentityStoreClient.EXPECT().CreateGroupWithContext(gomock.Any(), gomock.Any()).Times(len(tt.wantCreated)).DoAndReturn(
+				func(ctx aws_sdk.Context, input *identitystore.CreateGroupInput, opts ...request.Option) (*identitystore.CreateGroupOutput, error) {
+					created = append(created, *input.DisplayName)
+					return &identitystore.CreateGroupOutput{GroupId: aws_sdk.String("new")}, nil
+				})
+			mockIdentityStoreClient.EXPECT().DeleteGroupWithContext(gomock.Any(), &identitystore.DeleteGroupInput{
+				IdentityStoreId: &cfg.IdentityStoreID, GroupId: aws_sdk.String("group-old"),
+			}).Times(tt.wantDeleted).Return(&identitystore.DeleteGroupOutput{}, nil)
+
+			s := New(cfg, awsClient, google, mockIdentityStoreClient)
+			assert.NoError(t, s.SyncGroupMetadata("*"))
+
+			assert.Equal(t, tt.wantCreated, created)
+			assert.Equal(t, SyncStats{GroupsCreated: len(tt.wantCreated), GroupsDeleted: tt.wantDeleted}, s.Stats())
+		})
+	}
+}
+
+func Test_SyncGroupMetadataMigrateGroupNamesPrefix(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := config.New()
+	cfg.IdentityStoreID = "test-identity-store-id"
+	cfg.MigrateGroupNames = true
+	cfg.GroupNamePrefix = "GSuite-"
+
+	google := &fakeGoogleClient{groups: []*admin.Group{{Name: "group-1", Email: "group-1@email.com"}}}
+	awsClient := &fakeAWSClient{}
+
+	// the group named by email has the prefix too, it's renamed rather than recreated
+	mockIdentityStoreClient := mocks.NewMockIdentityStoreAPI(ctrl)
+	mockIdentityStoreClient.EXPECT().ListGroupsPages(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(input *identitystore.ListGroupsInput, fn func(*identitystore.ListGroupsOutput, bool) bool) error {
+			fn(&identitystore.ListGroupsOutput{Groups: []*identitystore.Group{
+				{GroupId: aws_sdk.String("group-1"), DisplayName: aws_sdk.String("GSuite-group-1@email.com")},
+			}}, true)
+			return nil
+		})
+
+	s := New(cfg, awsClient, google, mockIdentityStoreClient)
+	assert.NoError(t, s.SyncGroupMetadata("*"))
+
+	assert.Equal(t, map[string]string{"GSuite-group-1@email.com": "GSuite-group-1"}, awsClient.renames)
+	assert.Equal(t, 0, s.Stats().GroupsCreated)
+}
+
+func Test_SyncGroupsUsersPurgeOrphanedMemberships(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := config.New()
+	cfg.IdentityStoreID = "test-identity-store-id"
+	cfg.PurgeOrphanedMemberships = true
+
+	mockIdentityStoreClient := mocks.NewMockIdentityStoreAPI(ctrl)
+	google := &fakeGoogleClient{
+		users:  []*admin.User{{Name: &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1"}, PrimaryEmail: "user-1@email.com"}},
+		groups: []*admin.Group{{Name: "group-1", Email: "group-1@email.com"}},
+		members: map[string][]*admin.Member{
+			"group-1@email.com": {{Email: "user-1@email.com", Type: "USER", Status: "ACTIVE"}},
+		},
+	}
+	awsClient := &fakeAWSClient{
+		groups: map[string]*aws.Group{"group-old": {ID: "group-old", DisplayName: "group-old"}},
+		users:  map[string]*aws.User{"user-1@email.com": {ID: "id-user-1", Username: "user-1@email.com", Active: true}},
+	}
+	s := New(cfg, awsClient, google, mockIdentityStoreClient)
+
+	mockIdentityStoreClient.EXPECT().ListGroupsPages(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(input *identitystore.ListGroupsInput, fn func(*identitystore.ListGroupsOutput, bool) bool) error {
+			fn(&identitystore.ListGroupsOutput{Groups: []*identitystore.Group{
+				{GroupId: aws_sdk.String("group-1"), DisplayName: aws_sdk.String("group-1")},
+				{GroupId: aws_sdk.String("group-old"), DisplayName: aws_sdk.String("group-old")},
+			}}, true)
+			return nil
+		})
+	mockIdentityStoreClient.EXPECT().ListUsersPages(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(input *identitystore.ListUsersInput, fn func(*identitystore.ListUsersOutput, bool) bool) error {
+			fn(&identitystore.ListUsersOutput{Users: []*identitystore.User{{
+				UserId:      aws_sdk.String("id-user-1"),
+				UserName:    aws_sdk.String("user-1@email.com"),
+				DisplayName: aws_sdk.String("name-1 lastname-1"),
+				Name:        &identitystore.Name{GivenName: aws_sdk.String("name-1"), FamilyName: aws_sdk.String("lastname-1")},
+				Emails:      []*identitystore.Email{{Value: aws_sdk.String("user-1@email.com"), Type: aws_sdk.String("work"), Primary: aws_sdk.Bool(true)}},
+			}}}, true)
+			return nil
+		})
+	// user-1 is still a member of both groups in aws
+	mockIdentityStoreClient.EXPECT().ListGroupMembershipsPagesWithContext(gomock.Any(), gomock.Any(), gomock.Any()).Times(2).DoAndReturn(
+		func(ctx aws_sdk.Context, input *identitystore.ListGroupMembershipsInput, fn func(*identitystore.ListGroupMembershipsOutput, bool) bool, opts ...request.Option) error {
+			fn(&identitystore.ListGroupMembershipsOutput{GroupMemberships: []*identitystore.GroupMembership{
+				{GroupId: input.GroupId, MemberId: &identitystore.MemberId{UserId: aws_sdk.String("id-user-1")}},
+			}}, true)
+			return nil
+		})
+
+	mockIdentityStoreClient.EXPECT().IsMemberInGroups(gomock.Any()).Return(&identitystore.IsMemberInGroupsOutput{
+		Results: []*identitystore.GroupMembershipExistenceResult{{MembershipExists: aws_sdk.Bool(true)}},
+	}, nil)
+
+	// the membership of the deleted group is removed before the group
+	gomock.InOrder(
+		mockIdentityStoreClient.EXPECT().GetGroupMembershipId(gomock.Any()).DoAndReturn(
+			func(input *identitystore.GetGroupMembershipIdInput) (*identitystore.GetGroupMembershipIdOutput, error) {
+				assert.Equal(t, "group-old", *input.GroupId)
+				assert.Equal(t, "id-user-1", *input.MemberId.UserId)
+				return &identitystore.GetGroupMembershipIdOutput{MembershipId: aws_sdk.String("membership-old")}, nil
+			}),
+		mockIdentityStoreClient.EXPECT().DeleteGroupMembership(gomock.Any()).DoAndReturn(
+			func(input *identitystore.DeleteGroupMembershipInput) (*identitystore.DeleteGroupMembershipOutput, error) {
+				assert.Equal(t, "membership-old", *input.MembershipId)
+				return &identitystore.DeleteGroupMembershipOutput{}, nil
+			}),
+		mockIdentityStoreClient.EXPECT().DeleteGroupWithContext(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx aws_sdk.Context, input *identitystore.DeleteGroupInput, opts ...request.Option) (*identitystore.DeleteGroupOutput, error) {
+				assert.Equal(t, "group-old", *input.GroupId)
+				return &identitystore.DeleteGroupOutput{}, nil
+			}),
+	)
+
+	err := s.SyncGroupsUsers("*", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, s.Stats().MembershipsRemoved)
+	assert.Equal(t, 1, s.Stats().GroupsDeleted)
+}
+
+func Test_checkConnections(t *testing.T) {
+	cfg := config.New()
+	cfg.IdentityStoreID = "test-identity-store-id"
+
+	tests := []struct {
+		name        string
+		identityErr error
+		scimErr     error
+		wantErr     bool
+	}{
+		{name: "ok"},
+		{name: "identity store fails", identityErr: errors.New("access denied"), wantErr: true},
+		{name: "scim fails", scimErr: errors.New("status of http response was 401"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockIdentityStoreClient := mocks.NewMockIdentityStoreAPI(ctrl)
+			mockIdentityStoreClient.EXPECT().ListGroups(gomock.Any()).DoAndReturn(
+				func(input *identitystore.ListGroupsInput) (*identitystore.ListGroupsOutput, error) {
+					assert.Equal(t, "test-identity-store-id", *input.IdentityStoreId)
+					return &identitystore.ListGroupsOutput{}, tt.identityErr
+				})
+
+			conn := &connections{
+				google:        &fakeGoogleClient{},
+				scim:          &fakeAWSClient{checkErr: tt.scimErr},
+				identityStore: mockIdentityStoreClient,
+			}
+
+			err := checkConnections(context.Background(), conn, cfg)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func Test_SyncUsersDeleteConcurrency(t *testing.T) {
+	newSync := func(ctrl *gomock.Controller, cfg *config.Config) (*syncGSuite, *mocks.MockIdentityStoreAPI) {
+		google := &fakeGoogleClient{}
+		client := &fakeAWSClient{users: make(map[string]*aws.User)}
+		for i := 1; i <= 10; i++ {
+			email := "user-" + strconv.Itoa(i) + "@email.com"
+			google.deletedUsers = append(google.deletedUsers, &admin.User{PrimaryEmail: email})
+			// two of them are already deleted in aws
+			if i <= 8 {
+				client.users[email] = &aws.User{ID: "id-" + email, Username: email}
+			}
+		}
+
+		mockIdentityStoreClient := mocks.NewMockIdentityStoreAPI(ctrl)
+		var buf bytes.Buffer
+		return &syncGSuite{
+			aws:                 client,
+			google:              google,
+			identityStoreClient: mockIdentityStoreClient,
+			cfg:                 cfg,
+			users:               make(map[string]*aws.User),
+			audit:               newAuditLog(&buf),
+		}, mockIdentityStoreClient
+	}
+
+	t.Run("all deleted", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		cfg := config.New()
+		cfg.UserDeleteConcurrency = 4
+
+		s, mockIdentityStoreClient := newSync(ctrl, cfg)
+		mockIdentityStoreClient.EXPECT().DeleteUserWithContext(gomock.Any(), gomock.Any()).Times(8).Return(&identitystore.DeleteUserOutput{}, nil)
+
+		assert.NoError(t, s.SyncUsers(""))
+		assert.Equal(t, 8, s.Stats().UsersDeleted)
+	})
+
+	t.Run("continue on error", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		cfg := config.New()
+		cfg.UserDeleteConcurrency = 4
+		cfg.ContinueOnUserDeleteError = true
+
+		s, mockIdentityStoreClient := newSync(ctrl, cfg)
+		mockIdentityStoreClient.EXPECT().DeleteUserWithContext(gomock.Any(), gomock.Any()).Times(8).DoAndReturn(
+			func(ctx aws_sdk.Context, input *identitystore.DeleteUserInput, opts ...request.Option) (*identitystore.DeleteUserOutput, error) {
+				if *input.UserId == "id-user-3@email.com" {
+					return nil, errors.New("access denied")
+				}
+				return &identitystore.DeleteUserOutput{}, nil
+			})
+
+		err := s.SyncUsers("")
+		assert.EqualError(t, err, "1 user deletions failed: user user-3@email.com: access denied")
+		assert.Equal(t, 7, s.Stats().UsersDeleted)
+	})
+
+	t.Run("stop on error", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		s, mockIdentityStoreClient := newSync(ctrl, config.New())
+		mockIdentityStoreClient.EXPECT().DeleteUserWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("access denied"))
+
+		// the deletions are serial by default, the first failure stops them
+		err := s.SyncUsers("")
+		assert.EqualError(t, err, "access denied")
+		assert.Equal(t, 0, s.Stats().UsersDeleted)
+	})
+}
+
+func Test_SyncUsersDeactivate(t *testing.T) {
+	tests := []struct {
+		name        string
+		awsUser     *aws.User
+		wantUpdated bool
+	}{
+		{name: "active", awsUser: &aws.User{ID: "id-user-1", Username: "user-1@email.com", Active: true}, wantUpdated: true},
+		// deactivated by an earlier run
+		{name: "already inactive", awsUser: &aws.User{ID: "id-user-1", Username: "user-1@email.com", Active: false}},
+		{name: "missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			google := &fakeGoogleClient{deletedUsers: []*admin.User{{PrimaryEmail: "user-1@email.com"}}}
+			awsClient := &fakeAWSClient{users: map[string]*aws.User{}}
+			if tt.awsUser != nil {
+				awsClient.users[tt.awsUser.Username] = tt.awsUser
+			}
+
+			cfg := config.New()
+			cfg.UserDeleteStrategy = config.UserDeleteStrategyDeactivate
+
+			// no user is deleted
+			var buf bytes.Buffer
+			s := &syncGSuite{
+				aws:                 awsClient,
+				google:              google,
+				cfg:                 cfg,
+				identityStoreClient: mocks.NewMockIdentityStoreAPI(ctrl),
+				users:               make(map[string]*aws.User),
+				audit:               newAuditLog(&buf),
+			}
+
+			assert.NoError(t, s.SyncUsers(""))
+			if !tt.wantUpdated {
+				assert.Empty(t, awsClient.updated)
+				assert.Equal(t, SyncStats{}, s.Stats())
+				assert.Empty(t, buf.String())
+				return
+			}
+
+			if assert.Len(t, awsClient.updated, 1) {
+				assert.Equal(t, "id-user-1", awsClient.updated[0].ID)
+				assert.False(t, awsClient.updated[0].Active)
+			}
+			assert.Equal(t, SyncStats{UsersUpdated: 1}, s.Stats())
+			assert.Contains(t, buf.String(), auditUpdateUser)
+			assert.Contains(t, buf.String(), `"reasons":["deleted in google"]`)
+		})
+	}
+}
+
+func Test_unmanagedUserActionDeactivate(t *testing.T) {
+	cfg := config.New()
+	cfg.UserDeleteStrategy = config.UserDeleteStrategyDeactivate
+	s := &syncGSuite{cfg: cfg}
+
+	// once inactive the user is left alone
+	inactive := []*aws.User{{Username: "user-3@email.com", Active: false}}
+	_, del, update, _ := getUserOperations(inactive, nil, newUserMapping(cfg), s.unmanagedUserAction())
+	assert.Empty(t, del)
+	assert.Empty(t, update)
+
+	// unless the unmanaged users are ignored
+	cfg.UnmanagedUserAction = config.UnmanagedUserActionIgnore
+	assert.Equal(t, config.UnmanagedUserActionIgnore, s.unmanagedUserAction())
+}
+
+func Test_changedSince(t *testing.T) {
+	since := time.Date(2022, 9, 1, 10, 0, 0, 0, time.UTC)
+	users := []*admin.User{
+		{PrimaryEmail: "created@email.com", CreationTime: "2022-09-01T11:00:00.000Z", LastLoginTime: "1970-01-01T00:00:00.000Z"},
+		{PrimaryEmail: "logged-in@email.com", CreationTime: "2021-01-01T00:00:00.000Z", LastLoginTime: "2022-09-01T10:00:00.000Z"},
+		{PrimaryEmail: "old@email.com", CreationTime: "2021-01-01T00:00:00.000Z", LastLoginTime: "2022-08-31T23:00:00.000Z"},
+		{PrimaryEmail: "unknown@email.com"},
+	}
+
+	changed := changedSince(users, since)
+	assert.Equal(t, map[string]struct{}{"created@email.com": {}, "logged-in@email.com": {}}, changed)
+}
+
+func Test_SyncUsersIncrementalSince(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cfg := config.New()
+	cfg.IncrementalSince = 24 * time.Hour
+
+	recent := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	old := time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339)
+
+	google := &fakeGoogleClient{
+		users: []*admin.User{
+			{Name: &admin.UserName{GivenName: "name-1", FamilyName: "lastname-1"}, PrimaryEmail: "user-1@email.com", CreationTime: recent},
+			{Name: &admin.UserName{GivenName: "name-2", FamilyName: "lastname-2"}, PrimaryEmail: "user-2@email.com", CreationTime: old, LastLoginTime: old, Suspended: true},
+		},
+		deletedUsers: []*admin.User{{PrimaryEmail: "user-3@email.com"}},
+		groups:       []*admin.Group{{Name: "group", Email: "group@email.com"}},
+		members: map[string][]*admin.Member{
+			"group@email.com": {{Email: "user-2@email.com", Type: "USER", Status: "ACTIVE"}},
+		},
+	}
+	client := &fakeAWSClient{
+		users: map[string]*aws.User{
+			"user-2@email.com": {ID: "id-user-2", Username: "user-2@email.com", Active: true},
+		},
+		groups: map[string]*aws.Group{"group@email.com": {ID: "group", DisplayName: "group@email.com"}},
+	}
+	cfg.IncludeGroups = []string{"group@email.com"}
+
+	mockIdentityStoreClient := mocks.NewMockIdentityStoreAPI(ctrl)
+	mockIdentityStoreClient.EXPECT().IsMemberInGroups(gomock.Any()).AnyTimes().Return(&identitystore.IsMemberInGroupsOutput{
+		Results: []*identitystore.GroupMembershipExistenceResult{{MembershipExists: aws_sdk.Bool(false)}},
+	}, nil)
+	mockIdentityStoreClient.EXPECT().CreateGroupMembership(&identitystore.CreateGroupMembershipInput{
+		IdentityStoreId: aws_sdk.String(""),
+		GroupId:         aws_sdk.String("group"),
+		MemberId:        &identitystore.MemberId{UserId: aws_sdk.String("id-user-2")},
+	}).Return(&identitystore.CreateGroupMembershipOutput{}, nil)
+	s := &syncGSuite{aws: client, google: google, cfg: cfg, identityStoreClient: mockIdentityStoreClient, users: make(map[string]*aws.User)}
+
+	assert.NoError(t, s.SyncUsers("*"))
+
+	// the suspension of user-2 came without a login, it waits for a full sync
+	assert.Equal(t, 1, s.Stats().UsersCreated)
+	assert.Equal(t, 0, s.Stats().UsersUpdated)
+	assert.Empty(t, client.updated)
+
+	// but the unchanged user-2 is still a member of its groups
+	assert.NoError(t, s.SyncGroups("*"))
+	assert.Equal(t, 1, s.Stats().MembershipsAdded)
+}
+
+func Test_timeoutError(t *testing.T) {
+	cfg := config.New()
+	cfg.SyncTimeout = time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	err := timeoutError(ctx, cfg, errors.New("request canceled"))
+	assert.EqualError(t, err, "sync timed out after 1m0s: request canceled")
+	assert.NoError(t, timeoutError(ctx, cfg, nil))
+
+	// the errors of a sync within its time are left as they are
+	err = timeoutError(context.Background(), cfg, errors.New("access denied"))
+	assert.EqualError(t, err, "access denied")
+}
@@ -0,0 +1,309 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/awslabs/ssosync/internal/config"
+	"github.com/hashicorp/go-retryablehttp"
+
+	aws_sdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// tlsVersions maps the values accepted by --scim-verify-tls-min-version
+// to their crypto/tls constants
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion converts a version string such as "1.2" into the
+// matching crypto/tls constant
+func parseTLSVersion(version string) (uint16, error) {
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version %q, expected one of 1.0, 1.1, 1.2, 1.3", version)
+	}
+	return v, nil
+}
+
+// configureSCIMTransport applies the connection settings from the config
+// to the transport used for calls to the SCIM endpoint
+func configureSCIMTransport(t *http.Transport, cfg *config.Config) error {
+	minVersion, err := parseTLSVersion(cfg.SCIMVerifyTLSMinVersion)
+	if err != nil {
+		return err
+	}
+
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	t.TLSClientConfig.MinVersion = minVersion
+
+	certs, roots, err := loadSCIMClientTLS(cfg)
+	if err != nil {
+		return err
+	}
+	if certs != nil {
+		t.TLSClientConfig.Certificates = certs
+	}
+	if roots != nil {
+		t.TLSClientConfig.RootCAs = roots
+	}
+
+	if cfg.SCIMConnectionPoolSize > 0 {
+		t.MaxIdleConns = cfg.SCIMConnectionPoolSize
+		t.MaxIdleConnsPerHost = cfg.SCIMConnectionPoolSize
+	}
+
+	proxy, err := scimProxy(cfg)
+	if err != nil {
+		return err
+	}
+	if proxy != nil {
+		t.Proxy = proxy
+	}
+
+	return nil
+}
+
+// loadSCIMClientTLS reads the client certificate presented to the SCIM
+// endpoint and the CAs its certificate is verified with, each is nil when
+// it's not configured
+func loadSCIMClientTLS(cfg *config.Config) ([]tls.Certificate, *x509.CertPool, error) {
+	if (cfg.SCIMClientCert == "") != (cfg.SCIMClientKey == "") {
+		return nil, nil, fmt.Errorf("the scim client cert and key must be set together")
+	}
+
+	var certs []tls.Certificate
+	if cfg.SCIMClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.SCIMClientCert, cfg.SCIMClientKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid scim client cert %s: %w", cfg.SCIMClientCert, err)
+		}
+		certs = []tls.Certificate{cert}
+	}
+
+	var roots *x509.CertPool
+	if cfg.SCIMCACert != "" {
+		b, err := ioutil.ReadFile(cfg.SCIMCACert)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid scim ca cert %s: %w", cfg.SCIMCACert, err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(b) {
+			return nil, nil, fmt.Errorf("invalid scim ca cert %s: no PEM certificate found", cfg.SCIMCACert)
+		}
+	}
+
+	return certs, roots, nil
+}
+
+// scimProxy returns the proxy func of the configured proxies, nil when none
+// is configured so the transport keeps using the environment variables
+func scimProxy(cfg *config.Config) (func(*http.Request) (*url.URL, error), error) {
+	if cfg.HTTPProxy == "" && cfg.HTTPSProxy == "" && cfg.NoProxy == "" {
+		return nil, nil
+	}
+
+	for _, p := range []string{cfg.HTTPProxy, cfg.HTTPSProxy} {
+		if p == "" {
+			continue
+		}
+		u, err := url.Parse(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %q: %w", p, err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy %q: expected a url with a scheme and a host, such as http://proxy.example.com:3128", p)
+		}
+	}
+
+	proxyFunc := (&httpproxy.Config{
+		HTTPProxy:  cfg.HTTPProxy,
+		HTTPSProxy: cfg.HTTPSProxy,
+		NoProxy:    cfg.NoProxy,
+	}).ProxyFunc()
+
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}, nil
+}
+
+// newSCIMHTTPClient returns the http client of the SCIM endpoint. It retries
+// with backoff, each attempt is limited to the SCIM timeout and waits for the
+// throttling cool-down, the whole request, retries included, stops with the
+// context of the request.
+func newSCIMHTTPClient(cfg *config.Config, cooldown *throttleCooldown) (*http.Client, error) {
+	// create a http client with retry and backoff capabilities
+	retryClient := retryablehttp.NewClient()
+
+	// https://github.com/hashicorp/go-retryablehttp/issues/6
+	if cfg.Debug {
+		retryClient.Logger = log.StandardLogger()
+	} else {
+		retryClient.Logger = nil
+	}
+
+	if transport, ok := retryClient.HTTPClient.Transport.(*http.Transport); ok {
+		if err := configureSCIMTransport(transport, cfg); err != nil {
+			return nil, err
+		}
+	}
+	if cooldown != nil {
+		retryClient.HTTPClient.Transport = &cooldownTransport{next: retryClient.HTTPClient.Transport, cooldown: cooldown}
+	}
+	retryClient.HTTPClient.Timeout = cfg.SCIMTimeout
+
+	// once the retries are exhausted, return the last response so the SCIM
+	// client reports its status, such as how long a 429 asked to wait
+	retryClient.ErrorHandler = retryablehttp.PassthroughErrorHandler
+
+	return retryClient.StandardClient(), nil
+}
+
+// contextHandler gives the sdk requests made without a context of their own
+// the context of the sync, so they stop with it
+func contextHandler(ctx context.Context) func(*request.Request) {
+	return func(r *request.Request) {
+		if r.Context() == aws_sdk.BackgroundContext() {
+			r.SetContext(ctx)
+		}
+	}
+}
+
+// throttleCooldown pauses all the calls to aws for a while once threshold of
+// them were throttled within the window, so the retries don't keep up the
+// pressure on the apis
+type throttleCooldown struct {
+	threshold int
+	window    time.Duration
+	duration  time.Duration
+	now       func() time.Time
+
+	mu     sync.Mutex
+	events []time.Time
+	until  time.Time
+}
+
+// newThrottleCooldown returns the cool-down of the config, nil when it's disabled
+func newThrottleCooldown(cfg *config.Config) *throttleCooldown {
+	if cfg.ThrottleCooldownThreshold <= 0 || cfg.ThrottleCooldown <= 0 {
+		return nil
+	}
+
+	return &throttleCooldown{
+		threshold: cfg.ThrottleCooldownThreshold,
+		window:    cfg.ThrottleCooldownWindow,
+		duration:  cfg.ThrottleCooldown,
+		now:       time.Now,
+	}
+}
+
+// throttled records a throttled call and starts the cool-down once there
+// were threshold of them within the window
+func (c *throttleCooldown) throttled() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	recent := c.events[:0]
+	for _, e := range c.events {
+		if now.Sub(e) < c.window {
+			recent = append(recent, e)
+		}
+	}
+	c.events = append(recent, now)
+	if len(c.events) < c.threshold {
+		return
+	}
+
+	c.events = nil
+	c.until = now.Add(c.duration)
+	log.WithFields(log.Fields{"throttled": c.threshold, "window": c.window, "cooldown": c.duration}).Warn("calls to aws throttled repeatedly, pausing them")
+}
+
+// remaining returns how long the calls are still paused for
+func (c *throttleCooldown) remaining() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.until.Sub(c.now())
+}
+
+// wait blocks until the cool-down is over or the context is done
+func (c *throttleCooldown) wait(ctx context.Context) error {
+	d := c.remaining()
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// cooldownTransport waits for the cool-down before each attempt of a SCIM
+// call and records the throttled ones
+type cooldownTransport struct {
+	next     http.RoundTripper
+	cooldown *throttleCooldown
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *cooldownTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.cooldown.wait(req.Context()); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		t.cooldown.throttled()
+	}
+	return resp, err
+}
+
+// cooldownHandlers makes each attempt of the sdk requests wait for the
+// cool-down and records the throttled ones
+func cooldownHandlers(h *request.Handlers, c *throttleCooldown) {
+	h.Send.PushFront(func(r *request.Request) {
+		// a done context fails the send that follows
+		_ = c.wait(r.Context())
+	})
+	h.CompleteAttempt.PushBack(func(r *request.Request) {
+		if r.IsErrorThrottle() {
+			c.throttled()
+		}
+	})
+}
@@ -0,0 +1,380 @@
+// Copyright (c) 2020, Amazon.com, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/awslabs/ssosync/internal/config"
+	"github.com/hashicorp/go-retryablehttp"
+
+	aws_sdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/identitystore"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_configureSCIMTransport(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		want    uint16
+		wantErr bool
+	}{
+		{name: "default", version: config.DefaultSCIMVerifyTLSMinVersion, want: tls.VersionTLS12},
+		{name: "tls 1.3", version: "1.3", want: tls.VersionTLS13},
+		{name: "tls 1.0", version: "1.0", want: tls.VersionTLS10},
+		{name: "unsupported", version: "1.4", wantErr: true},
+		{name: "empty", version: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.New()
+			cfg.SCIMVerifyTLSMinVersion = tt.version
+
+			transport := &http.Transport{}
+			err := configureSCIMTransport(transport, cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.NotNil(t, transport.TLSClientConfig)
+			assert.Equal(t, tt.want, transport.TLSClientConfig.MinVersion)
+		})
+	}
+}
+
+func Test_configureSCIMTransportPoolSize(t *testing.T) {
+	cfg := config.New()
+
+	// the defaults of the transport are kept when not configured
+	transport := &http.Transport{MaxIdleConns: 100, MaxIdleConnsPerHost: 2}
+	assert.NoError(t, configureSCIMTransport(transport, cfg))
+	assert.Equal(t, 100, transport.MaxIdleConns)
+	assert.Equal(t, 2, transport.MaxIdleConnsPerHost)
+
+	cfg.SCIMConnectionPoolSize = 25
+	assert.NoError(t, configureSCIMTransport(transport, cfg))
+	assert.Equal(t, 25, transport.MaxIdleConns)
+	assert.Equal(t, 25, transport.MaxIdleConnsPerHost)
+}
+
+// writeTestCert writes a self-signed certificate and its key as PEM files
+func writeTestCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ssosync"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certPath, keyPath := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	assert.NoError(t, ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certPath, keyPath
+}
+
+func Test_configureSCIMTransportClientCert(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeTestCert(t, dir)
+
+	cfg := config.New()
+	cfg.SCIMClientCert = certPath
+	cfg.SCIMClientKey = keyPath
+	cfg.SCIMCACert = certPath
+
+	transport := &http.Transport{}
+	assert.NoError(t, configureSCIMTransport(transport, cfg))
+	assert.Len(t, transport.TLSClientConfig.Certificates, 1)
+	cert, err := x509.ParseCertificate(transport.TLSClientConfig.Certificates[0].Certificate[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "ssosync", cert.Subject.CommonName)
+	assert.NotNil(t, transport.TLSClientConfig.RootCAs)
+
+	// the system CAs and no certificate are used by default
+	transport = &http.Transport{}
+	assert.NoError(t, configureSCIMTransport(transport, config.New()))
+	assert.Empty(t, transport.TLSClientConfig.Certificates)
+	assert.Nil(t, transport.TLSClientConfig.RootCAs)
+}
+
+func Test_validateConfigSCIMClientCert(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeTestCert(t, dir)
+	notPEM := filepath.Join(dir, "ca.txt")
+	assert.NoError(t, ioutil.WriteFile(notPEM, []byte("not a certificate"), 0600))
+
+	tests := []struct {
+		name    string
+		cert    string
+		key     string
+		ca      string
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "cert and key", cert: certPath, key: keyPath},
+		{name: "ca", ca: certPath},
+		{name: "cert without key", cert: certPath, wantErr: true},
+		{name: "key without cert", key: keyPath, wantErr: true},
+		{name: "missing cert", cert: filepath.Join(dir, "missing.pem"), key: keyPath, wantErr: true},
+		{name: "mismatched key", cert: certPath, key: certPath, wantErr: true},
+		{name: "missing ca", ca: filepath.Join(dir, "missing.pem"), wantErr: true},
+		{name: "ca without certificate", ca: notPEM, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.New()
+			cfg.SCIMClientCert = tt.cert
+			cfg.SCIMClientKey = tt.key
+			cfg.SCIMCACert = tt.ca
+			err := validateConfig(cfg)
+			assert.Equal(t, tt.wantErr, err != nil, err)
+		})
+	}
+}
+
+func Test_configureSCIMTransportProxy(t *testing.T) {
+	request := func(rawURL string) *http.Request {
+		u, _ := url.Parse(rawURL)
+		return &http.Request{URL: u}
+	}
+
+	// the proxy of the environment is kept when none is configured
+	cfg := config.New()
+	transport := &http.Transport{}
+	assert.NoError(t, configureSCIMTransport(transport, cfg))
+	assert.Nil(t, transport.Proxy)
+
+	cfg.HTTPProxy = "http://proxy.example.com:3128"
+	cfg.HTTPSProxy = "http://secure-proxy.example.com:3128"
+	cfg.NoProxy = "internal.example.com"
+	assert.NoError(t, configureSCIMTransport(transport, cfg))
+
+	proxy, err := transport.Proxy(request("https://scim.us-east-1.amazonaws.com/abc/scim/v2/Users"))
+	assert.NoError(t, err)
+	assert.Equal(t, "http://secure-proxy.example.com:3128", proxy.String())
+
+	proxy, err = transport.Proxy(request("http://scim.example.com/scim/v2/Users"))
+	assert.NoError(t, err)
+	assert.Equal(t, "http://proxy.example.com:3128", proxy.String())
+
+	proxy, err = transport.Proxy(request("https://scim.internal.example.com/scim/v2/Users"))
+	assert.NoError(t, err)
+	assert.Nil(t, proxy)
+
+	cfg.HTTPProxy = "http://proxy.example.com:bad port"
+	assert.Error(t, configureSCIMTransport(transport, cfg))
+
+	// the scheme and the host are required
+	for _, p := range []string{"proxy.example.com:3128", "proxy.example.com", "http://", "/proxy"} {
+		cfg.HTTPProxy = p
+		assert.Error(t, configureSCIMTransport(transport, cfg), p)
+	}
+
+	// only the https proxy is set
+	cfg.HTTPProxy = ""
+	assert.NoError(t, configureSCIMTransport(transport, cfg))
+}
+
+func Test_newSCIMHTTPClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	cfg := config.New()
+	cfg.SCIMTimeout = 30 * time.Second
+
+	client, err := newSCIMHTTPClient(cfg, nil)
+	assert.NoError(t, err)
+
+	// the timeout applies to each attempt of the retrying client
+	transport := client.Transport.(*retryablehttp.RoundTripper)
+	assert.Equal(t, 30*time.Second, transport.Client.HTTPClient.Timeout)
+
+	resp, err := client.Get(srv.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	// the retries stop with the context of the request
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	assert.NoError(t, err)
+	_, err = client.Do(req)
+	assert.True(t, errors.Is(err, context.Canceled), err)
+}
+
+func Test_newSCIMHTTPClientLastResponse(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	client, err := newSCIMHTTPClient(config.New(), nil)
+	assert.NoError(t, err)
+	client.Transport.(*retryablehttp.RoundTripper).Client.RetryMax = 1
+
+	// the throttled response is returned once the retries are exhausted
+	resp, err := client.Get(srv.URL)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		resp.Body.Close()
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func Test_contextHandler(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"UserId": "id-user-1", "IdentityStoreId": "test-identity-store-id"}`))
+	}))
+	defer srv.Close()
+
+	sess := session.Must(session.NewSession(&aws_sdk.Config{
+		Region:      aws_sdk.String("us-east-1"),
+		Endpoint:    aws_sdk.String(srv.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws_sdk.Int(0),
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	sess.Handlers.Build.PushBack(contextHandler(ctx))
+
+	input := &identitystore.DescribeUserInput{IdentityStoreId: aws_sdk.String("test-identity-store-id"), UserId: aws_sdk.String("id-user-1")}
+	_, err := identitystore.New(sess).DescribeUser(input)
+	assert.NoError(t, err)
+
+	// an expired sync aborts the calls made without a context
+	cancel()
+	_, err = identitystore.New(sess).DescribeUser(input)
+	var aerr awserr.Error
+	if assert.True(t, errors.As(err, &aerr), err) {
+		assert.Equal(t, request.CanceledErrorCode, aerr.Code())
+	}
+}
+
+func Test_throttleCooldown(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := &throttleCooldown{threshold: 3, window: time.Minute, duration: 30 * time.Second, now: func() time.Time { return now }}
+
+	// throttles spread over more than the window don't trip it
+	c.throttled()
+	c.throttled()
+	now = now.Add(2 * time.Minute)
+	c.throttled()
+	assert.True(t, c.remaining() <= 0)
+
+	c.throttled()
+	c.throttled()
+	assert.Equal(t, 30*time.Second, c.remaining())
+
+	// the calls wait until the cool-down is over or their context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.True(t, errors.Is(c.wait(ctx), context.Canceled))
+
+	now = now.Add(31 * time.Second)
+	assert.True(t, c.remaining() <= 0)
+	assert.NoError(t, c.wait(ctx))
+}
+
+func Test_cooldownTransport(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	cfg := config.New()
+	cfg.ThrottleCooldownThreshold = 2
+	cfg.ThrottleCooldown = time.Hour
+	cooldown := newThrottleCooldown(cfg)
+	client := &http.Client{Transport: &cooldownTransport{next: http.DefaultTransport, cooldown: cooldown}}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(srv.URL)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+	assert.True(t, cooldown.remaining() > 0)
+
+	// the paused call isn't sent
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	assert.NoError(t, err)
+	_, err = client.Do(req)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.Equal(t, 2, calls)
+}
+
+func Test_cooldownHandlers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type": "ThrottlingException", "message": "Rate exceeded"}`))
+	}))
+	defer srv.Close()
+
+	sess := session.Must(session.NewSession(&aws_sdk.Config{
+		Region:      aws_sdk.String("us-east-1"),
+		Endpoint:    aws_sdk.String(srv.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws_sdk.Int(0),
+	}))
+
+	cfg := config.New()
+	cfg.ThrottleCooldownThreshold = 1
+	cfg.ThrottleCooldown = time.Hour
+	cooldown := newThrottleCooldown(cfg)
+	cooldownHandlers(&sess.Handlers, cooldown)
+
+	input := &identitystore.DescribeUserInput{IdentityStoreId: aws_sdk.String("test-identity-store-id"), UserId: aws_sdk.String("id-user-1")}
+	_, err := identitystore.New(sess).DescribeUser(input)
+	assert.Error(t, err)
+	assert.True(t, cooldown.remaining() > 0)
+
+	assert.Nil(t, newThrottleCooldown(config.New()))
+}